@@ -1,11 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/logging"
 
 	"github.com/manifoldco/promptui"
 	"github.com/rudestan/broadlinkrm"
@@ -42,7 +43,7 @@ func CmdDiscover(configFile string) error {
 
 	fmt.Println("Discovering, please wait...")
 
-	err = deviceControl.Discover(false)
+	err = deviceControl.Discover(context.Background(), false)
 	if err != nil {
 		return err
 	}
@@ -61,9 +62,9 @@ func CmdDiscover(configFile string) error {
 		case "Save changes":
 			err = config.SaveConfiguration(configFile)
 			if err != nil {
-				log.Println("Failed to save the config!")
+				logging.WithError(err).Errorf("Failed to save the config!")
 			} else {
-				log.Println("Changes saved")
+				logging.Infof("Changes saved")
 			}
 			break
 		default:
@@ -74,9 +75,9 @@ func CmdDiscover(configFile string) error {
 			deviceName := promptAddDevice(deviceInfo.Name)
 			err = deviceControl.AddOrUpdateDiscoveredDevice(deviceName, deviceInfo.Mac)
 			if err != nil {
-				log.Println("Failed to add/update device!")
+				logging.WithError(err).Errorf("Failed to add/update device!")
 			} else {
-				log.Printf("Device \"%s\" added!\n", deviceName)
+				logging.Infof("Device \"%s\" added!", deviceName)
 			}
 
 			break
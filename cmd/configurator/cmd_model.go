@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/devicecontrol"
+)
+
+// CmdExportModel writes the alexa interaction model generated from the configuration to the file or to stdout,
+// so it can be pasted to the JSON editor of the alexa developer console. The slot values are localized if the locale
+// is set.
+func CmdExportModel(configFile string, outputFile string, invocationName string, locale string) error {
+	config, err := devicecontrol.NewConfiguration(configFile)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(config.ExportInteractionModel(invocationName, locale), "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if outputFile == "" {
+		_, err = fmt.Fprintln(os.Stdout, string(data))
+
+		return err
+	}
+
+	err = ioutil.WriteFile(outputFile, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Interaction model saved to %s\n", outputFile)
+
+	return nil
+}
+
+// CmdImportModel replaces the intents of the configuration with the intents of the interaction model file exported
+// from the alexa developer console, the localized values of the model of the locale are merged into the slot values
+func CmdImportModel(configFile string, inputFile string, locale string) error {
+	config, err := devicecontrol.NewConfiguration(configFile)
+	if err != nil {
+		return err
+	}
+
+	model, err := alexakit.LoadInteractionModel(inputFile)
+	if err != nil {
+		return err
+	}
+
+	imported, err := config.ImportInteractionModel(model, locale)
+	if err != nil {
+		return err
+	}
+
+	err = config.SaveConfiguration(configFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d intents imported, configuration saved\n", imported)
+
+	return nil
+}
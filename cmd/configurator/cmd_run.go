@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"smh-apiengine/pkg/devicecontrol"
 )
@@ -75,7 +76,7 @@ func execCommandById(dc *devicecontrol.DeviceControl, config *devicecontrol.Conf
 		return errors.New("no command found")
 	}
 
-	return dc.ExecCommand(command)
+	return dc.ExecCommand(context.Background(), command)
 }
 
 func execScenarioById(dc *devicecontrol.DeviceControl, config *devicecontrol.Config, scId string) error {
@@ -85,5 +86,5 @@ func execScenarioById(dc *devicecontrol.DeviceControl, config *devicecontrol.Con
 		return errors.New("no scenario found")
 	}
 
-	return dc.ExecScenario(scenario)
+	return dc.ExecScenario(context.Background(), scenario)
 }
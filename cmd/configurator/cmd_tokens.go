@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"smh-apiengine/pkg/auth"
+	"smh-apiengine/pkg/devicecontrol"
+	"strings"
+	"time"
+)
+
+func CmdAddToken(configFile string, tokensFile string) error {
+	config, err := devicecontrol.NewConfiguration(configFile)
+	if err != nil {
+		return err
+	}
+
+	store, err := auth.NewTokenStore(tokensFile)
+	if err != nil {
+		return err
+	}
+
+	name, err := promptEnterName("token (e.g. kids tablet, phone)")
+	if err != nil {
+		return err
+	}
+
+	var scopes []string
+
+	for {
+		choices := []string{"Finish"}
+
+		for _, scope := range auth.AllScopes {
+			if !containsString(scopes, scope) {
+				choices = append(choices, scope)
+			}
+		}
+
+		scope, err := selectSimplePrompt(
+			fmt.Sprintf("Select scope to grant (granted: %s)", strings.Join(scopes, ", ")),
+			choices)
+		if err != nil {
+			return err
+		}
+
+		if scope == "Finish" {
+			break
+		}
+
+		scopes = append(scopes, scope)
+	}
+
+	if len(scopes) == 0 {
+		return errors.New("at least one scope must be granted")
+	}
+
+	var controls []string
+
+	if containsString(scopes, auth.ScopeRunControls) && !containsString(scopes, auth.ScopeRunCommands) {
+		controls, err = selectTokenControls(&config)
+		if err != nil {
+			return err
+		}
+	}
+
+	days, err := promptEnterInt("expiry in days (0 for never)")
+	if err != nil {
+		return err
+	}
+
+	secret, err := store.Add(name, scopes, controls, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	err = store.Save()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Token \"%s\" created. It is shown only once, please copy it now:\n\n%s\n\n", name, secret)
+
+	return nil
+}
+
+func CmdListTokens(tokensFile string) error {
+	store, err := auth.NewTokenStore(tokensFile)
+	if err != nil {
+		return err
+	}
+
+	tokens := store.List()
+
+	if len(tokens) == 0 {
+		fmt.Println("No tokens found!")
+
+		return nil
+	}
+
+	for _, token := range tokens {
+		expires := "never"
+
+		if token.ExpiresAt != nil {
+			expires = token.ExpiresAt.Format(time.RFC3339)
+
+			if token.Expired(time.Now()) {
+				expires += " (expired)"
+			}
+		}
+
+		fmt.Printf("\U000027A4  %s (scopes: %s, expires: %s)\n", token.Name, strings.Join(token.Scopes, ", "), expires)
+
+		if len(token.Controls) > 0 {
+			fmt.Printf("    controls: %s\n", strings.Join(token.Controls, ", "))
+		}
+	}
+
+	return nil
+}
+
+func CmdRevokeToken(tokensFile string) error {
+	store, err := auth.NewTokenStore(tokensFile)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+
+	for _, token := range store.List() {
+		names = append(names, token.Name)
+	}
+
+	if len(names) == 0 {
+		fmt.Println("No tokens found!")
+
+		return nil
+	}
+
+	name, err := selectSimplePrompt("Select token to revoke", append(names, "Exit"))
+	if err != nil {
+		return err
+	}
+
+	if name == "Exit" {
+		return nil
+	}
+
+	err = store.Revoke(name)
+	if err != nil {
+		return err
+	}
+
+	err = store.Save()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Token \"%s\" revoked\n", name)
+
+	return nil
+}
+
+func selectTokenControls(config *devicecontrol.Config) ([]string, error) {
+	var controls []string
+
+	for {
+		choices := []string{"All controls", "Finish"}
+		ids := map[string]string{}
+
+		for _, control := range config.Controls {
+			if containsString(controls, control.ID) {
+				continue
+			}
+
+			label := fmt.Sprintf("%s (%s)", control.Name, control.ID)
+			ids[label] = control.ID
+			choices = append(choices, label)
+		}
+
+		choice, err := selectSimplePrompt("Select control the token may run", choices)
+		if err != nil {
+			return nil, err
+		}
+
+		switch choice {
+		case "All controls":
+			return nil, nil
+		case "Finish":
+			return controls, nil
+		}
+
+		controls = append(controls, ids[choice])
+	}
+}
+
+func containsString(arr []string, val string) bool {
+	for _, item := range arr {
+		if item == val {
+			return true
+		}
+	}
+
+	return false
+}
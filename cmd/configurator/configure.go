@@ -3,14 +3,15 @@ package main
 import (
 	"fmt"
 	"github.com/urfave/cli/v2"
-	"log"
 	"os"
 	"path"
+	"smh-apiengine/pkg/logging"
 )
 
 func main() {
-	var logFile string
+	var logConfig logging.Config
 	var configFile string
+	var tokensFile string
 
 	execName, err := os.Executable()
 
@@ -31,7 +32,7 @@ func main() {
 			&cli.StringFlag{
 				Name:        "log",
 				Usage:       "Log file for logs output",
-				Destination: &logFile,
+				Destination: &logConfig.File,
 				Aliases:     []string{"l"},
 			},
 			&cli.PathFlag{
@@ -42,6 +43,13 @@ func main() {
 				EnvVars:	 []string{"SMH_CONFIG"},
 				Required:    true,
 			},
+			&cli.PathFlag{
+				Name:        "tokens",
+				Value:       "tokens.json",
+				Usage:       "Path to JSON file with named api tokens",
+				Destination: &tokensFile,
+				EnvVars:	 []string{"SMH_TOKENS"},
+			},
 		},
 		Commands: []*cli.Command{
 			{
@@ -72,6 +80,72 @@ func main() {
 					return CmdAddControls(configFile)
 				},
 			},
+			{
+				Name:        "add_token",
+				Usage:       "Adds named api token with scopes",
+				Action: func(c *cli.Context) error {
+					return CmdAddToken(configFile, tokensFile)
+				},
+			},
+			{
+				Name:        "list_tokens",
+				Usage:       "Lists named api tokens",
+				Action: func(c *cli.Context) error {
+					return CmdListTokens(tokensFile)
+				},
+			},
+			{
+				Name:        "revoke_token",
+				Usage:       "Revokes named api token",
+				Action: func(c *cli.Context) error {
+					return CmdRevokeToken(tokensFile)
+				},
+			},
+			{
+				Name:        "export_model",
+				Usage:       "Exports alexa interaction model generated from the intents, commands and scenarios",
+				Flags: []cli.Flag{
+					&cli.PathFlag{
+						Name:    "output",
+						Usage:   "Path to the interaction model JSON file (stdout if not set)",
+						Aliases: []string{"o"},
+					},
+					&cli.StringFlag{
+						Name:    "invocation",
+						Value:   "smart home",
+						Usage:   "Skill invocation name",
+						Aliases: []string{"i"},
+					},
+					&cli.StringFlag{
+						Name:    "locale",
+						Usage:   "Locale of the model, e.g. de-DE (the configured slot values if not set)",
+						Aliases: []string{"l"},
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return CmdExportModel(configFile, c.Path("output"), c.String("invocation"), c.String("locale"))
+				},
+			},
+			{
+				Name:        "import_model",
+				Usage:       "Imports intents, slots and synonyms from alexa interaction model",
+				Flags: []cli.Flag{
+					&cli.PathFlag{
+						Name:     "input",
+						Usage:    "Path to the interaction model JSON file",
+						Aliases:  []string{"f"},
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:    "locale",
+						Usage:   "Locale of the model, e.g. de-DE (the configured slot values if not set)",
+						Aliases: []string{"l"},
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return CmdImportModel(configFile, c.Path("input"), c.String("locale"))
+				},
+			},
 			{
 				Name:        "run",
 				Usage:       "Runs command or scenario",
@@ -81,30 +155,14 @@ func main() {
 			},
 		},
 		Before: func(context *cli.Context) error {
-			if logFile != "" {
-				err := setLogOutputToFile(logFile)
-				if err != nil {
-					log.Println("Failed to set the log output to file: ", err)
-				}
-			}
-
-			return nil
+			return logging.Setup(logConfig)
 		},
 	}
 
-	err = app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
+	app.Flags = append(app.Flags, logging.CliFlags(&logConfig, "SMH_CONFIGURATOR_")...)
 
-func setLogOutputToFile(fileName string) error {
-	logFile, err := os.Create(fileName)
+	err = app.Run(os.Args)
 	if err != nil {
-		return err
+		logging.Fatalf("%s", err)
 	}
-
-	log.SetOutput(logFile)
-
-	return nil
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"smh-apiengine/pkg/broker"
+	"smh-apiengine/pkg/queue"
+	"text/tabwriter"
+	"time"
+)
+
+// CmdListDeadLetters prints the dead-lettered messages, they are kept by the broker
+func CmdListDeadLetters(brokerConfig *broker.Config, limit int, withBody bool) error {
+	deadLetters, err := openDeadLetters(brokerConfig)
+	if err != nil {
+		return err
+	}
+
+	letters, err := deadLetters.DeadLetters(limit)
+	if err != nil {
+		return err
+	}
+
+	if len(letters) == 0 {
+		fmt.Println("No dead-lettered messages")
+
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "DEAD AT\tATTEMPTS\tTYPE\tID\tREQUEST ID\tERROR")
+
+	for _, letter := range letters {
+		_, _ = fmt.Fprintf(writer, "%s\t%d\t%s\t%s\t%s\t%s\n", letter.DeadAt.Local().Format(time.RFC3339),
+			letter.Attempts, letter.Type, letter.ID, letter.RequestID, letter.Error)
+
+		if withBody {
+			_, _ = fmt.Fprintf(writer, "\t\t\t\t%s\t\n", letter.Body)
+		}
+	}
+
+	return writer.Flush()
+}
+
+// CmdReplayDeadLetters publishes the dead-lettered messages with the message ids or the alexa request ids or all the
+// messages to the broker
+func CmdReplayDeadLetters(brokerConfig *broker.Config, ids []string, all bool) error {
+	if !all && len(ids) == 0 {
+		return errors.New("either --id or --all is required")
+	}
+
+	deadLetters, err := openDeadLetters(brokerConfig)
+	if err != nil {
+		return err
+	}
+
+	selected := map[string]bool{}
+
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	replayed, err := deadLetters.ReplayDeadLetters(func(letter queue.DeadLetter) bool {
+		return all || selected[letter.ID] || (letter.RequestID != "" && selected[letter.RequestID])
+	})
+
+	fmt.Printf("%d message(s) replayed\n", replayed)
+
+	return err
+}
+
+// CmdPurgeDeadLetters removes all the dead-lettered messages
+func CmdPurgeDeadLetters(brokerConfig *broker.Config) error {
+	deadLetters, err := openDeadLetters(brokerConfig)
+	if err != nil {
+		return err
+	}
+
+	purged, err := deadLetters.PurgeDeadLetters()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d message(s) removed\n", purged)
+
+	return nil
+}
+
+// openDeadLetters opens the broker keeping the dead letters, the mqtt broker only publishes them to the dead letter
+// topic
+func openDeadLetters(brokerConfig *broker.Config) (broker.DeadLetters, error) {
+	b, err := broker.Open(brokerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters, ok := b.(broker.DeadLetters)
+	if !ok {
+		return nil, fmt.Errorf("the %s broker does not keep the dead letters", brokerConfig.Type)
+	}
+
+	return deadLetters, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+)
+
+// loadConfigFile sets the options from the JSON config file, the keys are the names of the flags, e.g.
+// {"host": "rabbit", "rmq-tls": true, "max-retries": 3}. The options given on the command line or by the environment
+// variables take precedence over the file.
+func loadConfigFile(c *cli.Context, fileName string) error {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+
+	var options map[string]interface{}
+
+	err = decoder.Decode(&options)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", fileName, err)
+	}
+
+	names := make([]string, 0, len(options))
+
+	for name := range options {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !hasFlag(c.App.Flags, name) || name == "config" {
+			return fmt.Errorf("config file %s: unknown option %q", fileName, name)
+		}
+
+		if c.IsSet(name) {
+			continue
+		}
+
+		value, err := optionValue(options[name])
+		if err == nil {
+			err = c.Set(name, value)
+		}
+
+		if err != nil {
+			return fmt.Errorf("config file %s: option %q: %w", fileName, name, err)
+		}
+	}
+
+	return nil
+}
+
+func hasFlag(flags []cli.Flag, name string) bool {
+	for _, flag := range flags {
+		for _, flagName := range flag.Names() {
+			if flagName == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// optionValue returns the value of the option as it would be given on the command line
+func optionValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+
+	return "", fmt.Errorf("the value must be a string, a number or a boolean")
+}
@@ -1,19 +1,27 @@
 package main
 
 import (
+	"context"
 	"github.com/urfave/cli/v2"
-	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"smh-apiengine/pkg/alexakit"
-	"smh-apiengine/pkg/amqp"
+	"smh-apiengine/pkg/broker"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/metrics"
+	"smh-apiengine/pkg/queue"
+	"syscall"
 )
 
 const apiEndpoint = "http://localhost:8787/run/intent"
 
 func main() {
-	var rmqConfig amqp.Config
-	var logFile string
-	msgHandler := new(amqp.Handler)
+	var brokerConfig broker.Config
+	var logConfig logging.Config
+	var healthAddr string
+	var configFile string
+	msgHandler := new(queue.Handler)
 
 	app := &cli.App{
 		Name: "Smart home RMQ Proxy",
@@ -28,7 +36,7 @@ func main() {
 				Value:       alexakit.RmqHost,
 				EnvVars: 	 []string{alexakit.EnvRmqHost},
 				Usage:       "RabbitMQ Host",
-				Destination: &rmqConfig.Host,
+				Destination: &brokerConfig.AMQP.Host,
 				Aliases:     []string{"t"},
 			},
 			&cli.IntFlag{
@@ -36,7 +44,7 @@ func main() {
 				Value:       alexakit.RmqPort,
 				EnvVars: 	 []string{alexakit.EnvRmqPort},
 				Usage:       "RabbitMQ Host",
-				Destination: &rmqConfig.Port,
+				Destination: &brokerConfig.AMQP.Port,
 				Aliases:     []string{"p"},
 			},
 			&cli.StringFlag{
@@ -44,7 +52,7 @@ func main() {
 				Value:       alexakit.RmqLogin,
 				EnvVars: 	 []string{alexakit.EnvRmqLogin},
 				Usage:       "RabbitMQ Login",
-				Destination: &rmqConfig.Login,
+				Destination: &brokerConfig.AMQP.Login,
 				Aliases:     []string{"l"},
 			},
 			&cli.StringFlag{
@@ -52,7 +60,7 @@ func main() {
 				Value:       alexakit.RmqPassword,
 				EnvVars: 	 []string{alexakit.EnvRmqPassword},
 				Usage:       "RabbitMQ Password",
-				Destination: &rmqConfig.Password,
+				Destination: &brokerConfig.AMQP.Password,
 				Aliases:     []string{"s"},
 			},
 			&cli.StringFlag{
@@ -60,7 +68,7 @@ func main() {
 				Value:       alexakit.RmqExchange,
 				EnvVars: 	 []string{alexakit.EnvRmqExchange},
 				Usage:       "RabbitMQ Exchange name",
-				Destination: &rmqConfig.Exchange,
+				Destination: &brokerConfig.AMQP.Exchange,
 				Aliases:     []string{"e"},
 			},
 			&cli.StringFlag{
@@ -68,7 +76,7 @@ func main() {
 				Value:       alexakit.RmqQueue,
 				EnvVars: 	 []string{alexakit.EnvRmqQueue},
 				Usage:       "RabbitMQ Queue name",
-				Destination: &rmqConfig.Queue,
+				Destination: &brokerConfig.AMQP.Queue,
 				Aliases:     []string{"q"},
 			},
 			&cli.StringFlag{
@@ -76,54 +84,192 @@ func main() {
 				Value:       alexakit.RmqRoutingKey,
 				EnvVars: 	 []string{alexakit.EnvRmqRoutingKey},
 				Usage:       "RabbitMQ Queue name",
-				Destination: &rmqConfig.RoutingKey,
+				Destination: &brokerConfig.AMQP.RoutingKey,
 				Aliases:     []string{"r"},
 			},
+			&cli.IntFlag{
+				Name:        "max-retries",
+				Value:       alexakit.RmqMaxRetries,
+				EnvVars: 	 []string{alexakit.EnvRmqMaxRetries},
+				Usage:       "How many times the message is posted again if the endpoint fails",
+				Destination: &brokerConfig.MaxRetries,
+			},
+			&cli.DurationFlag{
+				Name:        "retry-delay",
+				Value:       alexakit.RmqRetryDelay,
+				EnvVars: 	 []string{alexakit.EnvRmqRetryDelay},
+				Usage:       "Delay before the message is posted again",
+				Destination: &brokerConfig.RetryDelay,
+			},
+			&cli.DurationFlag{
+				Name:        "dedup-window",
+				Value:       alexakit.RmqDedupWindow,
+				EnvVars: 	 []string{alexakit.EnvRmqDedupWindow},
+				Usage:       "How long the posted messages are remembered to drop their duplicates",
+				Destination: &brokerConfig.DedupWindow,
+			},
+			&cli.DurationFlag{
+				Name:        "max-age",
+				Value:       alexakit.RmqMaxMessageAge,
+				EnvVars: 	 []string{alexakit.EnvRmqMaxMessageAge},
+				Usage:       "The older messages are dropped instead of being posted",
+				Destination: &brokerConfig.MaxMessageAge,
+			},
+			&cli.StringFlag{
+				Name:        "dlx",
+				EnvVars: 	 []string{alexakit.EnvRmqDeadLetterExchange},
+				Usage:       "RabbitMQ Exchange for the messages that could not be posted (default: \"<exchange>.dead\")",
+				Destination: &brokerConfig.AMQP.DeadLetterExchange,
+			},
+			&cli.StringFlag{
+				Name:        "dlq",
+				EnvVars: 	 []string{alexakit.EnvRmqDeadLetterQueue},
+				Usage:       "RabbitMQ Queue keeping the messages that could not be posted (default: \"<queue>.dead\")",
+				Destination: &brokerConfig.AMQP.DeadLetterQueue,
+			},
 			&cli.StringFlag{
 				Name:        "endpoint",
 				Value:       apiEndpoint,
-				Usage:       "Endpoint where to post the payload using POST method",
+				Usage:       "Endpoint where to post the alexa requests using POST method, the other messages are posted to its server",
 				Destination: &msgHandler.EndPoint,
 				Aliases:     []string{"u"},
 			},
+			&cli.StringFlag{
+				Name:        "token",
+				EnvVars: 	 []string{"SMH_PROXY_API_TOKEN"},
+				Usage:       "Token sent to the endpoint as the bearer token, required if the web server checks the tokens",
+				Destination: &msgHandler.Token,
+			},
+			&cli.StringFlag{
+				Name:        "config",
+				EnvVars: 	 []string{"SMH_PROXY_CONFIG"},
+				Usage:       "JSON file with the options by their names, the command line and the environment take precedence",
+				Destination: &configFile,
+				Aliases:     []string{"c"},
+			},
+			&cli.StringFlag{
+				Name:        "health",
+				EnvVars: 	 []string{"SMH_PROXY_HEALTH_ADDR"},
+				Usage:       "Address of the health check endpoint /healthz reporting the RMQ connection and /metrics, e.g. :8788 (disabled if not set)",
+				Destination: &healthAddr,
+			},
 			&cli.StringFlag{
 				Name:        "log",
 				Usage:       "Log file for logs output",
-				Destination: &logFile,
+				Destination: &logConfig.File,
 			},
 		},
-		Action: func(context *cli.Context) error {
-			rmqProc := amqp.NewRmq(&rmqConfig)
-			rmqProc.Consume(msgHandler)
+		Action: func(c *cli.Context) error {
+			consumer, err := broker.Open(&brokerConfig)
+			if err != nil {
+				return err
+			}
+
+			if healthAddr != "" {
+				go serveHealth(healthAddr, consumer)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go func() {
+				signals := make(chan os.Signal, 1)
+				signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+				logging.Infof("Received %s, stopping the consumer", <-signals)
+				cancel()
+			}()
+
+			consumer.Consume(ctx, msgHandler)
 
 			return nil
 		},
+		Commands: []*cli.Command{
+			{
+				Name:  "dead-letters",
+				Usage: "Inspects and replays the messages that could not be posted to the endpoint",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "Lists the dead-lettered messages",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "limit",
+								Value: 50,
+								Usage: "Max number of the listed messages (all if zero)",
+							},
+							&cli.BoolFlag{
+								Name:  "body",
+								Usage: "Print the message bodies",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							return CmdListDeadLetters(&brokerConfig, c.Int("limit"), c.Bool("body"))
+						},
+					},
+					{
+						Name:  "replay",
+						Usage: "Publishes the dead-lettered messages to the exchange again",
+						Flags: []cli.Flag{
+							&cli.StringSliceFlag{
+								Name:    "id",
+								Usage:   "Id of the message or the alexa request to replay, can be repeated",
+								Aliases: []string{"request-id"},
+							},
+							&cli.BoolFlag{
+								Name:  "all",
+								Usage: "Replay all the messages",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							return CmdReplayDeadLetters(&brokerConfig, c.StringSlice("id"), c.Bool("all"))
+						},
+					},
+					{
+						Name:  "purge",
+						Usage: "Removes all the dead-lettered messages",
+						Action: func(c *cli.Context) error {
+							return CmdPurgeDeadLetters(&brokerConfig)
+						},
+					},
+				},
+			},
+		},
 		Before: func(context *cli.Context) error {
-			if logFile != "" {
-				err := setLogOutputToFile(logFile)
+			if configFile != "" {
+				err := loadConfigFile(context, configFile)
 				if err != nil {
-					log.Println("Failed to set the log output to file: ", err)
+					return err
 				}
 			}
 
-			return nil
+			return logging.Setup(logConfig)
 		},
 	}
 
+	app.Flags = append(app.Flags, broker.CliFlags(&brokerConfig, alexakit.EnvPrefix)...)
+	app.Flags = append(app.Flags, logging.CliFlags(&logConfig, "SMH_PROXY_")...)
+
 	err := app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatalf("%s", err)
 	}
 }
 
-func setLogOutputToFile(fileName string) error {
-	logFile, err := os.Create(fileName)
+// serveHealth serves the connection state of the consumer, so the supervisor (e.g. docker or systemd watchdog
+// script) can restart the consumer that can not reconnect, and the metrics of the consumed messages
+func serveHealth(addr string, consumer broker.Broker) {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", queue.HealthHandler(consumer.Status))
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		_, err := metrics.Default.WriteTo(w)
+		if err != nil {
+			logging.WithError(err).Errorf("Failed to write the metrics")
+		}
+	})
 
+	err := http.ListenAndServe(addr, mux)
 	if err != nil {
-		return err
+		logging.WithError(err).Errorf("Failed to serve the health check")
 	}
-
-	log.SetOutput(logFile)
-
-	return nil
 }
@@ -3,12 +3,12 @@ package main
 import (
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path"
 	"smh-apiengine/pkg/alexakit"
-	"smh-apiengine/pkg/amqp"
+	"smh-apiengine/pkg/broker"
 	"smh-apiengine/pkg/directpublisher"
+	"smh-apiengine/pkg/logging"
 	"smh-apiengine/pkg/webserver"
 	"time"
 
@@ -21,12 +21,14 @@ const (
 	defaultPort     = 8844
 	defaultStartRetires = 5
 	defaultStartRetryInterval = 3 // seconds
+	// publisherSource is the source of the published messages
+	publisherSource = "publisher-direct"
 )
 
 func main() {
-	var logFile string
+	var logConfig logging.Config
 	var srvConfig webserver.ServerConfig
-	var rmqConfig amqp.Config
+	var brokerConfig broker.Config
 
 	execName, err := os.Executable()
 
@@ -44,7 +46,7 @@ func main() {
 			&cli.StringFlag{
 				Name:        "log",
 				Usage:       "Log file for logs output",
-				Destination: &logFile,
+				Destination: &logConfig.File,
 				Aliases:     []string{"l"},
 				EnvVars:	 []string{"RMQ_DIRECT_PUBLISHER_LOG_FILE"},
 			},
@@ -90,7 +92,7 @@ func main() {
 				Name:        "rmqhost",
 				Value:       alexakit.RmqHost,
 				Usage:       "RabbitMQ Host",
-				Destination: &rmqConfig.Host,
+				Destination: &brokerConfig.AMQP.Host,
 				Aliases:     []string{"t"},
 				EnvVars:     []string{alexakit.EnvRmqHost},
 			},
@@ -98,7 +100,7 @@ func main() {
 				Name:        "rmqport",
 				Value:       alexakit.RmqPort,
 				Usage:       "RabbitMQ Port",
-				Destination: &rmqConfig.Port,
+				Destination: &brokerConfig.AMQP.Port,
 				Aliases:     []string{"o"},
 				EnvVars:     []string{alexakit.EnvRmqPort},
 			},
@@ -106,7 +108,7 @@ func main() {
 				Name:        "rmqlogin",
 				Value:       alexakit.RmqLogin,
 				Usage:       "RabbitMQ Login",
-				Destination: &rmqConfig.Login,
+				Destination: &brokerConfig.AMQP.Login,
 				Aliases:     []string{"i"},
 				EnvVars:	 []string{alexakit.EnvRmqLogin},
 			},
@@ -114,7 +116,7 @@ func main() {
 				Name:        "rmqpassword",
 				Value:       alexakit.RmqPassword,
 				Usage:       "RabbitMQ Password",
-				Destination: &rmqConfig.Password,
+				Destination: &brokerConfig.AMQP.Password,
 				Aliases:     []string{"s"},
 				EnvVars:	 []string{alexakit.EnvRmqPassword},
 			},
@@ -122,7 +124,7 @@ func main() {
 				Name:        "rmqexchange",
 				Value:       alexakit.RmqExchange,
 				Usage:       "RabbitMQ Exchange name",
-				Destination: &rmqConfig.Exchange,
+				Destination: &brokerConfig.AMQP.Exchange,
 				Aliases:     []string{"e"},
 				EnvVars:	 []string{alexakit.EnvRmqExchange},
 			},
@@ -130,7 +132,7 @@ func main() {
 				Name:        "rmqqueue",
 				Value:       alexakit.RmqQueue,
 				Usage:       "RabbitMQ Queue name",
-				Destination: &rmqConfig.Queue,
+				Destination: &brokerConfig.AMQP.Queue,
 				Aliases:     []string{"q"},
 				EnvVars:	 []string{alexakit.EnvRmqQueue},
 			},
@@ -138,39 +140,79 @@ func main() {
 				Name:        "rmqrkey",
 				Value:       alexakit.RmqRoutingKey,
 				Usage:       "RabbitMQ Routing key",
-				Destination: &rmqConfig.RoutingKey,
+				Destination: &brokerConfig.AMQP.RoutingKey,
 				Aliases:     []string{"n"},
 				EnvVars:	 []string{alexakit.EnvRmqRoutingKey},
 			},
+			&cli.DurationFlag{
+				Name:        "reply-timeout",
+				Value:       alexakit.RmqReplyTimeout,
+				Usage:       "How long to wait for the execution outcome before answering \"Ok.\", 0 disables waiting",
+				Destination: &brokerConfig.ReplyTimeout,
+				EnvVars:	 []string{alexakit.EnvRmqReplyTimeout},
+			},
+			&cli.IntFlag{
+				Name:        "buffer-size",
+				Value:       alexakit.RmqBufferSize,
+				Usage:       "Max number of the requests buffered while RabbitMQ is not available",
+				Destination: &brokerConfig.AMQP.BufferSize,
+				EnvVars:	 []string{alexakit.EnvRmqBufferSize},
+			},
+			&cli.DurationFlag{
+				Name:        "message-ttl",
+				Value:       alexakit.RmqMessageTTL,
+				Usage:       "Expiration of the published requests",
+				Destination: &brokerConfig.MessageTTL,
+				EnvVars:	 []string{alexakit.EnvRmqMessageTTL},
+			},
+			&cli.BoolFlag{
+				Name:        "alexa-verify",
+				Value:       true,
+				Usage:       "Verify the signature, timestamp and application id of the alexa requests",
+				Destination: &srvConfig.Alexa.Enabled,
+				EnvVars:	 []string{"RMQ_DIRECT_PUBLISHER_ALEXA_VERIFY"},
+			},
+			&cli.StringSliceFlag{
+				Name:        "alexa-app-id",
+				Usage:       "Allowed alexa skill application id, can be repeated (all skills are allowed if not set)",
+				EnvVars:	 []string{"RMQ_DIRECT_PUBLISHER_ALEXA_APP_IDS"},
+			},
 		},
 		Action: func(c *cli.Context) error {
-			if logFile != "" {
-				err := setLogOutputToFile(logFile)
-				if err != nil {
-					log.Println("Failed to set the log output to file: ", err)
-				}
+			err := logging.Setup(logConfig)
+			if err != nil {
+				return err
 			}
 
-			return runServer(&srvConfig, &rmqConfig)
+			srvConfig.Alexa.ApplicationIDs = c.StringSlice("alexa-app-id")
+			brokerConfig.Source = publisherSource
+
+			return runServer(&srvConfig, &brokerConfig)
 		},
 	}
 
+	app.Flags = append(app.Flags, broker.CliFlags(&brokerConfig, alexakit.EnvPrefix)...)
+	app.Flags = append(app.Flags, logging.CliFlags(&logConfig, "RMQ_DIRECT_PUBLISHER_")...)
+
 	err = app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatalf("%s", err)
 	}
 }
 
-func runServer(serverConfig *webserver.ServerConfig, rmqConfig *amqp.Config) error {
+func runServer(serverConfig *webserver.ServerConfig, brokerConfig *broker.Config) error {
 	if serverConfig.Protocol == "https" {
 		if serverConfig.TLSCert == "" || serverConfig.TLSKey == "" {
 			return errors.New("TLS Certificate and Key files are required when using https protocol")
 		}
 	}
 
-	directPublisher := directpublisher.NewDirectPublisher(rmqConfig)
+	directPublisher, err := directpublisher.NewDirectPublisher(brokerConfig, serverConfig.Alexa)
+	if err != nil {
+		return err
+	}
+
 	server := webserver.NewServer(serverConfig, directPublisher)
-	var err error
 
 	for i := 0; i < defaultStartRetires; i++ {
 		if serverConfig.Protocol == "https" {
@@ -180,22 +222,10 @@ func runServer(serverConfig *webserver.ServerConfig, rmqConfig *amqp.Config) err
 		}
 
 		if err != nil {
-			log.Printf("Failed: %s; attempt %d of %d ...\n", err.Error(), i + 1, defaultStartRetires)
+			logging.WithError(err).Warnf("Failed to serve, attempt %d of %d ...", i + 1, defaultStartRetires)
 			time.Sleep(time.Second * defaultStartRetryInterval)
 		}
 	}
 
 	return nil
 }
-
-func setLogOutputToFile(fileName string) error {
-	logFile, err := os.Create(fileName)
-	if err != nil {
-		return err
-	}
-
-	log.SetOutput(logFile)
-
-	return nil
-}
-
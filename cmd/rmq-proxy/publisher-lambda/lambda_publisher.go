@@ -1,32 +1,79 @@
 package main
 
 import (
+	"encoding/json"
+	"os"
 	"smh-apiengine/pkg/alexakit"
-	"smh-apiengine/pkg/amqp"
+	"smh-apiengine/pkg/broker"
+	"smh-apiengine/pkg/directpublisher"
+	"smh-apiengine/pkg/logging"
 
 	"github.com/aws/aws-lambda-go/lambda"
 )
 
-func HandleLambdaEvent(alexaRequest alexakit.AlexaRequest) (alexakit.AlexaResponse, error) {
-	payload, err := alexaRequest.ToJson()
+// publisherSource is the source of the published messages
+const publisherSource = "publisher-lambda"
+
+// rmqConfig and publisher are kept between the invocations of the warm lambda, so the connection to the broker is
+// reused instead of connecting for every request
+var (
+	rmqConfig = directpublisher.NewConfigFromEnv()
+	publisher broker.Broker
+)
+
+// HandleLambdaEvent handles both the custom skill requests and the smart home skill api directives, which have
+// different structure, so the event is parsed here
+func HandleLambdaEvent(event json.RawMessage) (interface{}, error) {
+	if alexakit.IsSmartHomeRequest(event) {
+		return directpublisher.PublishSmartHomeDirective(publisher, event, rmqConfig.ReplyTimeout)
+	}
+
+	var alexaRequest alexakit.AlexaRequest
 
+	err := json.Unmarshal(event, &alexaRequest)
 	if err != nil {
 		return alexakit.NewPlainTextSpeechResponse(alexakit.SpeechTextFailed), err
 	}
 
-	rmqConfig := alexakit.NewConfigFromEnv()
-	rmq := amqp.NewRmq(rmqConfig)
+	return handleAlexaRequest(alexaRequest)
+}
+
+func handleAlexaRequest(alexaRequest alexakit.AlexaRequest) (alexakit.AlexaResponse, error) {
+	if response, ok := alexakit.StandardResponse(alexaRequest); ok {
+		return response, nil
+	}
+
+	if alexaRequest.Request.Type == alexakit.RequestTypeCanFulfillIntent {
+		return alexakit.CanFulfillAnswer(alexaRequest), nil
+	}
+
+	payload, err := alexaRequest.ToJson()
 
-	err = rmq.Publish(payload)
 	if err != nil {
-		return alexakit.NewPlainTextSpeechResponse(alexakit.SpeechTextFailed), err
+		return alexakit.NewPlainTextSpeechResponse(alexakit.Speech(alexaRequest.Request.Locale).Failed), err
 	}
 
-	speechResponse := alexakit.NewPlainTextSpeechResponse(alexakit.SpeechTextConfirmation)
+	response, err := directpublisher.PublishRequest(publisher, payload, rmqConfig.ReplyTimeout)
+	if err != nil {
+		logging.WithError(err).Errorf("Failed to publish the payload")
+	}
 
-	return speechResponse, nil
+	return response, err
 }
 
 func main() {
+	rmqConfig.Source = publisherSource
+
+	// CloudWatch indexes JSON log entries, so the format is not configurable here
+	err := logging.Setup(logging.Config{JSON: true, Level: os.Getenv("SMH_LOG_LEVEL")})
+	if err != nil {
+		logging.Fatalf("%s", err)
+	}
+
+	publisher, err = broker.Open(rmqConfig)
+	if err != nil {
+		logging.Fatalf("%s", err)
+	}
+
 	lambda.Start(HandleLambdaEvent)
 }
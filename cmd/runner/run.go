@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path"
 	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/history"
+	"smh-apiengine/pkg/logging"
 
 	"github.com/urfave/cli/v2"
 )
@@ -18,7 +20,8 @@ var (
 
 func main() {
 	var configFile string
-	var logFile string
+	var logConfig logging.Config
+	var historyFile string
 
 	execName, err := os.Executable()
 
@@ -44,10 +47,16 @@ func main() {
 			&cli.StringFlag{
 				Name:        "log",
 				Usage:       "Log file for logs output",
-				Destination: &logFile,
+				Destination: &logConfig.File,
 				Aliases:     []string{"l"},
 				EnvVars:	 []string{"SMH_RUNNER_LOG_FILE"},
 			},
+			&cli.StringFlag{
+				Name:        "history",
+				Usage:       "Path to the execution history file shared with the web server",
+				Destination: &historyFile,
+				EnvVars:	 []string{"SMH_HISTORY"},
+			},
 		},
 		Action: func(c *cli.Context) error {
 			if c.NArg() != 2 {
@@ -68,6 +77,17 @@ func main() {
 
 			deviceControl := devicecontrol.NewDeviceControl(&config)
 			id := c.Args().Get(1)
+			ctx := devicecontrol.WithSource(context.Background(), devicecontrol.Source{Type: devicecontrol.SourceCLI})
+
+			if historyFile != "" {
+				// retention is applied by the web server, the runner only appends
+				historyStore, err := history.NewStore(historyFile, history.Retention{})
+				if err != nil {
+					return err
+				}
+
+				deviceControl.Subscribe(historyStore.Listen)
+			}
 
 			switch runType {
 			case "cmd":
@@ -76,43 +96,27 @@ func main() {
 					return errors.New("command not found")
 				}
 
-				return deviceControl.ExecCommandFullCycle(*cmd)
+				return deviceControl.ExecCommandFullCycle(ctx, *cmd)
 			case "scenario":
 				scenario, err := deviceControl.FindScenarioByName(id)
 				if err != nil {
 					return err
 				}
 
-				return deviceControl.ExecScenarioFullCycle(scenario)
+				return deviceControl.ExecScenarioFullCycle(ctx, scenario)
 			}
 
 			return nil
 		},
 		Before: func(context *cli.Context) error {
-			if logFile != "" {
-				err := setLogOutputToFile(logFile)
-				if err != nil {
-					log.Println("Failed to set the log output to file: ", err)
-				}
-			}
-
-			return nil
+			return logging.Setup(logConfig)
 		},
 	}
 
-	err = app.Run(os.Args)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
+	app.Flags = append(app.Flags, logging.CliFlags(&logConfig, "SMH_RUNNER_")...)
 
-func setLogOutputToFile(fileName string) error {
-	logFile, err := os.Create(fileName)
+	err = app.Run(os.Args)
 	if err != nil {
-		return err
+		logging.Fatalf("%s", err)
 	}
-
-	log.SetOutput(logFile)
-
-	return nil
 }
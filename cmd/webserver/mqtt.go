@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"github.com/urfave/cli/v2"
+	"smh-apiengine/pkg/webserver"
+)
+
+// mqttBridgeFlags returns the flags of the MQTT bridge, they are prefixed with mqtt-bridge so they do not clash with
+// the settings of the MQTT broker consumed by --rmq-consume
+func mqttBridgeFlags(config *webserver.MQTTConfig) []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "mqtt-bridge",
+			Usage:       "Publish the states to the MQTT broker and run the commands received from it",
+			Destination: &config.Enabled,
+			EnvVars:     []string{"SMH_SERVER_MQTT_BRIDGE"},
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-bridge-address",
+			Value:       webserver.DefaultMQTTAddress,
+			Usage:       "MQTT broker host:port of the bridge",
+			Destination: &config.Address,
+			EnvVars:     []string{"SMH_SERVER_MQTT_BRIDGE_ADDRESS"},
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-bridge-client-id",
+			Value:       webserver.DefaultMQTTClientID,
+			Usage:       "MQTT client id of the bridge",
+			Destination: &config.ClientID,
+			EnvVars:     []string{"SMH_SERVER_MQTT_BRIDGE_CLIENT_ID"},
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-bridge-login",
+			Usage:       "MQTT Login of the bridge",
+			Destination: &config.Username,
+			EnvVars:     []string{"SMH_SERVER_MQTT_BRIDGE_LOGIN"},
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-bridge-password",
+			Usage:       "MQTT Password of the bridge",
+			Destination: &config.Password,
+			EnvVars:     []string{"SMH_SERVER_MQTT_BRIDGE_PASSWORD"},
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-bridge-topic",
+			Value:       webserver.DefaultMQTTTopicPrefix,
+			Usage:       "Prefix of the state and command topics",
+			Destination: &config.TopicPrefix,
+			EnvVars:     []string{"SMH_SERVER_MQTT_BRIDGE_TOPIC"},
+		},
+		&cli.BoolFlag{
+			Name:        "mqtt-bridge-discovery",
+			Value:       true,
+			Usage:       "Publish the Home Assistant MQTT discovery of the control items and power switches",
+			Destination: &config.Discovery,
+			EnvVars:     []string{"SMH_SERVER_MQTT_BRIDGE_DISCOVERY"},
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-bridge-discovery-prefix",
+			Value:       webserver.DefaultMQTTDiscoveryPrefix,
+			Usage:       "Home Assistant discovery topic prefix",
+			Destination: &config.DiscoveryPrefix,
+			EnvVars:     []string{"SMH_SERVER_MQTT_BRIDGE_DISCOVERY_PREFIX"},
+		},
+	}
+}
+
+// startMQTTBridge runs the MQTT bridge in the background. Returns the function stopping the bridge, it blocks until
+// the bridge has published that it is offline.
+func startMQTTBridge(config webserver.MQTTConfig, handlers *webserver.ApiRouteHandlers) func() {
+	bridge := webserver.NewMQTTBridge(config, handlers)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		bridge.Run(ctx)
+	}()
+
+	return func() {
+		cancel()
+		<-stopped
+	}
+}
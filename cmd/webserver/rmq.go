@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"github.com/urfave/cli/v2"
+	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/broker"
+	"smh-apiengine/pkg/webserver"
+)
+
+// rmqFlags returns the flags of the in-process consumer, the broker settings share the defaults and environment
+// variables with the rmq-proxy consumer
+func rmqFlags(brokerConfig *broker.Config, consume *bool) []cli.Flag {
+	flags := []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "rmq-consume",
+			Usage:       "Consume the alexa requests from the broker in the server instead of the rmq-proxy consumer",
+			Destination: consume,
+			EnvVars:     []string{"SMH_SERVER_RMQ_CONSUME"},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-host",
+			Value:       alexakit.RmqHost,
+			Usage:       "RabbitMQ Host",
+			Destination: &brokerConfig.AMQP.Host,
+			EnvVars:     []string{alexakit.EnvRmqHost},
+		},
+		&cli.IntFlag{
+			Name:        "rmq-port",
+			Value:       alexakit.RmqPort,
+			Usage:       "RabbitMQ Port",
+			Destination: &brokerConfig.AMQP.Port,
+			EnvVars:     []string{alexakit.EnvRmqPort},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-login",
+			Value:       alexakit.RmqLogin,
+			Usage:       "RabbitMQ Login",
+			Destination: &brokerConfig.AMQP.Login,
+			EnvVars:     []string{alexakit.EnvRmqLogin},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-password",
+			Value:       alexakit.RmqPassword,
+			Usage:       "RabbitMQ Password",
+			Destination: &brokerConfig.AMQP.Password,
+			EnvVars:     []string{alexakit.EnvRmqPassword},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-exchange",
+			Value:       alexakit.RmqExchange,
+			Usage:       "RabbitMQ Exchange name",
+			Destination: &brokerConfig.AMQP.Exchange,
+			EnvVars:     []string{alexakit.EnvRmqExchange},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-queue",
+			Value:       alexakit.RmqQueue,
+			Usage:       "RabbitMQ Queue name",
+			Destination: &brokerConfig.AMQP.Queue,
+			EnvVars:     []string{alexakit.EnvRmqQueue},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-rkey",
+			Value:       alexakit.RmqRoutingKey,
+			Usage:       "RabbitMQ Routing key",
+			Destination: &brokerConfig.AMQP.RoutingKey,
+			EnvVars:     []string{alexakit.EnvRmqRoutingKey},
+		},
+		&cli.IntFlag{
+			Name:        "rmq-max-retries",
+			Value:       alexakit.RmqMaxRetries,
+			Usage:       "How many times the message is handled again if the execution fails",
+			Destination: &brokerConfig.MaxRetries,
+			EnvVars:     []string{alexakit.EnvRmqMaxRetries},
+		},
+		&cli.DurationFlag{
+			Name:        "rmq-retry-delay",
+			Value:       alexakit.RmqRetryDelay,
+			Usage:       "Delay before the message is handled again",
+			Destination: &brokerConfig.RetryDelay,
+			EnvVars:     []string{alexakit.EnvRmqRetryDelay},
+		},
+		&cli.DurationFlag{
+			Name:        "rmq-dedup-window",
+			Value:       alexakit.RmqDedupWindow,
+			Usage:       "How long the handled messages are remembered to drop their duplicates",
+			Destination: &brokerConfig.DedupWindow,
+			EnvVars:     []string{alexakit.EnvRmqDedupWindow},
+		},
+		&cli.DurationFlag{
+			Name:        "rmq-max-age",
+			Value:       alexakit.RmqMaxMessageAge,
+			Usage:       "The older messages are dropped instead of being handled",
+			Destination: &brokerConfig.MaxMessageAge,
+			EnvVars:     []string{alexakit.EnvRmqMaxMessageAge},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-dlx",
+			Usage:       "RabbitMQ Exchange for the messages that could not be handled (default: \"<exchange>.dead\")",
+			Destination: &brokerConfig.AMQP.DeadLetterExchange,
+			EnvVars:     []string{alexakit.EnvRmqDeadLetterExchange},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-dlq",
+			Usage:       "RabbitMQ Queue keeping the messages that could not be handled (default: \"<queue>.dead\")",
+			Destination: &brokerConfig.AMQP.DeadLetterQueue,
+			EnvVars:     []string{alexakit.EnvRmqDeadLetterQueue},
+		},
+	}
+
+	return append(flags, broker.CliFlags(brokerConfig, alexakit.EnvPrefix)...)
+}
+
+// startConsumer consumes the broker queue in the background and dispatches the messages to the api handlers.
+// Returns the function stopping the consumer, it blocks until the message being handled is acknowledged or requeued.
+func startConsumer(brokerConfig *broker.Config, handlers *webserver.ApiRouteHandlers) (func(), error) {
+	consumer, err := broker.Open(brokerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		consumer.Consume(ctx, handlers.RMQHandler())
+	}()
+
+	return func() {
+		cancel()
+		<-stopped
+	}, nil
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/urfave/cli/v2"
-	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"smh-apiengine/pkg/auth"
+	"smh-apiengine/pkg/broker"
 	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/history"
+	"smh-apiengine/pkg/logging"
 	"smh-apiengine/pkg/webserver"
+	"syscall"
 	"time"
 )
 
@@ -18,12 +25,36 @@ const (
 	defaultPort     = 8787
 	defaultStartRetires = 5
 	defaultStartRetryInterval = 3 // seconds
+	defaultTokenRate = 5 // requests per second
+	defaultTokenBurst = 10
+	defaultIPRate = 10 // requests per second
+	defaultIPBurst = 20
+	defaultMaxAuthFailures = 5
+	defaultBanDuration = 15 * time.Minute
+	defaultShutdownTimeout = 30 * time.Second
+	defaultHistoryMaxAge = 30 * 24 * time.Hour
+	defaultHistoryMaxRecords = 10000
 )
 
+const (
+	exitCodeServeFailed = 1
+	exitCodeShutdownFailed = 2
+)
+
+var shutdownTimeout = defaultShutdownTimeout
+
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
 func main() {
 	var configFile string
-	var logFile string
+	var logConfig logging.Config
 	var srvConfig webserver.ServerConfig
+	var historyFile string
+	var historyRetention history.Retention
+	var brokerConfig broker.Config
+	var rmqConsume bool
 
 	execName, err := os.Executable()
 
@@ -51,7 +82,7 @@ func main() {
 			&cli.StringFlag{
 				Name:        "log",
 				Usage:       "Log file for logs output",
-				Destination: &logFile,
+				Destination: &logConfig.File,
 				Aliases:     []string{"l"},
 				EnvVars:	 []string{"SMH_SERVER_LOG_FILE"},
 			},
@@ -100,15 +131,108 @@ func main() {
 				Aliases:     []string{"t"},
 				EnvVars:	 []string{"SMH_SERVER_AUTH_TOKEN"},
 			},
+			&cli.StringFlag{
+				Name:        "tokens",
+				Usage:       "Path to JSON file with named scoped tokens (managed by the configurator)",
+				Destination: &srvConfig.TokensFile,
+				EnvVars:	 []string{"SMH_TOKENS"},
+			},
+			&cli.Float64Flag{
+				Name:        "rate-limit",
+				Value:       defaultTokenRate,
+				Usage:       "Allowed requests per second per token (0 disables the limit)",
+				Destination: &srvConfig.RateLimit.TokenRate,
+				EnvVars:	 []string{"SMH_SERVER_RATE_LIMIT"},
+			},
+			&cli.IntFlag{
+				Name:        "rate-burst",
+				Value:       defaultTokenBurst,
+				Usage:       "Allowed burst of requests per token",
+				Destination: &srvConfig.RateLimit.TokenBurst,
+				EnvVars:	 []string{"SMH_SERVER_RATE_BURST"},
+			},
+			&cli.Float64Flag{
+				Name:        "ip-rate-limit",
+				Value:       defaultIPRate,
+				Usage:       "Allowed requests per second per client ip (0 disables the limit)",
+				Destination: &srvConfig.RateLimit.IPRate,
+				EnvVars:	 []string{"SMH_SERVER_IP_RATE_LIMIT"},
+			},
+			&cli.IntFlag{
+				Name:        "ip-rate-burst",
+				Value:       defaultIPBurst,
+				Usage:       "Allowed burst of requests per client ip",
+				Destination: &srvConfig.RateLimit.IPBurst,
+				EnvVars:	 []string{"SMH_SERVER_IP_RATE_BURST"},
+			},
+			&cli.IntFlag{
+				Name:        "ban-after",
+				Value:       defaultMaxAuthFailures,
+				Usage:       "Failed authentications after which the client ip is banned (0 disables the bans)",
+				Destination: &srvConfig.RateLimit.MaxAuthFailures,
+				EnvVars:	 []string{"SMH_SERVER_BAN_AFTER"},
+			},
+			&cli.DurationFlag{
+				Name:        "ban-duration",
+				Value:       defaultBanDuration,
+				Usage:       "Duration of the client ip ban",
+				Destination: &srvConfig.RateLimit.BanDuration,
+				EnvVars:	 []string{"SMH_SERVER_BAN_DURATION"},
+			},
+			&cli.DurationFlag{
+				Name:        "shutdown-timeout",
+				Value:       defaultShutdownTimeout,
+				Usage:       "Time to wait for running commands and scenarios on shutdown",
+				Destination: &shutdownTimeout,
+				EnvVars:	 []string{"SMH_SERVER_SHUTDOWN_TIMEOUT"},
+			},
+			&cli.StringFlag{
+				Name:        "history",
+				Usage:       "Path to the execution history file (history is not recorded if not set)",
+				Destination: &historyFile,
+				EnvVars:	 []string{"SMH_HISTORY"},
+			},
+			&cli.DurationFlag{
+				Name:        "history-max-age",
+				Value:       defaultHistoryMaxAge,
+				Usage:       "Max age of the history records (0 keeps all the records)",
+				Destination: &historyRetention.MaxAge,
+				EnvVars:	 []string{"SMH_HISTORY_MAX_AGE"},
+			},
+			&cli.IntFlag{
+				Name:        "history-max-records",
+				Value:       defaultHistoryMaxRecords,
+				Usage:       "Max number of the history records (0 keeps all the records)",
+				Destination: &historyRetention.MaxRecords,
+				EnvVars:	 []string{"SMH_HISTORY_MAX_RECORDS"},
+			},
+			&cli.BoolFlag{
+				Name:        "alexa-verify",
+				Usage:       "Verify the signature, timestamp and application id of the alexa requests",
+				Destination: &srvConfig.Alexa.Enabled,
+				EnvVars:	 []string{"SMH_SERVER_ALEXA_VERIFY"},
+			},
+			&cli.StringSliceFlag{
+				Name:        "alexa-app-id",
+				Usage:       "Allowed alexa skill application id, can be repeated (all skills are allowed if not set)",
+				EnvVars:	 []string{"SMH_SERVER_ALEXA_APP_IDS"},
+			},
+			&cli.DurationFlag{
+				Name:        "alexa-response-timeout",
+				Value:       webserver.DefaultAlexaResponseTimeout,
+				Usage:       "How long /run/intent waits for the execution outcome before answering \"Ok.\"",
+				Destination: &srvConfig.AlexaResponseTimeout,
+				EnvVars:	 []string{"SMH_SERVER_ALEXA_RESPONSE_TIMEOUT"},
+			},
 		},
 		Action: func(c *cli.Context) error {
-			if logFile != "" {
-				err := setLogOutputToFile(logFile)
-				if err != nil {
-					log.Println("Failed to set the log output to file: ", err)
-				}
+			err := logging.Setup(logConfig)
+			if err != nil {
+				return err
 			}
 
+			srvConfig.Alexa.ApplicationIDs = c.StringSlice("alexa-app-id")
+
 			config, err := devicecontrol.NewConfiguration(configFile)
 
 			if err != nil {
@@ -117,27 +241,91 @@ func main() {
 
 			deviceControl := devicecontrol.NewDeviceControl(&config)
 
-			return runServer(&srvConfig, &deviceControl)
+			var historyStore *history.Store
+
+			if historyFile != "" {
+				historyStore, err = history.NewStore(historyFile, historyRetention)
+				if err != nil {
+					return err
+				}
+			}
+
+			if !rmqConsume {
+				return runServer(&srvConfig, &deviceControl, historyStore, nil)
+			}
+
+			return runServer(&srvConfig, &deviceControl, historyStore, &brokerConfig)
 		},
 	}
 
+	app.Flags = append(app.Flags, rmqFlags(&brokerConfig, &rmqConsume)...)
+	app.Flags = append(app.Flags, mqttBridgeFlags(&srvConfig.MQTT)...)
+	app.Flags = append(app.Flags, webhookFlags(&srvConfig)...)
+	app.Flags = append(app.Flags, logging.CliFlags(&logConfig, "SMH_SERVER_")...)
+
 	err = app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatalf("%s", err)
 	}
 }
 
-func runServer(serverConfig *webserver.ServerConfig, deviceControl *devicecontrol.DeviceControl) error {
+func runServer(
+	serverConfig *webserver.ServerConfig,
+	deviceControl *devicecontrol.DeviceControl,
+	historyStore *history.Store,
+	brokerConfig *broker.Config) error {
 	if serverConfig.Protocol == "https" {
 		if serverConfig.TLSCert == "" || serverConfig.TLSKey == "" {
 			return errors.New("TLS Certificate and Key files are required when using https protocol")
 		}
 	}
 
-	apiRouteHandlers := webserver.NewApiRouteHandlers(serverConfig, deviceControl)
+	var tokens *auth.TokenStore
+
+	if serverConfig.TokensFile != "" {
+		var err error
+
+		tokens, err = auth.NewTokenStore(serverConfig.TokensFile)
+		if err != nil {
+			return err
+		}
+
+		logging.Infof("Loaded %d named token(s)", len(tokens.List()))
+	}
+
+	webhooks, err := newWebhooks(serverConfig)
+	if err != nil {
+		return err
+	}
+
+	apiRouteHandlers := webserver.NewApiRouteHandlers(serverConfig, deviceControl, tokens, historyStore, webhooks)
 	server := webserver.NewServer(serverConfig, apiRouteHandlers)
+	shutdownResult := make(chan error, 1)
+	stopConsumer := func() {}
+
+	if brokerConfig != nil {
+		stopConsumer, err = startConsumer(brokerConfig, apiRouteHandlers)
+		if err != nil {
+			return err
+		}
+	}
+
+	stopBridge := func() {}
+
+	if serverConfig.MQTT.Enabled {
+		stopBridge = startMQTTBridge(serverConfig.MQTT, apiRouteHandlers)
+	}
+
+	stopWebhooks := func() {}
+
+	if webhooks != nil {
+		stopWebhooks = startWebhooks(webhooks)
+	}
 
-	var err error
+	go func() {
+		shutdownResult <- waitForShutdown(server, apiRouteHandlers, deviceControl, stopConsumer, stopBridge,
+			stopWebhooks)
+	}()
 
 	for i := 0; i < defaultStartRetires; i++ {
 		if serverConfig.Protocol == "https" {
@@ -146,22 +334,69 @@ func runServer(serverConfig *webserver.ServerConfig, deviceControl *devicecontro
 			err = server.ServeHTTP()
 		}
 
-		if err != nil {
-			log.Printf("Failed: %s; attempt %d of %d ...\n", err.Error(), i + 1, defaultStartRetires)
-			time.Sleep(time.Second * defaultStartRetryInterval)
+		if err == http.ErrServerClosed {
+			return <-shutdownResult
 		}
+
+		logging.WithError(err).Warnf("Failed to serve, attempt %d of %d ...", i + 1, defaultStartRetires)
+		time.Sleep(time.Second * defaultStartRetryInterval)
 	}
 
-	return nil
+	return cli.Exit(fmt.Sprintf("server failed to start: %s", err), exitCodeServeFailed)
 }
 
-func setLogOutputToFile(fileName string) error {
-	logFile, err := os.Create(fileName)
+// waitForShutdown blocks until SIGINT or SIGTERM is received, then stops the server from accepting new requests and
+// the consumer from taking new messages, waits for the running commands and scenarios and flushes the configuration. Returns an error with exit code if
+// the shutdown could not be completed within the timeout. The MQTT bridge is stopped after the running executions,
+// so their states are published, then the webhooks are stopped and their undelivered events are dropped.
+func waitForShutdown(
+	server shutdowner,
+	handlers *webserver.ApiRouteHandlers,
+	deviceControl *devicecontrol.DeviceControl,
+	stopConsumer func(),
+	stopBridge func(),
+	stopWebhooks func()) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	signal.Stop(signals)
+
+	logging.Infof("Received %s, shutting down (timeout %s)", sig, shutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	exitCode := 0
+
+	err := server.Shutdown(ctx)
 	if err != nil {
-		return err
+		logging.WithError(err).Errorf("Failed to stop the server gracefully")
+		exitCode = exitCodeShutdownFailed
+	}
+
+	stopConsumer()
+
+	err = handlers.Wait(ctx)
+	if err != nil {
+		logging.WithError(err).Errorf("Running commands and scenarios did not finish in time")
+		exitCode = exitCodeShutdownFailed
+	}
+
+	stopBridge()
+	stopWebhooks()
+
+	err = deviceControl.Close()
+	if err != nil {
+		logging.WithError(err).Errorf("Failed to flush the configuration")
+		exitCode = exitCodeShutdownFailed
+	}
+
+	if exitCode != 0 {
+		return cli.Exit("shutdown was not completed cleanly", exitCode)
 	}
 
-	log.SetOutput(logFile)
+	logging.Infof("Shutdown completed")
 
 	return nil
 }
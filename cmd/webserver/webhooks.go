@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"github.com/urfave/cli/v2"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/webhook"
+	"smh-apiengine/pkg/webserver"
+)
+
+// webhookFlags returns the flags of the outbound webhooks, the webhooks themselves are configured in the file
+func webhookFlags(config *webserver.ServerConfig) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "webhooks",
+			Usage:       "Path to JSON file with the outbound webhooks (events are not posted if not set)",
+			Destination: &config.WebhooksFile,
+			EnvVars:     []string{"SMH_SERVER_WEBHOOKS"},
+		},
+		&cli.IntFlag{
+			Name:        "webhook-max-retries",
+			Value:       webhook.DefaultMaxRetries,
+			Usage:       "How many times the failed webhook delivery is retried",
+			Destination: &config.Webhooks.MaxRetries,
+			EnvVars:     []string{"SMH_SERVER_WEBHOOK_MAX_RETRIES"},
+		},
+		&cli.DurationFlag{
+			Name:        "webhook-retry-delay",
+			Value:       webhook.DefaultRetryDelay,
+			Usage:       "Delay before the first retry of the webhook delivery, doubled for every next retry",
+			Destination: &config.Webhooks.RetryDelay,
+			EnvVars:     []string{"SMH_SERVER_WEBHOOK_RETRY_DELAY"},
+		},
+		&cli.DurationFlag{
+			Name:        "webhook-timeout",
+			Value:       webhook.DefaultTimeout,
+			Usage:       "Timeout of a single webhook delivery attempt",
+			Destination: &config.Webhooks.Timeout,
+			EnvVars:     []string{"SMH_SERVER_WEBHOOK_TIMEOUT"},
+		},
+		&cli.IntFlag{
+			Name:        "webhook-log-size",
+			Value:       webhook.DefaultLogSize,
+			Usage:       "Number of the webhook deliveries kept in the log",
+			Destination: &config.Webhooks.LogSize,
+			EnvVars:     []string{"SMH_SERVER_WEBHOOK_LOG_SIZE"},
+		},
+	}
+}
+
+// newWebhooks loads the webhooks file, returns nil dispatcher if the file is not set
+func newWebhooks(config *webserver.ServerConfig) (*webhook.Dispatcher, error) {
+	if config.WebhooksFile == "" {
+		return nil, nil
+	}
+
+	webhooks, err := webhook.LoadWebhooks(config.WebhooksFile)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Infof("Loaded %d webhook(s)", len(webhooks))
+
+	return webhook.NewDispatcher(webhooks, config.Webhooks), nil
+}
+
+// startWebhooks delivers the events to the webhooks in the background. Returns the function stopping the deliveries,
+// the events still queued or waiting for the retry are not delivered.
+func startWebhooks(dispatcher *webhook.Dispatcher) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		dispatcher.Run(ctx)
+	}()
+
+	return func() {
+		cancel()
+		<-stopped
+	}
+}
@@ -0,0 +1,135 @@
+package alexakit
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// ResponseBuilder builds the alexa response step by step, e.g.
+//
+//	NewResponseBuilder().Speak("Turning on the light").SimpleCard("Smart Home", "Light is on").Build()
+//
+// The session is ended unless a reprompt is set or EndSession(false) is called.
+type ResponseBuilder struct {
+	response AlexaResponse
+}
+
+// NewResponseBuilder creates the builder of the empty response
+func NewResponseBuilder() *ResponseBuilder {
+	return &ResponseBuilder{response: NewEmptyResponse()}
+}
+
+// Speak sets the plain text output speech
+func (b *ResponseBuilder) Speak(text string) *ResponseBuilder {
+	b.response.Response.OutputSpeech = &OutputSpeech{Type: OutputSpeechTypePlainText, Text: text}
+
+	return b
+}
+
+// SpeakSSML sets the SSML output speech, the markup is wrapped into the speak element if it is not
+func (b *ResponseBuilder) SpeakSSML(ssml string) *ResponseBuilder {
+	b.response.Response.OutputSpeech = &OutputSpeech{Type: OutputSpeechTypeSSML, SSML: wrapSSML(ssml)}
+
+	return b
+}
+
+// Reprompt sets the plain text speech used if the user does not answer and keeps the session open
+func (b *ResponseBuilder) Reprompt(text string) *ResponseBuilder {
+	b.response.Response.Reprompt = &Reprompt{OutputSpeech: OutputSpeech{Type: OutputSpeechTypePlainText, Text: text}}
+
+	return b.EndSession(false)
+}
+
+// RepromptSSML sets the SSML speech used if the user does not answer and keeps the session open
+func (b *ResponseBuilder) RepromptSSML(ssml string) *ResponseBuilder {
+	b.response.Response.Reprompt = &Reprompt{OutputSpeech: OutputSpeech{Type: OutputSpeechTypeSSML, SSML: wrapSSML(ssml)}}
+
+	return b.EndSession(false)
+}
+
+// SimpleCard adds the card with the title and the text content to the alexa app
+func (b *ResponseBuilder) SimpleCard(title string, content string) *ResponseBuilder {
+	b.response.Response.Card = &Card{Type: CardTypeSimple, Title: title, Content: content}
+
+	return b
+}
+
+// StandardCard adds the card with the title, text and optional image urls (https only) to the alexa app
+func (b *ResponseBuilder) StandardCard(title string, text string, smallImageURL string, largeImageURL string) *ResponseBuilder {
+	card := &Card{Type: CardTypeStandard, Title: title, Text: text}
+
+	if smallImageURL != "" || largeImageURL != "" {
+		card.Image = &CardImage{SmallImageURL: smallImageURL, LargeImageURL: largeImageURL}
+	}
+
+	b.response.Response.Card = card
+
+	return b
+}
+
+// ElicitSlot asks alexa to get the slot value from the user, the speech should contain the question. The intent is
+// sent back in the next request with the slot filled.
+func (b *ResponseBuilder) ElicitSlot(slotName string, intent Intent) *ResponseBuilder {
+	b.response.Response.Directives = append(b.response.Response.Directives,
+		Directive{Type: DirectiveElicitSlot, SlotToElicit: slotName, UpdatedIntent: &intent})
+
+	return b.EndSession(false)
+}
+
+// ConfirmIntent asks alexa to get the yes or no answer from the user, the speech should contain the question. The
+// answer is sent back in the next request as the intent confirmation status.
+func (b *ResponseBuilder) ConfirmIntent(intent Intent) *ResponseBuilder {
+	intent.ConfirmationStatus = ConfirmationNone
+	b.response.Response.Directives = append(b.response.Response.Directives,
+		Directive{Type: DirectiveConfirmIntent, UpdatedIntent: &intent})
+
+	return b.EndSession(false)
+}
+
+// SessionAttribute sets the attribute, which alexa sends back with the next requests of the session
+func (b *ResponseBuilder) SessionAttribute(key string, value interface{}) *ResponseBuilder {
+	if b.response.SessionAttributes == nil {
+		b.response.SessionAttributes = map[string]interface{}{}
+	}
+
+	b.response.SessionAttributes[key] = value
+
+	return b
+}
+
+// EndSession sets whether the session ends after the response
+func (b *ResponseBuilder) EndSession(end bool) *ResponseBuilder {
+	b.response.Response.ShouldEndSession = boolPtr(end)
+
+	return b
+}
+
+// Build returns the response
+func (b *ResponseBuilder) Build() AlexaResponse {
+	response := b.response
+
+	if response.Response.ShouldEndSession == nil && response.Response.OutputSpeech != nil {
+		response.Response.ShouldEndSession = boolPtr(true)
+	}
+
+	return response
+}
+
+// EscapeSSML escapes the text, so it can be inserted into the SSML markup, e.g. the device names with "&"
+func EscapeSSML(text string) string {
+	var sb strings.Builder
+
+	_ = xml.EscapeText(&sb, []byte(text))
+
+	return sb.String()
+}
+
+func wrapSSML(ssml string) string {
+	trimmed := strings.TrimSpace(ssml)
+
+	if strings.HasPrefix(trimmed, "<speak>") && strings.HasSuffix(trimmed, "</speak>") {
+		return trimmed
+	}
+
+	return "<speak>" + trimmed + "</speak>"
+}
@@ -0,0 +1,32 @@
+package alexakit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ResponseBuilder_Build(t *testing.T) {
+	response := NewResponseBuilder().
+		SpeakSSML("Turning on "+EscapeSSML("R&B speaker")).
+		RepromptSSML("<speak>Anything else?</speak>").
+		StandardCard("Smart Home", "Speaker is on", "https://example.com/s.png", "").
+		Build()
+
+	content, err := response.ToJson()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"version":"1.0","response":{
+		"outputSpeech":{"type":"SSML","ssml":"<speak>Turning on R&amp;B speaker</speak>"},
+		"card":{"type":"Standard","title":"Smart Home","text":"Speaker is on",
+			"image":{"smallImageUrl":"https://example.com/s.png"}},
+		"reprompt":{"outputSpeech":{"type":"SSML","ssml":"<speak>Anything else?</speak>"}},
+		"shouldEndSession":false}}`, content)
+}
+
+func Test_ResponseBuilder_EndsSessionByDefault(t *testing.T) {
+	response := NewResponseBuilder().Speak("Ok.").SimpleCard("Smart Home", "Done").Build()
+
+	assert.Equal(t, true, *response.Response.ShouldEndSession)
+	assert.Equal(t, CardTypeSimple, response.Response.Card.Type)
+	assert.Equal(t, "Ok.", response.Response.OutputSpeech.Text)
+}
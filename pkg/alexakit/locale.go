@@ -0,0 +1,77 @@
+package alexakit
+
+import "strings"
+
+// Locales of the supported speech texts, the other locales of the same language (e.g. de-AT or en-GB) use the texts
+// of the language
+const (
+	LocaleEnglish = "en-US"
+	LocaleGerman  = "de-DE"
+	DefaultLocale = LocaleEnglish
+)
+
+// SpeechTexts struct holds the texts of the standard responses in one language
+type SpeechTexts struct {
+	Confirmation string
+	Failed       string
+	Welcome      string
+	Reprompt     string
+	Help         string
+	Goodbye      string
+	Fallback     string
+}
+
+// speechTexts are keyed by the language
+var speechTexts = map[string]SpeechTexts{
+	"en": {
+		Confirmation: SpeechTextConfirmation,
+		Failed:       SpeechTextFailed,
+		Welcome:      SpeechTextWelcome,
+		Reprompt:     SpeechTextReprompt,
+		Help:         SpeechTextHelp,
+		Goodbye:      SpeechTextGoodbye,
+		Fallback:     SpeechTextFallback,
+	},
+	"de": {
+		Confirmation: "Okay.",
+		Failed:       "Das hat nicht funktioniert.",
+		Welcome:      "Smart Home ist bereit. Was soll ich tun?",
+		Reprompt:     "Sag zum Beispiel: schalte das Licht ein.",
+		Help: "Du kannst jeden konfigurierten Befehl und jedes Szenario ausführen, zum Beispiel schalte das Licht " +
+			"ein oder starte den Filmabend. Was soll ich tun?",
+		Goodbye:  "Tschüss.",
+		Fallback: "Das kann ich leider nicht. Du kannst ein Gerät ein- oder ausschalten oder Hilfe sagen.",
+	},
+}
+
+// Speech returns the speech texts of the locale, the english texts if the language is not supported
+func Speech(locale string) SpeechTexts {
+	if texts, ok := speechTexts[Language(locale)]; ok {
+		return texts
+	}
+
+	return speechTexts[Language(DefaultLocale)]
+}
+
+// Language returns the lower case language of the locale, e.g. "de" for "de-DE"
+func Language(locale string) string {
+	locale = strings.Replace(locale, "_", "-", -1)
+
+	return strings.ToLower(strings.SplitN(locale, "-", 2)[0])
+}
+
+// LocaleKeys returns the keys the localized values are looked up by, from the most specific one: the locale itself
+// and its language, e.g. "de-AT" and "de"
+func LocaleKeys(locale string) []string {
+	if locale == "" {
+		return nil
+	}
+
+	language := Language(locale)
+
+	if strings.EqualFold(locale, language) {
+		return []string{language}
+	}
+
+	return []string{locale, language}
+}
@@ -0,0 +1,92 @@
+package alexakit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+)
+
+const builtInPrefix = "AMAZON."
+
+// InteractionModel struct is the skill interaction model as it is edited in the JSON editor of the alexa developer
+// console or deployed with ask cli
+type InteractionModel struct {
+	InteractionModel struct {
+		LanguageModel LanguageModel `json:"languageModel"`
+	} `json:"interactionModel"`
+}
+
+// LanguageModel struct holds the invocation name, intents and custom slot types of one locale
+type LanguageModel struct {
+	InvocationName string        `json:"invocationName"`
+	Intents        []ModelIntent `json:"intents"`
+	Types          []SlotType    `json:"types"`
+}
+
+// ModelIntent struct is the intent with its slots and sample utterances
+type ModelIntent struct {
+	Name    string      `json:"name"`
+	Slots   []ModelSlot `json:"slots,omitempty"`
+	Samples []string    `json:"samples"`
+}
+
+// ModelSlot struct is the slot of the intent referencing its type
+type ModelSlot struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// SlotType struct is the custom slot type with its values
+type SlotType struct {
+	Name   string          `json:"name"`
+	Values []SlotTypeValue `json:"values"`
+}
+
+// SlotTypeValue struct is the value of the custom slot type
+type SlotTypeValue struct {
+	ID   string `json:"id,omitempty"`
+	Name struct {
+		Value    string   `json:"value"`
+		Synonyms []string `json:"synonyms,omitempty"`
+	} `json:"name"`
+}
+
+// NewSlotTypeValue creates the slot type value with the synonyms
+func NewSlotTypeValue(value string, synonyms []string) SlotTypeValue {
+	var typeValue SlotTypeValue
+
+	typeValue.Name.Value = value
+	typeValue.Name.Synonyms = synonyms
+
+	return typeValue
+}
+
+// LoadInteractionModel reads the interaction model from the JSON file
+func LoadInteractionModel(fileName string) (InteractionModel, error) {
+	var model InteractionModel
+
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return model, err
+	}
+
+	err = json.Unmarshal(content, &model)
+
+	return model, err
+}
+
+// FindType returns the custom slot type by its name
+func (m *LanguageModel) FindType(name string) (SlotType, bool) {
+	for _, slotType := range m.Types {
+		if slotType.Name == name {
+			return slotType, true
+		}
+	}
+
+	return SlotType{}, false
+}
+
+// IsBuiltIn checks whether the intent or the slot type is amazon built-in, e.g. AMAZON.HelpIntent or AMAZON.Room
+func IsBuiltIn(name string) bool {
+	return strings.HasPrefix(name, builtInPrefix)
+}
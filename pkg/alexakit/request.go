@@ -11,9 +11,16 @@ type Slot struct {
 	Name               string `json:"name"`
 	Value              string `json:"value"`
 	ConfirmationStatus string `json:"confirmationStatus"`
-	Source             string `json:"source"`
+	Source             string `json:"source,omitempty"`
 }
 
+// Confirmation statuses of the intents and slots
+const (
+	ConfirmationNone      = "NONE"
+	ConfirmationConfirmed = "CONFIRMED"
+	ConfirmationDenied    = "DENIED"
+)
+
 // Intent struct represents alexa intent element and contains slots
 type Intent struct {
 	Name               string          `json:"name"`
@@ -30,22 +37,68 @@ type Request struct {
 	Intent    Intent `json:"intent"`
 }
 
+// Application struct identifies the skill the request was sent to
+type Application struct {
+	ApplicationID string `json:"applicationId"`
+}
+
+// User struct identifies the amazon account of the user
+type User struct {
+	UserID      string `json:"userId"`
+	AccessToken string `json:"accessToken,omitempty"`
+}
+
+// Device struct identifies the alexa enabled device the request came from
+type Device struct {
+	DeviceID string `json:"deviceId"`
+}
+
+// Session struct holds the session data, the attributes are kept between the requests of the same session
+type Session struct {
+	New         bool                   `json:"new"`
+	SessionID   string                 `json:"sessionId"`
+	Application Application            `json:"application"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	User        User                   `json:"user"`
+}
+
+// System struct holds the state of the alexa service and the device
+type System struct {
+	Application    Application `json:"application"`
+	User           User        `json:"user"`
+	Device         Device      `json:"device"`
+	APIEndpoint    string      `json:"apiEndpoint"`
+	APIAccessToken string      `json:"apiAccessToken,omitempty"`
+}
+
+// Context struct holds the context of the request, it is sent with all the requests including the ones without session
+type Context struct {
+	System System `json:"System"`
+}
+
 // AlexaRequest struct represent the json structure of the request from alexa api
 type AlexaRequest struct {
-	Version string  `json:"version"`
-	Request Request `json:"request"`
+	Version string   `json:"version"`
+	Session *Session `json:"session,omitempty"`
+	Context *Context `json:"context,omitempty"`
+	Request Request  `json:"request"`
 }
 
 // SimpleSlot struct that represent simplified version of the alexa slot data
 type SimpleSlot struct {
 	Name  string
 	Value string
+	Score int // how well the request value matched the configured one, higher is better, 0 if not known
 }
 
 // SimpleIntent struct that represents simplified version of the alexa intent with slots map
 type SimpleIntent struct {
 	Name  string
 	Slots map[string]SimpleSlot
+	// TargetType and TargetID identify the command or scenario confirmed by the user in the dialog, which is executed
+	// even if other ones match the slots equally well
+	TargetType string
+	TargetID   string
 }
 
 // NewAlexaRequestIntent creates AlexaRequest struct with Intent from received http request
@@ -74,3 +127,16 @@ func (r *AlexaRequest) ToJson() (string, error) {
 
 	return string(content), nil
 }
+
+// ApplicationID returns the id of the skill the request was sent to, taken from the context or from the session
+func (r *AlexaRequest) ApplicationID() string {
+	if r.Context != nil && r.Context.System.Application.ApplicationID != "" {
+		return r.Context.System.Application.ApplicationID
+	}
+
+	if r.Session != nil {
+		return r.Session.Application.ApplicationID
+	}
+
+	return ""
+}
@@ -3,34 +3,98 @@ package alexakit
 import "encoding/json"
 
 const (
-	version = "1.0"
+	version                   = "1.0"
 	OutputSpeechTypePlainText = "PlainText"
+	OutputSpeechTypeSSML      = "SSML"
+	CardTypeSimple            = "Simple"
+	CardTypeStandard          = "Standard"
+	DirectiveElicitSlot       = "Dialog.ElicitSlot"
+	DirectiveConfirmIntent    = "Dialog.ConfirmIntent"
 )
 
 const (
 	SpeechTextConfirmation = "Ok."
-	SpeechTextFailed = "Operation failed."
+	SpeechTextFailed       = "Operation failed."
+	SpeechTextWelcome      = "Smart home is ready. What should I do?"
+	SpeechTextReprompt     = "For example, say turn on the light."
+	SpeechTextHelp         = "You can ask me to run any configured command or scenario, for example, turn on the light " +
+		"or start the movie night. What should I do?"
+	SpeechTextGoodbye  = "Goodbye."
+	SpeechTextFallback = "Sorry, I can't help with that. You can ask me to turn a device on or off, or say help."
+)
+
+// Values of the can fulfill intent answers
+const (
+	CanFulfillYes   = "YES"
+	CanFulfillNo    = "NO"
+	CanFulfillMaybe = "MAYBE"
 )
 
 type OutputSpeech struct {
 	Type string `json:"type"`
-	Text string `json:"text"`
+	Text string `json:"text,omitempty"`
+	SSML string `json:"ssml,omitempty"`
 }
 
-type Response struct {
+// CardImage struct holds the urls of the standard card images
+type CardImage struct {
+	SmallImageURL string `json:"smallImageUrl,omitempty"`
+	LargeImageURL string `json:"largeImageUrl,omitempty"`
+}
+
+// Card struct is displayed in the alexa app, simple card has the content and standard card has the text and image
+type Card struct {
+	Type    string     `json:"type"`
+	Title   string     `json:"title,omitempty"`
+	Content string     `json:"content,omitempty"`
+	Text    string     `json:"text,omitempty"`
+	Image   *CardImage `json:"image,omitempty"`
+}
+
+// Reprompt struct is the speech used when the session is kept open and the user does not respond
+type Reprompt struct {
 	OutputSpeech OutputSpeech `json:"outputSpeech"`
 }
 
+// CanFulfillSlot struct tells whether the slot value is understood and can be fulfilled
+type CanFulfillSlot struct {
+	CanUnderstand string `json:"canUnderstand"`
+	CanFulfill    string `json:"canFulfill"`
+}
+
+// CanFulfillIntent struct is the answer to CanFulfillIntentRequest
+type CanFulfillIntent struct {
+	CanFulfill string                    `json:"canFulfill"`
+	Slots      map[string]CanFulfillSlot `json:"slots,omitempty"`
+}
+
+// Directive struct tells alexa to continue the dialog, e.g. to ask the user for the slot value
+type Directive struct {
+	Type          string  `json:"type"`
+	SlotToElicit  string  `json:"slotToElicit,omitempty"`
+	UpdatedIntent *Intent `json:"updatedIntent,omitempty"`
+}
+
+type Response struct {
+	OutputSpeech     *OutputSpeech     `json:"outputSpeech,omitempty"`
+	Card             *Card             `json:"card,omitempty"`
+	Reprompt         *Reprompt         `json:"reprompt,omitempty"`
+	ShouldEndSession *bool             `json:"shouldEndSession,omitempty"`
+	CanFulfillIntent *CanFulfillIntent `json:"canFulfillIntent,omitempty"`
+	Directives       []Directive       `json:"directives,omitempty"`
+}
+
 type AlexaResponse struct {
-	Version string `json:"version"`
-	Response Response `json:"response"`
+	Version           string                 `json:"version"`
+	SessionAttributes map[string]interface{} `json:"sessionAttributes,omitempty"`
+	Response          Response               `json:"response"`
 }
 
 func NewPlainTextSpeechResponse(speechText string) AlexaResponse {
 	return AlexaResponse{
-		Version:  version,
+		Version: version,
 		Response: Response{
-			OutputSpeech: OutputSpeech{
+			OutputSpeech: &OutputSpeech{
 				Type: OutputSpeechTypePlainText,
 				Text: speechText,
 			},
@@ -38,6 +102,35 @@ func NewPlainTextSpeechResponse(speechText string) AlexaResponse {
 	}
 }
 
+// NewAskResponse creates the response that keeps the session open and waits for the user's answer
+func NewAskResponse(speechText string, repromptText string) AlexaResponse {
+	response := NewPlainTextSpeechResponse(speechText)
+	response.Response.Reprompt = &Reprompt{
+		OutputSpeech: OutputSpeech{Type: OutputSpeechTypePlainText, Text: repromptText},
+	}
+	response.Response.ShouldEndSession = boolPtr(false)
+
+	return response
+}
+
+// NewTellResponse creates the response that ends the session after the speech
+func NewTellResponse(speechText string) AlexaResponse {
+	response := NewPlainTextSpeechResponse(speechText)
+	response.Response.ShouldEndSession = boolPtr(true)
+
+	return response
+}
+
+// NewEmptyResponse creates the response without speech, e.g. for SessionEndedRequest which can not be answered
+func NewEmptyResponse() AlexaResponse {
+	return AlexaResponse{Version: version}
+}
+
+// NewCanFulfillResponse creates the answer to CanFulfillIntentRequest
+func NewCanFulfillResponse(canFulfill CanFulfillIntent) AlexaResponse {
+	return AlexaResponse{Version: version, Response: Response{CanFulfillIntent: &canFulfill}}
+}
+
 func (r *AlexaResponse) ToJson() (string, error) {
 	content, err := json.Marshal(r)
 
@@ -47,3 +140,7 @@ func (r *AlexaResponse) ToJson() (string, error) {
 
 	return string(content), nil
 }
+
+func boolPtr(value bool) *bool {
+	return &value
+}
@@ -1,11 +1,6 @@
 package alexakit
 
-import (
-    "os"
-    "smh-apiengine/pkg/amqp"
-
-    "github.com/spf13/cast"
-)
+import "time"
 
 const (
     RmqHost       = "localhost"
@@ -15,6 +10,18 @@ const (
     RmqExchange   = "alexa_sync"
     RmqQueue      = "alexa.responses"
     RmqRoutingKey = "alexa.response.json"
+    // RmqReplyTimeout leaves enough time to answer within the 8 seconds allowed by alexa
+    RmqReplyTimeout = 6 * time.Second
+    // RmqMaxRetries and RmqRetryDelay give the api a few seconds to come back, e.g. during the restart
+    RmqMaxRetries = 2
+    RmqRetryDelay = 2 * time.Second
+    RmqBufferSize = 100
+    // RmqMessageTTL the requests older than this are not worth executing, alexa has answered the user long ago
+    RmqMessageTTL = 50 * time.Second
+    // RmqDedupWindow covers the alexa retries and the duplicates published after the network glitches
+    RmqDedupWindow = 5 * time.Minute
+    // RmqMaxMessageAge leaves the buffered requests of the publisher some time to reach the consumer
+    RmqMaxMessageAge = time.Minute
 )
 
 const (
@@ -25,26 +32,29 @@ const (
     EnvRmqExchange = "SMH_PROXY_RMQ_EXCHANGE"
     EnvRmqQueue = "SMH_PROXY_RMQ_QUEUE"
     EnvRmqRoutingKey = "SMH_PROXY_RMQ_ROUTING_KEY"
+    EnvRmqReplyTimeout = "SMH_PROXY_RMQ_REPLY_TIMEOUT"
+    EnvRmqMaxRetries = "SMH_PROXY_RMQ_MAX_RETRIES"
+    EnvRmqRetryDelay = "SMH_PROXY_RMQ_RETRY_DELAY"
+    EnvRmqDeadLetterExchange = "SMH_PROXY_RMQ_DLX"
+    EnvRmqDeadLetterQueue = "SMH_PROXY_RMQ_DLQ"
+    EnvRmqBufferSize = "SMH_PROXY_RMQ_BUFFER_SIZE"
+    EnvRmqMessageTTL = "SMH_PROXY_RMQ_MESSAGE_TTL"
+    EnvRmqDedupWindow = "SMH_PROXY_RMQ_DEDUP_WINDOW"
+    EnvRmqMaxMessageAge = "SMH_PROXY_RMQ_MAX_AGE"
+    EnvRmqVHost = "SMH_PROXY_RMQ_VHOST"
+    EnvRmqTLS = "SMH_PROXY_RMQ_TLS"
+    EnvRmqCACert = "SMH_PROXY_RMQ_CA_CERT"
+    EnvRmqClientCert = "SMH_PROXY_RMQ_CERT"
+    EnvRmqClientKey = "SMH_PROXY_RMQ_KEY"
+    EnvRmqHeartbeat = "SMH_PROXY_RMQ_HEARTBEAT"
+    // the broker settings are the same as the ones of broker.CliFlags with the SMH_PROXY_ prefix
+    EnvBroker = "SMH_PROXY_BROKER"
+    EnvMqttAddress = "SMH_PROXY_MQTT_ADDRESS"
+    EnvMqttClientID = "SMH_PROXY_MQTT_CLIENT_ID"
+    EnvMqttLogin = "SMH_PROXY_MQTT_LOGIN"
+    EnvMqttPassword = "SMH_PROXY_MQTT_PASSWORD"
+    EnvMqttTopic = "SMH_PROXY_MQTT_TOPIC"
 )
 
-func NewConfigFromEnv() *amqp.Config {
-    config := amqp.Config{
-        Host:       getEnvVar(EnvRmqHost, RmqHost),
-        Port:       cast.ToInt(getEnvVar(EnvRmqPort, cast.ToString(RmqPort))),
-        Login:      getEnvVar(EnvRmqLogin, RmqLogin),
-        Password:   getEnvVar(EnvRmqPassword, RmqPassword),
-        Exchange:   getEnvVar(EnvRmqExchange, RmqExchange),
-        Queue:      getEnvVar(EnvRmqQueue, RmqQueue),
-        RoutingKey: getEnvVar(EnvRmqRoutingKey, RmqRoutingKey),
-    }
-
-    return &config
-}
-
-func getEnvVar(name string, defaultVal string) string  {
-    if value, exists := os.LookupEnv(name); exists {
-        return value
-    }
-
-    return defaultVal
-}
\ No newline at end of file
+// EnvPrefix is the prefix of the environment variables shared by the publishers and the consumers
+const EnvPrefix = "SMH_PROXY_"
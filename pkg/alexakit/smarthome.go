@@ -0,0 +1,279 @@
+package alexakit
+
+import (
+	"encoding/json"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Namespaces and names of the smart home skill api directives and events
+const (
+	NamespaceAlexa           = "Alexa"
+	NamespaceDiscovery       = "Alexa.Discovery"
+	NamespacePowerController = "Alexa.PowerController"
+	NamespaceEndpointHealth  = "Alexa.EndpointHealth"
+	NamespaceAuthorization   = "Alexa.Authorization"
+
+	DirectiveDiscover    = "Discover"
+	DirectiveTurnOn      = "TurnOn"
+	DirectiveTurnOff     = "TurnOff"
+	DirectiveReportState = "ReportState"
+	DirectiveAcceptGrant = "AcceptGrant"
+
+	EventDiscoverResponse    = "Discover.Response"
+	EventResponse            = "Response"
+	EventStateReport         = "StateReport"
+	EventErrorResponse       = "ErrorResponse"
+	EventAcceptGrantResponse = "AcceptGrant.Response"
+
+	PropertyPowerState   = "powerState"
+	PropertyConnectivity = "connectivity"
+	PowerStateOn         = "ON"
+	PowerStateOff        = "OFF"
+
+	smartHomePayloadVersion = "3"
+	capabilityType          = "AlexaInterface"
+	capabilityVersion       = "3"
+)
+
+// Error types of the smart home ErrorResponse
+const (
+	ErrorTypeNoSuchEndpoint      = "NO_SUCH_ENDPOINT"
+	ErrorTypeEndpointUnreachable = "ENDPOINT_UNREACHABLE"
+	ErrorTypeEndpointBusy        = "ENDPOINT_BUSY"
+	ErrorTypeInvalidDirective    = "INVALID_DIRECTIVE"
+	ErrorTypeInternalError       = "INTERNAL_ERROR"
+)
+
+// Display categories of the discovered endpoints
+const (
+	DisplayCategoryLight     = "LIGHT"
+	DisplayCategorySwitch    = "SWITCH"
+	DisplayCategorySmartPlug = "SMARTPLUG"
+	DisplayCategoryTV        = "TV"
+	DisplayCategorySpeaker   = "SPEAKER"
+	DisplayCategoryFan       = "FAN"
+	DisplayCategoryOther     = "OTHER"
+)
+
+// SmartHomeHeader struct identifies the directive or the event
+type SmartHomeHeader struct {
+	Namespace        string `json:"namespace"`
+	Name             string `json:"name"`
+	PayloadVersion   string `json:"payloadVersion"`
+	MessageID        string `json:"messageId"`
+	CorrelationToken string `json:"correlationToken,omitempty"`
+}
+
+// SmartHomeScope struct holds the access token of the linked account
+type SmartHomeScope struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
+// SmartHomeEndpoint struct identifies the device the directive is sent to
+type SmartHomeEndpoint struct {
+	Scope      *SmartHomeScope   `json:"scope,omitempty"`
+	EndpointID string            `json:"endpointId"`
+	Cookie     map[string]string `json:"cookie,omitempty"`
+}
+
+// SmartHomeDirective struct is the directive sent by alexa, the payload depends on the directive
+type SmartHomeDirective struct {
+	Header   SmartHomeHeader    `json:"header"`
+	Endpoint *SmartHomeEndpoint `json:"endpoint,omitempty"`
+	Payload  json.RawMessage    `json:"payload"`
+}
+
+// SmartHomeRequest struct is the root element of the smart home skill api request
+type SmartHomeRequest struct {
+	Directive SmartHomeDirective `json:"directive"`
+}
+
+// SmartHomeProperty struct is the reported state property of the endpoint
+type SmartHomeProperty struct {
+	Namespace                 string      `json:"namespace"`
+	Name                      string      `json:"name"`
+	Value                     interface{} `json:"value"`
+	TimeOfSample              string      `json:"timeOfSample"`
+	UncertaintyInMilliseconds int         `json:"uncertaintyInMilliseconds"`
+}
+
+// SmartHomeContext struct holds the state of the endpoint reported with the event
+type SmartHomeContext struct {
+	Properties []SmartHomeProperty `json:"properties"`
+}
+
+// SmartHomeEvent struct is the answer to the directive
+type SmartHomeEvent struct {
+	Header   SmartHomeHeader    `json:"header"`
+	Endpoint *SmartHomeEndpoint `json:"endpoint,omitempty"`
+	Payload  interface{}        `json:"payload"`
+}
+
+// SmartHomeResponse struct is the root element of the smart home skill api response
+type SmartHomeResponse struct {
+	Context *SmartHomeContext `json:"context,omitempty"`
+	Event   SmartHomeEvent    `json:"event"`
+}
+
+// CapabilityProperty struct names the property supported by the capability
+type CapabilityProperty struct {
+	Name string `json:"name"`
+}
+
+// CapabilityProperties struct describes how the properties of the capability are reported
+type CapabilityProperties struct {
+	Supported           []CapabilityProperty `json:"supported"`
+	ProactivelyReported bool                 `json:"proactivelyReported"`
+	Retrievable         bool                 `json:"retrievable"`
+}
+
+// Capability struct is the interface supported by the endpoint
+type Capability struct {
+	Type       string                `json:"type"`
+	Interface  string                `json:"interface"`
+	Version    string                `json:"version"`
+	Properties *CapabilityProperties `json:"properties,omitempty"`
+}
+
+// DiscoveryEndpoint struct describes the endpoint in the discovery response
+type DiscoveryEndpoint struct {
+	EndpointID        string            `json:"endpointId"`
+	ManufacturerName  string            `json:"manufacturerName"`
+	FriendlyName      string            `json:"friendlyName"`
+	Description       string            `json:"description"`
+	DisplayCategories []string          `json:"displayCategories"`
+	Cookie            map[string]string `json:"cookie,omitempty"`
+	Capabilities      []Capability      `json:"capabilities"`
+}
+
+type discoveryPayload struct {
+	Endpoints []DiscoveryEndpoint `json:"endpoints"`
+}
+
+type errorPayload struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// IsSmartHomeRequest checks whether the raw request is the smart home skill api directive, the custom skill
+// requests have the request element instead
+func IsSmartHomeRequest(body []byte) bool {
+	var probe struct {
+		Directive *json.RawMessage `json:"directive"`
+	}
+
+	return json.Unmarshal(body, &probe) == nil && probe.Directive != nil
+}
+
+// NewPowerCapabilities returns the capabilities of the endpoint that can be turned on and off
+func NewPowerCapabilities() []Capability {
+	return []Capability{
+		{Type: capabilityType, Interface: NamespaceAlexa, Version: capabilityVersion},
+		{
+			Type:      capabilityType,
+			Interface: NamespacePowerController,
+			Version:   capabilityVersion,
+			Properties: &CapabilityProperties{
+				Supported:   []CapabilityProperty{{Name: PropertyPowerState}},
+				Retrievable: true,
+			},
+		},
+		{
+			Type:      capabilityType,
+			Interface: NamespaceEndpointHealth,
+			Version:   capabilityVersion,
+			Properties: &CapabilityProperties{
+				Supported:   []CapabilityProperty{{Name: PropertyConnectivity}},
+				Retrievable: true,
+			},
+		},
+	}
+}
+
+// NewDiscoveryResponse creates the answer to the discovery directive
+func NewDiscoveryResponse(request SmartHomeRequest, endpoints []DiscoveryEndpoint) SmartHomeResponse {
+	if endpoints == nil {
+		endpoints = []DiscoveryEndpoint{}
+	}
+
+	return SmartHomeResponse{Event: SmartHomeEvent{
+		Header:  newEventHeader(request, NamespaceDiscovery, EventDiscoverResponse),
+		Payload: discoveryPayload{Endpoints: endpoints},
+	}}
+}
+
+// NewStateResponse creates the answer to the power controller directive (name EventResponse) or the state report
+// (name EventStateReport) with the endpoint properties. The power state is not reported if it is unknown.
+func NewStateResponse(request SmartHomeRequest, name string, powerState string, reachable bool) SmartHomeResponse {
+	now := time.Now().UTC().Format(time.RFC3339)
+	connectivity := "OK"
+
+	if !reachable {
+		connectivity = "UNREACHABLE"
+	}
+
+	properties := []SmartHomeProperty{{
+		Namespace:    NamespaceEndpointHealth,
+		Name:         PropertyConnectivity,
+		Value:        map[string]string{"value": connectivity},
+		TimeOfSample: now,
+	}}
+
+	if powerState != "" {
+		properties = append(properties, SmartHomeProperty{
+			Namespace:    NamespacePowerController,
+			Name:         PropertyPowerState,
+			Value:        powerState,
+			TimeOfSample: now,
+		})
+	}
+
+	return SmartHomeResponse{
+		Context: &SmartHomeContext{Properties: properties},
+		Event: SmartHomeEvent{
+			Header:   newEventHeader(request, NamespaceAlexa, name),
+			Endpoint: request.Directive.Endpoint,
+			Payload:  struct{}{},
+		},
+	}
+}
+
+// NewSmartHomeErrorResponse creates the error answer to the directive
+func NewSmartHomeErrorResponse(request SmartHomeRequest, errorType string, message string) SmartHomeResponse {
+	return SmartHomeResponse{Event: SmartHomeEvent{
+		Header:   newEventHeader(request, NamespaceAlexa, EventErrorResponse),
+		Endpoint: request.Directive.Endpoint,
+		Payload:  errorPayload{Type: errorType, Message: message},
+	}}
+}
+
+// NewAcceptGrantResponse creates the answer to the authorization grant sent when the account is linked
+func NewAcceptGrantResponse(request SmartHomeRequest) SmartHomeResponse {
+	return SmartHomeResponse{Event: SmartHomeEvent{
+		Header:  newEventHeader(request, NamespaceAuthorization, EventAcceptGrantResponse),
+		Payload: struct{}{},
+	}}
+}
+
+func (r *SmartHomeResponse) ToJson() (string, error) {
+	content, err := json.Marshal(r)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+func newEventHeader(request SmartHomeRequest, namespace string, name string) SmartHomeHeader {
+	return SmartHomeHeader{
+		Namespace:        namespace,
+		Name:             name,
+		PayloadVersion:   smartHomePayloadVersion,
+		MessageID:        uuid.NewV4().String(),
+		CorrelationToken: request.Directive.Header.CorrelationToken,
+	}
+}
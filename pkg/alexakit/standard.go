@@ -0,0 +1,67 @@
+package alexakit
+
+// Types of the requests sent by alexa to the custom skill
+const (
+	RequestTypeLaunch           = "LaunchRequest"
+	RequestTypeIntent           = "IntentRequest"
+	RequestTypeSessionEnded     = "SessionEndedRequest"
+	RequestTypeCanFulfillIntent = "CanFulfillIntentRequest"
+)
+
+// Names of the amazon built-in intents
+const (
+	IntentHelp         = "AMAZON.HelpIntent"
+	IntentStop         = "AMAZON.StopIntent"
+	IntentCancel       = "AMAZON.CancelIntent"
+	IntentFallback     = "AMAZON.FallbackIntent"
+	IntentNavigateHome = "AMAZON.NavigateHomeIntent"
+)
+
+// StandardResponse returns the response to the requests that do not depend on the configuration: launch, session
+// end and amazon built-in intents, in the language of the request locale. Returns false if the request has to be
+// handled by the skill.
+func StandardResponse(request AlexaRequest) (AlexaResponse, bool) {
+	speech := Speech(request.Request.Locale)
+
+	switch request.Request.Type {
+	case RequestTypeLaunch:
+		return NewAskResponse(speech.Welcome, speech.Reprompt), true
+	case RequestTypeSessionEnded:
+		return NewEmptyResponse(), true
+	case RequestTypeIntent, "":
+	default:
+		return AlexaResponse{}, false
+	}
+
+	switch request.Request.Intent.Name {
+	case IntentHelp:
+		return NewAskResponse(speech.Help, speech.Reprompt), true
+	case IntentStop, IntentCancel, IntentNavigateHome:
+		return NewTellResponse(speech.Goodbye), true
+	case IntentFallback:
+		return NewAskResponse(speech.Fallback, speech.Reprompt), true
+	}
+
+	return AlexaResponse{}, false
+}
+
+// CanFulfillAnswer returns the answer to CanFulfillIntentRequest for the skills that can not check the configuration,
+// built-in intents can always be fulfilled and the custom ones maybe
+func CanFulfillAnswer(request AlexaRequest) AlexaResponse {
+	canFulfill := CanFulfillMaybe
+
+	switch request.Request.Intent.Name {
+	case IntentHelp, IntentStop, IntentCancel, IntentNavigateHome:
+		canFulfill = CanFulfillYes
+	case IntentFallback:
+		canFulfill = CanFulfillNo
+	}
+
+	answer := CanFulfillIntent{CanFulfill: canFulfill, Slots: map[string]CanFulfillSlot{}}
+
+	for name := range request.Request.Intent.Slots {
+		answer.Slots[name] = CanFulfillSlot{CanUnderstand: canFulfill, CanFulfill: canFulfill}
+	}
+
+	return NewCanFulfillResponse(answer)
+}
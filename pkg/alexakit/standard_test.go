@@ -0,0 +1,57 @@
+package alexakit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_StandardResponse_AnswersBuiltInRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    Request
+		handled    bool
+		speech     string
+		endSession *bool
+	}{
+		{"launch", Request{Type: RequestTypeLaunch}, true, SpeechTextWelcome, boolPtr(false)},
+		{"session ended", Request{Type: RequestTypeSessionEnded}, true, "", nil},
+		{"help", Request{Type: RequestTypeIntent, Intent: Intent{Name: IntentHelp}}, true, SpeechTextHelp, boolPtr(false)},
+		{"stop", Request{Type: RequestTypeIntent, Intent: Intent{Name: IntentStop}}, true, SpeechTextGoodbye, boolPtr(true)},
+		{"cancel", Request{Type: RequestTypeIntent, Intent: Intent{Name: IntentCancel}}, true, SpeechTextGoodbye,
+			boolPtr(true)},
+		{"fallback", Request{Type: RequestTypeIntent, Intent: Intent{Name: IntentFallback}}, true, SpeechTextFallback,
+			boolPtr(false)},
+		{"german", Request{Type: RequestTypeIntent, Locale: "de-AT", Intent: Intent{Name: IntentStop}}, true, "Tschüss.",
+			boolPtr(true)},
+		{"unsupported language", Request{Type: RequestTypeLaunch, Locale: "fr-FR"}, true, SpeechTextWelcome,
+			boolPtr(false)},
+		{"custom intent", Request{Type: RequestTypeIntent, Intent: Intent{Name: "TurnOn"}}, false, "", nil},
+		{"can fulfill", Request{Type: RequestTypeCanFulfillIntent, Intent: Intent{Name: IntentHelp}}, false, "", nil},
+	}
+
+	for _, test := range tests {
+		response, ok := StandardResponse(AlexaRequest{Request: test.request})
+
+		assert.Equal(t, test.handled, ok, test.name)
+		assert.Equal(t, test.endSession, response.Response.ShouldEndSession, test.name)
+
+		if test.speech == "" {
+			assert.Nil(t, response.Response.OutputSpeech, test.name)
+		} else {
+			assert.Equal(t, test.speech, response.Response.OutputSpeech.Text, test.name)
+		}
+	}
+}
+
+func Test_CanFulfillAnswer_MarshalsSlots(t *testing.T) {
+	response := CanFulfillAnswer(AlexaRequest{Request: Request{
+		Type:   RequestTypeCanFulfillIntent,
+		Intent: Intent{Name: "TurnOn", Slots: map[string]Slot{"device": {Name: "device", Value: "tv"}}},
+	}})
+
+	content, err := response.ToJson()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"version":"1.0","response":{"canFulfillIntent":{"canFulfill":"MAYBE",
+		"slots":{"device":{"canUnderstand":"MAYBE","canFulfill":"MAYBE"}}}}}`, content)
+}
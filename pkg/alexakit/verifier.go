@@ -0,0 +1,324 @@
+package alexakit
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderSignatureCertChainURL = "SignatureCertChainUrl"
+	HeaderSignature             = "Signature"
+	HeaderSignature256          = "Signature-256"
+
+	// DefaultTimestampTolerance is the max allowed difference between the request timestamp and the current time
+	DefaultTimestampTolerance = 150 * time.Second
+	// MaxRequestSize limits the body of the alexa requests, which are a few KB
+	MaxRequestSize = 64 * 1024
+
+	certURLScheme    = "https"
+	certURLHost      = "s3.amazonaws.com"
+	certURLPort      = "443"
+	certURLPrefix    = "/echo.api/"
+	certSubjectName  = "echo-api.amazon.com"
+	certFetchTimeout = 5 * time.Second
+	maxCertChainSize = 64 * 1024
+)
+
+var (
+	ErrCertURLInvalid        = errors.New("signature certificate url is invalid")
+	ErrCertInvalid           = errors.New("signature certificate is invalid")
+	ErrSignatureInvalid      = errors.New("request signature is invalid")
+	ErrTimestampInvalid      = errors.New("request timestamp is out of the allowed window")
+	ErrApplicationNotAllowed = errors.New("request application id is not allowed")
+)
+
+// VerificationConfig struct configures the verification of the requests sent by alexa
+type VerificationConfig struct {
+	Enabled        bool
+	ApplicationIDs []string      // allowed skill ids, all the skills are allowed if empty
+	Tolerance      time.Duration // DefaultTimestampTolerance if zero
+}
+
+// CertFetcher downloads the PEM encoded certificate chain from the url
+type CertFetcher interface {
+	Fetch(certURL string) ([]byte, error)
+}
+
+// HTTPCertFetcher fetches the certificate chains over https
+type HTTPCertFetcher struct {
+	Client *http.Client
+}
+
+// Verifier struct verifies that the requests are sent by alexa to one of the allowed skills: the signature is
+// checked against the amazon certificate, the request timestamp must be within the tolerance and the application id
+// must be in the allow-list. Verified certificates are cached by their url until they expire.
+type Verifier struct {
+	config  VerificationConfig
+	fetcher CertFetcher
+	roots   *x509.CertPool // system roots if nil
+	now     func() time.Time
+	certs   map[string]*x509.Certificate
+	mu      sync.Mutex
+}
+
+// NewVerifier creates the verifier fetching the certificates from amazon and validating them with the system roots
+func NewVerifier(config VerificationConfig) *Verifier {
+	return NewVerifierWithFetcher(config, &HTTPCertFetcher{Client: &http.Client{Timeout: certFetchTimeout}}, nil)
+}
+
+// NewVerifierWithFetcher creates the verifier with provided certificate fetcher and root certificates
+func NewVerifierWithFetcher(config VerificationConfig, fetcher CertFetcher, roots *x509.CertPool) *Verifier {
+	if config.Tolerance <= 0 {
+		config.Tolerance = DefaultTimestampTolerance
+	}
+
+	return &Verifier{
+		config:  config,
+		fetcher: fetcher,
+		roots:   roots,
+		now:     time.Now,
+		certs:   make(map[string]*x509.Certificate),
+	}
+}
+
+// Verify checks the signature of the raw request body and returns the parsed request if it is valid
+func (v *Verifier) Verify(header http.Header, body []byte) (AlexaRequest, error) {
+	var request AlexaRequest
+
+	certURL := header.Get(HeaderSignatureCertChainURL)
+
+	err := ValidateCertURL(certURL)
+	if err != nil {
+		return request, err
+	}
+
+	cert, err := v.certificate(certURL)
+	if err != nil {
+		return request, err
+	}
+
+	err = verifySignature(cert, header, body)
+	if err != nil {
+		return request, err
+	}
+
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		return request, err
+	}
+
+	err = v.verifyTimestamp(request.Request.TimeStamp)
+	if err != nil {
+		return request, err
+	}
+
+	if !v.applicationAllowed(request.ApplicationID()) {
+		return request, ErrApplicationNotAllowed
+	}
+
+	return request, nil
+}
+
+// VerifyHTTPRequest verifies the request and replaces its body, so it can be read again by the handlers. The body
+// larger than MaxRequestSize fails the verification.
+func (v *Verifier) VerifyHTTPRequest(r *http.Request) (AlexaRequest, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, MaxRequestSize))
+	if err != nil {
+		return AlexaRequest{}, err
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return v.Verify(r.Header, body)
+}
+
+// ValidateCertURL checks that the certificate chain url points to the amazon echo api bucket
+func ValidateCertURL(certURL string) error {
+	u, err := url.Parse(certURL)
+	if err != nil || certURL == "" {
+		return ErrCertURLInvalid
+	}
+
+	if !strings.EqualFold(u.Scheme, certURLScheme) || !strings.EqualFold(u.Hostname(), certURLHost) {
+		return ErrCertURLInvalid
+	}
+
+	if u.Port() != "" && u.Port() != certURLPort {
+		return ErrCertURLInvalid
+	}
+
+	if !strings.HasPrefix(path.Clean(u.Path), certURLPrefix) {
+		return ErrCertURLInvalid
+	}
+
+	return nil
+}
+
+func (f *HTTPCertFetcher) Fetch(certURL string) ([]byte, error) {
+	resp, err := f.Client.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch the certificate chain: %s", resp.Status)
+	}
+
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxCertChainSize))
+}
+
+// certificate returns the verified signing certificate from the cache or fetches it
+func (v *Verifier) certificate(certURL string) (*x509.Certificate, error) {
+	v.mu.Lock()
+	cert, ok := v.certs[certURL]
+	v.mu.Unlock()
+
+	if ok && v.now().Before(cert.NotAfter) {
+		return cert, nil
+	}
+
+	chain, err := v.fetcher.Fetch(certURL)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err = v.verifyChain(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	v.certs[certURL] = cert
+	v.mu.Unlock()
+
+	return cert, nil
+}
+
+// verifyChain parses the PEM chain, the first certificate is the signing one and the others are intermediates
+func (v *Verifier) verifyChain(chain []byte) (*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	for {
+		var block *pem.Block
+
+		block, chain = pem.Decode(chain)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, ErrCertInvalid
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, ErrCertInvalid
+	}
+
+	intermediates := x509.NewCertPool()
+
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       certSubjectName,
+		Intermediates: intermediates,
+		Roots:         v.roots,
+		CurrentTime:   v.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, ErrCertInvalid
+	}
+
+	return certs[0], nil
+}
+
+// verifySignature checks the SHA-256 signature, falling back to the legacy SHA-1 one
+func verifySignature(cert *x509.Certificate, header http.Header, body []byte) error {
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ErrCertInvalid
+	}
+
+	var hash crypto.Hash
+	var digest []byte
+
+	encoded := header.Get(HeaderSignature256)
+
+	if encoded != "" {
+		sum := sha256.Sum256(body)
+		hash, digest = crypto.SHA256, sum[:]
+	} else {
+		encoded = header.Get(HeaderSignature)
+		sum := sha1.Sum(body)
+		hash, digest = crypto.SHA1, sum[:]
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(signature) == 0 {
+		return ErrSignatureInvalid
+	}
+
+	if rsa.VerifyPKCS1v15(publicKey, hash, digest, signature) != nil {
+		return ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+func (v *Verifier) verifyTimestamp(timestamp string) error {
+	requestTime, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return ErrTimestampInvalid
+	}
+
+	diff := v.now().Sub(requestTime)
+
+	if diff > v.config.Tolerance || diff < -v.config.Tolerance {
+		return ErrTimestampInvalid
+	}
+
+	return nil
+}
+
+func (v *Verifier) applicationAllowed(applicationID string) bool {
+	if len(v.config.ApplicationIDs) == 0 {
+		return true
+	}
+
+	for _, allowed := range v.config.ApplicationIDs {
+		if allowed == applicationID {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,163 @@
+package alexakit
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testCertURL = "https://s3.amazonaws.com/echo.api/echo-api-cert.pem"
+
+type staticCertFetcher struct {
+	chain   []byte
+	fetched int
+}
+
+func (f *staticCertFetcher) Fetch(certURL string) ([]byte, error) {
+	f.fetched++
+
+	return f.chain, nil
+}
+
+func Test_ValidateCertURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://s3.amazonaws.com/echo.api/echo-api-cert.pem", true},
+		{"https://s3.amazonaws.com:443/echo.api/echo-api-cert.pem", true},
+		{"https://s3.amazonaws.com/echo.api/../echo.api/echo-api-cert.pem", true},
+		{"HTTPS://s3.AmazonAWS.com/echo.api/echo-api-cert.pem", true},
+		{"http://s3.amazonaws.com/echo.api/echo-api-cert.pem", false},
+		{"https://notamazon.com/echo.api/echo-api-cert.pem", false},
+		{"https://s3.amazonaws.com/EcHo.aPi/echo-api-cert.pem", false},
+		{"https://s3.amazonaws.com/invalid.path/echo-api-cert.pem", false},
+		{"https://s3.amazonaws.com:563/echo.api/echo-api-cert.pem", false},
+		{"https://s3.amazonaws.com/echo.api/../invalid.path/echo-api-cert.pem", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		err := ValidateCertURL(test.url)
+		assert.Equal(t, test.valid, err == nil, test.url)
+	}
+}
+
+func Test_Verifier_Verify(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	roots, chain, key := testCertChain(t, now)
+	fetcher := &staticCertFetcher{chain: chain}
+
+	verifier := NewVerifierWithFetcher(VerificationConfig{
+		Enabled:        true,
+		ApplicationIDs: []string{"amzn1.ask.skill.allowed"},
+	}, fetcher, roots)
+	verifier.now = func() time.Time { return now }
+
+	body := testRequestBody("amzn1.ask.skill.allowed", now.Add(-time.Minute))
+	request, err := verifier.Verify(signedHeader(t, key, body), body)
+	assert.Nil(t, err)
+	assert.Equal(t, "amzn1.ask.skill.allowed", request.ApplicationID())
+
+	_, err = verifier.Verify(signedHeader(t, key, body), body)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, fetcher.fetched, "verified certificate should be cached")
+
+	header := signedHeader(t, key, body)
+	_, err = verifier.Verify(header, append(body, ' '))
+	assert.Equal(t, ErrSignatureInvalid, err)
+
+	oldBody := testRequestBody("amzn1.ask.skill.allowed", now.Add(-10*time.Minute))
+	_, err = verifier.Verify(signedHeader(t, key, oldBody), oldBody)
+	assert.Equal(t, ErrTimestampInvalid, err)
+
+	otherBody := testRequestBody("amzn1.ask.skill.other", now)
+	_, err = verifier.Verify(signedHeader(t, key, otherBody), otherBody)
+	assert.Equal(t, ErrApplicationNotAllowed, err)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	_, err = verifier.Verify(signedHeader(t, otherKey, body), body)
+	assert.Equal(t, ErrSignatureInvalid, err)
+
+	untrusted := NewVerifierWithFetcher(VerificationConfig{Enabled: true}, fetcher, x509.NewCertPool())
+	untrusted.now = verifier.now
+	_, err = untrusted.Verify(signedHeader(t, key, body), body)
+	assert.Equal(t, ErrCertInvalid, err)
+}
+
+func testRequestBody(applicationID string, timestamp time.Time) []byte {
+	return []byte(fmt.Sprintf(`{"version":"1.0","context":{"System":{"application":{"applicationId":"%s"}}},`+
+		`"request":{"type":"LaunchRequest","requestId":"req-1","timestamp":"%s"}}`,
+		applicationID, timestamp.Format(time.RFC3339)))
+}
+
+func signedHeader(t *testing.T, key *rsa.PrivateKey, body []byte) http.Header {
+	digest := sha256.Sum256(body)
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	assert.Nil(t, err)
+
+	header := http.Header{}
+	header.Set(HeaderSignatureCertChainURL, testCertURL)
+	header.Set(HeaderSignature256, base64.StdEncoding.EncodeToString(signature))
+
+	return header
+}
+
+// testCertChain creates a root and signing certificate for echo-api.amazon.com, returns the root pool, PEM chain
+// and the signing key
+func testCertChain(t *testing.T, now time.Time) (*x509.CertPool, []byte, *rsa.PrivateKey) {
+	rootKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	assert.Nil(t, err)
+
+	rootCert, err := x509.ParseCertificate(rootDER)
+	assert.Nil(t, err)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: certSubjectName},
+		DNSNames:     []string{certSubjectName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, rootCert, &key.PublicKey, rootKey)
+	assert.Nil(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCert)
+
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+
+	return roots, chain, key
+}
@@ -1,73 +1,206 @@
 package amqp
 
 import (
-	"fmt"
-	"github.com/spf13/cast"
+	"context"
+	"errors"
 	"github.com/streadway/amqp"
-	"log"
-	"reflect"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/queue"
 )
 
-// Consume creates a new RMQ connection and starts listener to the preselect queue. Upon receiving
-// a message handlerFunc will be executed with the received message payload.
-func (proc* Rmq) Consume(handler interface{}) {
-	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:%d/", proc.config.Login, proc.config.Password, proc.config.Host, proc.config.Port))
+var errConnectionClosed = errors.New("connection closed by the broker")
+
+// Consume starts the supervised listener of the preselected queue, upon receiving a message the handler is executed
+// with the message payload. If the connection can not be established or is lost (e.g. the broker restarts or the
+// network is down) the consumer reconnects with exponential backoff and declares the exchange, queue and binding
+// again. The messages are acknowledged after they are handled, the failed ones are delayed in the retry queue and
+// then published to the dead letter exchange. Blocks until the context is cancelled.
+func (proc *Rmq) Consume(ctx context.Context, handler queue.MessageHandler) {
+	queue.Supervise(ctx, &proc.status, "RMQ", func(ctx context.Context) (bool, error) {
+		return proc.consumeOnce(ctx, handler)
+	})
+}
 
-	displayError(err, "failed to connect")
+// consumeOnce connects to the broker and handles the messages until the connection is closed or the context is
+// cancelled. Returns whether the consumer has been connected and the reason of the disconnection.
+func (proc *Rmq) consumeOnce(ctx context.Context, handler queue.MessageHandler) (bool, error) {
+	conn, err := proc.connect()
+	if err != nil {
+		return false, err
+	}
 
-	defer func() {
-		err := conn.Close()
+	defer closeConnection(conn)
 
-		if err != nil {
-			panic(err)
-		}
-	}()
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	ch, q, err := proc.openChannelAndQueue(conn)
+	if err != nil {
+		return false, err
+	}
+
+	err = proc.declareDeadLetters(ch)
+	if err != nil {
+		return false, err
+	}
 
-	ch, q := proc.openChannelAndQueue(conn)
-	defer func() {
-		err := ch.Close()
+	err = proc.declareRetryQueue(ch)
+	if err != nil {
+		return false, err
+	}
 
+	if proc.config.Prefetch > 0 {
+		err = ch.Qos(proc.config.Prefetch, 0, false)
 		if err != nil {
-			panic(err)
+			return false, err
 		}
-	}()
+	}
 
 	msgs, err := ch.Consume(
 		q.Name,
 		"",
-		true,
+		false,
 		false,
 		false,
 		false,
 		nil,
 	)
-	displayError(err, "failed to register a consumer")
+	if err != nil {
+		return false, err
+	}
+
+	proc.status.Set(queue.StateConnected, nil)
+	logging.Infof("RMQ consumer started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case amqpErr := <-closed:
+			if amqpErr != nil {
+				return true, amqpErr
+			}
 
-	consumer := make(chan bool)
+			return true, errConnectionClosed
+		case d, ok := <-msgs:
+			// the deliveries are closed with the channel, e.g. when the queue is deleted
+			if !ok {
+				return true, errConnectionClosed
+			}
 
-	go func() {
-		for d := range msgs {
-			handlerValue := reflect.ValueOf(handler)
+			proc.process(ch, d, handler)
+		}
+	}
+}
 
-			switch handlerValue.Interface().(type) {
-			case MessageHandler:
-				handler.(MessageHandler).handle(cast.ToString(d.Body))
-			default:
-				log.Println("Wrong handler type provided!")
-				return
-			}
+// process handles the message and acknowledges it. The failed message is published to the retry queue instead of
+// waiting for the retry here, so it does not hold the other messages, and is dead-lettered once the retries are
+// exhausted. The messages that are not valid envelopes are dead-lettered right away. If the message can not be
+// retried or dead-lettered, it is returned to the queue.
+func (proc *Rmq) process(ch publishChannel, d amqp.Delivery, handler queue.MessageHandler) {
+	msg, err := deliveryEnvelope(d)
+	if err != nil {
+		proc.reject(ch, d, 1, err)
+
+		return
+	}
+
+	_, replayed := d.Headers[headerReplayed]
+	result := proc.processor.ProcessAttempt(msg, replayed, deliveryAttempt(d), handler)
+
+	switch result.Outcome {
+	case queue.Handled, queue.Skipped:
+		if d.ReplyTo != "" && result.Reply != "" {
+			proc.reply(ch, d, result.Reply)
 		}
-	}()
 
-	log.Println("RMQ consumer started")
+		proc.ack(d)
+	case queue.Retry:
+		err = proc.retry(ch, d, result.Attempts)
+		if err != nil {
+			logging.WithError(err).Errorf("Failed to delay the retry, returning the message to the queue")
+			proc.requeue(d)
 
-	<-consumer
+			return
+		}
+
+		proc.ack(d)
+	case queue.Failed:
+		proc.reject(ch, d, result.Attempts, result.Err)
+	}
 }
 
-func (proc *Rmq) openChannelAndQueue(conn *amqp.Connection) (*amqp.Channel, amqp.Queue) {
-	ch, err := conn.Channel()
+// deliveryEnvelope parses the envelope of the delivered message, the message id is taken from the properties for the
+// messages published without the envelope
+func deliveryEnvelope(d amqp.Delivery) (queue.Envelope, error) {
+	msg, err := queue.ParseEnvelope(d.Body)
+	if err != nil {
+		return queue.Envelope{}, err
+	}
+
+	if msg.ID == "" {
+		msg.ID = d.MessageId
+	}
+
+	if msg.ID == "" {
+		msg.ID = logging.NewRequestID()
+	}
 
-	displayError(err, "could not create a channel")
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = d.Timestamp
+	}
+
+	return msg, nil
+}
+
+func (proc *Rmq) ack(d amqp.Delivery) {
+	if err := d.Ack(false); err != nil {
+		logging.WithError(err).Warnf("Failed to acknowledge the message")
+	}
+}
+
+func (proc *Rmq) requeue(d amqp.Delivery) {
+	if err := d.Nack(false, true); err != nil {
+		logging.WithError(err).Warnf("Failed to return the message to the queue")
+	}
+}
+
+func (proc *Rmq) reject(ch publishChannel, d amqp.Delivery, attempts int, cause error) {
+	err := proc.deadLetter(ch, d, attempts, cause)
+	if err != nil {
+		logging.WithError(err).Errorf("Failed to dead-letter the message, returning it to the queue")
+		proc.requeue(d)
+
+		return
+	}
+
+	logging.WithError(cause).Warnf("Message dead-lettered to %s after %d attempt(s)", proc.config.deadLetterQueue(),
+		attempts)
+	proc.ack(d)
+}
+
+// reply sends the reply to the publisher waiting for it, the reply is lost if the publisher has already timed out
+func (proc *Rmq) reply(ch publishChannel, d amqp.Delivery, reply string) {
+	err := ch.Publish(
+		"",
+		d.ReplyTo,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:   queue.ContentType,
+			CorrelationId: d.CorrelationId,
+			Body:          []byte(reply),
+		})
+
+	if err != nil {
+		logging.WithError(err).Warnf("Failed to send the reply")
+	}
+}
+
+func (proc *Rmq) openChannelAndQueue(conn *amqp.Connection) (*amqp.Channel, amqp.Queue, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, amqp.Queue{}, err
+	}
 
 	err = ch.ExchangeDeclare(
 		proc.config.Exchange,
@@ -78,8 +211,9 @@ func (proc *Rmq) openChannelAndQueue(conn *amqp.Connection) (*amqp.Channel, amqp
 		false,
 		nil,
 	)
-
-	displayError(err, "can not declare the exchange")
+	if err != nil {
+		return nil, amqp.Queue{}, err
+	}
 
 	q, err := ch.QueueDeclare(
 		proc.config.Queue,
@@ -89,7 +223,9 @@ func (proc *Rmq) openChannelAndQueue(conn *amqp.Connection) (*amqp.Channel, amqp
 		false,
 		nil,
 	)
-	displayError(err, "failed to declare the queue")
+	if err != nil {
+		return nil, amqp.Queue{}, err
+	}
 
 	err = ch.QueueBind(
 		q.Name,
@@ -98,7 +234,9 @@ func (proc *Rmq) openChannelAndQueue(conn *amqp.Connection) (*amqp.Channel, amqp
 		false,
 		nil,
 	)
-	displayError(err, "failed to bind the queue")
+	if err != nil {
+		return nil, amqp.Queue{}, err
+	}
 
-	return ch, q
+	return ch, q, nil
 }
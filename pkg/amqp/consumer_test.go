@@ -0,0 +1,135 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"smh-apiengine/pkg/queue"
+)
+
+type noopHandler struct{}
+
+func (noopHandler) Handle(msg queue.Envelope) (string, error) {
+	return "", nil
+}
+
+func Test_Consume_ReconnectsUntilCancelled(t *testing.T) {
+	// nothing listens on the port, so the connection fails and the consumer waits to reconnect
+	proc := NewRmq(&Config{Host: "127.0.0.1", Port: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		proc.Consume(ctx, noopHandler{})
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return proc.Status().State == queue.StateDisconnected
+	}, time.Second, 10*time.Millisecond)
+	assert.NotEmpty(t, proc.Status().LastError)
+
+	recorder := httptest.NewRecorder()
+	proc.HealthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("consumer did not stop")
+	}
+
+	assert.Equal(t, queue.StateStopped, proc.Status().State)
+}
+
+type fakeAcknowledger struct {
+	acked    bool
+	requeued bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.requeued = requeue
+
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+type failingHandler struct {
+	failures int
+	calls    int
+}
+
+func (h *failingHandler) Handle(msg queue.Envelope) (string, error) {
+	h.calls++
+
+	if h.calls <= h.failures {
+		return "", errors.New("api is not available")
+	}
+
+	return "", nil
+}
+
+type fakeChannel struct {
+	published []amqp.Publishing
+	keys      []string
+}
+
+func (c *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.published = append(c.published, msg)
+	c.keys = append(c.keys, exchange+"/"+key)
+
+	return nil
+}
+
+func Test_process_DelaysRetries(t *testing.T) {
+	proc := NewRmq(&Config{Exchange: "alexa", Queue: "alexa.requests",
+		Options: queue.Options{MaxRetries: 2, RetryDelay: 2 * time.Second}})
+	ch := &fakeChannel{}
+	acknowledger := &fakeAcknowledger{}
+	handler := &failingHandler{failures: 1}
+
+	// the failed message is acknowledged and published to the retry queue instead of waiting for the retry
+	proc.process(ch, amqp.Delivery{Acknowledger: acknowledger, Body: []byte("{}"), ReplyTo: "replies"}, handler)
+
+	assert.Equal(t, 1, handler.calls)
+	assert.True(t, acknowledger.acked)
+	assert.Equal(t, []string{"/alexa.requests.retry"}, ch.keys)
+
+	retried := ch.published[0]
+	assert.Equal(t, "2000", retried.Expiration)
+	assert.Equal(t, int32(1), retried.Headers[headerRetries])
+	assert.Equal(t, "replies", retried.ReplyTo)
+
+	// the expired retry is consumed again and handled
+	acknowledger = &fakeAcknowledger{}
+	proc.process(ch, amqp.Delivery{Acknowledger: acknowledger, Body: retried.Body, Headers: retried.Headers}, handler)
+
+	assert.Equal(t, 2, handler.calls)
+	assert.True(t, acknowledger.acked)
+	assert.Len(t, ch.published, 1)
+
+	// the message failing after the last retry is dead-lettered
+	acknowledger = &fakeAcknowledger{}
+	proc.process(ch, amqp.Delivery{Acknowledger: acknowledger, Body: []byte("{}"),
+		Headers: amqp.Table{headerRetries: int32(2)}}, &failingHandler{failures: 1})
+
+	assert.True(t, acknowledger.acked)
+	assert.Equal(t, "alexa.dead/", ch.keys[1])
+}
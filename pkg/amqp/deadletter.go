@@ -0,0 +1,203 @@
+package amqp
+
+import (
+	"github.com/spf13/cast"
+	"github.com/streadway/amqp"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/queue"
+	"time"
+)
+
+// Headers added to the dead-lettered and replayed messages
+const (
+	headerDeadError    = "x-smh-error"
+	headerDeadAttempts = "x-smh-attempts"
+	headerDeadAt       = "x-smh-dead-at"
+	// headerReplayed marks the replayed dead letters, they are executed even if they are older than the max age
+	headerReplayed   = "x-smh-replayed"
+	deadLetterSuffix = ".dead"
+)
+
+func (c *Config) deadLetterExchange() string {
+	if c.DeadLetterExchange != "" {
+		return c.DeadLetterExchange
+	}
+
+	return c.Exchange + deadLetterSuffix
+}
+
+func (c *Config) deadLetterQueue() string {
+	if c.DeadLetterQueue != "" {
+		return c.DeadLetterQueue
+	}
+
+	return c.Queue + deadLetterSuffix
+}
+
+// declareDeadLetters declares the dead letter exchange and the queue keeping the dead-lettered messages
+func (proc *Rmq) declareDeadLetters(ch *amqp.Channel) error {
+	err := ch.ExchangeDeclare(proc.config.deadLetterExchange(), "fanout", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(proc.config.deadLetterQueue(), true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	return ch.QueueBind(proc.config.deadLetterQueue(), "", proc.config.deadLetterExchange(), false, nil)
+}
+
+// deadLetter publishes the message to the dead letter exchange with the failure details. The original expiration is
+// not kept, the dead letters stay in the queue until they are replayed or purged.
+func (proc *Rmq) deadLetter(ch publishChannel, d amqp.Delivery, attempts int, cause error) error {
+	return ch.Publish(
+		proc.config.deadLetterExchange(),
+		"",
+		false,
+		false,
+		amqp.Publishing{
+			Headers: amqp.Table{
+				headerDeadError:    cause.Error(),
+				headerDeadAttempts: int32(attempts),
+				headerDeadAt:       time.Now().UTC().Format(time.RFC3339),
+			},
+			DeliveryMode:  amqp.Persistent,
+			Timestamp:     d.Timestamp,
+			ContentType:   d.ContentType,
+			MessageId:     d.MessageId,
+			Type:          d.Type,
+			AppId:         d.AppId,
+			CorrelationId: d.CorrelationId,
+			Body:          d.Body,
+		})
+}
+
+// DeadLetters returns up to the limit of the dead-lettered messages without removing them from the queue
+func (proc *Rmq) DeadLetters(limit int) ([]queue.DeadLetter, error) {
+	var letters []queue.DeadLetter
+
+	err := proc.withDeadLetters(func(ch *amqp.Channel, d amqp.Delivery, letter queue.DeadLetter) (bool, error) {
+		letters = append(letters, letter)
+
+		return limit <= 0 || len(letters) < limit, nil
+	})
+
+	return letters, err
+}
+
+// ReplayDeadLetters publishes the dead letters selected by the filter to the exchange again and removes them from the
+// dead letter queue. Returns the number of the replayed messages.
+func (proc *Rmq) ReplayDeadLetters(filter queue.DeadLetterFilter) (int, error) {
+	replayed := 0
+
+	err := proc.withDeadLetters(func(ch *amqp.Channel, d amqp.Delivery, letter queue.DeadLetter) (bool, error) {
+		if !filter(letter) {
+			return true, nil
+		}
+
+		err := ch.Publish(
+			proc.config.Exchange,
+			proc.config.RoutingKey,
+			false,
+			false,
+			amqp.Publishing{
+				Headers:      amqp.Table{headerReplayed: true},
+				DeliveryMode: amqp.Persistent,
+				Timestamp:    time.Now(),
+				ContentType:  d.ContentType,
+				MessageId:    d.MessageId,
+				Type:         d.Type,
+				AppId:        d.AppId,
+				Body:         d.Body,
+			})
+		if err != nil {
+			return false, err
+		}
+
+		replayed++
+
+		return true, d.Ack(false)
+	})
+
+	return replayed, err
+}
+
+// PurgeDeadLetters removes all the dead letters, returns the number of the removed messages
+func (proc *Rmq) PurgeDeadLetters() (int, error) {
+	conn, err := proc.connect()
+	if err != nil {
+		return 0, err
+	}
+
+	defer closeConnection(conn)
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+
+	err = proc.declareDeadLetters(ch)
+	if err != nil {
+		return 0, err
+	}
+
+	return ch.QueuePurge(proc.config.deadLetterQueue(), false)
+}
+
+// withDeadLetters gets the dead letters one by one until the queue is empty or the visitor stops. The messages that
+// are not acknowledged by the visitor are returned to the queue when the channel is closed.
+func (proc *Rmq) withDeadLetters(visit func(ch *amqp.Channel, d amqp.Delivery, letter queue.DeadLetter) (bool, error)) error {
+	conn, err := proc.connect()
+	if err != nil {
+		return err
+	}
+
+	defer closeConnection(conn)
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	err = proc.declareDeadLetters(ch)
+	if err != nil {
+		return err
+	}
+
+	for {
+		d, ok, err := ch.Get(proc.config.deadLetterQueue(), false)
+		if err != nil || !ok {
+			return err
+		}
+
+		next, err := visit(ch, d, newDeadLetter(d))
+		if err != nil || !next {
+			return err
+		}
+	}
+}
+
+func newDeadLetter(d amqp.Delivery) queue.DeadLetter {
+	deadAt, _ := time.Parse(time.RFC3339, cast.ToString(d.Headers[headerDeadAt]))
+	letter := queue.NewDeadLetter(d.Body, cast.ToString(d.Headers[headerDeadError]),
+		cast.ToInt(d.Headers[headerDeadAttempts]), deadAt)
+
+	// the messages published without the envelope are identified by the message properties
+	if letter.ID == "" {
+		letter.ID = d.MessageId
+	}
+
+	if letter.Type == "" {
+		letter.Type = d.Type
+	}
+
+	return letter
+}
+
+func closeConnection(conn *amqp.Connection) {
+	if err := conn.Close(); err != nil && err != amqp.ErrClosed {
+		logging.WithError(err).Warnf("failed to close the connection")
+	}
+}
@@ -1,57 +1,451 @@
 package amqp
 
 import (
+	"errors"
 	"github.com/streadway/amqp"
-	"log"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/queue"
+	"strconv"
+	"sync"
 	"time"
 )
 
-const defaultExp = "50000" // 50 sec.
+const (
+	directReplyTo = "amq.rabbitmq.reply-to"
 
-func (proc *Rmq) Publish(payload string) error {
-	conn, err := proc.connect()
+	defaultPoolSize       = 4
+	defaultConfirmTimeout = 5 * time.Second
+	defaultBufferSize     = 100
+)
 
-	defer func() {
-		err = conn.Close()
-		if err != nil {
-			log.Println("failed to close the connection")
-		}
-	}()
+var (
+	// ErrBufferFull is returned if the broker is not available and the message can not be buffered
+	ErrBufferFull = errors.New("broker is not available and the buffer is full")
 
-	return proc.publishToRmq(conn, payload)
+	errNotConfirmed    = errors.New("message was not confirmed by the broker")
+	errConfirmTimeout  = errors.New("message confirmation timeout")
+	errChannelClosed   = errors.New("channel closed")
+	errPublisherClosed = errors.New("publisher closed")
+)
+
+// publisherPool keeps the connection to the broker and the idle channels between the publications, the channels are
+// in the confirm mode, so the message is known to be accepted by the broker
+type publisherPool struct {
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	channels chan *confirmChannel
+	buffer   []bufferedMessage
+	flushing bool
+	closed   bool
 }
 
-func (proc *Rmq) publishToRmq(conn *amqp.Connection, jsonMessage string) error  {
-	ch, err := conn.Channel()
+type confirmChannel struct {
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+type bufferedMessage struct {
+	msg      queue.Envelope
+	received time.Time
+}
+
+// Publish publishes the message to the exchange and waits for the broker confirmation. If the broker is not
+// available the message is buffered and published once the connection is restored, ErrBuffered is returned then.
+func (proc *Rmq) Publish(msg queue.Envelope) error {
+	publishing, err := proc.newPublishing(msg)
 	if err != nil {
 		return err
 	}
 
+	err = proc.publish(publishing)
+	if err == nil {
+		return nil
+	}
+
+	logging.WithError(err).Warnf("Failed to publish the message")
+
+	return proc.bufferMessage(msg)
+}
+
+// Request publishes the message and waits for the reply of the consumer. The reply is received via RabbitMQ direct
+// reply-to, so no reply queue has to be declared. Returns queue.ErrReplyTimeout if the reply was not received in time. The
+// message is not buffered if the broker is not available, as nobody would wait for the reply.
+func (proc *Rmq) Request(msg queue.Envelope, timeout time.Duration) (string, error) {
+	// the reply is matched by the correlation id, the message id is used unless the publisher sets it
+	if msg.CorrelationID == "" {
+		msg.CorrelationID = msg.ID
+	}
+
+	msg.ReplyTo = directReplyTo
+
+	publishing, err := proc.newPublishing(msg)
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := proc.connection()
+	if err != nil {
+		return "", err
+	}
+
+	// the reply-to pseudo queue is consumed on the channel the request is published to, so the channel is not pooled
+	ch, err := conn.Channel()
+	if err != nil {
+		proc.resetConnection(conn)
+
+		return "", err
+	}
+
 	defer func() {
-		err = ch.Close()
-		if err != nil {
-			log.Println("failed to close the channel")
+		if err := ch.Close(); err != nil && err != amqp.ErrClosed {
+			logging.WithError(err).Warnf("failed to close the channel")
 		}
 	}()
 
-	msg := amqp.Publishing{
-		DeliveryMode: amqp.Persistent,
-		Timestamp:    time.Now(),
-		ContentType:  "text/plain",
-		Body:         []byte(jsonMessage),
-		Expiration:   defaultExp,
+	// the reply-to pseudo queue must be consumed before publishing, in no-ack mode
+	replies, err := ch.Consume(directReplyTo, "", true, true, false, false, nil)
+	if err != nil {
+		return "", err
+	}
+
+	err = ch.Publish(proc.config.Exchange, proc.config.RoutingKey, false, false, publishing)
+	if err != nil {
+		return "", err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case reply, ok := <-replies:
+			if !ok {
+				return "", queue.ErrReplyTimeout
+			}
+
+			if reply.CorrelationId == msg.CorrelationID {
+				return string(reply.Body), nil
+			}
+		case <-timer.C:
+			return "", queue.ErrReplyTimeout
+		}
+	}
+}
+
+// Close closes the pooled channels and the connection, the buffered messages are dropped
+func (proc *Rmq) Close() error {
+	pool := &proc.pool
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.closed = true
+
+	if len(pool.buffer) > 0 {
+		logging.Warnf("%d buffered message(s) dropped", len(pool.buffer))
+		pool.buffer = nil
+	}
+
+	if pool.conn == nil {
+		return nil
+	}
+
+	// closing the connection closes its channels too
+	err := pool.conn.Close()
+	pool.conn = nil
+
+	if err == amqp.ErrClosed {
+		return nil
+	}
+
+	return err
+}
+
+// newPublishing returns the message with the envelope as the JSON body, the envelope fields are set to the message
+// properties too, so they can be seen without parsing the body (e.g. in the management ui)
+func (proc *Rmq) newPublishing(msg queue.Envelope) (amqp.Publishing, error) {
+	if msg.Source == "" {
+		msg.Source = proc.config.Source
 	}
 
-	err = ch.Publish(
-		proc.config.Exchange,
-		proc.config.RoutingKey,
-		false,
-		false,
-		msg)
+	body, err := msg.ToJson()
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
 
+	return amqp.Publishing{
+		DeliveryMode:  amqp.Persistent,
+		Timestamp:     msg.Timestamp,
+		ContentType:   queue.ContentType,
+		MessageId:     msg.ID,
+		Type:          msg.Type,
+		AppId:         msg.Source,
+		CorrelationId: msg.CorrelationID,
+		ReplyTo:       msg.ReplyTo,
+		Body:          body,
+		Expiration:    expiration(proc.config.TTL()),
+	}, nil
+}
+
+// publish publishes the message on the pooled channel and waits for the confirmation. The channel is discarded if
+// the publication fails, as the confirmations of the channel can not be matched anymore.
+func (proc *Rmq) publish(msg amqp.Publishing) error {
+	cc, err := proc.acquireChannel()
 	if err != nil {
 		return err
 	}
 
+	err = cc.ch.Publish(proc.config.Exchange, proc.config.RoutingKey, false, false, msg)
+	if err == nil {
+		err = cc.waitConfirm(proc.confirmTimeout())
+	}
+
+	if err != nil {
+		_ = cc.ch.Close()
+
+		if cc.conn.IsClosed() {
+			proc.resetConnection(cc.conn)
+		}
+
+		return err
+	}
+
+	proc.releaseChannel(cc)
+
 	return nil
 }
+
+// connection returns the open connection, connecting to the broker if there is none
+func (proc *Rmq) connection() (*amqp.Connection, error) {
+	pool := &proc.pool
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.closed {
+		return nil, errPublisherClosed
+	}
+
+	if pool.conn != nil && !pool.conn.IsClosed() {
+		return pool.conn, nil
+	}
+
+	conn, err := proc.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	pool.conn = conn
+
+	if pool.channels == nil {
+		pool.channels = make(chan *confirmChannel, proc.poolSize())
+	}
+
+	return conn, nil
+}
+
+// resetConnection closes the failed connection, so the next publication connects again
+func (proc *Rmq) resetConnection(conn *amqp.Connection) {
+	pool := &proc.pool
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.conn == conn {
+		_ = conn.Close()
+		pool.conn = nil
+	}
+}
+
+// acquireChannel returns the idle channel of the current connection or opens a new one
+func (proc *Rmq) acquireChannel() (*confirmChannel, error) {
+	conn, err := proc.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		select {
+		case cc := <-proc.pool.channels:
+			// the channels of the previous connections are closed already
+			if cc.conn == conn {
+				return cc, nil
+			}
+		default:
+			return proc.openConfirmChannel(conn)
+		}
+	}
+}
+
+func (proc *Rmq) releaseChannel(cc *confirmChannel) {
+	select {
+	case proc.pool.channels <- cc:
+	default:
+		_ = cc.ch.Close()
+	}
+}
+
+func (proc *Rmq) openConfirmChannel(conn *amqp.Connection) (*confirmChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		proc.resetConnection(conn)
+
+		return nil, err
+	}
+
+	err = ch.Confirm(false)
+	if err != nil {
+		_ = ch.Close()
+
+		return nil, err
+	}
+
+	return &confirmChannel{
+		conn:     conn,
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+	}, nil
+}
+
+func (cc *confirmChannel) waitConfirm(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case confirm, ok := <-cc.confirms:
+		switch {
+		case !ok:
+			return errChannelClosed
+		case !confirm.Ack:
+			return errNotConfirmed
+		}
+
+		return nil
+	case <-timer.C:
+		return errConfirmTimeout
+	}
+}
+
+// bufferMessage keeps the message to be published when the broker is available again, the oldest messages are kept
+// if the buffer is full as the new ones are more likely to be retried by the user
+func (proc *Rmq) bufferMessage(msg queue.Envelope) error {
+	pool := &proc.pool
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.closed {
+		return errPublisherClosed
+	}
+
+	pool.buffer = dropExpired(pool.buffer, proc.config.TTL())
+
+	if len(pool.buffer) >= proc.bufferSize() {
+		return ErrBufferFull
+	}
+
+	pool.buffer = append(pool.buffer, bufferedMessage{msg: msg, received: time.Now()})
+
+	if !pool.flushing {
+		pool.flushing = true
+
+		go proc.flushBuffer()
+	}
+
+	return queue.ErrBuffered
+}
+
+// flushBuffer publishes the buffered messages in order, retrying with backoff until the buffer is empty
+func (proc *Rmq) flushBuffer() {
+	pool := &proc.pool
+	delay := queue.MinReconnectDelay
+
+	for {
+		pool.mu.Lock()
+		pool.buffer = dropExpired(pool.buffer, proc.config.TTL())
+
+		if len(pool.buffer) == 0 || pool.closed {
+			pool.flushing = false
+			pool.mu.Unlock()
+
+			return
+		}
+
+		message := pool.buffer[0]
+		pool.mu.Unlock()
+
+		// the publishing can not fail, the envelope was marshalled when it was published first
+		msg, _ := proc.newPublishing(message.msg)
+
+		// the buffered message expires as if it had been published when it was received
+		msg.Expiration = expiration(proc.config.TTL() - time.Since(message.received))
+
+		err := proc.publish(msg)
+		if err != nil {
+			logging.WithError(err).Debugf("Buffered message not published, retrying in %s", delay)
+			time.Sleep(delay)
+			delay = queue.NextReconnectDelay(delay)
+
+			continue
+		}
+
+		delay = queue.MinReconnectDelay
+
+		pool.mu.Lock()
+		if len(pool.buffer) > 0 && pool.buffer[0].msg.ID == message.msg.ID {
+			pool.buffer = pool.buffer[1:]
+		}
+		pool.mu.Unlock()
+
+		logging.Infof("Buffered message published")
+	}
+}
+
+// dropExpired drops the buffered messages older than the message expiration
+func dropExpired(buffer []bufferedMessage, ttl time.Duration) []bufferedMessage {
+	first := 0
+
+	for first < len(buffer) && time.Since(buffer[first].received) > ttl {
+		first++
+	}
+
+	if first > 0 {
+		logging.Warnf("%d buffered message(s) expired", first)
+	}
+
+	return buffer[first:]
+}
+
+// expiration formats the message expiration in milliseconds
+func expiration(ttl time.Duration) string {
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	return strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+}
+
+func (proc *Rmq) poolSize() int {
+	if proc.config.PoolSize > 0 {
+		return proc.config.PoolSize
+	}
+
+	return defaultPoolSize
+}
+
+func (proc *Rmq) confirmTimeout() time.Duration {
+	if proc.config.ConfirmTimeout > 0 {
+		return proc.config.ConfirmTimeout
+	}
+
+	return defaultConfirmTimeout
+}
+
+func (proc *Rmq) bufferSize() int {
+	if proc.config.BufferSize > 0 {
+		return proc.config.BufferSize
+	}
+
+	return defaultBufferSize
+}
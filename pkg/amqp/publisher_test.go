@@ -0,0 +1,59 @@
+package amqp
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"smh-apiengine/pkg/queue"
+)
+
+func Test_Publish_BuffersWhileBrokerIsDown(t *testing.T) {
+	// nothing listens on the port, so the messages are buffered
+	proc := NewRmq(&Config{Host: "127.0.0.1", Port: 1, BufferSize: 2})
+	msg, _ := queue.NewEnvelope(queue.TypeRunCommand, queue.CommandPayload{CommandID: "tv-on"})
+
+	assert.Equal(t, queue.ErrBuffered, proc.Publish(msg))
+	assert.Equal(t, queue.ErrBuffered, proc.Publish(msg))
+	assert.Equal(t, ErrBufferFull, proc.Publish(msg))
+
+	_, err := proc.Request(msg, time.Second)
+	assert.NotNil(t, err)
+	assert.NotEqual(t, queue.ErrBuffered, err)
+
+	assert.Nil(t, proc.Close())
+	assert.Equal(t, errPublisherClosed, proc.Publish(msg))
+}
+
+func Test_dropExpired(t *testing.T) {
+	buffer := []bufferedMessage{
+		{msg: queue.Envelope{ID: "old"}, received: time.Now().Add(-2 * time.Minute)},
+		{msg: queue.Envelope{ID: "new"}, received: time.Now()},
+	}
+
+	buffer = dropExpired(buffer, time.Minute)
+
+	assert.Len(t, buffer, 1)
+	assert.Equal(t, "new", buffer[0].msg.ID)
+}
+
+func Test_newPublishing(t *testing.T) {
+	proc := NewRmq(&Config{Options: queue.Options{Source: "lambda", MessageTTL: 10 * time.Second}})
+	msg, err := queue.NewEnvelope(queue.TypeAlexaIntent, json.RawMessage(`{"version":"1.0"}`))
+	assert.Nil(t, err)
+
+	publishing, err := proc.newPublishing(msg)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "application/json", publishing.ContentType)
+	assert.Equal(t, "10000", publishing.Expiration)
+	assert.Equal(t, msg.ID, publishing.MessageId)
+	assert.Equal(t, queue.TypeAlexaIntent, publishing.Type)
+	assert.Equal(t, "lambda", publishing.AppId)
+
+	parsed, err := queue.ParseEnvelope(publishing.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "lambda", parsed.Source)
+	assert.JSONEq(t, `{"version":"1.0"}`, string(parsed.Payload))
+}
@@ -0,0 +1,70 @@
+package amqp
+
+import (
+	"github.com/spf13/cast"
+	"github.com/streadway/amqp"
+	"strconv"
+)
+
+const (
+	// headerRetries counts the failed attempts of the message delayed in the retry queue
+	headerRetries = "x-smh-retries"
+	retrySuffix   = ".retry"
+)
+
+// publishChannel is the part of the channel publishing the replies, the retries and the dead letters
+type publishChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+func (c *Config) retryQueue() string {
+	return c.Queue + retrySuffix
+}
+
+// declareRetryQueue declares the queue delaying the failed messages. Nothing consumes the queue, the messages expire
+// after the retry delay and are dead-lettered back to the exchange of the consumed queue.
+func (proc *Rmq) declareRetryQueue(ch *amqp.Channel) error {
+	_, err := ch.QueueDeclare(proc.config.retryQueue(), true, false, false, false, amqp.Table{
+		"x-dead-letter-exchange":    proc.config.Exchange,
+		"x-dead-letter-routing-key": proc.config.RoutingKey,
+	})
+
+	return err
+}
+
+// deliveryAttempt returns the attempt of the delivered message, the retried message counts the previous attempts in
+// the header
+func deliveryAttempt(d amqp.Delivery) int {
+	return cast.ToInt(d.Headers[headerRetries]) + 1
+}
+
+// retry publishes the failed message to the retry queue, so it is consumed again after the retry delay while the
+// consumer goes on with the other messages
+func (proc *Rmq) retry(ch publishChannel, d amqp.Delivery, attempts int) error {
+	headers := amqp.Table{}
+
+	for key, value := range d.Headers {
+		headers[key] = value
+	}
+
+	headers[headerRetries] = int32(attempts)
+
+	return ch.Publish(
+		"",
+		proc.config.retryQueue(),
+		false,
+		false,
+		amqp.Publishing{
+			Headers:       headers,
+			DeliveryMode:  amqp.Persistent,
+			Expiration:    strconv.FormatInt(proc.config.RetryDelay.Milliseconds(), 10),
+			Timestamp:     d.Timestamp,
+			ContentType:   d.ContentType,
+			MessageId:     d.MessageId,
+			Type:          d.Type,
+			AppId:         d.AppId,
+			CorrelationId: d.CorrelationId,
+			ReplyTo:       d.ReplyTo,
+			Body:          d.Body,
+		})
+}
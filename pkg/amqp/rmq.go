@@ -1,13 +1,29 @@
 package amqp
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/streadway/amqp"
-	"log"
+	"io/ioutil"
+	"net/http"
+	"smh-apiengine/pkg/queue"
+	"time"
 )
 
+const (
+	DefaultHeartbeat = 10 * time.Second
+	defaultLocale = "en_US"
+)
+
+var errClientKeyPair = errors.New("both the client certificate and the key are required")
+
 type Rmq struct {
-	config *Config
+	config    *Config
+	status    queue.StatusTracker
+	pool      publisherPool
+	processor *queue.Processor
 }
 
 type Config struct {
@@ -18,32 +34,113 @@ type Config struct {
 	Exchange   string
 	Queue      string
 	RoutingKey string
+	DeadLetterExchange string // <Exchange>.dead if empty
+	DeadLetterQueue string // <Queue>.dead if empty
+	PoolSize int // max number of the idle publisher channels kept open
+	ConfirmTimeout time.Duration // how long the publisher waits for the broker to confirm the message
+	BufferSize int // max number of the messages buffered while the broker is not available
+	VHost string // "/" if empty
+	TLS bool // connect with amqps, implied by the certificates
+	CACert string // CA certificate file verifying the broker, the system CAs if empty
+	ClientCert string // client certificate file, e.g. for the EXTERNAL auth of RabbitMQ
+	ClientKey string // key file of the client certificate
+	Heartbeat time.Duration // 10 seconds if zero
+	Prefetch int // max number of the unacknowledged messages delivered to the consumer, unlimited if zero
+	queue.Options
+}
+
+func NewRmq(config *Config) *Rmq  {
+	return &Rmq{config:config, processor: queue.NewProcessor(&config.Options)}
 }
 
-type MessageHandler interface {
-	handle(req string)
+// Status returns the current connection state of the consumer
+func (proc *Rmq) Status() queue.Status {
+	return proc.status.Status()
 }
 
-func NewRmq(config *Config) *Rmq  {
-	return &Rmq{config:config}
+// HealthHandler responds with the consumer status, the status code is 503 if the consumer is not connected
+func (proc *Rmq) HealthHandler() http.Handler {
+	return queue.HealthHandler(proc.Status)
 }
 
+// connect dials the broker, the certificates are loaded on every connection, so the renewed ones are picked up
 func (proc *Rmq) connect() (*amqp.Connection, error)  {
-	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:%d/",
-		proc.config.Login,
-		proc.config.Password,
-		proc.config.Host,
-		proc.config.Port))
-
+	tlsConfig, err := proc.config.tlsConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	return conn, nil
+	heartbeat := proc.config.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeat
+	}
+
+	return amqp.DialConfig(proc.config.url(), amqp.Config{
+		Heartbeat:       heartbeat,
+		TLSClientConfig: tlsConfig,
+		Locale:          defaultLocale,
+	})
 }
 
-func displayError(err error, msg string) {
-	if err != nil {
-		log.Fatalf("%s: %s", msg, err)
+// url returns the amqp or amqps url of the broker
+func (config *Config) url() string {
+	uri := amqp.URI{
+		Scheme:   "amqp",
+		Host:     config.Host,
+		Port:     config.Port,
+		Username: config.Login,
+		Password: config.Password,
+		Vhost:    config.VHost,
+	}
+
+	if config.useTLS() {
+		uri.Scheme = "amqps"
+	}
+
+	if uri.Vhost == "" {
+		uri.Vhost = "/"
+	}
+
+	return uri.String()
+}
+
+func (config *Config) useTLS() bool {
+	return config.TLS || config.CACert != "" || config.ClientCert != ""
+}
+
+// tlsConfig returns the TLS settings with the CA and the client certificates, nil if TLS is not used
+func (config *Config) tlsConfig() (*tls.Config, error) {
+	if !config.useTLS() {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if config.CACert != "" {
+		pem, err := ioutil.ReadFile(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CACert)
+		}
+	}
+
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return nil, errClientKeyPair
 	}
+
+	if config.ClientCert != "" {
+		certificate, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
 }
@@ -0,0 +1,90 @@
+package amqp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCertificate writes the self-signed certificate and its key to the directory
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smh"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return certFile, keyFile
+}
+
+func Test_Config_url(t *testing.T) {
+	tests := []struct {
+		config Config
+		uri    amqp.URI
+	}{
+		{Config{Host: "rabbit", Port: 5672, Login: "guest", Password: "guest"},
+			amqp.URI{Scheme: "amqp", Host: "rabbit", Port: 5672, Username: "guest", Password: "guest", Vhost: "/"}},
+		{Config{Host: "rabbit", Port: 5671, Login: "smh", Password: "secret", VHost: "home", TLS: true},
+			amqp.URI{Scheme: "amqps", Host: "rabbit", Port: 5671, Username: "smh", Password: "secret", Vhost: "home"}},
+		{Config{Host: "rabbit", Port: 5672, Login: "smh", Password: "secret", CACert: "ca.pem"},
+			amqp.URI{Scheme: "amqps", Host: "rabbit", Port: 5672, Username: "smh", Password: "secret", Vhost: "/"}},
+	}
+
+	for _, test := range tests {
+		uri, err := amqp.ParseURI(test.config.url())
+
+		assert.Nil(t, err)
+		assert.Equal(t, test.uri, uri)
+	}
+}
+
+func Test_Config_tlsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smh-amqp")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCertificate(t, dir)
+
+	tlsConfig, err := (&Config{}).tlsConfig()
+	assert.Nil(t, err)
+	assert.Nil(t, tlsConfig)
+
+	tlsConfig, err = (&Config{CACert: certFile, ClientCert: certFile, ClientKey: keyFile}).tlsConfig()
+	assert.Nil(t, err)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Len(t, tlsConfig.Certificates, 1)
+
+	_, err = (&Config{ClientCert: certFile}).tlsConfig()
+	assert.Equal(t, errClientKeyPair, err)
+
+	_, err = (&Config{CACert: keyFile}).tlsConfig()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTokenInvalid = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenExists  = errors.New("token with this name already exists")
+	ErrTokenUnknown = errors.New("token not found")
+)
+
+// TokenStore struct keeps the named tokens and persists them to the JSON file
+type TokenStore struct {
+	Tokens   map[string]*Token `json:"tokens"`
+	fileName string
+	mu       sync.RWMutex
+}
+
+// NewTokenStore loads the tokens from provided file, a missing file results in an empty store
+func NewTokenStore(fileName string) (*TokenStore, error) {
+	store := &TokenStore{
+		Tokens:   make(map[string]*Token),
+		fileName: fileName,
+	}
+
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, store)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse tokens file \"%s\": %s", fileName, err)
+	}
+
+	if store.Tokens == nil {
+		store.Tokens = make(map[string]*Token)
+	}
+
+	return store, nil
+}
+
+// Empty returns true if there are no tokens in the store
+func (s *TokenStore) Empty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.Tokens) == 0
+}
+
+// Add creates a new token with provided scopes and returns its secret. The secret is not stored and can not be
+// recovered later
+func (s *TokenStore) Add(name string, scopes []string, controls []string, ttl time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Tokens[name]; ok {
+		return "", ErrTokenExists
+	}
+
+	for _, scope := range scopes {
+		if !isKnownScope(scope) {
+			return "", fmt.Errorf("unknown scope \"%s\"", scope)
+		}
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	token := &Token{
+		Name:      name,
+		Hash:      HashSecret(secret),
+		Scopes:    scopes,
+		Controls:  controls,
+		CreatedAt: time.Now(),
+	}
+
+	if ttl > 0 {
+		expiresAt := token.CreatedAt.Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+
+	s.Tokens[name] = token
+
+	return secret, nil
+}
+
+// Revoke removes the token with provided name
+func (s *TokenStore) Revoke(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Tokens[name]; !ok {
+		return ErrTokenUnknown
+	}
+
+	delete(s.Tokens, name)
+
+	return nil
+}
+
+// List returns the tokens sorted by name
+func (s *TokenStore) List() []Token {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := make([]Token, 0, len(s.Tokens))
+
+	for _, token := range s.Tokens {
+		tokens = append(tokens, *token)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Name < tokens[j].Name
+	})
+
+	return tokens
+}
+
+// Authenticate finds the token matching provided secret
+func (s *TokenStore) Authenticate(secret string) (*Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash := []byte(HashSecret(secret))
+
+	for _, token := range s.Tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(token.Hash)) != 1 {
+			continue
+		}
+
+		if token.Expired(time.Now()) {
+			return nil, ErrTokenExpired
+		}
+
+		return token, nil
+	}
+
+	return nil, ErrTokenInvalid
+}
+
+// Save writes the tokens to the store file
+func (s *TokenStore) Save() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.fileName, data, 0600)
+}
+
+func isKnownScope(scope string) bool {
+	for _, known := range AllScopes {
+		if known == scope {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TokenStore_AddAuthenticateAndPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smh-tokens")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "tokens.json")
+	store, err := NewTokenStore(fileName)
+	assert.Nil(t, err)
+	assert.True(t, store.Empty())
+
+	secret, err := store.Add("tablet", []string{ScopeRead, ScopeRunControls}, []string{"lights"}, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Save())
+
+	contents, err := ioutil.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(contents), secret)
+
+	loaded, err := NewTokenStore(fileName)
+	assert.Nil(t, err)
+
+	token, err := loaded.Authenticate(secret)
+	assert.Nil(t, err)
+	assert.Equal(t, "tablet", token.Name)
+	assert.True(t, token.HasScope(ScopeRead))
+	assert.False(t, token.HasScope(ScopeRunCommands))
+	assert.True(t, token.AllowsControl("lights"))
+	assert.False(t, token.AllowsControl("tv"))
+
+	_, err = loaded.Authenticate("wrong")
+	assert.Equal(t, ErrTokenInvalid, err)
+}
+
+func Test_TokenStore_ExpiredAndRevoked(t *testing.T) {
+	store, err := NewTokenStore(filepath.Join(os.TempDir(), "smh-tokens-not-existing.json"))
+	assert.Nil(t, err)
+
+	secret, err := store.Add("guest", []string{ScopeAdmin}, nil, time.Nanosecond)
+	assert.Nil(t, err)
+
+	time.Sleep(time.Millisecond)
+
+	_, err = store.Authenticate(secret)
+	assert.Equal(t, ErrTokenExpired, err)
+
+	_, err = store.Add("guest", []string{ScopeRead}, nil, 0)
+	assert.Equal(t, ErrTokenExists, err)
+
+	_, err = store.Add("other", []string{"unknown"}, nil, 0)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, store.Revoke("guest"))
+	assert.Equal(t, ErrTokenUnknown, store.Revoke("guest"))
+}
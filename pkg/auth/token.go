@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	ScopeRead        = "read"     // read controls, device states and server information
+	ScopeRunCommands = "run"      // run any command, scenario, intent or control item
+	ScopeRunControls = "controls" // run control items, restricted to Token.Controls if set
+	ScopeForward     = "forward"  // tell the source of the forwarded executions, e.g. by the RMQ consumer
+	ScopeAdmin       = "admin"    // everything including configuration write
+)
+
+// AllScopes is the list of supported scopes
+var AllScopes = []string{ScopeRead, ScopeRunCommands, ScopeRunControls, ScopeForward, ScopeAdmin}
+
+const secretLength = 32
+
+type ctxKey struct{}
+
+// Token struct represents a named api token. Only the hash of the secret is stored
+type Token struct {
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	Scopes    []string   `json:"scopes"`
+	Controls  []string   `json:"controls,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// HasScope checks whether the token was granted the scope, admin scope grants all the scopes
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsControl checks whether the token may run items of the control with provided id
+func (t *Token) AllowsControl(controlID string) bool {
+	if t.HasScope(ScopeRunCommands) {
+		return true
+	}
+
+	if !t.HasScope(ScopeRunControls) {
+		return false
+	}
+
+	if len(t.Controls) == 0 {
+		return true
+	}
+
+	for _, id := range t.Controls {
+		if id == controlID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired checks whether the token expiry time has passed
+func (t *Token) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && now.After(*t.ExpiresAt)
+}
+
+// NewContext returns a copy of the context carrying the authenticated token
+func NewContext(ctx context.Context, token *Token) context.Context {
+	return context.WithValue(ctx, ctxKey{}, token)
+}
+
+// FromContext returns the authenticated token from the context or nil if there is none
+func FromContext(ctx context.Context) *Token {
+	token, _ := ctx.Value(ctxKey{}).(*Token)
+
+	return token
+}
+
+// HashSecret returns hex encoded sha256 hash of the token secret
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func generateSecret() (string, error) {
+	buf := make([]byte, secretLength)
+
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
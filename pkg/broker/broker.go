@@ -0,0 +1,118 @@
+// Package broker abstracts the message broker bridging the cloud publishers and the home consumers. The brokers carry
+// the queue envelopes, the consumed messages are handled by the queue processor, so the retries, deduplication and
+// expiry work the same way with every broker.
+package broker
+
+import (
+	"context"
+	"fmt"
+	"smh-apiengine/pkg/amqp"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/queue"
+	"time"
+)
+
+// Broker types
+const (
+	// TypeAMQP is RabbitMQ, the default broker
+	TypeAMQP = "amqp"
+	// TypeMQTT is any MQTT 3.1.1 broker, e.g. mosquitto running on the home router
+	TypeMQTT = "mqtt"
+	// TypeFile is the queue kept in the local directory, for the single box setups with the publisher and the
+	// consumer on the same machine. There is no SQLite backed queue on purpose, its driver would bring cgo or a
+	// large dependency for no gain, the directory already survives the restarts
+	TypeFile = "file"
+	// TypeMemory is the queue kept in the process memory, for the tests
+	TypeMemory = "memory"
+)
+
+// Broker publishes the messages and consumes them
+type Broker interface {
+	// Publish publishes the message without waiting for the reply
+	Publish(msg queue.Envelope) error
+	// Request publishes the message and waits for the reply of the consumer, returns queue.ErrReplyTimeout if the
+	// reply was not received in time
+	Request(msg queue.Envelope, timeout time.Duration) (string, error)
+	// Consume handles the messages until the context is cancelled, the lost connection is restored
+	Consume(ctx context.Context, handler queue.MessageHandler)
+	// Status returns the connection state of the consumer
+	Status() queue.Status
+	Close() error
+}
+
+// DeadLetters is implemented by the brokers keeping the messages that could not be handled
+type DeadLetters interface {
+	// DeadLetters returns up to the limit of the dead letters (all if the limit is zero)
+	DeadLetters(limit int) ([]queue.DeadLetter, error)
+	// ReplayDeadLetters publishes the dead letters selected by the filter again, returns the number of the replayed
+	// messages
+	ReplayDeadLetters(filter queue.DeadLetterFilter) (int, error)
+	// PurgeDeadLetters removes all the dead letters, returns the number of the removed messages
+	PurgeDeadLetters() (int, error)
+}
+
+// Config struct is the settings of the broker, only the settings of the selected type are used
+type Config struct {
+	Type string // amqp if empty
+	queue.Options
+	AMQP amqp.Config
+	MQTT MQTTConfig
+	File FileConfig
+}
+
+// Open returns the broker of the configured type, the connection is established by the first publication or by the
+// consumer
+func Open(config *Config) (Broker, error) {
+	switch config.Type {
+	case "", TypeAMQP:
+		amqpConfig := config.AMQP
+		amqpConfig.Options = config.Options
+
+		return amqp.NewRmq(&amqpConfig), nil
+	case TypeMQTT:
+		return NewMQTT(config.MQTT, config.Options), nil
+	case TypeFile:
+		return NewFile(config.File, config.Options)
+	case TypeMemory:
+		return NewMemory(config.Options), nil
+	}
+
+	return nil, fmt.Errorf("unknown broker type %q (values: %q, %q, %q, %q)", config.Type, TypeAMQP, TypeMQTT,
+		TypeFile, TypeMemory)
+}
+
+// parseMessage parses the envelope of the consumed message, the envelope without the id (e.g. the raw alexa request)
+// gets a new one
+func parseMessage(body []byte) (queue.Envelope, error) {
+	msg, err := queue.ParseEnvelope(body)
+	if err != nil {
+		return queue.Envelope{}, err
+	}
+
+	if msg.ID == "" {
+		msg.ID = logging.NewRequestID()
+	}
+
+	return msg, nil
+}
+
+// withSource sets the source of the published message if the publisher did not set it
+func withSource(msg queue.Envelope, options *queue.Options) queue.Envelope {
+	if msg.Source == "" {
+		msg.Source = options.Source
+	}
+
+	return msg
+}
+
+// withReplyTo sets the reply address of the requested message, the reply is matched by the correlation id, the
+// message id is used unless the publisher sets it
+func withReplyTo(msg queue.Envelope, replyTo string) queue.Envelope {
+	if msg.CorrelationID == "" {
+		msg.CorrelationID = msg.ID
+	}
+
+	msg.ReplyTo = replyTo
+
+	return msg
+}
@@ -0,0 +1,199 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"smh-apiengine/pkg/mqtt"
+	"smh-apiengine/pkg/queue"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testOptions = queue.Options{
+	Source:        "test",
+	MaxRetries:    1,
+	RetryDelay:    time.Millisecond,
+	MaxMessageAge: 500 * time.Millisecond,
+}
+
+// echoHandler replies with the payload and rejects the messages of the unknown types unless it accepts all
+type echoHandler struct {
+	handled   chan queue.Envelope
+	acceptAll int32
+}
+
+func (h *echoHandler) Handle(msg queue.Envelope) (string, error) {
+	if msg.Type != queue.TypeRunCommand && atomic.LoadInt32(&h.acceptAll) == 0 {
+		return "", queue.ErrRejected
+	}
+
+	h.handled <- msg
+
+	return string(msg.Payload), nil
+}
+
+func newCommand(t *testing.T, commandID string) queue.Envelope {
+	msg, err := queue.NewEnvelope(queue.TypeRunCommand, queue.CommandPayload{CommandID: commandID})
+	assert.Nil(t, err)
+
+	return msg
+}
+
+func waitHandled(t *testing.T, handled chan queue.Envelope) queue.Envelope {
+	select {
+	case msg := <-handled:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("message not handled")
+	}
+
+	return queue.Envelope{}
+}
+
+// testBroker checks the publication, the request with the reply and the dead letters of the broker
+func testBroker(t *testing.T, b Broker) {
+	handler := &echoHandler{handled: make(chan queue.Envelope, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		b.Consume(ctx, handler)
+	}()
+
+	defer func() {
+		cancel()
+		<-stopped
+		assert.Equal(t, queue.StateStopped, b.Status().State)
+	}()
+
+	// the messages published before the consumer subscribes may be lost by mqtt
+	for b.Status().State != queue.StateConnected {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Nil(t, b.Publish(newCommand(t, "tv-on")))
+
+	msg := waitHandled(t, handler.handled)
+	assert.Equal(t, "test", msg.Source)
+	assert.JSONEq(t, `{"command_id": "tv-on"}`, string(msg.Payload))
+
+	reply, err := b.Request(newCommand(t, "tv-off"), 3*time.Second)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"command_id": "tv-off"}`, reply)
+	waitHandled(t, handler.handled)
+
+	rejected, _ := queue.NewEnvelope("run-macro", struct{}{})
+	_, err = b.Request(rejected, 200*time.Millisecond)
+	assert.True(t, errors.Is(err, queue.ErrReplyTimeout))
+
+	deadLetters, ok := b.(DeadLetters)
+	if !ok {
+		return
+	}
+
+	letters, err := deadLetters.DeadLetters(0)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 1)
+	assert.Equal(t, rejected.ID, letters[0].ID)
+	assert.Equal(t, "run-macro", letters[0].Type)
+	assert.Equal(t, 1, letters[0].Attempts)
+
+	// the replayed message is handled even if it is older than the max age
+	time.Sleep(testOptions.MaxMessageAge)
+	atomic.StoreInt32(&handler.acceptAll, 1)
+
+	replayed, err := deadLetters.ReplayDeadLetters(func(letter queue.DeadLetter) bool {
+		return letter.ID == rejected.ID
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, replayed)
+	assert.Equal(t, rejected.ID, waitHandled(t, handler.handled).ID)
+
+	letters, err = deadLetters.DeadLetters(0)
+	assert.Nil(t, err)
+	assert.Len(t, letters, 0)
+}
+
+func Test_Memory(t *testing.T) {
+	testBroker(t, NewMemory(testOptions))
+}
+
+func Test_Memory_DropsUnexpectedReply(t *testing.T) {
+	m := NewMemory(testOptions)
+	m.replies["correlation"] = make(chan string, 1)
+
+	// the second reply (e.g. of the duplicate message) does not block the consumer
+	m.reply("correlation", "first")
+	m.reply("correlation", "second")
+
+	assert.Equal(t, "first", <-m.replies["correlation"])
+}
+
+func Test_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smh-queue")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := NewFile(FileConfig{Dir: dir, PollInterval: 10 * time.Millisecond}, testOptions)
+	assert.Nil(t, err)
+
+	testBroker(t, f)
+}
+
+func Test_File_RecoversUnfinishedMessages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smh-queue")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := NewFile(FileConfig{Dir: dir, PollInterval: 10 * time.Millisecond}, testOptions)
+	assert.Nil(t, err)
+
+	msg := newCommand(t, "tv-on")
+	body, _ := msg.ToJson()
+	assert.Nil(t, ioutil.WriteFile(f.path(processingDir, fileName(msg.ID)), body, 0600))
+
+	handler := &echoHandler{handled: make(chan queue.Envelope, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go f.Consume(ctx, handler)
+
+	assert.Equal(t, msg.ID, waitHandled(t, handler.handled).ID)
+}
+
+func Test_MQTT(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	server := mqtt.NewServer(mqtt.ServerOptions{})
+	defer server.Close()
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	b := NewMQTT(MQTTConfig{Address: listener.Addr().String(), Topic: "smh/test"}, testOptions)
+	defer b.Close()
+
+	testBroker(t, b)
+}
+
+func Test_Open(t *testing.T) {
+	b, err := Open(&Config{Type: TypeMemory})
+	assert.Nil(t, err)
+	assert.IsType(t, &Memory{}, b)
+
+	_, err = Open(&Config{Type: TypeFile})
+	assert.Equal(t, errQueueDirMissing, err)
+
+	_, err = Open(&Config{Type: "kafka"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,445 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/queue"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPollInterval = 100 * time.Millisecond
+
+	// staleReplyAge is how long the replies nobody waits for anymore are kept
+	staleReplyAge = time.Minute
+
+	queueDir      = "queue"
+	processingDir = "processing"
+	repliesDir    = "replies"
+	deadDir       = "dead"
+	tmpDir        = "tmp"
+
+	messageSuffix  = ".json"
+	replayedSuffix = ".replayed.json"
+)
+
+var errQueueDirMissing = errors.New("queue directory is required by the file broker")
+
+// FileConfig struct is the settings of the file broker
+type FileConfig struct {
+	Dir          string        // directory keeping the queue, created if missing
+	PollInterval time.Duration // how often the queue and the replies are checked, 100ms if zero
+}
+
+// File struct is the broker keeping the messages as files in the local directory, so they survive the restarts.
+// Every message is written to the tmp directory and renamed into the queue, the consumer claims the message by
+// renaming it to the processing directory, so the half written or half handled messages are never lost. The replies
+// and the dead letters are kept in their directories too. Only one consumer may consume the directory.
+type File struct {
+	config    FileConfig
+	options   queue.Options
+	processor *queue.Processor
+	status    queue.StatusTracker
+}
+
+// fileReply struct is the content of the reply file
+type fileReply struct {
+	Reply string `json:"reply"`
+}
+
+func NewFile(config FileConfig, options queue.Options) (*File, error) {
+	if config.Dir == "" {
+		return nil, errQueueDirMissing
+	}
+
+	f := &File{config: config, options: options}
+	f.processor = queue.NewProcessor(&f.options)
+
+	return f, f.makeDirs()
+}
+
+// Publish writes the message to the queue
+func (f *File) Publish(msg queue.Envelope) error {
+	return f.enqueue(withSource(msg, &f.options), messageSuffix)
+}
+
+// Request writes the message to the queue and polls for the reply file of the consumer
+func (f *File) Request(msg queue.Envelope, timeout time.Duration) (string, error) {
+	msg = withReplyTo(withSource(msg, &f.options), repliesDir)
+	replyPath := f.path(repliesDir, fileName(msg.CorrelationID))
+
+	// the late reply is not needed anymore
+	defer removeFile(replyPath)
+
+	err := f.enqueue(msg, messageSuffix)
+	if err != nil {
+		return "", err
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		content, err := ioutil.ReadFile(replyPath)
+
+		if err == nil {
+			var reply fileReply
+
+			err = json.Unmarshal(content, &reply)
+
+			return reply.Reply, err
+		}
+
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		time.Sleep(f.pollInterval())
+	}
+
+	return "", queue.ErrReplyTimeout
+}
+
+// Consume handles the queued messages in the order they were published until the context is cancelled. The messages
+// left in the processing directory by the stopped consumer are returned to the queue first.
+func (f *File) Consume(ctx context.Context, handler queue.MessageHandler) {
+	queue.Supervise(ctx, &f.status, "File queue", func(ctx context.Context) (bool, error) {
+		return f.consumeOnce(ctx, handler)
+	})
+}
+
+func (f *File) consumeOnce(ctx context.Context, handler queue.MessageHandler) (bool, error) {
+	err := f.makeDirs()
+	if err != nil {
+		return false, err
+	}
+
+	err = f.recover()
+	if err != nil {
+		return false, err
+	}
+
+	f.status.Set(queue.StateConnected, nil)
+	logging.Infof("File queue consumer started in %s", f.config.Dir)
+
+	var cleaned time.Time
+
+	for {
+		names, err := f.list(queueDir)
+		if err != nil {
+			return true, err
+		}
+
+		for _, name := range names {
+			if ctx.Err() != nil {
+				return true, nil
+			}
+
+			err = f.process(ctx, name, handler)
+			if err != nil {
+				return true, err
+			}
+		}
+
+		if time.Since(cleaned) > staleReplyAge {
+			f.removeStaleReplies()
+			cleaned = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case <-time.After(f.pollInterval()):
+		}
+	}
+}
+
+// process claims the queued message, handles it and removes it. Returns the error if the queue directory is not
+// usable anymore.
+func (f *File) process(ctx context.Context, name string, handler queue.MessageHandler) error {
+	claimed := f.path(processingDir, name)
+
+	err := os.Rename(f.path(queueDir, name), claimed)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadFile(claimed)
+	if err != nil {
+		return err
+	}
+
+	msg, err := parseMessage(body)
+	if err != nil {
+		return f.deadLetter(name, body, 1, err)
+	}
+
+	result := f.processor.Process(ctx, msg, strings.HasSuffix(name, replayedSuffix), handler)
+
+	switch result.Outcome {
+	case queue.Handled, queue.Skipped:
+		if msg.ReplyTo != "" && result.Reply != "" {
+			f.reply(msg.CorrelationID, result.Reply)
+		}
+	case queue.Failed:
+		return f.deadLetter(name, body, result.Attempts, result.Err)
+	case queue.Interrupted:
+		return os.Rename(claimed, f.path(queueDir, name))
+	}
+
+	return os.Remove(claimed)
+}
+
+// reply writes the reply file, the reply is lost if it can not be written
+func (f *File) reply(correlationID string, reply string) {
+	content, err := json.Marshal(fileReply{Reply: reply})
+	if err == nil {
+		err = f.writeFile(repliesDir, fileName(correlationID), content)
+	}
+
+	if err != nil {
+		logging.WithError(err).Warnf("Failed to send the reply")
+	}
+}
+
+// deadLetter moves the claimed message to the dead letters with the failure details
+func (f *File) deadLetter(name string, body []byte, attempts int, cause error) error {
+	content, err := json.Marshal(queue.NewDeadLetter(body, cause.Error(), attempts, time.Now().UTC()))
+	if err != nil {
+		return err
+	}
+
+	err = f.writeFile(deadDir, name, content)
+	if err != nil {
+		return err
+	}
+
+	logging.WithError(cause).Warnf("Message dead-lettered to %s after %d attempt(s)", f.path(deadDir), attempts)
+
+	return os.Remove(f.path(processingDir, name))
+}
+
+// Status returns the consumer state, the file queue is connected while the directory is usable
+func (f *File) Status() queue.Status {
+	return f.status.Status()
+}
+
+func (f *File) Close() error {
+	return nil
+}
+
+// DeadLetters returns up to the limit of the dead letters, the oldest first
+func (f *File) DeadLetters(limit int) ([]queue.DeadLetter, error) {
+	var letters []queue.DeadLetter
+
+	err := f.withDeadLetters(func(name string, letter queue.DeadLetter) (bool, error) {
+		letters = append(letters, letter)
+
+		return limit <= 0 || len(letters) < limit, nil
+	})
+
+	return letters, err
+}
+
+// ReplayDeadLetters writes the dead letters selected by the filter to the queue again and removes them
+func (f *File) ReplayDeadLetters(filter queue.DeadLetterFilter) (int, error) {
+	replayed := 0
+
+	err := f.withDeadLetters(func(name string, letter queue.DeadLetter) (bool, error) {
+		if !filter(letter) {
+			return true, nil
+		}
+
+		// the replayed message is marked by the name, so it is handled even if it is older than the max age
+		replayedName := strings.TrimSuffix(strings.TrimSuffix(name, replayedSuffix), messageSuffix) + replayedSuffix
+
+		err := f.writeFile(queueDir, replayedName, []byte(letter.Body))
+		if err != nil {
+			return false, err
+		}
+
+		replayed++
+
+		return true, os.Remove(f.path(deadDir, name))
+	})
+
+	return replayed, err
+}
+
+// PurgeDeadLetters removes all the dead letters
+func (f *File) PurgeDeadLetters() (int, error) {
+	purged := 0
+
+	err := f.withDeadLetters(func(name string, letter queue.DeadLetter) (bool, error) {
+		purged++
+
+		return true, os.Remove(f.path(deadDir, name))
+	})
+
+	return purged, err
+}
+
+func (f *File) withDeadLetters(visit func(name string, letter queue.DeadLetter) (bool, error)) error {
+	names, err := f.list(deadDir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		content, err := ioutil.ReadFile(f.path(deadDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		var letter queue.DeadLetter
+
+		err = json.Unmarshal(content, &letter)
+		if err != nil {
+			return fmt.Errorf("dead letter %s: %w", name, err)
+		}
+
+		next, err := visit(name, letter)
+		if err != nil || !next {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enqueue writes the message to the queue, the name starts with the time, so the messages are consumed in order
+func (f *File) enqueue(msg queue.Envelope, suffix string) error {
+	content, err := msg.ToJson()
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%s", time.Now().UnixNano(), strings.TrimSuffix(fileName(msg.ID), messageSuffix))
+
+	return f.writeFile(queueDir, name+suffix, content)
+}
+
+// recover returns the messages claimed by the stopped consumer to the queue
+func (f *File) recover() error {
+	names, err := f.list(processingDir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = os.Rename(f.path(processingDir, name), f.path(queueDir, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(names) > 0 {
+		logging.Warnf("%d unfinished message(s) returned to the queue", len(names))
+	}
+
+	return nil
+}
+
+func (f *File) removeStaleReplies() {
+	files, err := ioutil.ReadDir(f.path(repliesDir))
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if time.Since(file.ModTime()) > staleReplyAge {
+			removeFile(f.path(repliesDir, file.Name()))
+		}
+	}
+}
+
+// writeFile writes the file to the tmp directory and renames it to the target directory, so the readers never see
+// the partially written file
+func (f *File) writeFile(dir string, name string, content []byte) error {
+	tmp := f.path(tmpDir, name)
+
+	err := ioutil.WriteFile(tmp, content, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, f.path(dir, name))
+}
+
+// list returns the names of the message files in the directory sorted by the name
+func (f *File) list(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(f.path(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), messageSuffix) {
+			names = append(names, file.Name())
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (f *File) makeDirs() error {
+	for _, dir := range []string{queueDir, processingDir, repliesDir, deadDir, tmpDir} {
+		err := os.MkdirAll(f.path(dir), 0700)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *File) path(elem ...string) string {
+	return filepath.Join(append([]string{f.config.Dir}, elem...)...)
+}
+
+func (f *File) pollInterval() time.Duration {
+	if f.config.PollInterval > 0 {
+		return f.config.PollInterval
+	}
+
+	return defaultPollInterval
+}
+
+// fileName returns the file name of the message id, the characters that are not safe in the file names are replaced
+func fileName(id string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+
+		return '_'
+	}, id)
+
+	return safe + messageSuffix
+}
+
+func removeFile(path string) {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		logging.WithError(err).Warnf("Failed to remove %s", path)
+	}
+}
@@ -0,0 +1,111 @@
+package broker
+
+import (
+	"smh-apiengine/pkg/amqp"
+
+	"github.com/urfave/cli/v2"
+)
+
+// CliFlags returns the flags selecting the broker, the AMQP connection settings and the settings of the MQTT and file
+// brokers, the environment variables are prefixed by the prefix of the application. The AMQP host, login and queue
+// settings are configured by the application flags.
+func CliFlags(config *Config, envPrefix string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "broker",
+			Value:       TypeAMQP,
+			Usage:       "Message broker (values: \"amqp\", \"mqtt\", \"file\")",
+			Destination: &config.Type,
+			EnvVars:     []string{envPrefix + "BROKER"},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-vhost",
+			Value:       "/",
+			Usage:       "RabbitMQ Virtual host",
+			Destination: &config.AMQP.VHost,
+			EnvVars:     []string{envPrefix + "RMQ_VHOST"},
+		},
+		&cli.BoolFlag{
+			Name:        "rmq-tls",
+			Usage:       "Connect to RabbitMQ with amqps, implied by the certificate options (the port is usually 5671)",
+			Destination: &config.AMQP.TLS,
+			EnvVars:     []string{envPrefix + "RMQ_TLS"},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-ca-cert",
+			Usage:       "CA certificate file verifying RabbitMQ (default: the system CAs)",
+			Destination: &config.AMQP.CACert,
+			EnvVars:     []string{envPrefix + "RMQ_CA_CERT"},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-cert",
+			Usage:       "Client certificate file for RabbitMQ",
+			Destination: &config.AMQP.ClientCert,
+			EnvVars:     []string{envPrefix + "RMQ_CERT"},
+		},
+		&cli.StringFlag{
+			Name:        "rmq-key",
+			Usage:       "Key file of the RabbitMQ client certificate",
+			Destination: &config.AMQP.ClientKey,
+			EnvVars:     []string{envPrefix + "RMQ_KEY"},
+		},
+		&cli.DurationFlag{
+			Name:        "rmq-heartbeat",
+			Value:       amqp.DefaultHeartbeat,
+			Usage:       "RabbitMQ heartbeat interval detecting the lost connection",
+			Destination: &config.AMQP.Heartbeat,
+			EnvVars:     []string{envPrefix + "RMQ_HEARTBEAT"},
+		},
+		&cli.IntFlag{
+			Name:        "rmq-prefetch",
+			Usage:       "Max number of the unacknowledged messages RabbitMQ delivers to the consumer (unlimited if zero)",
+			Destination: &config.AMQP.Prefetch,
+			EnvVars:     []string{envPrefix + "RMQ_PREFETCH"},
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-address",
+			Value:       defaultMQTTAddress,
+			Usage:       "MQTT broker host:port",
+			Destination: &config.MQTT.Address,
+			EnvVars:     []string{envPrefix + "MQTT_ADDRESS"},
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-client-id",
+			Usage:       "Prefix of the MQTT client ids (default: \"smh\")",
+			Destination: &config.MQTT.ClientID,
+			EnvVars:     []string{envPrefix + "MQTT_CLIENT_ID"},
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-login",
+			Usage:       "MQTT Login",
+			Destination: &config.MQTT.Username,
+			EnvVars:     []string{envPrefix + "MQTT_LOGIN"},
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-password",
+			Usage:       "MQTT Password",
+			Destination: &config.MQTT.Password,
+			EnvVars:     []string{envPrefix + "MQTT_PASSWORD"},
+		},
+		&cli.StringFlag{
+			Name:        "mqtt-topic",
+			Value:       defaultMQTTTopic,
+			Usage:       "MQTT topic of the messages, the replies and dead letters are published to its subtopics",
+			Destination: &config.MQTT.Topic,
+			EnvVars:     []string{envPrefix + "MQTT_TOPIC"},
+		},
+		&cli.StringFlag{
+			Name:        "queue-dir",
+			Usage:       "Directory of the file broker queue, shared by the publisher and the consumer",
+			Destination: &config.File.Dir,
+			EnvVars:     []string{envPrefix + "QUEUE_DIR"},
+		},
+		&cli.DurationFlag{
+			Name:        "queue-poll-interval",
+			Value:       defaultPollInterval,
+			Usage:       "How often the file broker queue is checked for the new messages and replies",
+			Destination: &config.File.PollInterval,
+			EnvVars:     []string{envPrefix + "QUEUE_POLL_INTERVAL"},
+		},
+	}
+}
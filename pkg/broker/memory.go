@@ -0,0 +1,242 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/queue"
+	"sync"
+	"time"
+)
+
+const (
+	memoryQueueSize = 1000
+	memoryReplyTo   = "memory"
+)
+
+var (
+	// ErrQueueFull is returned if the message can not be queued
+	ErrQueueFull = errors.New("queue is full")
+
+	errBrokerClosed = errors.New("broker closed")
+)
+
+// Memory struct is the broker keeping the messages in the process memory, the publisher and the consumer have to
+// share the same instance
+type Memory struct {
+	options   queue.Options
+	processor *queue.Processor
+	status    queue.StatusTracker
+	messages  chan memoryMessage
+	mu        sync.Mutex
+	replies   map[string]chan string
+	dead      []queue.DeadLetter
+	closed    bool
+}
+
+type memoryMessage struct {
+	msg      queue.Envelope
+	replayed bool
+}
+
+func NewMemory(options queue.Options) *Memory {
+	m := &Memory{
+		options:  options,
+		messages: make(chan memoryMessage, memoryQueueSize),
+		replies:  make(map[string]chan string),
+	}
+
+	m.processor = queue.NewProcessor(&m.options)
+
+	return m
+}
+
+// Publish queues the message, returns ErrQueueFull if the consumer does not keep up
+func (m *Memory) Publish(msg queue.Envelope) error {
+	return m.enqueue(memoryMessage{msg: withSource(msg, &m.options)})
+}
+
+// Request queues the message and waits for the reply of the consumer
+func (m *Memory) Request(msg queue.Envelope, timeout time.Duration) (string, error) {
+	msg = withReplyTo(withSource(msg, &m.options), memoryReplyTo)
+	reply := make(chan string, 1)
+
+	m.mu.Lock()
+	m.replies[msg.CorrelationID] = reply
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.replies, msg.CorrelationID)
+		m.mu.Unlock()
+	}()
+
+	err := m.enqueue(memoryMessage{msg: msg})
+	if err != nil {
+		return "", err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case body := <-reply:
+		return body, nil
+	case <-timer.C:
+		return "", queue.ErrReplyTimeout
+	}
+}
+
+// Consume handles the queued messages until the context is cancelled
+func (m *Memory) Consume(ctx context.Context, handler queue.MessageHandler) {
+	m.status.Set(queue.StateConnected, nil)
+	defer m.status.Set(queue.StateStopped, nil)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case next := <-m.messages:
+			m.process(ctx, next, handler)
+		}
+	}
+}
+
+func (m *Memory) process(ctx context.Context, next memoryMessage, handler queue.MessageHandler) {
+	result := m.processor.Process(ctx, next.msg, next.replayed, handler)
+
+	switch result.Outcome {
+	case queue.Handled, queue.Skipped:
+		if next.msg.ReplyTo != "" && result.Reply != "" {
+			m.reply(next.msg.CorrelationID, result.Reply)
+		}
+	case queue.Failed:
+		m.deadLetter(next.msg, result.Attempts, result.Err)
+	case queue.Interrupted:
+		err := m.enqueue(next)
+		if err != nil {
+			logging.WithError(err).Warnf("Failed to return the message to the queue")
+		}
+	}
+}
+
+func (m *Memory) reply(correlationID string, body string) {
+	m.mu.Lock()
+	reply, ok := m.replies[correlationID]
+	m.mu.Unlock()
+
+	// the reply is lost if the publisher has already timed out or got the reply already, so the consumer is never
+	// blocked
+	if ok {
+		select {
+		case reply <- body:
+		default:
+		}
+	}
+}
+
+func (m *Memory) deadLetter(msg queue.Envelope, attempts int, cause error) {
+	body, err := msg.ToJson()
+	if err != nil {
+		logging.WithError(err).Errorf("Failed to dead-letter the message")
+
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dead = append(m.dead, queue.NewDeadLetter(body, cause.Error(), attempts, time.Now().UTC()))
+}
+
+// Status returns the consumer state, the memory broker is connected while it is consumed
+func (m *Memory) Status() queue.Status {
+	return m.status.Status()
+}
+
+// Close stops accepting the messages, the queued messages are dropped
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+
+	return nil
+}
+
+// DeadLetters returns up to the limit of the dead letters
+func (m *Memory) DeadLetters(limit int) ([]queue.DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	letters := m.dead
+
+	if limit > 0 && len(letters) > limit {
+		letters = letters[:limit]
+	}
+
+	return append([]queue.DeadLetter(nil), letters...), nil
+}
+
+// ReplayDeadLetters queues the dead letters selected by the filter again
+func (m *Memory) ReplayDeadLetters(filter queue.DeadLetterFilter) (int, error) {
+	m.mu.Lock()
+	var selected, kept []queue.DeadLetter
+
+	for _, letter := range m.dead {
+		if filter(letter) {
+			selected = append(selected, letter)
+		} else {
+			kept = append(kept, letter)
+		}
+	}
+
+	m.dead = kept
+	m.mu.Unlock()
+
+	for i, letter := range selected {
+		msg, err := parseMessage([]byte(letter.Body))
+		if err == nil {
+			err = m.enqueue(memoryMessage{msg: msg, replayed: true})
+		}
+
+		if err != nil {
+			// the letters that are not replayed stay dead
+			m.mu.Lock()
+			m.dead = append(m.dead, selected[i:]...)
+			m.mu.Unlock()
+
+			return i, err
+		}
+	}
+
+	return len(selected), nil
+}
+
+// PurgeDeadLetters removes all the dead letters
+func (m *Memory) PurgeDeadLetters() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := len(m.dead)
+	m.dead = nil
+
+	return purged, nil
+}
+
+func (m *Memory) enqueue(next memoryMessage) error {
+	m.mu.Lock()
+	closed := m.closed
+	m.mu.Unlock()
+
+	if closed {
+		return errBrokerClosed
+	}
+
+	select {
+	case m.messages <- next:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
@@ -0,0 +1,323 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/mqtt"
+	"smh-apiengine/pkg/queue"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMQTTAddress = "localhost:1883"
+	defaultMQTTTopic   = "smh/messages"
+
+	repliesTopicSuffix = "/replies/"
+	deadTopicSuffix    = "/dead"
+)
+
+// MQTTConfig struct is the settings of the MQTT broker
+type MQTTConfig struct {
+	Address  string // host:port, localhost:1883 if empty
+	ClientID string // prefix of the client ids, random if empty
+	Username string
+	Password string
+	Topic    string // topic of the messages, smh/messages if empty
+}
+
+// MQTT struct is the broker publishing the messages to the MQTT topic with QoS 1. The replies are published to the
+// reply topic of the requesting client and the dead letters to the <topic>/dead topic, where they can be watched,
+// but they are not kept. Every connection is a clean session, so the messages published while the consumer is
+// disconnected are lost.
+type MQTT struct {
+	config    MQTTConfig
+	options   queue.Options
+	processor *queue.Processor
+	status    queue.StatusTracker
+	clientID  string
+	mu        sync.Mutex
+	client    *mqtt.Client
+	replies   map[string]chan string
+}
+
+// mqttReply struct is the payload of the reply message
+type mqttReply struct {
+	CorrelationID string `json:"correlation_id"`
+	Reply         string `json:"reply"`
+}
+
+func NewMQTT(config MQTTConfig, options queue.Options) *MQTT {
+	m := &MQTT{config: config, options: options, replies: make(map[string]chan string)}
+	m.processor = queue.NewProcessor(&m.options)
+
+	m.clientID = config.ClientID
+	if m.clientID == "" {
+		m.clientID = "smh"
+	}
+
+	// the publishers running side by side must not take over each other's session
+	m.clientID += "-" + logging.NewRequestID()[:8]
+
+	return m
+}
+
+// Publish publishes the message and waits for the broker acknowledgement
+func (m *MQTT) Publish(msg queue.Envelope) error {
+	return m.publish(withSource(msg, &m.options))
+}
+
+// Request publishes the message and waits for the reply published by the consumer to the reply topic of the client
+func (m *MQTT) Request(msg queue.Envelope, timeout time.Duration) (string, error) {
+	msg = withReplyTo(withSource(msg, &m.options), m.replyTopic())
+	reply := make(chan string, 1)
+
+	m.mu.Lock()
+	m.replies[msg.CorrelationID] = reply
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.replies, msg.CorrelationID)
+		m.mu.Unlock()
+	}()
+
+	err := m.publish(msg)
+	if err != nil {
+		return "", err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case body := <-reply:
+		return body, nil
+	case <-timer.C:
+		return "", queue.ErrReplyTimeout
+	}
+}
+
+func (m *MQTT) publish(msg queue.Envelope) error {
+	content, err := msg.ToJson()
+	if err != nil {
+		return err
+	}
+
+	client, err := m.connection()
+	if err != nil {
+		return err
+	}
+
+	err = client.Publish(mqtt.Message{Topic: m.topic(), Payload: content, QoS: 1})
+	if err != nil {
+		m.resetConnection(client)
+	}
+
+	return err
+}
+
+// connection returns the publisher connection, the lost connection is established again. The connection subscribes
+// to the reply topic of the client.
+func (m *MQTT) connection() (*mqtt.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.client != nil {
+		select {
+		case <-m.client.Done():
+			m.client = nil
+		default:
+			return m.client, nil
+		}
+	}
+
+	client, err := m.dial(m.clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Subscribe(m.replyTopic(), 1, m.receiveReply)
+	if err != nil {
+		_ = client.Close()
+
+		return nil, err
+	}
+
+	m.client = client
+
+	return client, nil
+}
+
+func (m *MQTT) resetConnection(client *mqtt.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.client == client {
+		_ = client.Close()
+		m.client = nil
+	}
+}
+
+func (m *MQTT) receiveReply(msg mqtt.Message) {
+	var reply mqttReply
+
+	err := json.Unmarshal(msg.Payload, &reply)
+	if err != nil {
+		logging.WithError(err).Warnf("Invalid reply received from %s", msg.Topic)
+
+		return
+	}
+
+	m.mu.Lock()
+	waiting, ok := m.replies[reply.CorrelationID]
+	m.mu.Unlock()
+
+	// the reply is dropped if the publisher has already timed out or got the reply already (e.g. the duplicate
+	// message is answered as well), so the subscription is never blocked
+	if ok {
+		select {
+		case waiting <- reply.Reply:
+		default:
+		}
+	}
+}
+
+// Consume subscribes to the topic and handles the messages until the context is cancelled, the lost connection is
+// established again with exponential backoff
+func (m *MQTT) Consume(ctx context.Context, handler queue.MessageHandler) {
+	queue.Supervise(ctx, &m.status, "MQTT", func(ctx context.Context) (bool, error) {
+		return m.consumeOnce(ctx, handler)
+	})
+}
+
+func (m *MQTT) consumeOnce(ctx context.Context, handler queue.MessageHandler) (bool, error) {
+	client, err := m.dial(m.clientID + "-consumer")
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = client.Close()
+	}()
+
+	// the message is acknowledged on receipt, the failed one is retried here and dead-lettered as the broker does
+	// not deliver it again
+	err = client.Subscribe(m.topic(), 1, func(msg mqtt.Message) {
+		m.process(ctx, client, msg.Payload, handler)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	m.status.Set(queue.StateConnected, nil)
+	logging.Infof("MQTT consumer started on %s", m.topic())
+
+	select {
+	case <-ctx.Done():
+		return true, nil
+	case <-client.Done():
+		if client.Err() != nil {
+			return true, client.Err()
+		}
+
+		return true, mqtt.ErrClosed
+	}
+}
+
+func (m *MQTT) process(ctx context.Context, client *mqtt.Client, body []byte, handler queue.MessageHandler) {
+	msg, err := parseMessage(body)
+	if err != nil {
+		m.deadLetter(client, body, 1, err)
+
+		return
+	}
+
+	result := m.processor.Process(ctx, msg, false, handler)
+
+	switch result.Outcome {
+	case queue.Handled, queue.Skipped:
+		if msg.ReplyTo != "" && result.Reply != "" {
+			m.reply(client, msg, result.Reply)
+		}
+	case queue.Failed:
+		m.deadLetter(client, body, result.Attempts, result.Err)
+	case queue.Interrupted:
+		// without the persistent sessions the message can not be returned to the broker
+		logging.WithField(logging.FieldRequestID, msg.ID).Warnf("Consumer stopped, the message is dropped")
+	}
+}
+
+func (m *MQTT) reply(client *mqtt.Client, msg queue.Envelope, reply string) {
+	content, err := json.Marshal(mqttReply{CorrelationID: msg.CorrelationID, Reply: reply})
+	if err == nil {
+		err = client.Publish(mqtt.Message{Topic: msg.ReplyTo, Payload: content, QoS: 1})
+	}
+
+	if err != nil {
+		logging.WithError(err).Warnf("Failed to send the reply")
+	}
+}
+
+func (m *MQTT) deadLetter(client *mqtt.Client, body []byte, attempts int, cause error) {
+	content, err := json.Marshal(queue.NewDeadLetter(body, cause.Error(), attempts, time.Now().UTC()))
+	if err == nil {
+		err = client.Publish(mqtt.Message{Topic: m.topic() + deadTopicSuffix, Payload: content, QoS: 1})
+	}
+
+	if err != nil {
+		logging.WithError(err).Errorf("Failed to dead-letter the message")
+
+		return
+	}
+
+	logging.WithError(cause).Warnf("Message dead-lettered to %s after %d attempt(s)", m.topic()+deadTopicSuffix,
+		attempts)
+}
+
+// Status returns the connection state of the consumer
+func (m *MQTT) Status() queue.Status {
+	return m.status.Status()
+}
+
+// Close closes the publisher connection
+func (m *MQTT) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.client == nil {
+		return nil
+	}
+
+	err := m.client.Close()
+	m.client = nil
+
+	return err
+}
+
+func (m *MQTT) dial(clientID string) (*mqtt.Client, error) {
+	address := m.config.Address
+	if address == "" {
+		address = defaultMQTTAddress
+	}
+
+	return mqtt.Dial(mqtt.Options{
+		Address:  address,
+		ClientID: clientID,
+		Username: m.config.Username,
+		Password: m.config.Password,
+	})
+}
+
+func (m *MQTT) topic() string {
+	if m.config.Topic != "" {
+		return m.config.Topic
+	}
+
+	return defaultMQTTTopic
+}
+
+func (m *MQTT) replyTopic() string {
+	return m.topic() + repliesTopicSuffix + m.clientID
+}
@@ -1,153 +1,197 @@
 package devicecontrol
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"smh-apiengine/pkg/alexakit"
+	"sort"
 	"strings"
+	"time"
 )
 
+var (
+	ErrNoSlots            = errors.New("no intents found in the request")
+	ErrIntentNotSupported = errors.New("intent not supported")
+	ErrCommandNotFound    = errors.New("command not found")
+	ErrIntentIncomplete   = errors.New("intent is missing the slot values required by the command")
+	ErrIntentAmbiguous    = errors.New("intent matches several commands and scenarios equally")
+)
+
+// SlotValueError is returned when the slot value of the request does not match any configured value or synonym
+type SlotValueError struct {
+	Slot  string
+	Value string
+}
+
+func (e *SlotValueError) Error() string {
+	return fmt.Sprintf("can not find the original supported slot value: %s=%s", e.Slot, e.Value)
+}
+
 // HandleAlexaRequest tries to find the command and device for the alexa request execution. In case of execution
 // failure, for example because the device has changed the ip address, retries to discover the devices again and
-// execute command. If the execution was successful, updates the device's data save it into config json file
-func (deviceControl *DeviceControl) HandleAlexaRequest(reqIntent alexakit.SimpleIntent) error {
-	scenario, err := deviceControl.config.findScenario(reqIntent)
+// execute command. If the execution was successful, updates the device's data save it into config json file. Only
+// the complete and unique match is executed, the incomplete and ambiguous requests have to be resolved with the
+// dialog by ResolveAlexaIntent first.
+func (deviceControl *DeviceControl) HandleAlexaRequest(ctx context.Context, reqIntent alexakit.SimpleIntent) (err error) {
+	start := time.Now()
+	defer func() {
+		deviceControl.emit(ctx, Event{
+			Type:     EventIntent,
+			ID:       reqIntent.Name,
+			Name:     describeIntent(reqIntent),
+			Err:      err,
+			Duration: time.Since(start)})
+	}()
+
+	match := deviceControl.config.matchIntent(reqIntent)
+	candidate, ok := match.Best()
+
+	if reqIntent.TargetID != "" {
+		candidate, ok = match.find(reqIntent.TargetType, reqIntent.TargetID)
+	}
 
-	if err == nil {
-		if len(scenario.Sequence) > 0 {
-			return deviceControl.ExecScenarioFullCycle(scenario)
+	if !ok {
+		return fmt.Errorf("%w. Searched for: %s", ErrCommandNotFound, describeIntent(reqIntent))
+	}
+
+	if !candidate.Complete {
+		return fmt.Errorf("%w (%s). Searched for: %s", ErrIntentIncomplete, strings.Join(candidate.Missing, ", "),
+			describeIntent(reqIntent))
+	}
+
+	// the target confirmed in the dialog is executed even if other candidates match equally well
+	if match.Ambiguous() && reqIntent.TargetID == "" {
+		names := make([]string, 0, len(match.Ties))
+
+		for _, tie := range match.Ties {
+			names = append(names, tie.Name)
 		}
 
-		return fmt.Errorf("scenario \"%s\" has no sequence items", scenario.Name)
+		return fmt.Errorf("%w (%s). Searched for: %s", ErrIntentAmbiguous, strings.Join(names, ", "),
+			describeIntent(reqIntent))
 	}
 
-	cmd, err := deviceControl.config.findCommand(reqIntent)
+	if candidate.Type == ElementTypeScenario {
+		scenario := deviceControl.config.FindScenarioByID(candidate.ID)
 
-	if err != nil {
-		return err
+		if scenario == nil || len(scenario.Sequence) == 0 {
+			return fmt.Errorf("scenario \"%s\" has no sequence items", candidate.Name)
+		}
+
+		return deviceControl.ExecScenarioFullCycle(ctx, *scenario)
 	}
 
-	err = deviceControl.ExecCommandFullCycle(cmd)
+	cmd := deviceControl.config.FindCommandByID(candidate.ID)
 
-	if err != nil {
-		return err
+	if cmd == nil {
+		return fmt.Errorf("%w: %s", ErrCommandNotFound, candidate.ID)
 	}
 
-	return nil
+	return deviceControl.ExecCommandFullCycle(ctx, *cmd)
 }
 
-// NewSimpleRequestIntent create SimpleRequestIntent struct from full AlexaRequest
+// describeIntent returns the intent name with the slot values sorted by the slot name, e.g. "TurnOn device=tv"
+func describeIntent(reqIntent alexakit.SimpleIntent) string {
+	names := make([]string, 0, len(reqIntent.Slots))
+
+	for name := range reqIntent.Slots {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	parts := []string{reqIntent.Name}
+
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%s", name, reqIntent.Slots[name].Value))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// NewSimpleRequestIntent create SimpleRequestIntent struct from full AlexaRequest, the slot values spoken in the
+// language of the request locale are resolved to the configured value names
 func (deviceControl *DeviceControl) NewSimpleRequestIntent(request alexakit.AlexaRequest) (alexakit.SimpleIntent, error) {
 	var simpleRequestIntent alexakit.SimpleIntent
 	intent := request.Request.Intent
 
 	if len(intent.Slots) == 0 {
-		return simpleRequestIntent, errors.New("no intents found in the request")
+		return simpleRequestIntent, ErrNoSlots
 	}
 
 	if _, ok := deviceControl.config.Intents[intent.Name]; !ok {
-		return alexakit.SimpleIntent{}, errors.New("intent not supported")
+		return alexakit.SimpleIntent{}, ErrIntentNotSupported
 	}
 
 	targetSlots := deviceControl.config.Intents[intent.Name].Slots
 	requestSlots := map[string]alexakit.SimpleSlot{}
 
-	for _, slot := range intent.Slots {
-		value, err := deviceControl.config.searchSlotValueWithSynonyms(targetSlots, slot)
-
-		if err != nil {
-			return simpleRequestIntent, errors.New("can not find the original supported slot value")
-		}
+	names := make([]string, 0, len(intent.Slots))
 
-		requestSlots[slot.Name] = alexakit.SimpleSlot{Name: slot.Name, Value: value}
+	for name := range intent.Slots {
+		names = append(names, name)
 	}
 
-	return alexakit.SimpleIntent{
-		Name:  intent.Name,
-		Slots: requestSlots,
-	}, nil
-}
-
-// searchSlotValueWithSynonyms searches for corresponding slot value, defined in intents json config. Compares not
-// only name but also synonyms if any defined
-func (c *Config) searchSlotValueWithSynonyms(targetSlots map[string]Slot, searchSlot alexakit.Slot) (string, error) {
-	if _, ok := targetSlots[searchSlot.Name]; !ok {
-		return "", errors.New("slot not found")
-	}
+	// the slots are resolved in the order of their names, so the same slot is asked for if several are not found
+	sort.Strings(names)
 
-	values := targetSlots[searchSlot.Name].Values
+	for _, name := range names {
+		slot := intent.Slots[name]
 
-	for _, value := range values {
-		if strings.EqualFold(value.Name, searchSlot.Value) || c.iContains(value.Synonyms, searchSlot.Value) {
-			return value.Name, nil
+		// alexa sends all the slots of the intent, the ones the user did not say have no value
+		if slot.Value == "" {
+			continue
 		}
-	}
 
-	return "", errors.New("no supported value found")
-}
+		value, score, ok := resolveSlotValue(targetSlots[slot.Name], slot.Value, request.Request.Locale)
 
-func (c *Config) findCommand(reqIntent alexakit.SimpleIntent) (Command, error) {
-	for _, command := range c.Commands {
-		if len(c.Intents) == 0 {
-			continue
+		if !ok {
+			return simpleRequestIntent, &SlotValueError{Slot: slot.Name, Value: slot.Value}
 		}
 
-		if c.hasMatchedIntent(command.Intents, reqIntent) {
-			return command, nil
-		}
+		requestSlots[slot.Name] = alexakit.SimpleSlot{Name: slot.Name, Value: value, Score: score}
 	}
 
-	return Command{}, fmt.Errorf("command not found. Searched for: %s", reqIntent)
+	return alexakit.SimpleIntent{
+		Name:  intent.Name,
+		Slots: requestSlots,
+	}, nil
 }
 
-func (c *Config) findScenario(reqIntent alexakit.SimpleIntent) (Scenario, error) {
-	for _, scenario := range c.Scenarios {
-		if len(scenario.Intents) == 0 {
-			continue
-		}
-
-		if c.hasMatchedIntent(scenario.Intents, reqIntent) {
-			return scenario, nil
-		}
-	}
+// CanFulfillIntent answers the CanFulfillIntentRequest: the intent can be fulfilled if the configured command or
+// scenario matches its slots, each slot is understood if its value is one of the configured values or synonyms
+func (deviceControl *DeviceControl) CanFulfillIntent(request alexakit.AlexaRequest) alexakit.CanFulfillIntent {
+	intent := request.Request.Intent
+	answer := alexakit.CanFulfillIntent{CanFulfill: alexakit.CanFulfillNo, Slots: map[string]alexakit.CanFulfillSlot{}}
+	configIntent, supported := deviceControl.config.Intents[intent.Name]
+	locale := request.Request.Locale
 
-	return Scenario{}, fmt.Errorf("scenario not found. Searched for: %s", reqIntent)
-}
+	for name, slot := range intent.Slots {
+		slotAnswer := alexakit.CanFulfillSlot{CanUnderstand: alexakit.CanFulfillNo, CanFulfill: alexakit.CanFulfillNo}
 
-func (c *Config) hasMatchedIntent(intents []CommandIntent, reqIntent alexakit.SimpleIntent) bool {
-	for i := 0; i < len(intents); i++ {
-		if intents[i].Name != reqIntent.Name {
-			continue
+		if supported {
+			if _, _, ok := resolveSlotValue(configIntent.Slots[slot.Name], slot.Value, locale); ok {
+				slotAnswer = alexakit.CanFulfillSlot{CanUnderstand: alexakit.CanFulfillYes, CanFulfill: alexakit.CanFulfillYes}
+			}
 		}
 
-		if c.hasMatchedSlots(intents[i].Slots, reqIntent.Slots) {
-			return true
-		}
+		answer.Slots[name] = slotAnswer
 	}
 
-	return false
-}
-
-func (c *Config) hasMatchedSlots(sourceSlots map[string]CommandSlot, lookupSlots map[string]alexakit.SimpleSlot) bool {
-	for _, lookupSlot := range lookupSlots {
-		if slot, ok := sourceSlots[lookupSlot.Name]; ok {
-			if !strings.EqualFold(slot.Value, lookupSlot.Value) {
-				return false
-			}
-		} else {
-			return false
-		}
+	simpleIntent, err := deviceControl.NewSimpleRequestIntent(request)
+	if err != nil {
+		return answer
 	}
 
-	return true
-}
+	// the incomplete or ambiguous match may be fulfilled after the dialog
+	match := deviceControl.config.matchIntent(simpleIntent)
 
-func (c *Config) iContains(arr []string, val string) bool {
-	for i := 0; i < len(arr); i++ {
-		if strings.EqualFold(arr[i], val) {
-			return true
-		}
+	if candidate, ok := match.Best(); ok && candidate.Complete && !match.Ambiguous() {
+		answer.CanFulfill = alexakit.CanFulfillYes
+	} else if ok {
+		answer.CanFulfill = alexakit.CanFulfillMaybe
 	}
 
-	return false
+	return answer
 }
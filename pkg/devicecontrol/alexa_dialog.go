@@ -0,0 +1,278 @@
+package devicecontrol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"smh-apiengine/pkg/alexakit"
+	"sort"
+	"strings"
+)
+
+const (
+	// dialogAttribute is the session attribute holding the dialog state
+	dialogAttribute = "smh_dialog"
+	// maxElicitations limits how many times the user is asked for the slot values before the request fails
+	maxElicitations = 2
+	// maxSpokenOptions limits the number of the slot values listed in the question
+	maxSpokenOptions = 5
+)
+
+// dialogCandidate is the command or scenario matching the intent, identified by its type and id as several ones may
+// have the same slot values
+type dialogCandidate struct {
+	Type     string            `json:"type"`
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Slots    map[string]string `json:"slots"`
+	Complete bool              `json:"-"`
+	Missing  []string          `json:"-"`
+}
+
+// dialogState is kept in the session attributes between the turns of the dialog
+type dialogState struct {
+	Intent       string            `json:"intent"`
+	Elicitations int               `json:"elicitations"`
+	Candidates   []dialogCandidate `json:"candidates,omitempty"`
+	Candidate    int               `json:"candidate"`
+}
+
+// ResolveAlexaIntent resolves the intent of the alexa request to a single command or scenario. If a slot value is
+// not recognized, the intent misses a slot required by the command or matches several commands and scenarios, the
+// dialog response is returned instead: it
+// asks the user for the slot value (Dialog.ElicitSlot) or to confirm one of the matches (Dialog.ConfirmIntent), the
+// dialog state is kept in the session attributes and the resolution continues with the follow-up request. The
+// questions are asked in the language of the request locale.
+func (deviceControl *DeviceControl) ResolveAlexaIntent(request alexakit.AlexaRequest) (
+	alexakit.SimpleIntent, *alexakit.AlexaResponse, error) {
+	intent := request.Request.Intent
+	state := readDialogState(request)
+	locale := request.Request.Locale
+	texts := textsFor(locale)
+	slots := deviceControl.config.Intents[intent.Name].Slots
+
+	if len(state.Candidates) > 0 && intent.ConfirmationStatus != alexakit.ConfirmationNone &&
+		intent.ConfirmationStatus != "" {
+		return deviceControl.continueConfirmation(intent, state, texts)
+	}
+
+	simpleIntent, err := deviceControl.NewSimpleRequestIntent(request)
+
+	var slotErr *SlotValueError
+
+	if errors.As(err, &slotErr) && state.Elicitations < maxElicitations {
+		intent = withSlotValue(intent, slotErr.Slot, "")
+		slotName := alexakit.EscapeSSML(slots[slotErr.Slot].spokenName(slotErr.Slot, locale))
+		speech := fmt.Sprintf(texts.SlotNotFound, slotName, alexakit.EscapeSSML(slotErr.Value)) + " " +
+			fmt.Sprintf(texts.WhichSlot, slotName)
+
+		return simpleIntent, elicitSlot(intent, slotErr.Slot, speech, state), nil
+	}
+
+	if err != nil {
+		return simpleIntent, nil, err
+	}
+
+	candidates := deviceControl.config.findCandidates(simpleIntent)
+
+	switch {
+	case len(candidates) == 0:
+		return simpleIntent, nil, nil
+	case len(candidates) == 1 && candidates[0].Complete:
+		return candidates[0].intent(intent.Name), nil, nil
+	}
+
+	if slotName := missingSlot(intent, candidates); slotName != "" && state.Elicitations < maxElicitations {
+		speech := fmt.Sprintf(texts.WhichSlot, alexakit.EscapeSSML(slots[slotName].spokenName(slotName, locale)))
+
+		return simpleIntent, elicitSlot(intent, slotName, speech, state), nil
+	}
+
+	if slotName, options := distinguishingSlot(intent, candidates); slotName != "" && state.Elicitations < maxElicitations {
+		for i, option := range options {
+			options[i] = slots[slotName].spokenValue(option, locale)
+		}
+
+		sort.Strings(options)
+
+		speech := fmt.Sprintf(texts.WhichOption, alexakit.EscapeSSML(slots[slotName].spokenName(slotName, locale)),
+			alexakit.EscapeSSML(joinOptions(options, texts.Or)))
+
+		return simpleIntent, elicitSlot(intent, slotName, speech, state), nil
+	}
+
+	state.Candidates = candidates
+	state.Candidate = 0
+
+	return simpleIntent, confirmCandidate(intent, state, texts), nil
+}
+
+// continueConfirmation handles the answer to the confirmation question: the confirmed candidate is executed and the
+// next candidate is offered if the user said no
+func (deviceControl *DeviceControl) continueConfirmation(intent alexakit.Intent, state dialogState,
+	texts alexaTexts) (alexakit.SimpleIntent, *alexakit.AlexaResponse, error) {
+	if state.Candidate >= len(state.Candidates) {
+		state.Candidate = 0
+	}
+
+	if intent.ConfirmationStatus == alexakit.ConfirmationConfirmed {
+		return state.Candidates[state.Candidate].intent(intent.Name), nil, nil
+	}
+
+	state.Candidate++
+
+	if state.Candidate >= len(state.Candidates) {
+		response := alexakit.NewResponseBuilder().Speak(texts.Denied).Build()
+
+		return alexakit.SimpleIntent{}, &response, nil
+	}
+
+	return alexakit.SimpleIntent{}, confirmCandidate(intent, state, texts), nil
+}
+
+// findCandidates returns the scenarios and commands matching the intent equally well
+func (c *Config) findCandidates(reqIntent alexakit.SimpleIntent) []dialogCandidate {
+	var candidates []dialogCandidate
+
+	for _, tie := range c.matchIntent(reqIntent).Ties {
+		candidates = append(candidates, dialogCandidate{Type: tie.Type, ID: tie.ID, Name: tie.Name, Slots: tie.Slots,
+			Complete: tie.Complete, Missing: tie.Missing})
+	}
+
+	return candidates
+}
+
+func (candidate dialogCandidate) intent(name string) alexakit.SimpleIntent {
+	slots := map[string]alexakit.SimpleSlot{}
+
+	for slotName, value := range candidate.Slots {
+		if value != SlotWildcard {
+			slots[slotName] = alexakit.SimpleSlot{Name: slotName, Value: value}
+		}
+	}
+
+	return alexakit.SimpleIntent{Name: name, Slots: slots, TargetType: candidate.Type, TargetID: candidate.ID}
+}
+
+// distinguishingSlot returns the slot the user did not say, which has different values in the candidates
+func distinguishingSlot(intent alexakit.Intent, candidates []dialogCandidate) (string, []string) {
+	names := make([]string, 0, len(intent.Slots))
+
+	for name, slot := range intent.Slots {
+		if slot.Value == "" {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		var options []string
+
+		seen := map[string]bool{}
+
+		for _, candidate := range candidates {
+			value, ok := candidate.Slots[name]
+
+			if ok && value != SlotWildcard && !seen[value] {
+				seen[value] = true
+				options = append(options, value)
+			}
+		}
+
+		if len(options) > 1 {
+			sort.Strings(options)
+
+			return name, options
+		}
+	}
+
+	return "", nil
+}
+
+// missingSlot returns the slot of the intent the user did not say, which is required by the only candidate
+func missingSlot(intent alexakit.Intent, candidates []dialogCandidate) string {
+	if len(candidates) != 1 {
+		return ""
+	}
+
+	for _, name := range candidates[0].Missing {
+		if slot, ok := intent.Slots[name]; ok && slot.Value == "" {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func elicitSlot(intent alexakit.Intent, slotName string, speech string, state dialogState) *alexakit.AlexaResponse {
+	state.Intent = intent.Name
+	state.Elicitations++
+	state.Candidates = nil
+
+	response := alexakit.NewResponseBuilder().
+		SpeakSSML(speech).
+		ElicitSlot(slotName, intent).
+		SessionAttribute(dialogAttribute, state).
+		Build()
+
+	return &response
+}
+
+func confirmCandidate(intent alexakit.Intent, state dialogState, texts alexaTexts) *alexakit.AlexaResponse {
+	state.Intent = intent.Name
+	speech := fmt.Sprintf(texts.DidYouMean, alexakit.EscapeSSML(state.Candidates[state.Candidate].Name))
+
+	response := alexakit.NewResponseBuilder().
+		SpeakSSML(speech).
+		ConfirmIntent(intent).
+		SessionAttribute(dialogAttribute, state).
+		Build()
+
+	return &response
+}
+
+// readDialogState returns the dialog state from the session attributes, the state of another intent is discarded
+func readDialogState(request alexakit.AlexaRequest) dialogState {
+	var state dialogState
+
+	if request.Session == nil || request.Session.Attributes[dialogAttribute] == nil {
+		return state
+	}
+
+	content, err := json.Marshal(request.Session.Attributes[dialogAttribute])
+	if err != nil || json.Unmarshal(content, &state) != nil || state.Intent != request.Request.Intent.Name {
+		return dialogState{}
+	}
+
+	return state
+}
+
+func withSlotValue(intent alexakit.Intent, slotName string, value string) alexakit.Intent {
+	slots := make(map[string]alexakit.Slot, len(intent.Slots))
+
+	for name, slot := range intent.Slots {
+		if name == slotName {
+			slot.Value = value
+		}
+
+		slots[name] = slot
+	}
+
+	intent.Slots = slots
+
+	return intent
+}
+
+// joinOptions lists the options for the speech, e.g. "kitchen, bedroom or hallway"
+func joinOptions(options []string, or string) string {
+	if len(options) > maxSpokenOptions {
+		options = options[:maxSpokenOptions]
+	}
+
+	if len(options) == 1 {
+		return options[0]
+	}
+
+	return strings.Join(options[:len(options)-1], ", ") + " " + or + " " + options[len(options)-1]
+}
@@ -0,0 +1,229 @@
+package devicecontrol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"smh-apiengine/pkg/alexakit"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ResolveAlexaIntent_Dialog(t *testing.T) {
+	deviceControl := NewDeviceControl(&Config{
+		Intents: map[string]Intent{"TurnOn": {Name: "TurnOn", Slots: map[string]Slot{
+			"device": {Name: "device", Values: map[string]SlotValue{
+				"light": {Name: "light", Synonyms: []string{"lamp"}},
+				"tv":    {Name: "tv"}}},
+			"room": {Name: "room", Values: map[string]SlotValue{
+				"kitchen": {Name: "kitchen"},
+				"bedroom": {Name: "bedroom"}}},
+		}}},
+		Commands: map[string]Command{
+			"1": dialogTestCommand("Kitchen light", map[string]string{"device": "light", "room": "kitchen"}),
+			"2": dialogTestCommand("Bedroom light", map[string]string{"device": "light", "room": "bedroom"}),
+			"3": dialogTestCommand("TV", map[string]string{"device": "tv"}),
+		},
+	})
+
+	intent, dialog, err := deviceControl.ResolveAlexaIntent(dialogTestRequest("tv", "", nil))
+	assert.Nil(t, err)
+	assert.Nil(t, dialog)
+	assert.Equal(t, "tv", intent.Slots["device"].Value)
+
+	_, dialog, err = deviceControl.ResolveAlexaIntent(dialogTestRequest("hallway light", "", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, alexakit.DirectiveElicitSlot, dialog.Response.Directives[0].Type)
+	assert.Equal(t, "device", dialog.Response.Directives[0].SlotToElicit)
+	assert.Equal(t, "<speak>I couldn't find a device called hallway light. Which device?</speak>",
+		dialog.Response.OutputSpeech.SSML)
+
+	_, dialog, err = deviceControl.ResolveAlexaIntent(dialogTestRequest("lamp", "", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, "room", dialog.Response.Directives[0].SlotToElicit)
+	assert.Equal(t, "<speak>Which room, bedroom or kitchen?</speak>", dialog.Response.OutputSpeech.SSML)
+
+	intent, dialog, err = deviceControl.ResolveAlexaIntent(dialogTestRequest("lamp", "kitchen", dialog))
+	assert.Nil(t, err)
+	assert.Nil(t, dialog)
+	assert.Equal(t, "kitchen", intent.Slots["room"].Value)
+}
+
+func Test_ResolveAlexaIntent_Incomplete(t *testing.T) {
+	deviceControl := NewDeviceControl(&Config{
+		Intents: map[string]Intent{"TurnOn": {Name: "TurnOn", Slots: map[string]Slot{
+			"device": {Name: "device", Values: map[string]SlotValue{"light": {Name: "light"}}},
+			"room":   {Name: "room", Values: map[string]SlotValue{"bedroom": {Name: "bedroom"}}},
+		}}},
+		Commands: map[string]Command{
+			"1": dialogTestCommand("Bedroom light", map[string]string{"device": "light", "room": "bedroom"}),
+		},
+	})
+
+	// the only match misses the room, so it is asked for instead of executing the bedroom light
+	_, dialog, err := deviceControl.ResolveAlexaIntent(dialogTestRequest("light", "", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, alexakit.DirectiveElicitSlot, dialog.Response.Directives[0].Type)
+	assert.Equal(t, "room", dialog.Response.Directives[0].SlotToElicit)
+	assert.Equal(t, "<speak>Which room?</speak>", dialog.Response.OutputSpeech.SSML)
+
+	intent, dialog, err := deviceControl.ResolveAlexaIntent(dialogTestRequest("light", "bedroom", dialog))
+	assert.Nil(t, err)
+	assert.Nil(t, dialog)
+	assert.Equal(t, "bedroom", intent.Slots["room"].Value)
+}
+
+func Test_ResolveAlexaIntent_Confirmation(t *testing.T) {
+	deviceControl := NewDeviceControl(&Config{
+		Intents: map[string]Intent{"TurnOn": {Name: "TurnOn", Slots: map[string]Slot{
+			"device": {Name: "device", Values: map[string]SlotValue{"light": {Name: "light"}}}}}},
+		Commands: map[string]Command{
+			"1": dialogTestCommand("Kitchen light", map[string]string{"device": "light", "room": "kitchen"}),
+			"2": dialogTestCommand("Bedroom light", map[string]string{"device": "light", "room": "bedroom"}),
+		},
+	})
+
+	request := alexakit.AlexaRequest{Request: alexakit.Request{Type: alexakit.RequestTypeIntent, Intent: alexakit.Intent{
+		Name: "TurnOn", Slots: map[string]alexakit.Slot{"device": {Name: "device", Value: "light"}}}}}
+
+	_, dialog, err := deviceControl.ResolveAlexaIntent(request)
+	assert.Nil(t, err)
+	assert.Equal(t, alexakit.DirectiveConfirmIntent, dialog.Response.Directives[0].Type)
+	assert.Equal(t, "<speak>Did you mean Bedroom light?</speak>", dialog.Response.OutputSpeech.SSML)
+
+	request.Session = &alexakit.Session{Attributes: dialog.SessionAttributes}
+	request.Request.Intent.ConfirmationStatus = alexakit.ConfirmationDenied
+
+	_, dialog, err = deviceControl.ResolveAlexaIntent(request)
+	assert.Nil(t, err)
+	assert.Equal(t, "<speak>Did you mean Kitchen light?</speak>", dialog.Response.OutputSpeech.SSML)
+
+	request.Session = &alexakit.Session{Attributes: roundTrip(t, dialog.SessionAttributes)}
+	request.Request.Intent.ConfirmationStatus = alexakit.ConfirmationConfirmed
+
+	intent, dialog, err := deviceControl.ResolveAlexaIntent(request)
+	assert.Nil(t, err)
+	assert.Nil(t, dialog)
+	assert.Equal(t, "kitchen", intent.Slots["room"].Value)
+}
+
+func Test_ResolveAlexaIntent_ConfirmationSameSlots(t *testing.T) {
+	deviceControl := NewDeviceControl(&Config{
+		Intents: map[string]Intent{"TurnOn": {Name: "TurnOn", Slots: map[string]Slot{
+			"device": {Name: "device", Values: map[string]SlotValue{"radio": {Name: "radio"}}}}}},
+		Commands: map[string]Command{
+			"a": dialogTestCommand("Kitchen radio", map[string]string{"device": "radio"}),
+			"b": dialogTestCommand("Bedroom radio", map[string]string{"device": "radio"}),
+		},
+	})
+
+	request := alexakit.AlexaRequest{Request: alexakit.Request{Type: alexakit.RequestTypeIntent, Intent: alexakit.Intent{
+		Name: "TurnOn", Slots: map[string]alexakit.Slot{"device": {Name: "device", Value: "radio"}}}}}
+
+	_, dialog, err := deviceControl.ResolveAlexaIntent(request)
+	assert.Nil(t, err)
+	assert.Equal(t, "<speak>Did you mean Bedroom radio?</speak>", dialog.Response.OutputSpeech.SSML)
+
+	request.Session = &alexakit.Session{Attributes: roundTrip(t, dialog.SessionAttributes)}
+	request.Request.Intent.ConfirmationStatus = alexakit.ConfirmationDenied
+
+	_, dialog, err = deviceControl.ResolveAlexaIntent(request)
+	assert.Nil(t, err)
+	assert.Equal(t, "<speak>Did you mean Kitchen radio?</speak>", dialog.Response.OutputSpeech.SSML)
+
+	request.Session = &alexakit.Session{Attributes: roundTrip(t, dialog.SessionAttributes)}
+	request.Request.Intent.ConfirmationStatus = alexakit.ConfirmationConfirmed
+
+	intent, dialog, err := deviceControl.ResolveAlexaIntent(request)
+	assert.Nil(t, err)
+	assert.Nil(t, dialog)
+	assert.Equal(t, ElementTypeCommand, intent.TargetType)
+	assert.Equal(t, "a", intent.TargetID)
+
+	// the confirmed command is executed, the commands with the same slots do not make it ambiguous
+	err = deviceControl.HandleAlexaRequest(context.Background(), intent)
+	assert.False(t, errors.Is(err, ErrIntentAmbiguous))
+
+	intent.TargetType, intent.TargetID = "", ""
+	err = deviceControl.HandleAlexaRequest(context.Background(), intent)
+	assert.True(t, errors.Is(err, ErrIntentAmbiguous))
+}
+
+func Test_ResolveAlexaIntent_Locale(t *testing.T) {
+	deviceControl := NewDeviceControl(&Config{
+		Intents: map[string]Intent{"TurnOn": {Name: "TurnOn", Slots: map[string]Slot{
+			"device": {Name: "device", Values: map[string]SlotValue{
+				"light": {Name: "light", Locales: map[string]LocalizedSlotValue{
+					"de": {Name: "Licht", Synonyms: []string{"Lampe"}}}}}},
+			"room": {Name: "room", Locales: map[string]string{"de-DE": "Raum"}, Values: map[string]SlotValue{
+				"kitchen": {Name: "kitchen", Locales: map[string]LocalizedSlotValue{"de": {Name: "Küche"}}},
+				"bedroom": {Name: "bedroom", Locales: map[string]LocalizedSlotValue{"de": {Name: "Schlafzimmer"}}}}},
+		}}},
+		Commands: map[string]Command{
+			"1": dialogTestCommand("Kitchen light", map[string]string{"device": "light", "room": "kitchen"}),
+			"2": dialogTestCommand("Bedroom light", map[string]string{"device": "light", "room": "bedroom"}),
+		},
+	})
+
+	request := dialogTestRequest("Lampe", "", nil)
+	request.Request.Locale = alexakit.LocaleGerman
+
+	_, dialog, err := deviceControl.ResolveAlexaIntent(request)
+	assert.Nil(t, err)
+	assert.Equal(t, "<speak>Raum: Küche oder Schlafzimmer?</speak>", dialog.Response.OutputSpeech.SSML)
+
+	request = dialogTestRequest("Lampe", "Küche", dialog)
+	request.Request.Locale = alexakit.LocaleGerman
+
+	intent, dialog, err := deviceControl.ResolveAlexaIntent(request)
+	assert.Nil(t, err)
+	assert.Nil(t, dialog)
+	assert.Equal(t, "light", intent.Slots["device"].Value)
+	assert.Equal(t, "kitchen", intent.Slots["room"].Value)
+
+	// the german names are not known in english
+	request.Request.Locale = alexakit.LocaleEnglish
+
+	_, dialog, err = deviceControl.ResolveAlexaIntent(request)
+	assert.Nil(t, err)
+	assert.Equal(t, "<speak>I couldn't find a device called Lampe. Which device?</speak>",
+		dialog.Response.OutputSpeech.SSML)
+}
+
+func dialogTestCommand(name string, slots map[string]string) Command {
+	commandSlots := map[string]CommandSlot{}
+
+	for slotName, value := range slots {
+		commandSlots[slotName] = CommandSlot{Name: slotName, Value: value}
+	}
+
+	return Command{ID: name, Name: name, Intents: []CommandIntent{{Name: "TurnOn", Slots: commandSlots}}}
+}
+
+func dialogTestRequest(device string, room string, previous *alexakit.AlexaResponse) alexakit.AlexaRequest {
+	request := alexakit.AlexaRequest{Request: alexakit.Request{Type: alexakit.RequestTypeIntent, Intent: alexakit.Intent{
+		Name: "TurnOn",
+		Slots: map[string]alexakit.Slot{
+			"device": {Name: "device", Value: device},
+			"room":   {Name: "room", Value: room},
+		}}}}
+
+	if previous != nil {
+		request.Session = &alexakit.Session{Attributes: previous.SessionAttributes}
+	}
+
+	return request
+}
+
+// roundTrip passes the attributes through json as alexa does
+func roundTrip(t *testing.T, attributes map[string]interface{}) map[string]interface{} {
+	response := alexakit.AlexaResponse{SessionAttributes: attributes}
+	content, err := response.ToJson()
+	assert.Nil(t, err)
+
+	var decoded alexakit.AlexaResponse
+	assert.Nil(t, json.Unmarshal([]byte(content), &decoded))
+
+	return decoded.SessionAttributes
+}
@@ -0,0 +1,86 @@
+package devicecontrol
+
+import "smh-apiengine/pkg/alexakit"
+
+// alexaTexts struct holds the speech texts of the intent outcomes and dialogs in one language
+type alexaTexts struct {
+	NotMapped    string
+	NoSlots      string
+	Failed       string
+	Unclear      string
+	Denied       string
+	SlotNotFound string // slot name and value
+	WhichSlot    string // slot name
+	WhichOption  string // slot name and options
+	DidYouMean   string // command or scenario name
+	Or           string
+}
+
+// alexaTextsByLanguage are keyed by the language, the texts are SSML, so the arguments have to be escaped
+var alexaTextsByLanguage = map[string]alexaTexts{
+	"en": {
+		NotMapped:    "Sorry, I don't know how to do that yet.",
+		NoSlots:      "Sorry, I didn't get which device you mean.",
+		Failed:       "Sorry, it didn't work. Please check the device.",
+		Unclear:      "Sorry, I'm not sure which device you mean. Please be more specific.",
+		Denied:       "Ok, I won't do anything.",
+		SlotNotFound: "I couldn't find a %s called %s.",
+		WhichSlot:    "Which %s?",
+		WhichOption:  "Which %s, %s?",
+		DidYouMean:   "Did you mean %s?",
+		Or:           "or",
+	},
+	"de": {
+		NotMapped:    "Das kann ich leider noch nicht.",
+		NoSlots:      "Ich habe leider nicht verstanden, welches Gerät du meinst.",
+		Failed:       "Das hat leider nicht funktioniert. Bitte prüfe das Gerät.",
+		Unclear:      "Ich weiß leider nicht genau, welches Gerät du meinst. Bitte sei genauer.",
+		Denied:       "Okay, ich mache nichts.",
+		SlotNotFound: "Ich konnte %[2]s nicht finden.",
+		WhichSlot:    "Welcher Wert für %s?",
+		WhichOption:  "%s: %s?",
+		DidYouMean:   "Meintest du %s?",
+		Or:           "oder",
+	},
+}
+
+// textsFor returns the speech texts of the locale, the english texts if the language is not supported
+func textsFor(locale string) alexaTexts {
+	if texts, ok := alexaTextsByLanguage[alexakit.Language(locale)]; ok {
+		return texts
+	}
+
+	return alexaTextsByLanguage[alexakit.Language(alexakit.DefaultLocale)]
+}
+
+// localized returns the name and synonyms of the slot value in the language of the locale, false if the value is
+// not localized
+func (value SlotValue) localized(locale string) (LocalizedSlotValue, bool) {
+	for _, key := range alexakit.LocaleKeys(locale) {
+		if localized, ok := value.Locales[key]; ok && localized.Name != "" {
+			return localized, true
+		}
+	}
+
+	return LocalizedSlotValue{}, false
+}
+
+// spokenName returns the name of the slot in the language of the locale
+func (slot Slot) spokenName(slotName string, locale string) string {
+	for _, key := range alexakit.LocaleKeys(locale) {
+		if name, ok := slot.Locales[key]; ok && name != "" {
+			return name
+		}
+	}
+
+	return slotName
+}
+
+// spokenValue returns the name of the slot value in the language of the locale
+func (slot Slot) spokenValue(valueName string, locale string) string {
+	if localized, ok := slot.Values[valueName].localized(locale); ok {
+		return localized.Name
+	}
+
+	return valueName
+}
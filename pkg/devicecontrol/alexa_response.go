@@ -0,0 +1,43 @@
+package devicecontrol
+
+import (
+	"errors"
+	"fmt"
+	"smh-apiengine/pkg/alexakit"
+)
+
+const alexaCardTitle = "Smart Home"
+
+// AlexaOutcomeResponse creates the alexa response telling the user the outcome of the intent handling: the
+// confirmation on success, otherwise why the request could not be fulfilled, e.g. "I couldn't find a device called
+// hallway light", in the language of the request locale. The failure details are added to the card shown in the
+// alexa app.
+func AlexaOutcomeResponse(err error, locale string) alexakit.AlexaResponse {
+	if err == nil {
+		return alexakit.NewResponseBuilder().Speak(alexakit.Speech(locale).Confirmation).Build()
+	}
+
+	texts := textsFor(locale)
+
+	var slotErr *SlotValueError
+	var speech string
+
+	switch {
+	case errors.As(err, &slotErr):
+		speech = fmt.Sprintf(texts.SlotNotFound, alexakit.EscapeSSML(slotErr.Slot),
+			alexakit.EscapeSSML(slotErr.Value))
+	case errors.Is(err, ErrNoSlots):
+		speech = texts.NoSlots
+	case errors.Is(err, ErrIntentNotSupported), errors.Is(err, ErrCommandNotFound):
+		speech = texts.NotMapped
+	case errors.Is(err, ErrIntentIncomplete), errors.Is(err, ErrIntentAmbiguous):
+		speech = texts.Unclear
+	default:
+		speech = texts.Failed
+	}
+
+	return alexakit.NewResponseBuilder().
+		SpeakSSML(speech).
+		SimpleCard(alexaCardTitle, err.Error()).
+		Build()
+}
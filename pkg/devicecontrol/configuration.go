@@ -6,8 +6,8 @@ import (
 	"fmt"
 	uuid "github.com/satori/go.uuid"
 	"io/ioutil"
-	"log"
 	"os"
+	"smh-apiengine/pkg/logging"
 	"sync"
 )
 
@@ -63,12 +63,21 @@ type Intent struct {
 type Slot struct {
 	Name   string               `json:"name"`
 	Values map[string]SlotValue `json:"values"`
+	Locales map[string]string `json:"locales,omitempty"` // spoken name of the slot by locale or language
 }
 
 // SlotValue struct contains the name of the slot value and an array of possible synonyms
 type SlotValue struct {
 	Name     string   `json:"name"`
 	Synonyms []string `json:"synonyms"`
+	Locales map[string]LocalizedSlotValue `json:"locales,omitempty"` // keyed by locale (e.g. "de-DE") or language
+}
+
+// LocalizedSlotValue struct contains the name and synonyms of the slot value spoken in other language, they resolve
+// to the slot value name, so the commands refer to the same value in all the languages
+type LocalizedSlotValue struct {
+	Name     string   `json:"name"`
+	Synonyms []string `json:"synonyms"`
 }
 
 // Command struct contains all the data required for the execution as well as related intents that can trigger it
@@ -89,7 +98,8 @@ type CommandIntent struct {
 // CommandSlot simplified version of Slot that does not contains synonyms
 type CommandSlot struct {
 	Name  string `json:"name"`
-	Value string `json:"value"`
+	Value string `json:"value"` // "*" matches any value
+	Optional bool `json:"optional,omitempty"` // the command is fully matched without this slot in the request
 }
 
 // SequenceItem struct contains the command name as a reference to a command and delay to the next execution in seconds
@@ -191,6 +201,11 @@ func (ci *ControlItem) FindEntityByState(state string) *Entity {
 	return nil
 }
 
+// SupportsPower checks whether the control item can be turned on and off
+func (ci *ControlItem) SupportsPower() bool {
+	return ci.FindEntityByState(StateOn) != nil && ci.FindEntityByState(StateOff) != nil
+}
+
 func (ci *ControlItem) FindNextStateEntity() *Entity {
 	if len(ci.StateEntities) == 0 {
 		return nil
@@ -289,6 +304,17 @@ func (c *Config) FindControlItemByID(id string) *ControlItem {
 	return nil
 }
 
+// FindControlIDByItemID returns the id of the control the item belongs to or empty string if there is no such item
+func (c *Config) FindControlIDByItemID(id string) string {
+	for _, control := range c.Controls {
+		if _, ok := control.Items[id]; ok {
+			return control.ID
+		}
+	}
+
+	return ""
+}
+
 func (c *Config) FindScenarioByID(id string) *Scenario {
 	if scenario, ok := c.Scenarios[id]; ok {
 		return &scenario
@@ -321,7 +347,7 @@ func NewConfiguration(fileName string) (Config, error) {
 		err := jsonFile.Close()
 
 		if err != nil {
-			log.Println("Unable to close the config JSON file")
+			logging.WithError(err).Warnf("Unable to close the config JSON file")
 		}
 	}()
 
@@ -376,7 +402,7 @@ func (c *Config) SaveConfiguration(fileName string) error {
 		err := jsonFile.Close()
 
 		if err != nil {
-			log.Println("Unable to close the config JSON file")
+			logging.WithError(err).Warnf("Unable to close the config JSON file")
 		}
 	}()
 
@@ -1,8 +1,9 @@
 package devicecontrol
 
 import (
-	"log"
+	"smh-apiengine/pkg/logging"
 	"strings"
+	"sync"
 
 	"github.com/rudestan/broadlinkrm"
 	"github.com/satori/go.uuid"
@@ -13,7 +14,9 @@ type DeviceControl struct {
 	config *Config
 	broadlink broadlinkrm.Broadlink
 	lock *spinLock
-	DeviceStateBuffer map[string]DeviceState
+	events *eventBus
+	status *deviceStatus
+	states *sync.RWMutex // guards the active states of the control items set by the concurrent executions
 }
 
 func NewDeviceControl(config *Config) DeviceControl  {
@@ -21,7 +24,9 @@ func NewDeviceControl(config *Config) DeviceControl  {
 		config:    config,
 		broadlink: broadlinkrm.NewBroadlink(),
 		lock: 	   &spinLock{},
-		DeviceStateBuffer: make(map[string]DeviceState),
+		events:    &eventBus{},
+		status:    newDeviceStatus(),
+		states:    &sync.RWMutex{},
 	}
 
 	if len(config.Devices) > 0 {
@@ -31,6 +36,15 @@ func NewDeviceControl(config *Config) DeviceControl  {
 	return deviceControl
 }
 
+// Close flushes the configuration with the latest devices data to the configuration file
+func (deviceControl *DeviceControl) Close() error {
+	if deviceControl.config.fileName == "" {
+		return nil
+	}
+
+	return deviceControl.config.SaveConfiguration(deviceControl.config.fileName)
+}
+
 func (deviceControl *DeviceControl) AddCommand(cmd Command) {
 	if deviceControl.config.Commands == nil {
 		deviceControl.config.Commands = make(map[string]Command)
@@ -135,6 +149,16 @@ func (deviceControl *DeviceControl) FindControlItemByID(id string) *ControlItem
 	return deviceControl.config.FindControlItemByID(id)
 }
 
+// FindControlIDByItemID finds the id of the control containing the control item with provided id
+func (deviceControl *DeviceControl) FindControlIDByItemID(id string) string {
+	return deviceControl.config.FindControlIDByItemID(id)
+}
+
+// FindScenarioByID finds Scenario structure by provided id or nil if there is no Scenario found
+func (deviceControl *DeviceControl) FindScenarioByID(id string) *Scenario {
+	return deviceControl.config.FindScenarioByID(id)
+}
+
 // FindScenarioByName finds Scenario structure by provided name or error if there is no Scenario found
 func (deviceControl *DeviceControl) FindScenarioByName(name string) (Scenario, error) {
 	return deviceControl.config.findScenarioByName(name)
@@ -145,10 +169,38 @@ func (deviceControl *DeviceControl) AllControls() map[string]Control {
 	return deviceControl.config.Controls
 }
 
+// ControlItemStates returns the active states of the control items which have been executed, by the item id
+func (deviceControl *DeviceControl) ControlItemStates() map[string]string {
+	states := make(map[string]string)
+
+	deviceControl.states.RLock()
+	defer deviceControl.states.RUnlock()
+
+	for _, control := range deviceControl.config.Controls {
+		for id, item := range control.Items {
+			if item.activeState != "" {
+				states[id] = item.activeState
+			}
+		}
+	}
+
+	return states
+}
+
+// controlItemState returns the active state of the control item, empty if it has not been executed
+func (deviceControl *DeviceControl) controlItemState(item *ControlItem) string {
+	deviceControl.states.RLock()
+	defer deviceControl.states.RUnlock()
+
+	return item.activeState
+}
+
 func (deviceControl *DeviceControl) initDevices() {
 	for _, deviceConfig := range deviceControl.config.Devices {
+		logger := logging.WithFields(logging.Fields{"device": deviceConfig.Name, "ip": deviceConfig.IP})
+
 		if !deviceConfig.Enabled {
-			log.Printf("The device with ip: %s is disbled. Skipping\n", deviceConfig.IP)
+			logger.Infof("The device is disabled. Skipping")
 			continue
 		}
 
@@ -160,9 +212,9 @@ func (deviceControl *DeviceControl) initDevices() {
 			cast.ToInt(deviceConfig.DeviceType))
 
 		if err != nil {
-			log.Printf("Failed to add the device with ip: %s\n", deviceConfig.IP)
+			logger.WithError(err).Errorf("Failed to add the device")
 		} else {
-			log.Printf("Device \"%s\" added\n", deviceConfig.Name)
+			logger.Infof("Device added")
 		}
 	}
 }
@@ -0,0 +1,108 @@
+package devicecontrol
+
+import (
+	"context"
+	"smh-apiengine/pkg/logging"
+	"sync"
+	"time"
+)
+
+const (
+	EventCommand     = "command"
+	EventScenario    = "scenario"
+	EventControlItem = "control_item"
+	EventDiscover    = "discover"
+	EventPowerState  = "power_state"
+	EventIntent      = "intent"
+	// EventDeviceStatus is emitted when the device becomes unreachable (a command fails on it) or reachable again
+	EventDeviceStatus = "device_status"
+)
+
+// States of the device status events
+const (
+	DeviceOnline  = "online"
+	DeviceOffline = "offline"
+)
+
+// Sources of the executions
+const (
+	SourceHTTP     = "http"
+	SourceAlexa    = "alexa"
+	SourceRMQ      = "rmq"
+	SourceMQTT     = "mqtt"
+	SourceSchedule = "schedule"
+	SourceCLI      = "cli"
+)
+
+// Event struct describes an execution outcome or a state change in device control
+type Event struct {
+	Type      string        `json:"type"`
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	DeviceID  string        `json:"device_id,omitempty"`
+	State     string        `json:"state,omitempty"`
+	Err       error         `json:"-"`
+	Duration  time.Duration `json:"duration"`
+	Time      time.Time     `json:"time"`
+	RequestID string        `json:"request_id,omitempty"`
+	Source    string        `json:"source,omitempty"`
+	Actor     string        `json:"actor,omitempty"`
+}
+
+// Source struct describes who started the execution, Actor is for example the name of the api token
+type Source struct {
+	Type  string
+	Actor string
+}
+
+type sourceKey struct{}
+
+// EventListener is called synchronously for every event, so it should not block
+type EventListener func(event Event)
+
+type eventBus struct {
+	listeners []EventListener
+	mu        sync.RWMutex
+}
+
+// Subscribe adds the listener that will be notified about all the following events
+func (deviceControl *DeviceControl) Subscribe(listener EventListener) {
+	deviceControl.events.mu.Lock()
+	defer deviceControl.events.mu.Unlock()
+
+	deviceControl.events.listeners = append(deviceControl.events.listeners, listener)
+}
+
+func (deviceControl *DeviceControl) emit(ctx context.Context, event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	event.RequestID = logging.RequestID(ctx)
+	source := SourceFromContext(ctx)
+	event.Source, event.Actor = source.Type, source.Actor
+
+	deviceControl.events.mu.RLock()
+	defer deviceControl.events.mu.RUnlock()
+
+	for _, listener := range deviceControl.events.listeners {
+		listener(event)
+	}
+}
+
+// Failed returns true if the event describes failed execution
+func (e Event) Failed() bool {
+	return e.Err != nil
+}
+
+// WithSource returns a copy of the context carrying the source of the execution, so it is added to the events
+func WithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// SourceFromContext returns the source of the execution from the context or empty source if there is none
+func SourceFromContext(ctx context.Context) Source {
+	source, _ := ctx.Value(sourceKey{}).(Source)
+
+	return source
+}
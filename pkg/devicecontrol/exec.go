@@ -1,10 +1,11 @@
 package devicecontrol
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"github.com/rudestan/broadlinkrm"
+	"smh-apiengine/pkg/logging"
 	"time"
 )
 
@@ -13,31 +14,41 @@ const (
 	stateOn = "on"
 )
 
-type DeviceState struct {
-	Id string `json:"id"`
-	State string `json:"state"`
-}
+// ErrDeviceControlBusy is returned when the command is not executed because another command or the discovery is
+// running
+var ErrDeviceControlBusy = errors.New("device control is busy")
 
 // ExecScenarioFullCycle executes scenario full cycle with commands one after another, including the delay
-func (deviceControl *DeviceControl) ExecScenarioFullCycle(scenario Scenario) error {
-	log.Printf("Executing scenario \"%s\" with %d sequence items", scenario.Name, len(scenario.Sequence))
+func (deviceControl *DeviceControl) ExecScenarioFullCycle(ctx context.Context, scenario Scenario) (err error) {
+	start := time.Now()
+	defer func() {
+		deviceControl.emit(ctx, Event{
+			Type:     EventScenario,
+			ID:       scenario.ID,
+			Name:     scenario.Name,
+			Err:      err,
+			Duration: time.Since(start)})
+	}()
+
+	logger := logging.WithContext(ctx).WithField("scenario", scenario.Name)
+	logger.Infof("Executing scenario with %d sequence items", len(scenario.Sequence))
 
 	for _, sequenceItem := range scenario.Sequence {
-		log.Printf("Executing sequence item \"%s\"", sequenceItem.CommandId)
+		logger.Debugf("Executing sequence item \"%s\"", sequenceItem.CommandId)
 
 		cmd := deviceControl.config.FindCommandByID(sequenceItem.CommandId)
 		if cmd == nil {
 			return errors.New("command not found")
 		}
 
-		err := deviceControl.ExecCommandFullCycle(*cmd)
+		err := deviceControl.ExecCommandFullCycle(ctx, *cmd)
 		if err != nil {
 			return err
 		}
 
-		if sequenceItem.Delay > 0 {
-			log.Printf("Sleeping %d seconds\n", sequenceItem.Delay)
-			time.Sleep(time.Second * time.Duration(sequenceItem.Delay))
+		err = deviceControl.sleep(ctx, sequenceItem.Delay)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -46,13 +57,13 @@ func (deviceControl *DeviceControl) ExecScenarioFullCycle(scenario Scenario) err
 
 // ExecCommandFullCycle executes the command in full cycle with retry and discover, as well as updating and saving
 // the device data
-func (deviceControl *DeviceControl) ExecCommandFullCycle(command Command) error {
+func (deviceControl *DeviceControl) ExecCommandFullCycle(ctx context.Context, command Command) error {
 	device, err := deviceControl.config.findDeviceByMac(command.DeviceID)
 	if err != nil {
 		return err
 	}
 
-	err = deviceControl.execCommandWithRetryAndDiscover(device, command)
+	err = deviceControl.execCommandWithRetryAndDiscover(ctx, device, command)
 	if err != nil {
 		return err
 	}
@@ -67,9 +78,25 @@ func (deviceControl *DeviceControl) ExecCommandFullCycle(command Command) error
 
 // ExecControlItem executes the command in full cycle with retry and discover, as well as updating and saving
 // the device data
-func (deviceControl *DeviceControl) ExecControlItem(controlItem *ControlItem, state string) error {
+func (deviceControl *DeviceControl) ExecControlItem(ctx context.Context, controlItem *ControlItem, state string) (err error) {
 	var stateEntity *Entity
 
+	start := time.Now()
+	defer func() {
+		event := Event{
+			Type:     EventControlItem,
+			ID:       controlItem.ID,
+			Name:     controlItem.Name,
+			Err:      err,
+			Duration: time.Since(start)}
+
+		if err == nil {
+			event.State = stateEntity.State
+		}
+
+		deviceControl.emit(ctx, event)
+	}()
+
 	if state != "" {
 		stateEntity = controlItem.FindEntityByState(state)
 
@@ -77,7 +104,9 @@ func (deviceControl *DeviceControl) ExecControlItem(controlItem *ControlItem, st
 			return errors.New(fmt.Sprintf("Can not find Entity with %s state", state))
 		}
 	} else {
+		deviceControl.states.RLock()
 		stateEntity = controlItem.FindNextStateEntity()
+		deviceControl.states.RUnlock()
 
 		if stateEntity == nil {
 			return errors.New("Can not find Entity with next state")
@@ -91,7 +120,7 @@ func (deviceControl *DeviceControl) ExecControlItem(controlItem *ControlItem, st
 			return errors.New("command not found")
 		}
 
-		err := deviceControl.ExecCommand(cmd)
+		err := deviceControl.ExecCommand(ctx, cmd)
 		if err != nil {
 			return err
 		}
@@ -101,7 +130,7 @@ func (deviceControl *DeviceControl) ExecControlItem(controlItem *ControlItem, st
 			return errors.New("scenario not found")
 		}
 
-		err := deviceControl.ExecScenario(scenario)
+		err := deviceControl.ExecScenario(ctx, scenario)
 		if err != nil {
 			return err
 		}
@@ -109,38 +138,42 @@ func (deviceControl *DeviceControl) ExecControlItem(controlItem *ControlItem, st
 		return errors.New("unknown element type")
 	}
 
+	deviceControl.states.Lock()
 	controlItem.activeState = stateEntity.State
+	deviceControl.states.Unlock()
 
 	return nil
 }
 
 // ExecCommandWithRetryAndDiscover executes the command on passed device, in case of failure calls the execution
 // with device discovering
-func (deviceControl *DeviceControl) execCommandWithRetryAndDiscover(device *Device, command Command) error {
-	err := deviceControl.ExecCommand(&command)
+func (deviceControl *DeviceControl) execCommandWithRetryAndDiscover(ctx context.Context, device *Device, command Command) error {
+	err := deviceControl.ExecCommand(ctx, &command)
 
-	if err == nil {
-		return nil
+	// the busy device control is not a device failure, the discovery would be skipped anyway
+	if err == nil || errors.Is(err, ErrDeviceControlBusy) {
+		return err
 	}
 
-	log.Printf("Failed trying with discovering: %s\n", err)
+	logger := logging.WithContext(ctx).WithField("device", device.Name)
+	logger.WithError(err).Warnf("Failed, trying with discovering")
 
-	err = deviceControl.Discover(true)
+	err = deviceControl.Discover(ctx, true)
 
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Retrying execution on device: %s (%s, %s)\n", device.Name, device.IP, device.Mac)
+	logger.Infof("Retrying execution on device (%s, %s)", device.IP, device.Mac)
 
-	return deviceControl.ExecCommand(&command)
+	return deviceControl.ExecCommand(ctx, &command)
 }
 
 // discover function discovers the devices, this operation is time consuming and should be executed only once (for
 // example from some goroutine).
-func (deviceControl *DeviceControl) Discover(debug bool) error {
+func (deviceControl *DeviceControl) Discover(ctx context.Context, debug bool) error {
 	if deviceControl.lock.Locked() {
-		log.Println("device control is locked, can not discover")
+		logging.WithContext(ctx).Warnf("device control is locked, can not discover")
 		return nil
 	}
 
@@ -153,47 +186,84 @@ func (deviceControl *DeviceControl) Discover(debug bool) error {
 		deviceControl.broadlink.DebugOff()
 	}
 
+	start := time.Now()
 	err := deviceControl.broadlink.Discover()
 
+	deviceControl.emit(ctx, Event{Type: EventDiscover, Err: err, Duration: time.Since(start)})
+
 	if err != nil {
 		return err
 	}
 
+	logging.WithContext(ctx).Infof("Discovered %d device(s) in %s", deviceControl.broadlink.Count(), time.Since(start))
+
 	return nil
 }
 
-// ExecScenarioFullCycle executes scenario full cycle with commands one after another, including the delay
-func (deviceControl *DeviceControl) ExecScenario(scenario *Scenario) error {
-	log.Printf("Executing scenario \"%s\" with %d sequence items", scenario.Name, len(scenario.Sequence))
+// ExecScenario executes scenario commands one after another without retries, including the delay
+func (deviceControl *DeviceControl) ExecScenario(ctx context.Context, scenario *Scenario) (err error) {
+	start := time.Now()
+	defer func() {
+		deviceControl.emit(ctx, Event{
+			Type:     EventScenario,
+			ID:       scenario.ID,
+			Name:     scenario.Name,
+			Err:      err,
+			Duration: time.Since(start)})
+	}()
+
+	logger := logging.WithContext(ctx).WithField("scenario", scenario.Name)
+	logger.Infof("Executing scenario with %d sequence items", len(scenario.Sequence))
 
 	for _, sequenceItem := range scenario.Sequence {
-		log.Printf("Executing sequence item \"%s\"", sequenceItem.CommandId)
+		logger.Debugf("Executing sequence item \"%s\"", sequenceItem.CommandId)
 
 		cmd := deviceControl.config.FindCommandByID(sequenceItem.CommandId)
 		if cmd == nil {
 			return errors.New("command not found")
 		}
 
-		err := deviceControl.ExecCommand(cmd)
+		err := deviceControl.ExecCommand(ctx, cmd)
 		if err != nil {
 			return err
 		}
 
-		if sequenceItem.Delay > 0 {
-			log.Printf("Sleeping %d seconds\n", sequenceItem.Delay)
-			time.Sleep(time.Second * time.Duration(sequenceItem.Delay))
+		err = deviceControl.sleep(ctx, sequenceItem.Delay)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// sleep waits for the delay in seconds between the sequence items, returns earlier with error if the context is done
+func (deviceControl *DeviceControl) sleep(ctx context.Context, delay int) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	logging.WithContext(ctx).Debugf("Sleeping %d seconds", delay)
+
+	timer := time.NewTimer(time.Second * time.Duration(delay))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // execCommand executes command on the device, should not be during lock. The operation can be time consuming in
 // case the device is not available on the network. It will fail on timeout.
-func (deviceControl *DeviceControl) ExecCommand(command *Command) error  {
+func (deviceControl *DeviceControl) ExecCommand(ctx context.Context, command *Command) error  {
+	logger := logging.WithContext(ctx).WithField("command", command.Name)
+
 	if deviceControl.lock.Locked() {
-		log.Println("device control is locked, can not execute command")
-		return nil
+		logger.Warnf("device control is locked, can not execute command")
+		return ErrDeviceControlBusy
 	}
 
 	deviceControl.lock.Lock()
@@ -205,10 +275,68 @@ func (deviceControl *DeviceControl) ExecCommand(command *Command) error  {
 		return errors.New(fmt.Sprintf("No device with id %s found", command.DeviceID))
 	}
 
-	return deviceControl.broadlink.Execute(device.Mac, command.Code)
+	logger = logger.WithFields(logging.Fields{"device": device.Name, "mac": device.Mac})
+	logger.Debugf("Sending command to broadlink device")
+
+	start := time.Now()
+	err := deviceControl.broadlink.Execute(device.Mac, command.Code)
+
+	if err != nil {
+		logger.WithError(err).Errorf("Broadlink device failed to execute command in %s", time.Since(start))
+	} else {
+		logger.Infof("Broadlink device executed command in %s", time.Since(start))
+	}
+
+	statusChanged := deviceControl.status.update(device.Mac, err)
+	deviceControl.emit(ctx, Event{
+		Type:     EventCommand,
+		ID:       command.ID,
+		Name:     command.Name,
+		DeviceID: device.Mac,
+		Err:      err,
+		Duration: time.Since(start)})
+
+	if statusChanged {
+		state := DeviceOnline
+
+		if err != nil {
+			state = DeviceOffline
+		}
+
+		deviceControl.emit(ctx, Event{
+			Type:     EventDeviceStatus,
+			ID:       device.Mac,
+			Name:     device.Name,
+			DeviceID: device.Mac,
+			State:    state,
+			Err:      err})
+	}
+
+	return err
 }
 
-func (deviceControl *DeviceControl) getPowerState(device *Device) error  {
+// SwitchPower turns the power switch device on or off and emits its new power state
+func (deviceControl *DeviceControl) SwitchPower(ctx context.Context, device *Device, on bool) error {
+	state := stateOff
+
+	if on {
+		state = stateOn
+	}
+
+	command := deviceControl.NewCommandForPowerSwitch(device, device.Name+" "+state, state)
+
+	err := deviceControl.ExecCommand(ctx, &command)
+	if err != nil {
+		return err
+	}
+
+	deviceControl.emitPowerState(ctx, device, state)
+
+	return nil
+}
+
+// RefreshPowerState requests the power state of the power switch device and emits it
+func (deviceControl *DeviceControl) RefreshPowerState(ctx context.Context, device *Device) error  {
 	powerState, err := deviceControl.broadlink.GetPowerState(device.Mac)
 
 	if err != nil {
@@ -221,15 +349,20 @@ func (deviceControl *DeviceControl) getPowerState(device *Device) error  {
 		state = stateOn
 	}
 
-	now := time.Now().String()
-	deviceControl.DeviceStateBuffer[now] = DeviceState{
-		Id:    device.ID,
-		State: state,
-	}
+	deviceControl.emitPowerState(ctx, device, state)
 
 	return nil
 }
 
+func (deviceControl *DeviceControl) emitPowerState(ctx context.Context, device *Device, state string) {
+	deviceControl.emit(ctx, Event{
+		Type:     EventPowerState,
+		ID:       device.Mac,
+		Name:     device.Name,
+		DeviceID: device.Mac,
+		State:    state})
+}
+
 func  (deviceControl *DeviceControl) updateAndSaveMatchedDiscoveredDevice(device *Device) error {
 	deviceInfo, err := deviceControl.broadlink.GetDeviceInfo(device.Mac)
 
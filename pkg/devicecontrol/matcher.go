@@ -0,0 +1,295 @@
+package devicecontrol
+
+import (
+	"smh-apiengine/pkg/alexakit"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Scores of the slot value matches, the request value matching the configured value exactly is preferred over the
+// synonym and the synonym over the similar value (e.g. misheard "lite" for "light")
+const (
+	ScoreExact    = 3
+	ScoreSynonym  = 2
+	ScoreFuzzy    = 1
+	ScoreWildcard = 0
+
+	// SlotWildcard is the command or scenario slot value matching any value of the slot
+	SlotWildcard = "*"
+
+	// fuzzyMinLength is the min length of the value compared by the edit distance, short values are too ambiguous
+	fuzzyMinLength = 4
+)
+
+// MatchCandidate struct is the command or scenario matching the request intent
+type MatchCandidate struct {
+	Type     string // ElementTypeCommand or ElementTypeScenario
+	ID       string
+	Name     string
+	Slots    map[string]string // slot values of the matched command intent
+	Score    int
+	Complete bool     // all the required slots of the command intent are in the request
+	Missing  []string // the required slots missing in the request, sorted
+}
+
+// MatchResult struct holds the candidates ordered from the best one, the ties are the candidates equal to the best.
+// The scenario wins the tie with the commands, so only the candidates of the same type are the ties.
+type MatchResult struct {
+	Candidates []MatchCandidate
+	Ties       []MatchCandidate
+}
+
+// Best returns the best candidate, false if nothing matched
+func (result MatchResult) Best() (MatchCandidate, bool) {
+	if len(result.Candidates) == 0 {
+		return MatchCandidate{}, false
+	}
+
+	return result.Candidates[0], true
+}
+
+// Ambiguous checks whether several candidates match equally well
+func (result MatchResult) Ambiguous() bool {
+	return len(result.Ties) > 1
+}
+
+// find returns the candidate of the type with the id, false if it does not match the intent
+func (result MatchResult) find(elementType string, id string) (MatchCandidate, bool) {
+	for _, candidate := range result.Candidates {
+		if candidate.Type == elementType && candidate.ID == id {
+			return candidate, true
+		}
+	}
+
+	return MatchCandidate{}, false
+}
+
+// resolveSlotValue finds the configured value of the request slot: the value name, its synonyms and the similar
+// values within the edit distance are compared. The values localized for the request locale are compared by their
+// localized name and synonyms instead. Returns the configured value name and the score of the match.
+func resolveSlotValue(slot Slot, requestValue string, locale string) (string, int, bool) {
+	requestValue = strings.ToLower(strings.TrimSpace(requestValue))
+
+	if requestValue == "" {
+		return "", 0, false
+	}
+
+	names := make([]string, 0, len(slot.Values))
+
+	for name := range slot.Values {
+		names = append(names, name)
+	}
+
+	// map order is random, the sorted names make the equal fuzzy matches deterministic
+	sort.Strings(names)
+
+	bestName, bestScore, bestDistance := "", -1, -1
+
+	for _, name := range names {
+		value := slot.Values[name]
+		spokenName, synonyms := value.Name, value.Synonyms
+
+		if localized, ok := value.localized(locale); ok {
+			spokenName, synonyms = localized.Name, localized.Synonyms
+		}
+
+		// the value name is also used by the code, e.g. in the dialog state, so it is matched in any language
+		if strings.EqualFold(value.Name, requestValue) || strings.EqualFold(spokenName, requestValue) {
+			return value.Name, ScoreExact, true
+		}
+
+		for _, phrase := range append([]string{spokenName}, synonyms...) {
+			phrase = strings.ToLower(phrase)
+
+			if phrase == requestValue {
+				if bestScore < ScoreSynonym {
+					bestName, bestScore = value.Name, ScoreSynonym
+				}
+
+				continue
+			}
+
+			distance, ok := fuzzyDistance(phrase, requestValue)
+
+			if ok && bestScore < ScoreFuzzy || ok && bestScore == ScoreFuzzy && distance < bestDistance {
+				bestName, bestScore, bestDistance = value.Name, ScoreFuzzy, distance
+			}
+		}
+	}
+
+	return bestName, bestScore, bestScore >= 0
+}
+
+// matchIntent scores the scenarios and commands against the request intent. A command intent matches if every
+// request slot is in the command intent with the same value or the wildcard. The command intent slots missing in the
+// request make the match incomplete unless they are optional. The candidates are ordered by completeness, score,
+// scenarios first, then by name and id, so the result does not depend on the map order.
+func (c *Config) matchIntent(reqIntent alexakit.SimpleIntent) MatchResult {
+	var candidates []MatchCandidate
+
+	for id, scenario := range c.Scenarios {
+		if candidate, ok := matchElement(scenario.Intents, reqIntent); ok {
+			candidate.Type, candidate.ID, candidate.Name = ElementTypeScenario, id, scenario.Name
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	for id, command := range c.Commands {
+		if candidate, ok := matchElement(command.Intents, reqIntent); ok {
+			candidate.Type, candidate.ID, candidate.Name = ElementTypeCommand, id, command.Name
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+
+		switch {
+		case a.Complete != b.Complete:
+			return a.Complete
+		case a.Score != b.Score:
+			return a.Score > b.Score
+		case a.Type != b.Type:
+			return a.Type == ElementTypeScenario
+		case a.Name != b.Name:
+			return a.Name < b.Name
+		}
+
+		return a.ID < b.ID
+	})
+
+	result := MatchResult{Candidates: candidates}
+
+	for _, candidate := range candidates {
+		if candidate.Complete != candidates[0].Complete || candidate.Score != candidates[0].Score ||
+			candidate.Type != candidates[0].Type {
+			break
+		}
+
+		result.Ties = append(result.Ties, candidate)
+	}
+
+	return result
+}
+
+// matchElement returns the best matching intent of the command or scenario
+func matchElement(intents []CommandIntent, reqIntent alexakit.SimpleIntent) (MatchCandidate, bool) {
+	var best MatchCandidate
+
+	matched := false
+
+	for _, commandIntent := range intents {
+		if commandIntent.Name != reqIntent.Name {
+			continue
+		}
+
+		candidate, ok := matchSlots(commandIntent.Slots, reqIntent.Slots)
+
+		if ok && (!matched || candidate.Complete && !best.Complete ||
+			candidate.Complete == best.Complete && candidate.Score > best.Score) {
+			best, matched = candidate, true
+		}
+	}
+
+	return best, matched
+}
+
+func matchSlots(commandSlots map[string]CommandSlot, slots map[string]alexakit.SimpleSlot) (MatchCandidate, bool) {
+	candidate := MatchCandidate{Slots: map[string]string{}, Complete: true}
+
+	for name, slot := range slots {
+		commandSlot, ok := commandSlots[name]
+
+		switch {
+		case !ok:
+			return MatchCandidate{}, false
+		case commandSlot.Value == SlotWildcard:
+			candidate.Score += ScoreWildcard
+		case strings.EqualFold(commandSlot.Value, slot.Value):
+			candidate.Score += slotScore(slot)
+		default:
+			return MatchCandidate{}, false
+		}
+	}
+
+	for name, commandSlot := range commandSlots {
+		candidate.Slots[name] = commandSlot.Value
+
+		if slot, ok := slots[name]; ok && commandSlot.Value == SlotWildcard {
+			candidate.Slots[name] = slot.Value
+		} else if !ok && !commandSlot.Optional {
+			candidate.Complete = false
+			candidate.Missing = append(candidate.Missing, name)
+		}
+	}
+
+	sort.Strings(candidate.Missing)
+
+	return candidate, true
+}
+
+// slotScore returns the score of the request slot resolution, the slots without the score are set by the code, e.g.
+// from the dialog state, and have the configured values
+func slotScore(slot alexakit.SimpleSlot) int {
+	if slot.Score == 0 {
+		return ScoreExact
+	}
+
+	return slot.Score
+}
+
+// fuzzyDistance returns the edit distance of the values if it is small enough to consider them the same, one edit
+// is allowed per four characters
+func fuzzyDistance(a string, b string) (int, bool) {
+	lengthA, lengthB := utf8.RuneCountInString(a), utf8.RuneCountInString(b)
+
+	if lengthA < fuzzyMinLength || lengthB < fuzzyMinLength {
+		return 0, false
+	}
+
+	distance := editDistance(a, b)
+
+	return distance, distance <= lengthA/fuzzyMinLength
+}
+
+// editDistance returns the levenshtein distance of the strings
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+func minInt(values ...int) int {
+	min := values[0]
+
+	for _, value := range values[1:] {
+		if value < min {
+			min = value
+		}
+	}
+
+	return min
+}
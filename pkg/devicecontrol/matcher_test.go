@@ -0,0 +1,175 @@
+package devicecontrol
+
+import (
+	"context"
+	"errors"
+	"smh-apiengine/pkg/alexakit"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_resolveSlotValue(t *testing.T) {
+	slot := Slot{Name: "item", Values: map[string]SlotValue{
+		"light":   {Name: "light", Synonyms: []string{"lamp", "ceiling light"}},
+		"lamp":    {Name: "lamp"},
+		"tv":      {Name: "tv", Synonyms: []string{"television"}},
+		"speaker": {Name: "speaker"},
+	}}
+
+	tests := []struct {
+		value string
+		want  string
+		score int
+		ok    bool
+	}{
+		{"Light", "light", ScoreExact, true},
+		{"lamp", "lamp", ScoreExact, true},
+		{"ceiling light", "light", ScoreSynonym, true},
+		{"televison", "tv", ScoreFuzzy, true},
+		{"speakers", "speaker", ScoreFuzzy, true},
+		{"tvs", "", 0, false},
+		{"radio", "", 0, false},
+		{"", "", 0, false},
+	}
+
+	for _, test := range tests {
+		value, score, ok := resolveSlotValue(slot, test.value, "")
+
+		assert.Equal(t, test.ok, ok, test.value)
+
+		if test.ok {
+			assert.Equal(t, test.want, value, test.value)
+			assert.Equal(t, test.score, score, test.value)
+		}
+	}
+}
+
+func Test_fuzzyDistance_CountsRunes(t *testing.T) {
+	tests := []struct {
+		a  string
+		b  string
+		ok bool
+	}{
+		{"türe", "türen", true},
+		{"türe", "tür", false},
+		{"tür", "tor", false},
+		{"küche", "kuche", true},
+	}
+
+	for _, test := range tests {
+		_, ok := fuzzyDistance(test.a, test.b)
+
+		assert.Equal(t, test.ok, ok, test.a+" "+test.b)
+	}
+}
+
+func Test_matchIntent(t *testing.T) {
+	config := Config{
+		Commands: map[string]Command{
+			"tv-on":       matcherTestCommand("TV on", map[string]string{"action": "on", "item": "tv"}),
+			"tv-off":      matcherTestCommand("TV off", map[string]string{"action": "off", "item": "tv"}),
+			"light-on-k":  matcherTestCommand("Kitchen light on", map[string]string{"action": "on", "item": "light", "room": "kitchen"}),
+			"light-on-b":  matcherTestCommand("Bedroom light on", map[string]string{"action": "on", "item": "light", "room": "bedroom"}),
+			"any-off":     matcherTestCommand("All off", map[string]string{"action": "off", "item": "*"}),
+			"fan-on":      matcherTestCommand("Fan on", map[string]string{"action": "on", "item": "fan", "room?": "bedroom"}),
+			"duplicate-a": matcherTestCommand("Radio A", map[string]string{"item": "radio"}),
+			"duplicate-b": matcherTestCommand("Radio B", map[string]string{"item": "radio"}),
+		},
+		Scenarios: map[string]Scenario{
+			"movie": {ID: "movie", Name: "Movie", Intents: []CommandIntent{matcherTestIntent(
+				map[string]string{"action": "on", "item": "tv"})}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		slots     map[string]alexakit.SimpleSlot
+		best      string
+		ties      int
+		ambiguous bool
+	}{
+		{"scenario preferred to command", slots("action", "on", "item", "tv"), "movie", 1, false},
+		{"exact preferred to wildcard", slots("action", "off", "item", "tv"), "tv-off", 1, false},
+		{"wildcard matches any value", slots("action", "off", "item", "light"), "any-off", 1, false},
+		{"missing slot is tie", slots("action", "on", "item", "light"), "light-on-b", 2, true},
+		{"all slots given", slots("action", "on", "item", "light", "room", "kitchen"), "light-on-k", 1, false},
+		{"optional slot missing", slots("action", "on", "item", "fan"), "fan-on", 1, false},
+		{"unknown slot", slots("action", "on", "color", "red"), "", 0, false},
+		{"duplicates are ties", slots("item", "radio"), "duplicate-a", 2, true},
+	}
+
+	for _, test := range tests {
+		result := config.matchIntent(alexakit.SimpleIntent{Name: "TurnOn", Slots: test.slots})
+		best, ok := result.Best()
+
+		assert.Equal(t, test.best != "", ok, test.name)
+		assert.Equal(t, test.best, best.ID, test.name)
+		assert.Len(t, result.Ties, test.ties, test.name)
+		assert.Equal(t, test.ambiguous, result.Ambiguous(), test.name)
+	}
+}
+
+func Test_matchIntent_PrefersBetterSlotScore(t *testing.T) {
+	config := Config{Commands: map[string]Command{
+		"a": matcherTestCommand("A", map[string]string{"item": "light"}),
+		"b": matcherTestCommand("B", map[string]string{"item": "*"}),
+	}}
+
+	fuzzy := map[string]alexakit.SimpleSlot{"item": {Name: "item", Value: "light", Score: ScoreFuzzy}}
+	best, _ := config.matchIntent(alexakit.SimpleIntent{Name: "TurnOn", Slots: fuzzy}).Best()
+
+	assert.Equal(t, "a", best.ID)
+}
+
+func Test_HandleAlexaRequest_ExecutesOnlyCompleteUniqueMatch(t *testing.T) {
+	deviceControl := NewDeviceControl(&Config{Commands: map[string]Command{
+		"light-on-b":  matcherTestCommand("Bedroom light on", map[string]string{"item": "light", "room": "bedroom"}),
+		"duplicate-a": matcherTestCommand("Radio A", map[string]string{"item": "radio"}),
+		"duplicate-b": matcherTestCommand("Radio B", map[string]string{"item": "radio"}),
+	}})
+	ctx := context.Background()
+
+	err := deviceControl.HandleAlexaRequest(ctx, alexakit.SimpleIntent{Name: "TurnOn", Slots: slots("item", "light")})
+	assert.True(t, errors.Is(err, ErrIntentIncomplete), err)
+
+	err = deviceControl.HandleAlexaRequest(ctx, alexakit.SimpleIntent{Name: "TurnOn", Slots: slots("item", "radio")})
+	assert.True(t, errors.Is(err, ErrIntentAmbiguous), err)
+
+	// the complete match is executed, it fails on the missing device
+	err = deviceControl.HandleAlexaRequest(ctx, alexakit.SimpleIntent{Name: "TurnOn",
+		Slots: slots("item", "light", "room", "bedroom")})
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrIntentIncomplete) || errors.Is(err, ErrIntentAmbiguous), err)
+}
+
+// matcherTestCommand creates the command, the slot names ending with "?" are optional
+func matcherTestCommand(name string, values map[string]string) Command {
+	return Command{Name: name, Intents: []CommandIntent{matcherTestIntent(values)}}
+}
+
+func matcherTestIntent(values map[string]string) CommandIntent {
+	commandSlots := map[string]CommandSlot{}
+
+	for slotName, value := range values {
+		optional := slotName[len(slotName)-1] == '?'
+
+		if optional {
+			slotName = slotName[:len(slotName)-1]
+		}
+
+		commandSlots[slotName] = CommandSlot{Name: slotName, Value: value, Optional: optional}
+	}
+
+	return CommandIntent{Name: "TurnOn", Slots: commandSlots}
+}
+
+func slots(nameValues ...string) map[string]alexakit.SimpleSlot {
+	result := map[string]alexakit.SimpleSlot{}
+
+	for i := 0; i+1 < len(nameValues); i += 2 {
+		result[nameValues[i]] = alexakit.SimpleSlot{Name: nameValues[i], Value: nameValues[i+1], Score: ScoreExact}
+	}
+
+	return result
+}
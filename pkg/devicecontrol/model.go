@@ -0,0 +1,399 @@
+package devicecontrol
+
+import (
+	"fmt"
+	"regexp"
+	"smh-apiengine/pkg/alexakit"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const slotTypePrefix = "SMH_"
+
+// builtInIntents are required by the certification, they are handled by alexakit.StandardResponse
+var builtInIntents = []string{
+	alexakit.IntentCancel,
+	alexakit.IntentHelp,
+	alexakit.IntentStop,
+	alexakit.IntentNavigateHome,
+	alexakit.IntentFallback,
+}
+
+// sampleSanitizer removes the characters that are not allowed in the sample utterances
+var sampleSanitizer = regexp.MustCompile(`[^\p{L}' {}]+`)
+
+// ExportInteractionModel creates the alexa interaction model from the intents configuration. Every slot gets the
+// custom type with the values and synonyms of all the intents having the slot with the same name. The sample
+// utterances are derived from the intent name and the names of the commands and scenarios, where the slot values
+// and synonyms are replaced by the slots, e.g. "Turn on TV" becomes "{action} {item}". The model of other language is
+// exported if the locale is set: the localized slot values get the configured value name as the id.
+func (c *Config) ExportInteractionModel(invocationName string, locale string) alexakit.InteractionModel {
+	var model alexakit.InteractionModel
+
+	languageModel := &model.InteractionModel.LanguageModel
+	languageModel.InvocationName = strings.ToLower(invocationName)
+	languageModel.Intents = []alexakit.ModelIntent{}
+	languageModel.Types = c.exportSlotTypes(locale)
+
+	for _, name := range c.sortedIntentNames() {
+		intent := c.Intents[name]
+		modelIntent := alexakit.ModelIntent{Name: name, Samples: c.intentSamples(intent, locale)}
+
+		for _, slotName := range sortedSlotNames(intent.Slots) {
+			modelIntent.Slots = append(modelIntent.Slots, alexakit.ModelSlot{Name: slotName, Type: slotTypeName(slotName)})
+		}
+
+		languageModel.Intents = append(languageModel.Intents, modelIntent)
+	}
+
+	for _, name := range builtInIntents {
+		languageModel.Intents = append(languageModel.Intents, alexakit.ModelIntent{Name: name, Samples: []string{}})
+	}
+
+	return model
+}
+
+// ImportInteractionModel replaces the intents configuration with the custom intents of the model, the slot values
+// and synonyms are taken from the custom slot types. The values with the id are keyed by the id, as the localized
+// model is exported with the configured value as the id. If the locale is set, the names and synonyms of such values
+// are the localized ones and they are merged into the value locales, the configured names and the other locales are
+// kept. Returns the number of imported intents.
+func (c *Config) ImportInteractionModel(model alexakit.InteractionModel, locale string) (int, error) {
+	languageModel := model.InteractionModel.LanguageModel
+	intents := map[string]Intent{}
+
+	c.Lock()
+	existing := c.Intents
+	c.Unlock()
+
+	for _, modelIntent := range languageModel.Intents {
+		if alexakit.IsBuiltIn(modelIntent.Name) {
+			continue
+		}
+
+		intent := Intent{Name: modelIntent.Name, Slots: map[string]Slot{}}
+
+		for _, modelSlot := range modelIntent.Slots {
+			existingSlot := existing[modelIntent.Name].Slots[modelSlot.Name]
+			slot := Slot{Name: modelSlot.Name, Values: map[string]SlotValue{}, Locales: existingSlot.Locales}
+
+			// values of the amazon built-in types (e.g. AMAZON.Room) are not known, any value is accepted by alexa
+			slotType, ok := languageModel.FindType(modelSlot.Type)
+			if !ok && !alexakit.IsBuiltIn(modelSlot.Type) {
+				return 0, fmt.Errorf("slot type %s of %s.%s is not defined", modelSlot.Type, modelIntent.Name,
+					modelSlot.Name)
+			}
+
+			for _, typeValue := range slotType.Values {
+				value := importSlotValue(typeValue, existingSlot.Values, locale)
+				slot.Values[value.Name] = value
+			}
+
+			intent.Slots[modelSlot.Name] = slot
+		}
+
+		intents[intent.Name] = intent
+	}
+
+	if len(intents) == 0 {
+		return 0, fmt.Errorf("the model has no custom intents")
+	}
+
+	c.Lock()
+	c.Intents = intents
+	c.Unlock()
+
+	return len(intents), nil
+}
+
+// importSlotValue creates the slot value from the value of the slot type, merging it with the configured value
+func importSlotValue(typeValue alexakit.SlotTypeValue, existing map[string]SlotValue, locale string) SlotValue {
+	spoken := typeValue.Name.Value
+
+	if typeValue.ID == "" || typeValue.ID == spoken {
+		value := SlotValue{Name: spoken, Synonyms: typeValue.Name.Synonyms}
+
+		if configured, ok := existing[spoken]; ok {
+			value.Locales = configured.Locales
+		}
+
+		return value
+	}
+
+	value, ok := existing[typeValue.ID]
+	if !ok {
+		value = SlotValue{Name: typeValue.ID}
+	}
+
+	// the model of the other language imported without the locale, its names are kept as the synonyms
+	if locale == "" {
+		value.Synonyms = mergeSynonyms(mergeSynonyms(value.Synonyms, []string{spoken}), typeValue.Name.Synonyms)
+
+		return value
+	}
+
+	locales := make(map[string]LocalizedSlotValue, len(value.Locales)+1)
+	key := locale
+
+	for existingKey, localized := range value.Locales {
+		locales[existingKey] = localized
+	}
+
+	for _, localeKey := range alexakit.LocaleKeys(locale) {
+		if _, ok := value.Locales[localeKey]; ok {
+			key = localeKey
+
+			break
+		}
+	}
+
+	locales[key] = LocalizedSlotValue{Name: spoken, Synonyms: typeValue.Name.Synonyms}
+	value.Locales = locales
+
+	return value
+}
+
+func (c *Config) exportSlotTypes(locale string) []alexakit.SlotType {
+	values := map[string]map[string]SlotValue{}
+
+	for _, intent := range c.Intents {
+		for slotName, slot := range intent.Slots {
+			if values[slotName] == nil {
+				values[slotName] = map[string]SlotValue{}
+			}
+
+			for _, value := range slot.Values {
+				merged := values[slotName][value.Name]
+				merged.Name = value.Name
+				merged.Synonyms = mergeSynonyms(merged.Synonyms, value.Synonyms)
+
+				if localized, ok := value.localized(locale); ok {
+					mergedLocalized, _ := merged.localized(locale)
+					mergedLocalized.Name = localized.Name
+					mergedLocalized.Synonyms = mergeSynonyms(mergedLocalized.Synonyms, localized.Synonyms)
+					merged.Locales = map[string]LocalizedSlotValue{locale: mergedLocalized}
+				}
+
+				values[slotName][value.Name] = merged
+			}
+		}
+	}
+
+	slotNames := make([]string, 0, len(values))
+
+	for slotName := range values {
+		slotNames = append(slotNames, slotName)
+	}
+
+	sort.Strings(slotNames)
+
+	slotTypes := []alexakit.SlotType{}
+
+	for _, slotName := range slotNames {
+		slotType := alexakit.SlotType{Name: slotTypeName(slotName), Values: []alexakit.SlotTypeValue{}}
+		valueNames := make([]string, 0, len(values[slotName]))
+
+		for valueName := range values[slotName] {
+			valueNames = append(valueNames, valueName)
+		}
+
+		sort.Strings(valueNames)
+
+		for _, valueName := range valueNames {
+			value := values[slotName][valueName]
+			typeValue := alexakit.NewSlotTypeValue(value.Name, value.Synonyms)
+
+			if localized, ok := value.localized(locale); ok {
+				typeValue = alexakit.NewSlotTypeValue(localized.Name, localized.Synonyms)
+				typeValue.ID = value.Name
+			}
+
+			slotType.Values = append(slotType.Values, typeValue)
+		}
+
+		slotTypes = append(slotTypes, slotType)
+	}
+
+	return slotTypes
+}
+
+// intentSamples returns the unique sample utterances of the intent sorted alphabetically
+func (c *Config) intentSamples(intent Intent, locale string) []string {
+	samples := map[string]bool{}
+	slotNames := sortedSlotNames(intent.Slots)
+	placeholders := make([]string, 0, len(slotNames))
+
+	for _, slotName := range slotNames {
+		placeholders = append(placeholders, "{"+slotName+"}")
+	}
+
+	add := func(name string, intents []CommandIntent) {
+		for _, commandIntent := range intents {
+			if commandIntent.Name == intent.Name {
+				addSample(samples, sampleFromName(name, commandIntent.Slots, intent.Slots, locale))
+			}
+		}
+	}
+
+	for _, command := range c.Commands {
+		add(command.Name, command.Intents)
+	}
+
+	for _, scenario := range c.Scenarios {
+		add(scenario.Name, scenario.Intents)
+	}
+
+	// the intent without the commands still needs a sample, e.g. "TurnOnIntent" gives "turn on {item}"
+	if len(samples) == 0 {
+		addSample(samples, splitIntentName(intent.Name)+" "+strings.Join(placeholders, " "))
+	}
+
+	result := make([]string, 0, len(samples))
+
+	for sample := range samples {
+		result = append(result, sample)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// sampleFromName replaces the slot values and synonyms in the command name with the slots, the sample is not
+// created if some of the command slots are not mentioned in the name
+func sampleFromName(name string, commandSlots map[string]CommandSlot, slots map[string]Slot, locale string) string {
+	sample := " " + strings.ToLower(name) + " "
+
+	for _, slotName := range sortedCommandSlotNames(commandSlots) {
+		value := commandSlots[slotName].Value
+		phrases := []string{value}
+
+		if slotValue, ok := slots[slotName].Values[value]; ok {
+			phrases = append(phrases, slotValue.Synonyms...)
+
+			if localized, ok := slotValue.localized(locale); ok {
+				phrases = append(phrases, localized.Name)
+				phrases = append(phrases, localized.Synonyms...)
+			}
+		}
+
+		// the longest phrases first, so "turn on" is replaced before "on"
+		sort.Slice(phrases, func(i, j int) bool {
+			return len(phrases[i]) > len(phrases[j])
+		})
+
+		replaced := false
+
+		for _, phrase := range phrases {
+			phrase = " " + strings.ToLower(strings.TrimSpace(phrase)) + " "
+
+			if phrase != "  " && strings.Contains(sample, phrase) {
+				sample = strings.Replace(sample, phrase, " {"+slotName+"} ", 1)
+				replaced = true
+
+				break
+			}
+		}
+
+		if !replaced {
+			return ""
+		}
+	}
+
+	return strings.TrimSpace(sample)
+}
+
+// addSample adds the sample if it has only the allowed characters, e.g. the numbers have to be spelled out
+func addSample(samples map[string]bool, sample string) {
+	if sample == "" || sampleSanitizer.MatchString(sample) {
+		return
+	}
+
+	samples[strings.Join(strings.Fields(sample), " ")] = true
+}
+
+// splitIntentName converts the intent name to words, e.g. "TurnOnIntent" to "turn on"
+func splitIntentName(name string) string {
+	name = strings.TrimSuffix(name, "Intent")
+
+	var words []string
+	var word []rune
+
+	for _, r := range name {
+		if (unicode.IsUpper(r) || r == '_') && len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+
+		if r != '_' {
+			word = append(word, unicode.ToLower(r))
+		}
+	}
+
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+
+	return strings.Join(words, " ")
+}
+
+func slotTypeName(slotName string) string {
+	return slotTypePrefix + strings.ToUpper(slotName)
+}
+
+func mergeSynonyms(synonyms []string, more []string) []string {
+	for _, synonym := range more {
+		found := false
+
+		for _, existing := range synonyms {
+			if strings.EqualFold(existing, synonym) {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			synonyms = append(synonyms, synonym)
+		}
+	}
+
+	return synonyms
+}
+
+func (c *Config) sortedIntentNames() []string {
+	names := make([]string, 0, len(c.Intents))
+
+	for name := range c.Intents {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func sortedSlotNames(slots map[string]Slot) []string {
+	names := make([]string, 0, len(slots))
+
+	for name := range slots {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func sortedCommandSlotNames(slots map[string]CommandSlot) []string {
+	names := make([]string, 0, len(slots))
+
+	for name := range slots {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,100 @@
+package devicecontrol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_InteractionModel_ExportImport(t *testing.T) {
+	intents := map[string]Intent{"TurnOnIntent": {Name: "TurnOnIntent", Slots: map[string]Slot{
+		"action": {Name: "action", Values: map[string]SlotValue{
+			"on":  {Name: "on", Synonyms: []string{"turn on", "switch on"}},
+			"off": {Name: "off", Synonyms: []string{"turn off"}}}},
+		"item": {Name: "item", Values: map[string]SlotValue{
+			"tv":    {Name: "tv"},
+			"light": {Name: "light", Synonyms: []string{"lamp"}}}},
+	}}}
+	config := Config{
+		Intents: intents,
+		Commands: map[string]Command{
+			"1": dialogTestCommand("Turn on TV", map[string]string{"action": "on", "item": "tv"}),
+			"2": dialogTestCommand("Switch on the kitchen lamp", map[string]string{"action": "on", "item": "light"}),
+			"3": dialogTestCommand("Channel 5", map[string]string{"action": "on", "item": "tv"}),
+		},
+	}
+
+	for id, command := range config.Commands {
+		command.Intents[0].Name = "TurnOnIntent"
+		config.Commands[id] = command
+	}
+
+	model := config.ExportInteractionModel("Smart Home", "")
+	languageModel := model.InteractionModel.LanguageModel
+
+	assert.Equal(t, "smart home", languageModel.InvocationName)
+	assert.Equal(t, "TurnOnIntent", languageModel.Intents[0].Name)
+	assert.Equal(t, []string{"{action} the kitchen {item}", "{action} {item}"}, languageModel.Intents[0].Samples)
+	assert.Len(t, languageModel.Intents, 1+len(builtInIntents))
+	assert.Equal(t, "SMH_ITEM", languageModel.Types[1].Name)
+	assert.Equal(t, "light", languageModel.Types[1].Values[0].Name.Value)
+	assert.Equal(t, []string{"lamp"}, languageModel.Types[1].Values[0].Name.Synonyms)
+
+	imported := Config{}
+	count, err := imported.ImportInteractionModel(model, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, intents, imported.Intents)
+
+	// the localized model keeps the configured values and the other languages
+	localizedIntents := func() map[string]Intent {
+		return map[string]Intent{"TurnOnIntent": {Name: "TurnOnIntent", Slots: map[string]Slot{
+			"item": {Name: "item", Locales: map[string]string{"de": "Gerät"}, Values: map[string]SlotValue{
+				"tv": {Name: "tv"},
+				"light": {Name: "light", Synonyms: []string{"lamp"}, Locales: map[string]LocalizedSlotValue{
+					"de": {Name: "licht", Synonyms: []string{"lampe"}},
+					"fr": {Name: "lumière"}}}}},
+		}}}
+	}
+	config = Config{Intents: localizedIntents(), Commands: map[string]Command{
+		"1": {Name: "Licht an", Intents: []CommandIntent{{Name: "TurnOnIntent", Slots: map[string]CommandSlot{
+			"item": {Name: "item", Value: "light"}}}}}}}
+
+	model = config.ExportInteractionModel("smart home", "de-DE")
+	assert.Equal(t, "light", model.InteractionModel.LanguageModel.Types[0].Values[0].ID)
+
+	count, err = config.ImportInteractionModel(model, "de-DE")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, localizedIntents(), config.Intents)
+
+	// the values of the other language are added to the locales of the new values
+	imported = Config{}
+	_, err = imported.ImportInteractionModel(model, "de-DE")
+	assert.Nil(t, err)
+	assert.Equal(t, SlotValue{Name: "light", Locales: map[string]LocalizedSlotValue{
+		"de-DE": {Name: "licht", Synonyms: []string{"lampe"}}}}, imported.Intents["TurnOnIntent"].Slots["item"].Values["light"])
+	assert.Equal(t, SlotValue{Name: "tv"}, imported.Intents["TurnOnIntent"].Slots["item"].Values["tv"])
+}
+
+func Test_InteractionModel_ExportLocale(t *testing.T) {
+	config := Config{
+		Intents: map[string]Intent{"TurnOn": {Name: "TurnOn", Slots: map[string]Slot{
+			"item": {Name: "item", Values: map[string]SlotValue{
+				"tv": {Name: "tv"},
+				"light": {Name: "light", Synonyms: []string{"lamp"}, Locales: map[string]LocalizedSlotValue{
+					"de": {Name: "licht", Synonyms: []string{"lampe"}}}}}},
+		}}},
+		Commands: map[string]Command{
+			"1": dialogTestCommand("Licht an", map[string]string{"item": "light"}),
+		},
+	}
+
+	languageModel := config.ExportInteractionModel("smart home", "de-DE").InteractionModel.LanguageModel
+
+	assert.Equal(t, []string{"{item} an"}, languageModel.Intents[0].Samples)
+	assert.Equal(t, "light", languageModel.Types[0].Values[0].ID)
+	assert.Equal(t, "licht", languageModel.Types[0].Values[0].Name.Value)
+	assert.Equal(t, []string{"lampe"}, languageModel.Types[0].Values[0].Name.Synonyms)
+	assert.Equal(t, "tv", languageModel.Types[0].Values[1].Name.Value)
+}
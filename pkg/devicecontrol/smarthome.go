@@ -0,0 +1,225 @@
+package devicecontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/logging"
+	"sort"
+	"strings"
+)
+
+const (
+	smartHomeManufacturer = "Smart Home API Engine"
+	// endpointPrefixDevice distinguishes the power switch devices from the control items, which use uuid as id
+	endpointPrefixDevice = "device-"
+)
+
+// displayCategories maps the control item icons to the alexa display categories
+var displayCategories = map[string]string{
+	"light":   alexakit.DisplayCategoryLight,
+	"lamp":    alexakit.DisplayCategoryLight,
+	"tv":      alexakit.DisplayCategoryTV,
+	"speaker": alexakit.DisplayCategorySpeaker,
+	"music":   alexakit.DisplayCategorySpeaker,
+	"volume":  alexakit.DisplayCategorySpeaker,
+	"fan":     alexakit.DisplayCategoryFan,
+	"socket":  alexakit.DisplayCategorySmartPlug,
+	"plug":    alexakit.DisplayCategorySmartPlug,
+}
+
+// HandleSmartHomeDirective handles the smart home skill api directive: the discovery returns the control items
+// having "on" and "off" states and the power switch devices, the power controller directives execute the item states
+// or switch the devices and the state report returns the last executed item state or the power state of the device.
+func (deviceControl *DeviceControl) HandleSmartHomeDirective(ctx context.Context,
+	request alexakit.SmartHomeRequest) alexakit.SmartHomeResponse {
+	header := request.Directive.Header
+
+	switch {
+	case header.Namespace == alexakit.NamespaceDiscovery && header.Name == alexakit.DirectiveDiscover:
+		return alexakit.NewDiscoveryResponse(request, deviceControl.SmartHomeEndpoints())
+	case header.Namespace == alexakit.NamespaceAuthorization && header.Name == alexakit.DirectiveAcceptGrant:
+		return alexakit.NewAcceptGrantResponse(request)
+	case header.Namespace == alexakit.NamespacePowerController &&
+		(header.Name == alexakit.DirectiveTurnOn || header.Name == alexakit.DirectiveTurnOff):
+		return deviceControl.smartHomePower(ctx, request, header.Name == alexakit.DirectiveTurnOn)
+	case header.Namespace == alexakit.NamespaceAlexa && header.Name == alexakit.DirectiveReportState:
+		return deviceControl.smartHomeStateReport(ctx, request)
+	}
+
+	return alexakit.NewSmartHomeErrorResponse(request, alexakit.ErrorTypeInvalidDirective,
+		fmt.Sprintf("directive %s.%s is not supported", header.Namespace, header.Name))
+}
+
+// SmartHomeEndpoints returns the endpoints discovered by alexa sorted by id. The item name is used as the friendly
+// name unless several items have the same name, then it is prefixed with the control name, e.g. "TV Power".
+func (deviceControl *DeviceControl) SmartHomeEndpoints() []alexakit.DiscoveryEndpoint {
+	var endpoints []alexakit.DiscoveryEndpoint
+
+	names := map[string]int{}
+
+	for _, control := range deviceControl.config.Controls {
+		for _, item := range control.Items {
+			if item.SupportsPower() {
+				names[strings.ToLower(item.Name)]++
+			}
+		}
+	}
+
+	for _, control := range deviceControl.config.Controls {
+		for _, item := range control.Items {
+			if !item.SupportsPower() {
+				continue
+			}
+
+			friendlyName := item.Name
+
+			if names[strings.ToLower(item.Name)] > 1 {
+				friendlyName = control.Name + " " + item.Name
+			}
+
+			endpoints = append(endpoints, alexakit.DiscoveryEndpoint{
+				EndpointID:        item.ID,
+				ManufacturerName:  smartHomeManufacturer,
+				FriendlyName:      friendlyName,
+				Description:       fmt.Sprintf("%s of %s control", item.Name, control.Name),
+				DisplayCategories: []string{displayCategory(item.Icon, alexakit.DisplayCategorySwitch)},
+				Capabilities:      alexakit.NewPowerCapabilities(),
+			})
+		}
+	}
+
+	for mac, device := range deviceControl.config.Devices {
+		if !device.SupportsPowerSwitch() || !device.Enabled {
+			continue
+		}
+
+		endpoints = append(endpoints, alexakit.DiscoveryEndpoint{
+			EndpointID:        endpointPrefixDevice + mac,
+			ManufacturerName:  smartHomeManufacturer,
+			FriendlyName:      device.Name,
+			Description:       "Broadlink power switch",
+			DisplayCategories: []string{alexakit.DisplayCategorySmartPlug},
+			Capabilities:      alexakit.NewPowerCapabilities(),
+		})
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].EndpointID < endpoints[j].EndpointID
+	})
+
+	return endpoints
+}
+
+func (deviceControl *DeviceControl) smartHomePower(ctx context.Context, request alexakit.SmartHomeRequest,
+	on bool) alexakit.SmartHomeResponse {
+	state, powerState := StateOff, powerStateName(on)
+
+	if on {
+		state = StateOn
+	}
+
+	endpointID := smartHomeEndpointID(request)
+
+	if mac := strings.TrimPrefix(endpointID, endpointPrefixDevice); mac != endpointID {
+		device, err := deviceControl.config.findDeviceByMac(mac)
+		if err != nil || !device.SupportsPowerSwitch() {
+			return noSuchEndpoint(request, endpointID)
+		}
+
+		err = deviceControl.SwitchPower(ctx, device, on)
+		if err != nil {
+			return smartHomeExecError(request, err)
+		}
+
+		return alexakit.NewStateResponse(request, alexakit.EventResponse, powerState, true)
+	}
+
+	item := deviceControl.config.FindControlItemByID(endpointID)
+	if item == nil || !item.SupportsPower() {
+		return noSuchEndpoint(request, endpointID)
+	}
+
+	err := deviceControl.ExecControlItem(ctx, item, state)
+	if err != nil {
+		return smartHomeExecError(request, err)
+	}
+
+	return alexakit.NewStateResponse(request, alexakit.EventResponse, powerState, true)
+}
+
+func (deviceControl *DeviceControl) smartHomeStateReport(ctx context.Context,
+	request alexakit.SmartHomeRequest) alexakit.SmartHomeResponse {
+	endpointID := smartHomeEndpointID(request)
+
+	if mac := strings.TrimPrefix(endpointID, endpointPrefixDevice); mac != endpointID {
+		device, err := deviceControl.config.findDeviceByMac(mac)
+		if err != nil || !device.SupportsPowerSwitch() {
+			return noSuchEndpoint(request, endpointID)
+		}
+
+		on, err := deviceControl.broadlink.GetPowerState(device.Mac)
+		if err != nil {
+			logging.WithContext(ctx).WithError(err).Warnf("Failed to get the power state of %s", device.Name)
+
+			return alexakit.NewStateResponse(request, alexakit.EventStateReport, "", false)
+		}
+
+		return alexakit.NewStateResponse(request, alexakit.EventStateReport, powerStateName(on), true)
+	}
+
+	item := deviceControl.config.FindControlItemByID(endpointID)
+	if item == nil || !item.SupportsPower() {
+		return noSuchEndpoint(request, endpointID)
+	}
+
+	powerState := ""
+
+	switch deviceControl.controlItemState(item) {
+	case StateOn:
+		powerState = alexakit.PowerStateOn
+	case StateOff:
+		powerState = alexakit.PowerStateOff
+	}
+
+	return alexakit.NewStateResponse(request, alexakit.EventStateReport, powerState, true)
+}
+
+// smartHomeExecError returns the error response of the failed execution, the skipped one is reported as busy
+func smartHomeExecError(request alexakit.SmartHomeRequest, err error) alexakit.SmartHomeResponse {
+	if errors.Is(err, ErrDeviceControlBusy) {
+		return alexakit.NewSmartHomeErrorResponse(request, alexakit.ErrorTypeEndpointBusy, err.Error())
+	}
+
+	return alexakit.NewSmartHomeErrorResponse(request, alexakit.ErrorTypeEndpointUnreachable, err.Error())
+}
+
+func displayCategory(icon string, fallback string) string {
+	if category, ok := displayCategories[strings.ToLower(icon)]; ok {
+		return category
+	}
+
+	return fallback
+}
+
+func powerStateName(on bool) string {
+	if on {
+		return alexakit.PowerStateOn
+	}
+
+	return alexakit.PowerStateOff
+}
+
+func smartHomeEndpointID(request alexakit.SmartHomeRequest) string {
+	if request.Directive.Endpoint == nil {
+		return ""
+	}
+
+	return request.Directive.Endpoint.EndpointID
+}
+
+func noSuchEndpoint(request alexakit.SmartHomeRequest, endpointID string) alexakit.SmartHomeResponse {
+	return alexakit.NewSmartHomeErrorResponse(request, alexakit.ErrorTypeNoSuchEndpoint,
+		fmt.Sprintf("endpoint %s not found", endpointID))
+}
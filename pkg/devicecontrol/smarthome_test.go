@@ -0,0 +1,86 @@
+package devicecontrol
+
+import (
+	"context"
+	"encoding/json"
+	"smh-apiengine/pkg/alexakit"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_HandleSmartHomeDirective(t *testing.T) {
+	tvPower := &ControlItem{ID: "b-tv-power", Name: "Power", Icon: "tv", StateEntities: []Entity{
+		{State: StateOn, Type: ElementTypeCommand}, {State: StateOff, Type: ElementTypeCommand}}}
+	audioPower := &ControlItem{ID: "a-audio-power", Name: "Power", StateEntities: []Entity{
+		{State: StateOn, Type: ElementTypeCommand}, {State: StateOff, Type: ElementTypeCommand}}}
+	volumeUp := &ControlItem{ID: "c-volume-up", Name: "Volume up", StateEntities: []Entity{
+		{State: StateNA, Type: ElementTypeCommand}}}
+
+	deviceControl := NewDeviceControl(&Config{Controls: map[string]Control{
+		"tv":    {ID: "tv", Name: "TV", Items: map[string]*ControlItem{tvPower.ID: tvPower, volumeUp.ID: volumeUp}},
+		"audio": {ID: "audio", Name: "Audio", Items: map[string]*ControlItem{audioPower.ID: audioPower}},
+	}})
+
+	response := deviceControl.HandleSmartHomeDirective(context.Background(),
+		smartHomeTestRequest(alexakit.NamespaceDiscovery, alexakit.DirectiveDiscover, ""))
+	endpoints := deviceControl.SmartHomeEndpoints()
+
+	assert.Equal(t, alexakit.EventDiscoverResponse, response.Event.Header.Name)
+	assert.Len(t, endpoints, 2)
+	assert.Equal(t, "Audio Power", endpoints[0].FriendlyName)
+	assert.Equal(t, []string{alexakit.DisplayCategorySwitch}, endpoints[0].DisplayCategories)
+	assert.Equal(t, "TV Power", endpoints[1].FriendlyName)
+	assert.Equal(t, []string{alexakit.DisplayCategoryTV}, endpoints[1].DisplayCategories)
+
+	tvPower.activeState = StateOn
+	response = deviceControl.HandleSmartHomeDirective(context.Background(),
+		smartHomeTestRequest(alexakit.NamespaceAlexa, alexakit.DirectiveReportState, tvPower.ID))
+
+	assert.Equal(t, alexakit.EventStateReport, response.Event.Header.Name)
+	assert.Equal(t, "token", response.Event.Header.CorrelationToken)
+	assert.Equal(t, alexakit.PowerStateOn, response.Context.Properties[1].Value)
+
+	response = deviceControl.HandleSmartHomeDirective(context.Background(),
+		smartHomeTestRequest(alexakit.NamespacePowerController, alexakit.DirectiveTurnOn, volumeUp.ID))
+
+	assert.Equal(t, alexakit.EventErrorResponse, response.Event.Header.Name)
+	content, err := json.Marshal(response.Event.Payload)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), alexakit.ErrorTypeNoSuchEndpoint)
+}
+
+func Test_HandleSmartHomeDirective_Busy(t *testing.T) {
+	tvPower := &ControlItem{ID: "tv-power", Name: "Power", StateEntities: []Entity{
+		{State: StateOn, Type: ElementTypeCommand, Target: "tv-on"},
+		{State: StateOff, Type: ElementTypeCommand, Target: "tv-off"}}}
+
+	deviceControl := NewDeviceControl(&Config{
+		Commands: map[string]Command{"tv-on": {ID: "tv-on"}, "tv-off": {ID: "tv-off"}},
+		Controls: map[string]Control{"tv": {ID: "tv", Name: "TV", Items: map[string]*ControlItem{tvPower.ID: tvPower}}},
+	})
+
+	// another command is running, so the power is not switched
+	deviceControl.lock.Lock()
+	defer deviceControl.lock.Unlock()
+
+	response := deviceControl.HandleSmartHomeDirective(context.Background(),
+		smartHomeTestRequest(alexakit.NamespacePowerController, alexakit.DirectiveTurnOn, tvPower.ID))
+
+	assert.Equal(t, alexakit.EventErrorResponse, response.Event.Header.Name)
+	content, err := json.Marshal(response.Event.Payload)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), alexakit.ErrorTypeEndpointBusy)
+	assert.Empty(t, deviceControl.ControlItemStates())
+}
+
+func smartHomeTestRequest(namespace string, name string, endpointID string) alexakit.SmartHomeRequest {
+	request := alexakit.SmartHomeRequest{Directive: alexakit.SmartHomeDirective{Header: alexakit.SmartHomeHeader{
+		Namespace: namespace, Name: name, PayloadVersion: "3", MessageID: "1", CorrelationToken: "token"}}}
+
+	if endpointID != "" {
+		request.Directive.Endpoint = &alexakit.SmartHomeEndpoint{EndpointID: endpointID}
+	}
+
+	return request
+}
@@ -0,0 +1,108 @@
+package devicecontrol
+
+import (
+	"sync"
+	"time"
+)
+
+// DeviceStatus struct represents the reachability of the device based on the last command executed on it
+type DeviceStatus struct {
+	Name      string     `json:"name"`
+	Enabled   bool       `json:"enabled"`
+	Known     bool       `json:"known"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// Reachable returns true if the device is known and the last command executed on it did not fail
+func (ds DeviceStatus) Reachable() bool {
+	return ds.Known && ds.LastError == ""
+}
+
+type deviceOutcome struct {
+	lastSeen  time.Time
+	lastError error
+}
+
+type deviceStatus struct {
+	outcomes map[string]deviceOutcome
+	mu       sync.RWMutex
+}
+
+func newDeviceStatus() *deviceStatus {
+	return &deviceStatus{outcomes: make(map[string]deviceOutcome)}
+}
+
+// update records the outcome of the command executed on the device, returns true if the device became unreachable or
+// reachable again. The device without any outcome is considered reachable.
+func (ds *deviceStatus) update(mac string, err error) bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	outcome := ds.outcomes[mac]
+	changed := (outcome.lastError == nil) != (err == nil)
+	outcome.lastError = err
+
+	if err == nil {
+		outcome.lastSeen = time.Now()
+	}
+
+	ds.outcomes[mac] = outcome
+
+	return changed
+}
+
+func (ds *deviceStatus) get(mac string) (deviceOutcome, bool) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	outcome, ok := ds.outcomes[mac]
+
+	return outcome, ok
+}
+
+// DevicesStatus returns the status of all configured devices keyed by mac address
+func (deviceControl *DeviceControl) DevicesStatus() map[string]DeviceStatus {
+	statuses := make(map[string]DeviceStatus)
+
+	for mac, device := range deviceControl.config.Devices {
+		_, err := deviceControl.broadlink.GetDeviceInfo(mac)
+		status := DeviceStatus{
+			Name:    device.Name,
+			Enabled: device.Enabled,
+			Known:   err == nil,
+		}
+
+		if outcome, ok := deviceControl.status.get(mac); ok {
+			if !outcome.lastSeen.IsZero() {
+				lastSeen := outcome.lastSeen
+				status.LastSeen = &lastSeen
+			}
+
+			if outcome.lastError != nil {
+				status.LastError = outcome.lastError.Error()
+			}
+		}
+
+		statuses[mac] = status
+	}
+
+	return statuses
+}
+
+// Ready returns true if the configuration with devices is loaded and all enabled devices are reachable
+func (deviceControl *DeviceControl) Ready() (bool, map[string]DeviceStatus) {
+	statuses := deviceControl.DevicesStatus()
+
+	if len(statuses) == 0 {
+		return false, statuses
+	}
+
+	for _, status := range statuses {
+		if status.Enabled && !status.Reachable() {
+			return false, statuses
+		}
+	}
+
+	return true, statuses
+}
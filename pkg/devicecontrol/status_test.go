@@ -0,0 +1,21 @@
+package devicecontrol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_deviceStatus_update(t *testing.T) {
+	status := newDeviceStatus()
+	mac := "00:11:22:33:44:55"
+
+	assert.False(t, status.update(mac, nil))
+	assert.True(t, status.update(mac, errors.New("timeout")))
+	assert.False(t, status.update(mac, errors.New("timeout")))
+	assert.True(t, status.update(mac, nil))
+
+	// the first outcome of the unknown device changes the status only if it failed
+	assert.True(t, status.update("66:77:88:99:aa:bb", errors.New("timeout")))
+}
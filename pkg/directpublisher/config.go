@@ -0,0 +1,55 @@
+package directpublisher
+
+import (
+	"os"
+	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/amqp"
+	"smh-apiengine/pkg/broker"
+	"smh-apiengine/pkg/queue"
+
+	"github.com/spf13/cast"
+)
+
+// NewConfigFromEnv returns the broker settings of the publisher configured by the environment variables
+func NewConfigFromEnv() *broker.Config {
+	config := broker.Config{
+		Type: getEnvVar(alexakit.EnvBroker, broker.TypeAMQP),
+		Options: queue.Options{
+			ReplyTimeout: cast.ToDuration(getEnvVar(alexakit.EnvRmqReplyTimeout, alexakit.RmqReplyTimeout.String())),
+			MessageTTL:   cast.ToDuration(getEnvVar(alexakit.EnvRmqMessageTTL, alexakit.RmqMessageTTL.String())),
+		},
+		AMQP: amqp.Config{
+			Host:       getEnvVar(alexakit.EnvRmqHost, alexakit.RmqHost),
+			Port:       cast.ToInt(getEnvVar(alexakit.EnvRmqPort, cast.ToString(alexakit.RmqPort))),
+			Login:      getEnvVar(alexakit.EnvRmqLogin, alexakit.RmqLogin),
+			Password:   getEnvVar(alexakit.EnvRmqPassword, alexakit.RmqPassword),
+			Exchange:   getEnvVar(alexakit.EnvRmqExchange, alexakit.RmqExchange),
+			Queue:      getEnvVar(alexakit.EnvRmqQueue, alexakit.RmqQueue),
+			RoutingKey: getEnvVar(alexakit.EnvRmqRoutingKey, alexakit.RmqRoutingKey),
+			BufferSize: cast.ToInt(getEnvVar(alexakit.EnvRmqBufferSize, cast.ToString(alexakit.RmqBufferSize))),
+			VHost:      os.Getenv(alexakit.EnvRmqVHost),
+			TLS:        cast.ToBool(os.Getenv(alexakit.EnvRmqTLS)),
+			CACert:     os.Getenv(alexakit.EnvRmqCACert),
+			ClientCert: os.Getenv(alexakit.EnvRmqClientCert),
+			ClientKey:  os.Getenv(alexakit.EnvRmqClientKey),
+			Heartbeat:  cast.ToDuration(os.Getenv(alexakit.EnvRmqHeartbeat)),
+		},
+		MQTT: broker.MQTTConfig{
+			Address:  os.Getenv(alexakit.EnvMqttAddress),
+			ClientID: os.Getenv(alexakit.EnvMqttClientID),
+			Username: os.Getenv(alexakit.EnvMqttLogin),
+			Password: os.Getenv(alexakit.EnvMqttPassword),
+			Topic:    os.Getenv(alexakit.EnvMqttTopic),
+		},
+	}
+
+	return &config
+}
+
+func getEnvVar(name string, defaultVal string) string {
+	if value, exists := os.LookupEnv(name); exists {
+		return value
+	}
+
+	return defaultVal
+}
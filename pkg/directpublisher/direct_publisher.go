@@ -1,18 +1,34 @@
 package directpublisher
 
 import (
-	"smh-apiengine/pkg/amqp"
+	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/broker"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 type DirectPublisher struct {
-	rmq *amqp.Rmq
+	publisher broker.Broker
 	router *mux.Router
+	verifier *alexakit.Verifier
+	replyTimeout time.Duration
 }
 
-func NewDirectPublisher(rmqConfig *amqp.Config) *DirectPublisher  {
-	return &DirectPublisher{
-		rmq:amqp.NewRmq(rmqConfig),
-		router:mux.NewRouter()}
+func NewDirectPublisher(brokerConfig *broker.Config, verification alexakit.VerificationConfig) (*DirectPublisher, error)  {
+	publisher, err := broker.Open(brokerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	dp := &DirectPublisher{
+		publisher:publisher,
+		router:mux.NewRouter(),
+		replyTimeout:brokerConfig.ReplyTimeout}
+
+	if verification.Enabled {
+		dp.verifier = alexakit.NewVerifier(verification)
+	}
+
+	return dp, nil
 }
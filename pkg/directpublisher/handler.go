@@ -1,12 +1,12 @@
 package directpublisher
 
 import (
-	"fmt"
+	"encoding/json"
 	"github.com/gorilla/mux"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/logging"
 	"smh-apiengine/pkg/webserver"
 )
 
@@ -17,37 +17,72 @@ func (dp *DirectPublisher) Router() *mux.Router  {
 // Init implements routes webservers
 func (dp *DirectPublisher) InitRoutes()  {
 	headersMiddleware := webserver.HeadersMiddleware{}
-	dp.router.Use(headersMiddleware.Middleware)
+	dp.router.Use(webserver.RequestIDMiddleware, headersMiddleware.Middleware)
 	dp.router.HandleFunc("/alexaproxy", dp.handleAlexaRequest).Methods("POST")
 }
 
 func (dp *DirectPublisher) handleAlexaRequest(w http.ResponseWriter, r *http.Request)  {
-	w.WriteHeader(http.StatusOK)
+	logger := logging.WithContext(r.Context())
 
-	reqBody, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Fatal(err)
+	if dp.verifier != nil {
+		_, err := dp.verifier.VerifyHTTPRequest(r)
+		if err != nil {
+			logger.WithError(err).Warnf("Alexa request verification failed, request from %s rejected", r.RemoteAddr)
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
 	}
 
-	fmt.Println("Recieved payload, pushing to the RMQ...")
+	reqBody, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, alexakit.MaxRequestSize))
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to read the request body")
+		w.WriteHeader(http.StatusBadRequest)
 
-	err = dp.rmq.Publish(string(reqBody))
+		return
+	}
 
+	var alexaRequest alexakit.AlexaRequest
+
+	err = json.Unmarshal(reqBody, &alexaRequest)
 	if err != nil {
-		log.Println(err)
+		logger.WithError(err).Warnf("Failed to parse alexa request")
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	// launch, help, stop etc. do not depend on the home configuration, so they are answered without the RMQ
+	if response, ok := alexakit.StandardResponse(alexaRequest); ok {
+		dp.writeAlexaResponse(w, response)
+
+		return
+	}
+
+	if alexaRequest.Request.Type == alexakit.RequestTypeCanFulfillIntent {
+		dp.writeAlexaResponse(w, alexakit.CanFulfillAnswer(alexaRequest))
 
 		return
 	}
 
-	dp.AlexaTextResponse(w)
+	logger.Infof("Received payload, pushing to the RMQ...")
+
+	response, err := PublishRequest(dp.publisher, string(reqBody), dp.replyTimeout)
+
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to publish the payload")
+	}
+
+	dp.writeAlexaResponse(w, response)
 }
 
-func (dp *DirectPublisher) AlexaTextResponse(w http.ResponseWriter) {
-	alexaResponse := alexakit.NewPlainTextSpeechResponse(alexakit.SpeechTextConfirmation)
+func (dp *DirectPublisher) writeAlexaResponse(w http.ResponseWriter, alexaResponse alexakit.AlexaResponse) {
 	responseJson, err := alexaResponse.ToJson()
 
 	if err != nil {
-		log.Println(err)
+		logging.WithError(err).Errorf("Failed to build the response")
 
 		return
 	}
@@ -55,6 +90,6 @@ func (dp *DirectPublisher) AlexaTextResponse(w http.ResponseWriter) {
 	_, err = w.Write([]byte(responseJson))
 
 	if err != nil {
-		log.Println(err)
+		logging.WithError(err).Errorf("Failed to write the response")
 	}
 }
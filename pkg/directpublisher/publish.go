@@ -0,0 +1,111 @@
+package directpublisher
+
+import (
+	"encoding/json"
+	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/broker"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/queue"
+	"time"
+)
+
+// PublishRequest publishes the alexa request to the broker and waits up to the timeout for the response of the
+// consumer, which reflects the actual outcome of the execution. If the consumer does not reply in time (e.g. the
+// execution takes long or the consumer does not support the replies) the optimistic confirmation is returned. The
+// reply is not requested if the timeout is zero. If the broker is not available the request is buffered by the
+// publisher and confirmed as well. The fallback responses are spoken in the language of the request.
+func PublishRequest(publisher broker.Broker, payload string, timeout time.Duration) (alexakit.AlexaResponse, error) {
+	speech := alexakit.Speech(payloadLocale(payload))
+
+	msg, err := queue.NewEnvelope(queue.TypeAlexaIntent, json.RawMessage(payload))
+	if err != nil {
+		return alexakit.NewTellResponse(speech.Failed), err
+	}
+
+	if timeout <= 0 {
+		return publishOptimistic(publisher, msg, speech)
+	}
+
+	reply, err := publisher.Request(msg, timeout)
+	if err == queue.ErrReplyTimeout {
+		logging.Warnf("No reply received in %s, answering with the confirmation", timeout)
+
+		return alexakit.NewPlainTextSpeechResponse(speech.Confirmation), nil
+	}
+
+	if err != nil {
+		logging.WithError(err).Warnf("Failed to request the reply, publishing without it")
+
+		return publishOptimistic(publisher, msg, speech)
+	}
+
+	var response alexakit.AlexaResponse
+
+	err = json.Unmarshal([]byte(reply), &response)
+	if err != nil || response.Version == "" {
+		logging.Warnf("The reply is not an alexa response, answering with the confirmation")
+
+		return alexakit.NewPlainTextSpeechResponse(speech.Confirmation), nil
+	}
+
+	return response, nil
+}
+
+// publishOptimistic publishes the request without waiting for the reply, the buffered request is confirmed too as
+// it will be executed once the broker is available
+func publishOptimistic(publisher broker.Broker, msg queue.Envelope, speech alexakit.SpeechTexts) (alexakit.AlexaResponse, error) {
+	err := publisher.Publish(msg)
+	if err != nil && err != queue.ErrBuffered {
+		return alexakit.NewTellResponse(speech.Failed), err
+	}
+
+	return alexakit.NewPlainTextSpeechResponse(speech.Confirmation), nil
+}
+
+// PublishSmartHomeDirective publishes the smart home directive to the broker and returns the event replied by the
+// consumer. Unlike the custom skill intents, the directives can not be answered optimistically (e.g. discovery needs
+// the endpoints), so the reply is always awaited and ENDPOINT_UNREACHABLE is returned if it does not arrive in time.
+func PublishSmartHomeDirective(publisher broker.Broker, payload []byte, timeout time.Duration) (json.RawMessage, error) {
+	var request alexakit.SmartHomeRequest
+
+	err := json.Unmarshal(payload, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := queue.NewEnvelope(queue.TypeAlexaIntent, json.RawMessage(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout <= 0 {
+		timeout = alexakit.RmqReplyTimeout
+	}
+
+	reply, err := publisher.Request(msg, timeout)
+	if err == nil && json.Valid([]byte(reply)) {
+		return json.RawMessage(reply), nil
+	}
+
+	if err == nil {
+		logging.Warnf("The reply is not a smart home event")
+	} else if err != queue.ErrReplyTimeout {
+		logging.WithError(err).Errorf("Failed to publish the directive")
+	}
+
+	response := alexakit.NewSmartHomeErrorResponse(request, alexakit.ErrorTypeEndpointUnreachable, "smart home is not available")
+	content, err := json.Marshal(response)
+
+	return content, err
+}
+
+// payloadLocale returns the locale of the serialized alexa request, empty if it can not be parsed
+func payloadLocale(payload string) string {
+	var request alexakit.AlexaRequest
+
+	if json.Unmarshal([]byte(payload), &request) != nil {
+		return ""
+	}
+
+	return request.Request.Locale
+}
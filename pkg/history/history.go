@@ -0,0 +1,410 @@
+// Package history implements the append-only execution history of commands, scenarios, control items and intents
+// with their source, outcome and duration, stored in a JSON lines file.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/logging"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLimit    = 100
+	maxLimit        = 1000
+	compactInterval = time.Hour
+)
+
+// Record struct is a single execution entry of the history
+type Record struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Source     string    `json:"source,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	DeviceID   string    `json:"device_id,omitempty"`
+	State      string    `json:"state,omitempty"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// Retention struct limits the records kept in the history, zero values disable the limits
+type Retention struct {
+	MaxAge     time.Duration
+	MaxRecords int
+}
+
+// Filter struct selects the records returned by Query, empty fields match all the records
+type Filter struct {
+	Type     string
+	ID       string
+	Source   string
+	Actor    string
+	DeviceID string
+	Failed   *bool
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// Store struct appends the history records to the file, which is shared with the other applications (e.g. the
+// runner), so the records are always read from the file. The file is rewritten only when the records exceeding the
+// retention limits are removed. The appends and the rewrites are done holding the lock of the file and the file is
+// reopened for every append, so the records appended by the other applications are not lost when it is rewritten.
+type Store struct {
+	fileName    string
+	retention   Retention
+	count       int
+	size        int64 // size of the file after the last append or rewrite, a different size means other writers
+	oldest      time.Time
+	lastCompact time.Time
+	now         func() time.Time
+	mu          sync.Mutex
+}
+
+// NewStore opens the history file, creating it if it does not exist, and applies the retention limits
+func NewStore(fileName string, retention Retention) (*Store, error) {
+	store := &Store{fileName: fileName, retention: retention, now: time.Now}
+
+	err := store.withLock(store.compact)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// FromEvent creates the record from device control event, returns false for the events that are not executions
+func FromEvent(event devicecontrol.Event) (Record, bool) {
+	switch event.Type {
+	case devicecontrol.EventCommand, devicecontrol.EventScenario, devicecontrol.EventControlItem,
+		devicecontrol.EventIntent:
+	default:
+		return Record{}, false
+	}
+
+	record := Record{
+		Time:       event.Time,
+		Type:       event.Type,
+		ID:         event.ID,
+		Name:       event.Name,
+		Source:     event.Source,
+		Actor:      event.Actor,
+		DeviceID:   event.DeviceID,
+		State:      event.State,
+		Success:    !event.Failed(),
+		DurationMs: event.Duration.Milliseconds(),
+		RequestID:  event.RequestID,
+	}
+
+	if event.Failed() {
+		record.Error = event.Err.Error()
+	}
+
+	return record, true
+}
+
+// Listen records the device control execution events, it is meant to be subscribed to device control
+func (store *Store) Listen(event devicecontrol.Event) {
+	record, ok := FromEvent(event)
+	if !ok {
+		return
+	}
+
+	err := store.Append(record)
+	if err != nil {
+		logging.WithError(err).Errorf("Failed to append the history record")
+	}
+}
+
+// Append adds the record to the history
+func (store *Store) Append(record Record) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return store.withLock(func() error {
+		err := store.refresh()
+		if err != nil {
+			return err
+		}
+
+		file, err := os.OpenFile(store.fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+
+		_, err = file.Write(append(line, '\n'))
+
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			return err
+		}
+
+		store.count++
+		store.size += int64(len(line) + 1)
+
+		if store.oldest.IsZero() {
+			store.oldest = record.Time
+		}
+
+		if store.exceedsRetention() {
+			return store.compact()
+		}
+
+		return nil
+	})
+}
+
+// Query returns the records matching the filter, newest first
+func (store *Store) Query(filter Filter) ([]Record, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	records, err := store.load()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	oldest := store.oldestAllowed()
+	result := []Record{}
+
+	for i := len(records) - 1; i >= 0 && len(result) < limit; i-- {
+		record := records[i]
+
+		if record.Time.Before(oldest) {
+			break
+		}
+
+		if filter.matches(record) {
+			result = append(result, record)
+		}
+	}
+
+	return result, nil
+}
+
+func (filter Filter) matches(record Record) bool {
+	switch {
+	case filter.Type != "" && filter.Type != record.Type,
+		filter.ID != "" && filter.ID != record.ID,
+		filter.Source != "" && filter.Source != record.Source,
+		filter.Actor != "" && filter.Actor != record.Actor,
+		filter.DeviceID != "" && filter.DeviceID != record.DeviceID,
+		filter.Failed != nil && *filter.Failed == record.Success,
+		!filter.Since.IsZero() && record.Time.Before(filter.Since),
+		!filter.Until.IsZero() && record.Time.After(filter.Until):
+		return false
+	}
+
+	return true
+}
+
+func (store *Store) load() ([]Record, error) {
+	file, err := os.Open(store.fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var records []Record
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+
+		var record Record
+
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			logging.WithError(err).Warnf("Skipping malformed history record at %s:%d", store.fileName, lineNum)
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// exceedsRetention checks whether the records should be compacted. To avoid rewriting the file on every append the
+// max records limit has 10% slack and the max age is checked once per compact interval.
+func (store *Store) exceedsRetention() bool {
+	if store.retention.MaxRecords > 0 && store.count > store.retention.MaxRecords+store.retention.MaxRecords/10 {
+		return true
+	}
+
+	if store.retention.MaxAge > 0 && store.now().Sub(store.lastCompact) > compactInterval {
+		return store.oldest.Before(store.oldestAllowed())
+	}
+
+	return false
+}
+
+func (store *Store) oldestAllowed() time.Time {
+	if store.retention.MaxAge <= 0 {
+		return time.Time{}
+	}
+
+	return store.now().Add(-store.retention.MaxAge)
+}
+
+// compact removes the records exceeding the retention limits. The file is rewritten only if there are records to
+// remove, the new file is written first and renamed, so the history is not lost if the rewrite fails.
+func (store *Store) compact() error {
+	records, err := store.load()
+	if err != nil {
+		return err
+	}
+
+	oldest := store.oldestAllowed()
+	first := 0
+
+	for first < len(records) && records[first].Time.Before(oldest) {
+		first++
+	}
+
+	if store.retention.MaxRecords > 0 && len(records)-first > store.retention.MaxRecords {
+		first = len(records) - store.retention.MaxRecords
+	}
+
+	records = records[first:]
+	store.count = len(records)
+	store.oldest = time.Time{}
+	store.lastCompact = store.now()
+
+	if len(records) > 0 {
+		store.oldest = records[0].Time
+	}
+
+	if first > 0 {
+		err = store.rewrite(records)
+		if err != nil {
+			return err
+		}
+	}
+
+	store.size, err = fileSize(store.fileName)
+
+	return err
+}
+
+// refresh reloads the record count and the oldest record if the file was changed by the other writers since the last
+// append or rewrite, so the retention is applied to all the records
+func (store *Store) refresh() error {
+	size, err := fileSize(store.fileName)
+	if err != nil || size == store.size {
+		return err
+	}
+
+	records, err := store.load()
+	if err != nil {
+		return err
+	}
+
+	store.count = len(records)
+	store.size = size
+	store.oldest = time.Time{}
+
+	if len(records) > 0 {
+		store.oldest = records[0].Time
+	}
+
+	return nil
+}
+
+// withLock runs the function holding the exclusive lock of the history file. The lock is taken on the separate lock
+// file, as the history file itself is replaced when it is rewritten.
+func (store *Store) withLock(fn func() error) error {
+	lock, err := os.OpenFile(store.fileName+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer lock.Close()
+
+	err = lockFile(lock)
+	if err != nil {
+		return fmt.Errorf("failed to lock the history file: %w", err)
+	}
+
+	defer unlockFile(lock)
+
+	return fn()
+}
+
+func fileSize(fileName string) (int64, error) {
+	info, err := os.Stat(fileName)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+func (store *Store) rewrite(records []Record) error {
+	tmpFileName := store.fileName + ".tmp"
+
+	tmpFile, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+
+	for _, record := range records {
+		err = encoder.Encode(record)
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = writer.Flush()
+	}
+
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFileName, store.fileName)
+}
@@ -0,0 +1,119 @@
+package history
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"smh-apiengine/pkg/devicecontrol"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Store_RecordsAndQueriesEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smh-history")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "history.jsonl")
+	store, err := NewStore(fileName, Retention{})
+	assert.Nil(t, err)
+
+	now := time.Now()
+	store.Listen(devicecontrol.Event{Type: devicecontrol.EventDiscover, Time: now})
+	store.Listen(devicecontrol.Event{Type: devicecontrol.EventCommand, ID: "tv-on", DeviceID: "aa:bb",
+		Source: devicecontrol.SourceHTTP, Actor: "tablet", Duration: 120 * time.Millisecond, Time: now})
+	store.Listen(devicecontrol.Event{Type: devicecontrol.EventScenario, ID: "movie", Err: errors.New("timeout"),
+		Source: devicecontrol.SourceAlexa, Time: now.Add(time.Second)})
+
+	loaded, err := NewStore(fileName, Retention{})
+	assert.Nil(t, err)
+
+	records, err := loaded.Query(Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "movie", records[0].ID)
+	assert.Equal(t, "timeout", records[0].Error)
+	assert.Equal(t, "tv-on", records[1].ID)
+	assert.Equal(t, int64(120), records[1].DurationMs)
+
+	failed := true
+	assertQueryLen(t, loaded, Filter{Failed: &failed}, 1)
+	assertQueryLen(t, loaded, Filter{Actor: "tablet", DeviceID: "aa:bb"}, 1)
+	assertQueryLen(t, loaded, Filter{Source: devicecontrol.SourceRMQ}, 0)
+	assertQueryLen(t, loaded, Filter{Since: now.Add(time.Millisecond)}, 1)
+}
+
+func Test_Store_AppliesRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smh-history")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "history.jsonl")
+	store, err := NewStore(fileName, Retention{MaxRecords: 10})
+	assert.Nil(t, err)
+
+	for i := 0; i < 12; i++ {
+		assert.Nil(t, store.Append(Record{Type: devicecontrol.EventCommand, Time: time.Now()}))
+	}
+
+	assertQueryLen(t, store, Filter{}, 10)
+
+	store, err = NewStore(fileName, Retention{MaxAge: time.Hour})
+	assert.Nil(t, err)
+	assertQueryLen(t, store, Filter{}, 10)
+
+	store.now = func() time.Time {
+		return time.Now().Add(2 * time.Hour)
+	}
+
+	assertQueryLen(t, store, Filter{}, 0)
+	assert.Nil(t, store.Append(Record{Type: devicecontrol.EventCommand, Time: store.now()}))
+	assert.Equal(t, 1, store.count)
+
+	contents, err := ioutil.ReadFile(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(contents), "\n"))
+}
+
+func Test_Store_SharedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smh-history")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the runner store appends to the file compacted by the webserver store
+	fileName := filepath.Join(dir, "history.jsonl")
+	runner, err := NewStore(fileName, Retention{})
+	assert.Nil(t, err)
+
+	server, err := NewStore(fileName, Retention{MaxRecords: 10})
+	assert.Nil(t, err)
+
+	for i := 0; i < 8; i++ {
+		assert.Nil(t, runner.Append(Record{Type: devicecontrol.EventCommand, ID: "runner", Time: time.Now()}))
+	}
+
+	// the records of the runner are counted, so the file is compacted
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, server.Append(Record{Type: devicecontrol.EventCommand, ID: "server", Time: time.Now()}))
+	}
+
+	assert.Equal(t, 10, server.count)
+
+	// the runner keeps appending to the rewritten file
+	assert.Nil(t, runner.Append(Record{Type: devicecontrol.EventScenario, ID: "runner", Time: time.Now()}))
+
+	records, err := server.Query(Filter{})
+	assert.Nil(t, err)
+	assert.Len(t, records, 11)
+	assert.Equal(t, devicecontrol.EventScenario, records[0].Type)
+}
+
+func assertQueryLen(t *testing.T, store *Store, filter Filter, expected int) {
+	records, err := store.Query(filter)
+	assert.Nil(t, err)
+	assert.Len(t, records, expected)
+}
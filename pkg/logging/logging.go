@@ -0,0 +1,289 @@
+// Package logging implements levelled logging in text or JSON format with request ids correlation and log file
+// rotation, shared by all the applications
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+const (
+	FieldRequestID = "request_id"
+	FieldError     = "error"
+	timeFormat     = "2006-01-02T15:04:05.000Z07:00"
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+type ctxKey struct{}
+
+// Fields are the key value pairs added to the log entry
+type Fields map[string]interface{}
+
+// Logger struct writes the log entries with level not lower than configured one
+type Logger struct {
+	level Level
+	json  bool
+	out   io.Writer
+	mu    sync.Mutex
+}
+
+// Entry struct is a log entry with fields, created by WithField(s), WithError and WithContext
+type Entry struct {
+	logger *Logger
+	fields Fields
+}
+
+var std = New(os.Stderr, LevelInfo, false)
+
+// New creates a logger writing to provided output
+func New(out io.Writer, level Level, json bool) *Logger {
+	return &Logger{level: level, json: json, out: out}
+}
+
+// ParseLevel converts the level name to Level
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(levelName, name) {
+			return level, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level \"%s\"", name)
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// Default returns the logger used by the package level functions
+func Default() *Logger {
+	return std
+}
+
+// Enabled returns true if the entries with provided level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) log(level Level, fields Fields, msg string) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var line []byte
+
+	now := time.Now()
+
+	if l.json {
+		line = l.formatJSON(now, level, fields, msg)
+	} else {
+		line = l.formatText(now, level, fields, msg)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, _ = l.out.Write(line)
+}
+
+func (l *Logger) formatText(now time.Time, level Level, fields Fields, msg string) []byte {
+	var b strings.Builder
+
+	b.WriteString(now.Format(timeFormat))
+	b.WriteString(" ")
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteString(" ")
+	b.WriteString(strings.TrimRight(msg, "\n"))
+
+	for _, key := range sortedKeys(fields) {
+		value := fmt.Sprint(fields[key])
+
+		if strings.ContainsAny(value, " \"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+
+		b.WriteString(" ")
+		b.WriteString(key)
+		b.WriteString("=")
+		b.WriteString(value)
+	}
+
+	b.WriteString("\n")
+
+	return []byte(b.String())
+}
+
+func (l *Logger) formatJSON(now time.Time, level Level, fields Fields, msg string) []byte {
+	data := make(map[string]interface{}, len(fields)+3)
+
+	for key, value := range fields {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+
+		data[key] = value
+	}
+
+	data["time"] = now.Format(timeFormat)
+	data["level"] = level.String()
+	data["msg"] = strings.TrimRight(msg, "\n")
+
+	line, err := json.Marshal(data)
+	if err != nil {
+		line = []byte(fmt.Sprintf(`{"level":"error","msg":"failed to marshal log entry: %s"}`, err))
+	}
+
+	return append(line, '\n')
+}
+
+// WithFields creates an entry with provided fields
+func (l *Logger) WithFields(fields Fields) *Entry {
+	entry := &Entry{logger: l, fields: make(Fields, len(fields))}
+
+	for key, value := range fields {
+		entry.fields[key] = value
+	}
+
+	return entry
+}
+
+// WithField returns a copy of the entry with added field
+func (e *Entry) WithField(key string, value interface{}) *Entry {
+	return e.WithFields(Fields{key: value})
+}
+
+// WithFields returns a copy of the entry with added fields
+func (e *Entry) WithFields(fields Fields) *Entry {
+	entry := e.logger.WithFields(e.fields)
+
+	for key, value := range fields {
+		entry.fields[key] = value
+	}
+
+	return entry
+}
+
+// WithError returns a copy of the entry with the error field
+func (e *Entry) WithError(err error) *Entry {
+	return e.WithField(FieldError, err)
+}
+
+// WithContext returns a copy of the entry with the request id from the context if there is one
+func (e *Entry) WithContext(ctx context.Context) *Entry {
+	if id := RequestID(ctx); id != "" {
+		return e.WithField(FieldRequestID, id)
+	}
+
+	return e.WithFields(nil)
+}
+
+func (e *Entry) Debugf(format string, args ...interface{}) {
+	e.logger.log(LevelDebug, e.fields, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Infof(format string, args ...interface{}) {
+	e.logger.log(LevelInfo, e.fields, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Warnf(format string, args ...interface{}) {
+	e.logger.log(LevelWarn, e.fields, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	e.logger.log(LevelError, e.fields, fmt.Sprintf(format, args...))
+}
+
+// WithFields creates an entry of the default logger with provided fields
+func WithFields(fields Fields) *Entry {
+	return std.WithFields(fields)
+}
+
+// WithField creates an entry of the default logger with provided field
+func WithField(key string, value interface{}) *Entry {
+	return std.WithFields(Fields{key: value})
+}
+
+// WithError creates an entry of the default logger with the error field
+func WithError(err error) *Entry {
+	return std.WithFields(Fields{FieldError: err})
+}
+
+// WithContext creates an entry of the default logger with the request id from the context
+func WithContext(ctx context.Context) *Entry {
+	return std.WithFields(nil).WithContext(ctx)
+}
+
+func Debugf(format string, args ...interface{}) {
+	std.log(LevelDebug, nil, fmt.Sprintf(format, args...))
+}
+
+func Infof(format string, args ...interface{}) {
+	std.log(LevelInfo, nil, fmt.Sprintf(format, args...))
+}
+
+func Warnf(format string, args ...interface{}) {
+	std.log(LevelWarn, nil, fmt.Sprintf(format, args...))
+}
+
+func Errorf(format string, args ...interface{}) {
+	std.log(LevelError, nil, fmt.Sprintf(format, args...))
+}
+
+// Fatalf logs the message with error level and exits the application
+func Fatalf(format string, args ...interface{}) {
+	std.log(LevelError, nil, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// NewRequestID generates a new request id
+func NewRequestID() string {
+	return uuid.NewV4().String()
+}
+
+// WithRequestID returns a copy of the context carrying the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// RequestID returns the request id from the context or empty string if there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+
+	return id
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+
+	for key := range fields {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
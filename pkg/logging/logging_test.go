@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Logger_LevelsAndFormats(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := New(&buf, LevelInfo, false)
+	ctx := WithRequestID(context.Background(), "req-1")
+
+	logger.WithFields(nil).Debugf("hidden")
+	logger.WithFields(Fields{"device": "Living room"}).WithContext(ctx).Infof("executed %s", "tv on")
+
+	line := buf.String()
+	assert.NotContains(t, line, "hidden")
+	assert.Contains(t, line, ` INFO executed tv on device="Living room" request_id=req-1`)
+
+	buf.Reset()
+	logger = New(&buf, LevelDebug, true)
+	logger.WithFields(nil).WithContext(ctx).WithError(errors.New("timeout")).Errorf("failed")
+
+	var entry map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "error", entry["level"])
+	assert.Equal(t, "failed", entry["msg"])
+	assert.Equal(t, "timeout", entry["error"])
+	assert.Equal(t, "req-1", entry["request_id"])
+}
+
+func Test_RotatingFile_AppendsAndRotates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smh-logs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "server.log")
+	assert.Nil(t, ioutil.WriteFile(fileName, []byte("previous run\n"), 0644))
+
+	rf, err := NewRotatingFile(fileName, 20, 1)
+	assert.Nil(t, err)
+
+	_, err = rf.Write([]byte("first\n"))
+	assert.Nil(t, err)
+
+	contents, _ := ioutil.ReadFile(fileName)
+	assert.Equal(t, "previous run\nfirst\n", string(contents))
+
+	_, err = rf.Write([]byte(strings.Repeat("x", 10) + "\n"))
+	assert.Nil(t, err)
+	_, err = rf.Write([]byte(strings.Repeat("y", 10) + "\n"))
+	assert.Nil(t, err)
+	assert.Nil(t, rf.Close())
+
+	contents, _ = ioutil.ReadFile(fileName)
+	assert.Equal(t, strings.Repeat("y", 10)+"\n", string(contents))
+
+	backup, _ := ioutil.ReadFile(fileName + ".1")
+	assert.Equal(t, strings.Repeat("x", 10)+"\n", string(backup))
+
+	_, err = os.Stat(fileName + ".2")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,202 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	defaultLevel      = "info"
+	defaultMaxSize    = 10 // megabytes
+	defaultMaxBackups = 3
+	megabyte          = 1024 * 1024
+)
+
+// Config struct defines the logging output, level, format and log file rotation
+type Config struct {
+	File       string
+	Level      string
+	JSON       bool
+	MaxSize    int // megabytes, 0 disables the rotation
+	MaxBackups int
+}
+
+// Setup configures the default logger and redirects the standard library logger to it, so the messages logged
+// by the dependencies have the same format
+func Setup(config Config) error {
+	level := LevelInfo
+
+	if config.Level != "" {
+		var err error
+
+		level, err = ParseLevel(config.Level)
+		if err != nil {
+			return err
+		}
+	}
+
+	var out io.Writer = os.Stderr
+
+	if config.File != "" {
+		file, err := NewRotatingFile(config.File, int64(config.MaxSize)*megabyte, config.MaxBackups)
+		if err != nil {
+			return err
+		}
+
+		out = file
+	}
+
+	std = New(out, level, config.JSON)
+
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(&stdLogWriter{logger: std})
+
+	return nil
+}
+
+// CliFlags returns the flags for the logging level, format and rotation, the environment variables are prefixed
+// with provided prefix. The log file flag is defined by the applications themselves.
+func CliFlags(config *Config, envPrefix string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:        "log-level",
+			Value:       defaultLevel,
+			Usage:       "Log level (values: \"debug\", \"info\", \"warn\", \"error\")",
+			Destination: &config.Level,
+			EnvVars:     []string{envPrefix + "LOG_LEVEL"},
+		},
+		&cli.BoolFlag{
+			Name:        "log-json",
+			Usage:       "Log in JSON format",
+			Destination: &config.JSON,
+			EnvVars:     []string{envPrefix + "LOG_JSON"},
+		},
+		&cli.IntFlag{
+			Name:        "log-max-size",
+			Value:       defaultMaxSize,
+			Usage:       "Max size of the log file in megabytes before it is rotated (0 disables the rotation)",
+			Destination: &config.MaxSize,
+			EnvVars:     []string{envPrefix + "LOG_MAX_SIZE"},
+		},
+		&cli.IntFlag{
+			Name:        "log-max-backups",
+			Value:       defaultMaxBackups,
+			Usage:       "Number of rotated log files to keep",
+			Destination: &config.MaxBackups,
+			EnvVars:     []string{envPrefix + "LOG_MAX_BACKUPS"},
+		},
+	}
+}
+
+// stdLogWriter writes the standard library logger output as info entries
+type stdLogWriter struct {
+	logger *Logger
+}
+
+func (w *stdLogWriter) Write(p []byte) (int, error) {
+	w.logger.log(LevelInfo, nil, string(bytes.TrimRight(p, "\n")))
+
+	return len(p), nil
+}
+
+// RotatingFile struct is a log file opened in append mode which is rotated once it exceeds the max size.
+// Rotated files get numeric suffixes, the oldest ones above max backups are removed.
+type RotatingFile struct {
+	fileName   string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	mu         sync.Mutex
+}
+
+func NewRotatingFile(fileName string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{fileName: fileName, maxSize: maxSize, maxBackups: maxBackups}
+
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		err := rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the underlying file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	return rf.file.Close()
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+
+	return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+	err := rf.file.Close()
+	if err != nil {
+		return err
+	}
+
+	if rf.maxBackups <= 0 {
+		err = os.Remove(rf.fileName)
+	} else {
+		_ = os.Remove(rf.backupName(rf.maxBackups))
+
+		for i := rf.maxBackups - 1; i > 0; i-- {
+			_ = os.Rename(rf.backupName(i), rf.backupName(i+1))
+		}
+
+		err = os.Rename(rf.fileName, rf.backupName(1))
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return rf.open()
+}
+
+func (rf *RotatingFile) backupName(idx int) string {
+	return fmt.Sprintf("%s.%d", rf.fileName, idx)
+}
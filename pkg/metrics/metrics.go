@@ -0,0 +1,362 @@
+// Package metrics implements simple counters, gauges and histograms exposed in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefaultBuckets are the histogram buckets in seconds suitable for the device commands latency
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Default is the registry used by the packages of the application
+var Default = NewRegistry()
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry struct keeps all the registered metrics
+type Registry struct {
+	collectors []collector
+	names      map[string]collector
+	mu         sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]collector)}
+}
+
+// metric is the common part of all the metric types, values are kept per label values combination
+type metric struct {
+	name       string
+	help       string
+	metricType string
+	labels     []string
+	mu         sync.Mutex
+}
+
+// Counter struct is a monotonically increasing value
+type Counter struct {
+	metric
+	values map[string]float64
+}
+
+// Gauge struct is a value that can go up and down
+type Gauge struct {
+	metric
+	values map[string]float64
+	fn     func() float64
+}
+
+// Histogram struct counts the observations in the buckets
+type Histogram struct {
+	metric
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewCounter registers a counter, or returns already registered one with the same name
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	return r.register(name, func() collector {
+		return &Counter{metric: newMetric(name, help, typeCounter, labels), values: make(map[string]float64)}
+	}).(*Counter)
+}
+
+// NewGauge registers a gauge, or returns already registered one with the same name
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Gauge {
+	return r.register(name, func() collector {
+		return &Gauge{metric: newMetric(name, help, typeGauge, labels), values: make(map[string]float64)}
+	}).(*Gauge)
+}
+
+// NewGaugeFunc registers a gauge without labels whose value is taken from the function on every exposition
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64) *Gauge {
+	gauge := r.NewGauge(name, help)
+
+	gauge.mu.Lock()
+	gauge.fn = fn
+	gauge.mu.Unlock()
+
+	return gauge
+}
+
+// NewHistogram registers a histogram with provided upper bounds of the buckets, or returns already registered one
+// with the same name
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return r.register(name, func() collector {
+		sorted := append([]float64(nil), buckets...)
+		sort.Float64s(sorted)
+
+		return &Histogram{
+			metric:  newMetric(name, help, typeHistogram, labels),
+			buckets: sorted,
+			values:  make(map[string]*histogramValue),
+		}
+	}).(*Histogram)
+}
+
+// WriteTo writes all the metrics in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+
+	for _, c := range collectors {
+		c.write(buf)
+	}
+
+	err := buf.Flush()
+
+	return counter.n, err
+}
+
+func (r *Registry) register(name string, create func() collector) collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.names[name]; ok {
+		return c
+	}
+
+	c := create()
+	r.names[name] = c
+	r.collectors = append(r.collectors, c)
+
+	return c
+}
+
+// Inc increments the counter for the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the value to the counter for the label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[c.key(labelValues)] += value
+}
+
+// Value returns the current counter value for the label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[c.key(labelValues)]
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w)
+
+	for _, key := range sortedKeys(c.values) {
+		c.writeSample(w, c.name, key, "", c.values[key])
+	}
+}
+
+// Set sets the gauge value for the label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.values[g.key(labelValues)] = value
+}
+
+// Add adds the value (can be negative) to the gauge for the label values
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.values[g.key(labelValues)] += value
+}
+
+// Inc increments the gauge for the label values
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec decrements the gauge for the label values
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Value returns the current gauge value for the label values
+func (g *Gauge) Value(labelValues ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.fn != nil {
+		return g.fn()
+	}
+
+	return g.values[g.key(labelValues)]
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.writeHeader(w)
+
+	if g.fn != nil {
+		g.writeSample(w, g.name, "", "", g.fn())
+
+		return
+	}
+
+	for _, key := range sortedKeys(g.values) {
+		g.writeSample(w, g.name, key, "", g.values[key])
+	}
+}
+
+// Observe adds the observation for the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := h.key(labelValues)
+	hv, ok := h.values[key]
+
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			hv.counts[i]++
+		}
+	}
+
+	hv.count++
+	hv.sum += value
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		hv := h.values[key]
+
+		for i, bound := range h.buckets {
+			h.writeSample(w, h.name+"_bucket", key, `le="`+formatFloat(bound)+`"`, float64(hv.counts[i]))
+		}
+
+		h.writeSample(w, h.name+"_bucket", key, `le="+Inf"`, float64(hv.count))
+		h.writeSample(w, h.name+"_sum", key, "", hv.sum)
+		h.writeSample(w, h.name+"_count", key, "", float64(hv.count))
+	}
+}
+
+func newMetric(name string, help string, metricType string, labels []string) metric {
+	return metric{name: name, help: help, metricType: metricType, labels: labels}
+}
+
+// key builds the label pairs string used both as map key and in the exposition
+func (m *metric) key(labelValues []string) string {
+	pairs := make([]string, len(m.labels))
+
+	for i, label := range m.labels {
+		value := ""
+
+		if i < len(labelValues) {
+			value = labelValues[i]
+		}
+
+		pairs[i] = label + `="` + escapeLabelValue(value) + `"`
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (m *metric) writeHeader(w *bufio.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.metricType)
+}
+
+func (m *metric) writeSample(w *bufio.Writer, name string, key string, extra string, value float64) {
+	labels := key
+
+	if extra != "" {
+		if labels != "" {
+			labels += ","
+		}
+
+		labels += extra
+	}
+
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+
+	_, _ = fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Registry_WriteTo_TextFormat(t *testing.T) {
+	registry := NewRegistry()
+
+	commands := registry.NewCounter("smh_commands_total", "Executed commands.", "device")
+	commands.Inc("Living \"room\"")
+	commands.Add(2, "bedroom")
+	assert.Same(t, commands, registry.NewCounter("smh_commands_total", "Executed commands.", "device"))
+
+	registry.NewGaugeFunc("smh_subscribers", "Subscribers.", func() float64 { return 3 })
+
+	latency := registry.NewHistogram("smh_latency_seconds", "Latency.", []float64{1, 0.1}, "device")
+	latency.Observe(0.05, "bedroom")
+	latency.Observe(0.5, "bedroom")
+
+	var buf bytes.Buffer
+	_, err := registry.WriteTo(&buf)
+	assert.Nil(t, err)
+
+	expected := `# HELP smh_commands_total Executed commands.
+# TYPE smh_commands_total counter
+smh_commands_total{device="Living \"room\""} 1
+smh_commands_total{device="bedroom"} 2
+# HELP smh_subscribers Subscribers.
+# TYPE smh_subscribers gauge
+smh_subscribers 3
+# HELP smh_latency_seconds Latency.
+# TYPE smh_latency_seconds histogram
+smh_latency_seconds_bucket{device="bedroom",le="0.1"} 1
+smh_latency_seconds_bucket{device="bedroom",le="1"} 2
+smh_latency_seconds_bucket{device="bedroom",le="+Inf"} 2
+smh_latency_seconds_sum{device="bedroom"} 0.55
+smh_latency_seconds_count{device="bedroom"} 2
+`
+	assert.Equal(t, expected, buf.String())
+}
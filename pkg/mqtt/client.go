@@ -0,0 +1,247 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultKeepAlive   = 30 * time.Second
+	defaultDialTimeout = 10 * time.Second
+	defaultAckTimeout  = 10 * time.Second
+	deliveriesBuffer   = 256
+
+	// protocolVersion is MQTT 3.1.1, paho falls back to 3.1 without it
+	protocolVersion = 4
+	// subscribeFailure is the SUBACK return code of the refused subscription
+	subscribeFailure = 0x80
+)
+
+var (
+	// ErrClosed is returned if the client is closed or the connection is lost
+	ErrClosed = errors.New("mqtt connection closed")
+
+	errAckTimeout     = errors.New("mqtt acknowledgement timeout")
+	errConnectTimeout = errors.New("mqtt connection timeout")
+)
+
+// Options struct is the connection settings of the client
+type Options struct {
+	Address     string // host:port of the broker
+	ClientID    string
+	Username    string
+	Password    string
+	KeepAlive   time.Duration // 30 seconds if zero
+	DialTimeout time.Duration // 10 seconds if zero
+	TLS         *tls.Config   // the connection is not encrypted if nil
+	Will        *Message      // published by the broker if the connection is lost
+}
+
+// Handler is called with the messages of the subscription, one by one in the order of the messages. The QoS 1 message
+// is acknowledged when it is received, every connection is a clean session, so the broker would not deliver the
+// unacknowledged message again anyway.
+type Handler func(msg Message)
+
+// Client struct is the connection to the broker. The connection is not reestablished by the client, the owner dials
+// again once Done is closed.
+type Client struct {
+	client     paho.Client
+	mu         sync.Mutex
+	deliveries chan delivery
+	done       chan struct{}
+	err        error
+	closeOnce  sync.Once
+}
+
+type delivery struct {
+	msg     Message
+	handler Handler
+}
+
+// Dial connects to the broker and starts the session
+func Dial(opts Options) (*Client, error) {
+	dialTimeout := opts.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultDialTimeout
+	}
+
+	keepAlive := opts.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = defaultKeepAlive
+	}
+
+	c := &Client{
+		deliveries: make(chan delivery, deliveriesBuffer),
+		done:       make(chan struct{}),
+	}
+
+	scheme := "tcp"
+	if opts.TLS != nil {
+		scheme = "ssl"
+	}
+
+	// the handlers are called by the delivery loop instead of the paho routing, so they may publish and wait for the
+	// acknowledgements themselves
+	clientOpts := paho.NewClientOptions().
+		AddBroker(scheme + "://" + opts.Address).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetProtocolVersion(protocolVersion).
+		SetCleanSession(true).
+		SetKeepAlive(keepAlive).
+		SetConnectTimeout(dialTimeout).
+		SetAutoReconnect(false).
+		SetConnectRetry(false).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			c.shutdown(err)
+		})
+
+	if opts.TLS != nil {
+		clientOpts.SetTLSConfig(opts.TLS)
+	}
+
+	if opts.Will != nil {
+		clientOpts.SetBinaryWill(opts.Will.Topic, opts.Will.Payload, opts.Will.QoS, opts.Will.Retain)
+	}
+
+	c.client = paho.NewClient(clientOpts)
+
+	token := c.client.Connect()
+	if !token.WaitTimeout(dialTimeout) {
+		c.client.Disconnect(0)
+
+		return nil, errConnectTimeout
+	}
+
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+
+	go c.deliverLoop()
+
+	return c, nil
+}
+
+// Publish publishes the message, the QoS 1 message is acknowledged by the broker before Publish returns
+func (c *Client) Publish(msg Message) error {
+	if msg.QoS > 1 {
+		msg.QoS = 1
+	}
+
+	if c.closed() {
+		return ErrClosed
+	}
+
+	return c.wait(c.client.Publish(msg.Topic, msg.QoS, msg.Retain, msg.Payload))
+}
+
+// Subscribe subscribes the handler to the topic filter, the wildcards are allowed
+func (c *Client) Subscribe(filter string, qos byte, handler Handler) error {
+	if c.closed() {
+		return ErrClosed
+	}
+
+	token := c.client.Subscribe(filter, qos, func(_ paho.Client, msg paho.Message) {
+		d := delivery{
+			msg:     Message{Topic: msg.Topic(), Payload: msg.Payload(), QoS: msg.Qos(), Retain: msg.Retained()},
+			handler: handler,
+		}
+
+		select {
+		case c.deliveries <- d:
+		case <-c.done:
+		}
+	})
+
+	err := c.wait(token)
+	if err != nil {
+		return err
+	}
+
+	if code, ok := token.(*paho.SubscribeToken).Result()[filter]; !ok || code == subscribeFailure {
+		return fmt.Errorf("mqtt subscription to %s refused", filter)
+	}
+
+	return nil
+}
+
+// Done is closed when the connection is closed or lost
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason of the lost connection, nil if the client has been closed
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+// Close disconnects from the broker, the last will is not published
+func (c *Client) Close() error {
+	c.shutdown(nil)
+
+	return nil
+}
+
+func (c *Client) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+
+		close(c.done)
+
+		if err == nil {
+			c.client.Disconnect(0)
+		}
+	})
+}
+
+func (c *Client) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits for the acknowledgement of the token, the connection lost meanwhile is reported as ErrClosed
+func (c *Client) wait(token paho.Token) error {
+	timer := time.NewTimer(defaultAckTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-token.Done():
+	case <-c.done:
+		return ErrClosed
+	case <-timer.C:
+		return errAckTimeout
+	}
+
+	err := token.Error()
+	if err == paho.ErrNotConnected {
+		return ErrClosed
+	}
+
+	return err
+}
+
+func (c *Client) deliverLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case d := <-c.deliveries:
+			d.handler(d.msg)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+// Package mqtt wraps the MQTT 3.1.1 client (eclipse paho) and the embedded broker (mochi-mqtt) behind the small API
+// used by the smart home bridges and the single box setups. The sessions are not persisted, every connection is a
+// clean session.
+package mqtt
+
+// Message struct is the application message published to the topic
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
@@ -0,0 +1,97 @@
+package mqtt
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func startServer(t *testing.T, opts ServerOptions) (*Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	server := NewServer(opts)
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	return server, listener.Addr().String()
+}
+
+func receive(t *testing.T, messages chan Message) Message {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	return Message{}
+}
+
+func Test_Client_PublishSubscribe(t *testing.T) {
+	server, addr := startServer(t, ServerOptions{Username: "smh", Password: "secret"})
+	defer server.Close()
+
+	_, err := Dial(Options{Address: addr, ClientID: "intruder", Password: "wrong", Username: "smh"})
+	assert.NotNil(t, err)
+
+	subscriber, err := Dial(Options{Address: addr, ClientID: "subscriber", Username: "smh", Password: "secret"})
+	assert.Nil(t, err)
+	defer subscriber.Close()
+
+	publisher, err := Dial(Options{Address: addr, ClientID: "publisher", Username: "smh", Password: "secret",
+		Will: &Message{Topic: "smh/status", Payload: []byte("offline"), Retain: true}})
+	assert.Nil(t, err)
+
+	// the retained message is delivered to the later subscriptions
+	assert.Nil(t, publisher.Publish(Message{Topic: "smh/state/tv", Payload: []byte("on"), QoS: 1, Retain: true}))
+
+	messages := make(chan Message, 10)
+	assert.Nil(t, subscriber.Subscribe("smh/#", 1, func(msg Message) {
+		messages <- msg
+	}))
+
+	msg := receive(t, messages)
+	assert.Equal(t, "smh/state/tv", msg.Topic)
+	assert.Equal(t, "on", string(msg.Payload))
+	assert.True(t, msg.Retain)
+
+	assert.Nil(t, publisher.Publish(Message{Topic: "smh/state/light", Payload: []byte("off"), QoS: 1}))
+
+	msg = receive(t, messages)
+	assert.Equal(t, "smh/state/light", msg.Topic)
+	assert.Equal(t, byte(1), msg.QoS)
+	assert.False(t, msg.Retain)
+
+	// the will is published when the connection is lost
+	client, ok := server.broker.Clients.Get("publisher")
+	assert.True(t, ok)
+	client.Stop(errors.New("connection lost"))
+
+	msg = receive(t, messages)
+	assert.Equal(t, "smh/status", msg.Topic)
+	assert.Equal(t, "offline", string(msg.Payload))
+}
+
+func Test_Client_DoneWhenServerCloses(t *testing.T) {
+	server, addr := startServer(t, ServerOptions{})
+
+	client, err := Dial(Options{Address: addr, ClientID: "client"})
+	assert.Nil(t, err)
+
+	assert.Nil(t, server.Close())
+
+	select {
+	case <-client.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("client did not notice the closed connection")
+	}
+
+	assert.NotNil(t, client.Err())
+	assert.Equal(t, ErrClosed, client.Publish(Message{Topic: "smh/state/tv"}))
+}
@@ -0,0 +1,177 @@
+package mqtt
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// ServerOptions struct is the settings of the embedded broker, the clients are not authenticated if the username is
+// empty
+type ServerOptions struct {
+	Username string
+	Password string
+}
+
+// Server struct is the embedded broker
+type Server struct {
+	broker  *mochi.Server
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	count   int
+	done    chan struct{}
+}
+
+func NewServer(opts ServerOptions) *Server {
+	// the broker logs are dropped, the clients report the failures that matter
+	broker := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	_ = broker.AddHook(&authHook{opts: opts}, nil)
+
+	return &Server{broker: broker, done: make(chan struct{})}
+}
+
+// ListenAndServe listens on the tcp address and serves the clients until the server is closed
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(listener)
+}
+
+// Serve serves the clients connecting to the listener until the server is closed
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+
+		return ErrClosed
+	}
+
+	s.count++
+	id := fmt.Sprintf("listener-%d", s.count)
+
+	err := s.broker.AddListener(&netListener{id: id, listener: listener})
+	if err == nil && !s.started {
+		s.started = true
+		err = s.broker.Serve()
+	} else if err == nil {
+		s.broker.Listeners.Serve(id, s.broker.EstablishConnection)
+	}
+
+	s.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	<-s.done
+
+	return ErrClosed
+}
+
+// Close stops the listeners and disconnects the clients
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+	close(s.done)
+
+	return s.broker.Close()
+}
+
+// Publish publishes the message to the subscribers as if it was published by a client
+func (s *Server) Publish(msg Message) {
+	_ = s.broker.Publish(msg.Topic, msg.Payload, msg.Retain, msg.QoS)
+}
+
+// authHook authenticates the clients by the username and password of the server options, every authenticated client
+// may publish and subscribe to any topic
+type authHook struct {
+	mochi.HookBase
+	opts ServerOptions
+}
+
+func (h *authHook) ID() string {
+	return "smh-auth"
+}
+
+func (h *authHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{mochi.OnConnectAuthenticate, mochi.OnACLCheck}, []byte{b})
+}
+
+func (h *authHook) OnConnectAuthenticate(cl *mochi.Client, pk packets.Packet) bool {
+	if h.opts.Username == "" {
+		return true
+	}
+
+	username := subtle.ConstantTimeCompare(pk.Connect.Username, []byte(h.opts.Username))
+	password := subtle.ConstantTimeCompare(pk.Connect.Password, []byte(h.opts.Password))
+
+	return username&password == 1
+}
+
+func (h *authHook) OnACLCheck(cl *mochi.Client, topic string, write bool) bool {
+	return true
+}
+
+// netListener serves the clients of the listener opened by the caller, e.g. on the random port of the tests
+type netListener struct {
+	id       string
+	listener net.Listener
+}
+
+func (l *netListener) Init(*slog.Logger) error {
+	return nil
+}
+
+func (l *netListener) Serve(establish listeners.EstablishFn) {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			_ = establish(l.id, conn)
+		}()
+	}
+}
+
+func (l *netListener) ID() string {
+	return l.id
+}
+
+func (l *netListener) Address() string {
+	return l.listener.Addr().String()
+}
+
+func (l *netListener) Protocol() string {
+	return "tcp"
+}
+
+func (l *netListener) Close(closeClients listeners.CloseFn) {
+	_ = l.listener.Close()
+
+	closeClients(l.id)
+}
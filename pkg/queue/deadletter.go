@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeadLetter struct is the message that could not be handled, kept by the broker for inspection and replay
+type DeadLetter struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	RequestID string    `json:"request_id,omitempty"` // id of the alexa request, empty for other messages
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"`
+	DeadAt    time.Time `json:"dead_at"`
+	Body      string    `json:"body"`
+}
+
+// DeadLetterFilter selects the dead letters to replay
+type DeadLetterFilter func(letter DeadLetter) bool
+
+// NewDeadLetter returns the dead letter of the message body identified by the envelope
+func NewDeadLetter(body []byte, cause string, attempts int, deadAt time.Time) DeadLetter {
+	letter := DeadLetter{
+		Error:    cause,
+		Attempts: attempts,
+		DeadAt:   deadAt,
+		Body:     string(body),
+	}
+
+	msg, err := ParseEnvelope(body)
+	if err != nil {
+		return letter
+	}
+
+	letter.ID, letter.Type = msg.ID, msg.Type
+
+	// the alexa requests are identified by their request id for the replay
+	var alexa alexaMessage
+
+	if msg.Type == TypeAlexaIntent && json.Unmarshal(msg.Payload, &alexa) == nil {
+		letter.RequestID = alexa.Request.RequestID
+	}
+
+	return letter
+}
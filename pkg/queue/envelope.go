@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"smh-apiengine/pkg/logging"
+	"time"
+)
+
+// EnvelopeVersion is the version of the envelope published by this package, the consumers reject the newer versions
+const EnvelopeVersion = 1
+
+// ContentType of the published envelopes
+const ContentType = "application/json"
+
+// Message types, the consumers dispatch the payload by the type
+const (
+	// TypeAlexaIntent payload is the alexa custom skill request or the smart home skill api directive
+	TypeAlexaIntent = "alexa-intent"
+	// TypeRunCommand payload is CommandPayload
+	TypeRunCommand = "run-command"
+	// TypeRunScenario payload is ScenarioPayload
+	TypeRunScenario = "run-scenario"
+	// TypeRunControlItem payload is ControlItemPayload
+	TypeRunControlItem = "run-control-item"
+)
+
+var errInvalidEnvelope = errors.New("invalid message envelope")
+
+// Envelope struct is the message carried by the queue, the payload depends on the type
+type Envelope struct {
+	Version       int             `json:"version"`
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	Source        string          `json:"source,omitempty"` // application that published the message
+	Timestamp     time.Time       `json:"timestamp"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	ReplyTo       string          `json:"reply_to,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// CommandPayload struct is the payload of the run-command message
+type CommandPayload struct {
+	CommandID string `json:"command_id"`
+}
+
+// ScenarioPayload struct is the payload of the run-scenario message
+type ScenarioPayload struct {
+	ScenarioID string `json:"scenario_id"`
+}
+
+// ControlItemPayload struct is the payload of the run-control-item message, the next state of the item is executed
+// if the state is empty
+type ControlItemPayload struct {
+	ControlItemID string `json:"control_item_id"`
+	State         string `json:"state,omitempty"`
+}
+
+// NewEnvelope returns the envelope of the given type with the payload marshalled to JSON, the json.RawMessage payload
+// is kept as it is
+func NewEnvelope(msgType string, payload interface{}) (Envelope, error) {
+	content, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		Version:   EnvelopeVersion,
+		ID:        logging.NewRequestID(),
+		Type:      msgType,
+		Timestamp: time.Now().UTC(),
+		Payload:   content,
+	}, nil
+}
+
+// ParseEnvelope parses the message body. The body without the payload is the raw alexa request published before the
+// envelope was introduced, it is wrapped in the alexa-intent envelope, so the publishers and the consumers can be
+// upgraded one by one.
+func ParseEnvelope(body []byte) (Envelope, error) {
+	var fields map[string]json.RawMessage
+
+	err := json.Unmarshal(body, &fields)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("%w: %s", errInvalidEnvelope, err)
+	}
+
+	if _, ok := fields["payload"]; !ok {
+		return Envelope{Version: EnvelopeVersion, Type: TypeAlexaIntent, Payload: body}, nil
+	}
+
+	var msg Envelope
+
+	err = json.Unmarshal(body, &msg)
+
+	switch {
+	case err != nil:
+		return Envelope{}, fmt.Errorf("%w: %s", errInvalidEnvelope, err)
+	case msg.Version < 1 || msg.Version > EnvelopeVersion:
+		return Envelope{}, fmt.Errorf("%w: unsupported version %d", errInvalidEnvelope, msg.Version)
+	case msg.Type == "":
+		return Envelope{}, fmt.Errorf("%w: type is missing", errInvalidEnvelope)
+	}
+
+	return msg, nil
+}
+
+// DecodePayload unmarshals the payload to the struct of the message type
+func (msg Envelope) DecodePayload(payload interface{}) error {
+	err := json.Unmarshal(msg.Payload, payload)
+	if err != nil {
+		return fmt.Errorf("%w: %s payload: %s", ErrRejected, msg.Type, err)
+	}
+
+	return nil
+}
+
+func (msg Envelope) ToJson() ([]byte, error) {
+	return json.Marshal(msg)
+}
@@ -0,0 +1,36 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseEnvelope(t *testing.T) {
+	msg, err := ParseEnvelope([]byte(`{"version":1,"id":"m-1","type":"run-scenario","payload":{"scenario_id":"movie"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "m-1", msg.ID)
+	assert.Equal(t, TypeRunScenario, msg.Type)
+
+	var payload ScenarioPayload
+	assert.Nil(t, msg.DecodePayload(&payload))
+	assert.Equal(t, "movie", payload.ScenarioID)
+
+	// the raw alexa request of the older publishers
+	msg, err = ParseEnvelope([]byte(`{"version":"1.0","request":{"type":"IntentRequest"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, TypeAlexaIntent, msg.Type)
+	assert.JSONEq(t, `{"version":"1.0","request":{"type":"IntentRequest"}}`, string(msg.Payload))
+
+	invalid := []string{
+		`not json`,
+		`{"version":2,"type":"run-command","payload":{}}`,
+		`{"version":1,"payload":{}}`,
+	}
+
+	for _, body := range invalid {
+		_, err = ParseEnvelope([]byte(body))
+		assert.True(t, errors.Is(err, errInvalidEnvelope), body)
+	}
+}
@@ -0,0 +1,224 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/metrics"
+	"sync"
+	"time"
+)
+
+// Outcome of the processed message, tells the broker what to do with the message
+type Outcome int
+
+const (
+	// Handled message is acknowledged, the reply is sent if the publisher waits for it
+	Handled Outcome = iota
+	// Skipped duplicate or expired message is acknowledged, the duplicate gets the reply of the handled message
+	Skipped
+	// Failed message is dead-lettered, it has been rejected or the retries are exhausted
+	Failed
+	// Interrupted message is returned to the queue, the consumer has been stopped during the retries
+	Interrupted
+	// Retry message has failed and is handled again after the retry delay, the broker delays it
+	Retry
+)
+
+var (
+	duplicateMessages = metrics.Default.NewCounter(
+		"smh_rmq_duplicate_messages_total", "Number of the consumed messages dropped as duplicates.", "type")
+	expiredMessages = metrics.Default.NewCounter(
+		"smh_rmq_expired_messages_total", "Number of the consumed messages dropped as too old.", "type")
+)
+
+// Result struct is the outcome of the processed message
+type Result struct {
+	Outcome  Outcome
+	Reply    string
+	Attempts int
+	Err      error // the last error of the failed or interrupted message
+}
+
+// Processor struct handles the consumed messages for the brokers: drops the duplicates and the expired messages and
+// retries the failed ones
+type Processor struct {
+	options *Options
+	dedup   dedupCache
+}
+
+func NewProcessor(options *Options) *Processor {
+	return &Processor{options: options}
+}
+
+// Process handles the message. The failed message is handled again after the retry delay up to the max retries. The
+// duplicates of the messages handled within the dedup window and the messages older than the max age are skipped,
+// the replayed dead letters are not checked for the age.
+func (p *Processor) Process(ctx context.Context, msg Envelope, replayed bool, handler MessageHandler) Result {
+	if reply, skip := p.skip(msg, replayed); skip {
+		return Result{Outcome: Skipped, Reply: reply}
+	}
+
+	for attempts := 1; ; attempts++ {
+		result := p.attempt(msg, attempts, handler)
+		if result.Outcome != Retry {
+			return result
+		}
+
+		select {
+		case <-ctx.Done():
+			return Result{Outcome: Interrupted, Attempts: attempts, Err: result.Err}
+		case <-time.After(p.options.RetryDelay):
+		}
+	}
+}
+
+// ProcessAttempt handles the message once, for the brokers delaying the retries themselves so the other messages are
+// not held meanwhile. The attempt counts the previous attempts too, the failed message gets the Retry outcome until
+// the max retries are exhausted. The messages are skipped like by Process.
+func (p *Processor) ProcessAttempt(msg Envelope, replayed bool, attempt int, handler MessageHandler) Result {
+	if reply, skip := p.skip(msg, replayed); skip {
+		return Result{Outcome: Skipped, Reply: reply}
+	}
+
+	return p.attempt(msg, attempt, handler)
+}
+
+// attempt handles the message, the failed message gets the Retry outcome unless it has been rejected or the retries
+// are exhausted
+func (p *Processor) attempt(msg Envelope, attempts int, handler MessageHandler) Result {
+	reply, err := handler.Handle(msg)
+
+	if err == nil {
+		p.dedup.remember(messageKey(msg), reply, p.options.dedupWindow())
+
+		return Result{Outcome: Handled, Reply: reply, Attempts: attempts}
+	}
+
+	if errors.Is(err, ErrRejected) || attempts > p.options.MaxRetries {
+		return Result{Outcome: Failed, Attempts: attempts, Err: err}
+	}
+
+	logging.WithField(logging.FieldRequestID, msg.ID).WithError(err).Warnf(
+		"Failed to handle the message, retry %d of %d in %s", attempts, p.options.MaxRetries, p.options.RetryDelay)
+
+	return Result{Outcome: Retry, Attempts: attempts, Err: err}
+}
+
+// dedupCache remembers the handled messages within the window with their replies, so the message delivered again
+// (e.g. retried by alexa or published twice after a network glitch) is not executed twice
+type dedupCache struct {
+	mu      sync.Mutex
+	entries map[string]dedupEntry
+}
+
+type dedupEntry struct {
+	reply     string
+	handledAt time.Time
+}
+
+// seen returns the reply of the message with the key if it has been handled within the window
+func (c *dedupCache) seen(key string, window time.Duration) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.handledAt) > window {
+		return "", false
+	}
+
+	return entry.reply, true
+}
+
+// remember records the handled message and forgets the ones out of the window
+func (c *dedupCache) remember(key string, reply string, window time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]dedupEntry)
+	}
+
+	for k, entry := range c.entries {
+		if time.Since(entry.handledAt) > window {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = dedupEntry{reply: reply, handledAt: time.Now()}
+}
+
+// alexaMessage is the part of the alexa request or smart home directive identifying it
+type alexaMessage struct {
+	Request struct {
+		RequestID string `json:"requestId"`
+		Timestamp string `json:"timestamp"`
+	} `json:"request"`
+	Directive struct {
+		Header struct {
+			MessageID string `json:"messageId"`
+		} `json:"header"`
+	} `json:"directive"`
+}
+
+// messageKey returns the id identifying the message for the deduplication: the request id of the alexa request, the
+// message id of the smart home directive or the envelope id of the other messages. The alexa ids are preferred, as
+// the retried request is published in a new envelope.
+func messageKey(msg Envelope) string {
+	var alexa alexaMessage
+
+	if msg.Type == TypeAlexaIntent && json.Unmarshal(msg.Payload, &alexa) == nil {
+		if alexa.Request.RequestID != "" {
+			return alexa.Request.RequestID
+		}
+
+		if alexa.Directive.Header.MessageID != "" {
+			return alexa.Directive.Header.MessageID
+		}
+	}
+
+	return msg.ID
+}
+
+// messageTime returns when the message was created: the timestamp of the alexa request or the envelope timestamp
+func messageTime(msg Envelope) time.Time {
+	var alexa alexaMessage
+
+	if msg.Type == TypeAlexaIntent && json.Unmarshal(msg.Payload, &alexa) == nil && alexa.Request.Timestamp != "" {
+		timestamp, err := time.Parse(time.RFC3339, alexa.Request.Timestamp)
+		if err == nil {
+			return timestamp
+		}
+	}
+
+	return msg.Timestamp
+}
+
+// skip tells whether the message has to be dropped without handling it: the duplicate of the message handled within
+// the dedup window, which gets the reply of the first one, or the message older than the max age, which would
+// surprise the user executed so late
+func (p *Processor) skip(msg Envelope, replayed bool) (string, bool) {
+	logger := logging.WithField(logging.FieldRequestID, msg.ID)
+
+	if reply, ok := p.dedup.seen(messageKey(msg), p.options.dedupWindow()); ok {
+		logger.Warnf("Duplicate message %s dropped", messageKey(msg))
+		duplicateMessages.Inc(msg.Type)
+
+		return reply, true
+	}
+
+	created := messageTime(msg)
+
+	if !replayed && !created.IsZero() {
+		if age := time.Since(created); age > p.options.maxMessageAge() {
+			logger.Warnf("Message created %s ago dropped, max age is %s", age.Round(time.Second),
+				p.options.maxMessageAge())
+			expiredMessages.Inc(msg.Type)
+
+			return "", true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingHandler struct {
+	failures int
+	calls    int
+}
+
+func (h *failingHandler) Handle(msg Envelope) (string, error) {
+	h.calls++
+
+	if h.calls <= h.failures {
+		return "", errors.New("api is not available")
+	}
+
+	return "ok", nil
+}
+
+func testAlexaMessage(requestID string, timestamp time.Time) Envelope {
+	msg, _ := NewEnvelope(TypeAlexaIntent, json.RawMessage(
+		`{"version":"1.0","request":{"requestId":"`+requestID+`","timestamp":"`+timestamp.Format(time.RFC3339)+`"}}`))
+
+	return msg
+}
+
+func Test_Process_Retries(t *testing.T) {
+	processor := NewProcessor(&Options{MaxRetries: 2, RetryDelay: time.Millisecond})
+	handler := &failingHandler{failures: 2}
+
+	result := processor.Process(context.Background(), testAlexaMessage("req-1", time.Now()), false, handler)
+
+	assert.Equal(t, Handled, result.Outcome)
+	assert.Equal(t, 3, result.Attempts)
+	assert.Equal(t, "ok", result.Reply)
+
+	result = processor.Process(context.Background(), testAlexaMessage("req-2", time.Now()), false,
+		&failingHandler{failures: 3})
+	assert.Equal(t, Failed, result.Outcome)
+	assert.NotNil(t, result.Err)
+
+	// the stopped consumer returns the message to the queue instead of waiting for the retry
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	processor = NewProcessor(&Options{MaxRetries: 2, RetryDelay: time.Hour})
+	result = processor.Process(ctx, testAlexaMessage("req-3", time.Now()), false, &failingHandler{failures: 1})
+	assert.Equal(t, Interrupted, result.Outcome)
+}
+
+func Test_ProcessAttempt(t *testing.T) {
+	processor := NewProcessor(&Options{MaxRetries: 2, RetryDelay: time.Hour})
+	msg := testAlexaMessage("req-1", time.Now())
+	handler := &failingHandler{failures: 2}
+
+	// the failed attempt returns right away, the broker delays the retry
+	result := processor.ProcessAttempt(msg, false, 1, handler)
+	assert.Equal(t, Retry, result.Outcome)
+	assert.Equal(t, 1, result.Attempts)
+	assert.NotNil(t, result.Err)
+
+	result = processor.ProcessAttempt(msg, false, 2, handler)
+	assert.Equal(t, Retry, result.Outcome)
+
+	result = processor.ProcessAttempt(msg, false, 3, handler)
+	assert.Equal(t, Handled, result.Outcome)
+	assert.Equal(t, "ok", result.Reply)
+
+	result = processor.ProcessAttempt(testAlexaMessage("req-2", time.Now()), false, 3, &failingHandler{failures: 1})
+	assert.Equal(t, Failed, result.Outcome)
+}
+
+func Test_Process_SkipsDuplicatesAndExpired(t *testing.T) {
+	processor := NewProcessor(&Options{MaxMessageAge: time.Minute})
+	handler := &failingHandler{}
+
+	// alexa retries the request in a new envelope
+	processor.Process(context.Background(), testAlexaMessage("req-1", time.Now()), false, handler)
+	result := processor.Process(context.Background(), testAlexaMessage("req-1", time.Now()), false, handler)
+	assert.Equal(t, Skipped, result.Outcome)
+	assert.Equal(t, "ok", result.Reply)
+
+	processor.Process(context.Background(), testAlexaMessage("req-2", time.Now()), false, handler)
+	assert.Equal(t, 2, handler.calls)
+
+	result = processor.Process(context.Background(), testAlexaMessage("req-3", time.Now().Add(-2*time.Minute)), false,
+		handler)
+	assert.Equal(t, Skipped, result.Outcome)
+	assert.Equal(t, 2, handler.calls)
+
+	// the replayed dead letters are executed however old they are
+	result = processor.Process(context.Background(), testAlexaMessage("req-4", time.Now().Add(-time.Hour)), true, handler)
+	assert.Equal(t, Handled, result.Outcome)
+	assert.Equal(t, 3, handler.calls)
+}
+
+func Test_messageKey(t *testing.T) {
+	directive, _ := NewEnvelope(TypeAlexaIntent, json.RawMessage(`{"directive":{"header":{"messageId":"msg-1"}}}`))
+	command, _ := NewEnvelope(TypeRunCommand, CommandPayload{CommandID: "tv-on"})
+
+	assert.Equal(t, "msg-1", messageKey(directive))
+	assert.Equal(t, command.ID, messageKey(command))
+}
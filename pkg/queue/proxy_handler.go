@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"smh-apiengine/pkg/logging"
+	"time"
+)
+
+const (
+	// headerRequestID is the header used to pass the request id to the api, so the logs can be correlated
+	headerRequestID = "X-Request-ID"
+	// headerRequestSource tells the api that the execution came from RMQ, so it is recorded in the history
+	headerRequestSource = "X-Request-Source"
+	headerAuthorization = "Authorization"
+	requestSource       = "rmq"
+	maxReplySize        = 64 * 1024
+	// defaultApiTimeout is long enough for the api to execute the scenario with the delays and answer alexa
+	defaultApiTimeout = 30 * time.Second
+)
+
+// Handler posts the messages to the api, EndPoint is the url of the alexa intents (/run/intent), the commands,
+// scenarios and control items are posted to their run routes on the same server. Token is sent as the bearer token,
+// so the api can require it. Timeout limits the whole post, 30 seconds if zero.
+type Handler struct {
+	EndPoint string
+	Token    string
+	Timeout  time.Duration
+}
+
+// Handle posts the alexa request received in json message payload to the api, which executes the matched command
+// or scenario. Returns the alexa response of the api, so the publisher can tell the user the actual outcome. The other
+// message types are posted to their run routes. The error is returned if the api is not available or does not accept
+// the request, so the message is retried or dead-lettered. The message id is passed to the api as the request id.
+func (h *Handler) Handle(msg Envelope) (string, error) {
+	endpoint, body, err := h.route(msg)
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := h.postToApi(endpoint, msg.ID, body)
+
+	if err != nil {
+		logging.WithField(logging.FieldRequestID, msg.ID).WithError(err).Errorf("Failed to post the message to api")
+	}
+
+	return reply, err
+}
+
+// route returns the url and the body to post the message to, the messages of unknown type are rejected
+func (h *Handler) route(msg Envelope) (string, []byte, error) {
+	var path string
+
+	switch msg.Type {
+	case TypeAlexaIntent:
+		return h.EndPoint, msg.Payload, nil
+	case TypeRunCommand:
+		var payload CommandPayload
+
+		if err := msg.DecodePayload(&payload); err != nil {
+			return "", nil, err
+		}
+
+		path = "/run/command/" + payload.CommandID
+	case TypeRunScenario:
+		var payload ScenarioPayload
+
+		if err := msg.DecodePayload(&payload); err != nil {
+			return "", nil, err
+		}
+
+		path = "/run/scenario/" + payload.ScenarioID
+	case TypeRunControlItem:
+		var payload ControlItemPayload
+
+		if err := msg.DecodePayload(&payload); err != nil {
+			return "", nil, err
+		}
+
+		path = "/run/item/" + payload.ControlItemID
+
+		if payload.State != "" {
+			path += "/" + payload.State
+		}
+	default:
+		return "", nil, fmt.Errorf("%w: unknown message type %q", ErrRejected, msg.Type)
+	}
+
+	base, err := url.Parse(h.EndPoint)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return base.ResolveReference(&url.URL{Path: path}).String(), nil, nil
+}
+
+func (h *Handler) postToApi(endpoint string, requestID string, body []byte) (string, error) {
+	httpReq, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(headerRequestID, requestID)
+	httpReq.Header.Set(headerRequestSource, requestSource)
+
+	if h.Token != "" {
+		httpReq.Header.Set(headerAuthorization, "Bearer "+h.Token)
+	}
+
+	client := &http.Client{Timeout: h.timeout()}
+	resp, err := client.Do(httpReq)
+
+	defer func() {
+		if resp != nil && resp.Body != nil {
+			err := resp.Body.Close()
+
+			if err != nil {
+				logging.WithError(err).Warnf("error closing response body")
+			}
+		}
+	}()
+
+	// the api not answering in time is retried like the unavailable one
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "", fmt.Errorf("api did not respond in %s", h.timeout())
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	logging.WithField(logging.FieldRequestID, requestID).Infof("Message posted to api, response status: %s", resp.Status)
+
+	// the client errors will not be fixed by the retry, e.g. the malformed request, except the rate limit
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return "", fmt.Errorf("api responded with %s", resp.Status)
+	case resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError:
+		return "", fmt.Errorf("%w: %s", ErrRejected, resp.Status)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return "", fmt.Errorf("api responded with %s", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return "", nil
+	}
+
+	reply, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxReplySize))
+	if err != nil {
+		return "", err
+	}
+
+	return string(reply), nil
+}
+
+func (h *Handler) timeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+
+	return defaultApiTimeout
+}
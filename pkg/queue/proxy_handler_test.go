@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Handler_RejectsClientErrors(t *testing.T) {
+	tests := []struct {
+		status   int
+		reply    string
+		err      bool
+		rejected bool
+	}{
+		{http.StatusOK, "{}", false, false},
+		{http.StatusAccepted, "", false, false},
+		{http.StatusBadRequest, "", true, true},
+		{http.StatusTooManyRequests, "", true, false},
+		{http.StatusServiceUnavailable, "", true, false},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			_, _ = w.Write([]byte("{}"))
+		}))
+
+		reply, err := (&Handler{EndPoint: server.URL}).Handle(Envelope{Type: TypeAlexaIntent, Payload: []byte("{}")})
+		server.Close()
+
+		assert.Equal(t, test.reply, reply, test.status)
+		assert.Equal(t, test.err, err != nil, test.status)
+		assert.Equal(t, test.rejected, errors.Is(err, ErrRejected), test.status)
+	}
+}
+
+func Test_Handler_SendsToken(t *testing.T) {
+	var authorization string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	_, err := (&Handler{EndPoint: server.URL, Token: "secret"}).Handle(Envelope{Type: TypeAlexaIntent})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer secret", authorization)
+}
+
+func Test_Handler_RetriesTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	handler := &Handler{EndPoint: server.URL, Timeout: 50 * time.Millisecond}
+	_, err := handler.Handle(Envelope{Type: TypeAlexaIntent})
+
+	assert.EqualError(t, err, "api did not respond in 50ms")
+	assert.False(t, errors.Is(err, ErrRejected))
+}
+
+func Test_Handler_RoutesByType(t *testing.T) {
+	handler := &Handler{EndPoint: "http://localhost:8787/run/intent"}
+	tests := []struct {
+		msgType  string
+		payload  string
+		endpoint string
+	}{
+		{TypeAlexaIntent, `{"request":{}}`, "http://localhost:8787/run/intent"},
+		{TypeRunCommand, `{"command_id":"tv-on"}`, "http://localhost:8787/run/command/tv-on"},
+		{TypeRunScenario, `{"scenario_id":"movie"}`, "http://localhost:8787/run/scenario/movie"},
+		{TypeRunControlItem, `{"control_item_id":"light","state":"off"}`, "http://localhost:8787/run/item/light/off"},
+	}
+
+	for _, test := range tests {
+		endpoint, _, err := handler.route(Envelope{Type: test.msgType, Payload: []byte(test.payload)})
+
+		assert.Nil(t, err, test.msgType)
+		assert.Equal(t, test.endpoint, endpoint, test.msgType)
+	}
+
+	_, err := handler.Handle(Envelope{Type: "unknown"})
+	assert.True(t, errors.Is(err, ErrRejected))
+}
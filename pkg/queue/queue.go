@@ -0,0 +1,73 @@
+// Package queue implements the parts of the messaging shared by the brokers: the message envelope, the handlers and
+// the processing of the consumed messages with the retries, deduplication and expiry
+package queue
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultMessageTTL    = 50 * time.Second
+	defaultDedupWindow   = 5 * time.Minute
+	defaultMaxMessageAge = time.Minute
+)
+
+var (
+	// ErrRejected is returned by the message handler if the message can not be handled, so it is dead-lettered
+	// without the retries
+	ErrRejected = errors.New("message rejected")
+	// ErrReplyTimeout is returned by the request if the consumer did not reply in time
+	ErrReplyTimeout = errors.New("reply timeout")
+	// ErrBuffered is returned if the broker is not available and the message is buffered to be published later
+	ErrBuffered = errors.New("broker is not available, the message is buffered")
+)
+
+// Options struct is the settings of the publishers and consumers shared by all the brokers
+type Options struct {
+	ReplyTimeout  time.Duration // how long the publisher waits for the reply, the reply is not requested if zero
+	MaxRetries    int           // how many times the failed message is handled again before it is dead-lettered
+	RetryDelay    time.Duration // delay before the retry
+	MessageTTL    time.Duration // expiration of the published messages, 50 seconds if zero
+	Source        string        // name of the publishing application, set to the source of the published envelopes
+	DedupWindow   time.Duration // how long the handled messages are remembered to drop the duplicates, 5 minutes if zero
+	MaxMessageAge time.Duration // the older messages are dropped by the consumer, a minute if zero
+}
+
+// MessageHandler handles the consumed message and returns the reply, which is sent back if the publisher requested it.
+// The message is retried if the handler fails, unless the error is ErrRejected.
+type MessageHandler interface {
+	Handle(msg Envelope) (string, error)
+}
+
+// HandlerFunc adapts the function to the MessageHandler
+type HandlerFunc func(msg Envelope) (string, error)
+
+func (f HandlerFunc) Handle(msg Envelope) (string, error) {
+	return f(msg)
+}
+
+// TTL returns the expiration of the published messages
+func (o *Options) TTL() time.Duration {
+	if o.MessageTTL > 0 {
+		return o.MessageTTL
+	}
+
+	return defaultMessageTTL
+}
+
+func (o *Options) dedupWindow() time.Duration {
+	if o.DedupWindow > 0 {
+		return o.DedupWindow
+	}
+
+	return defaultDedupWindow
+}
+
+func (o *Options) maxMessageAge() time.Duration {
+	if o.MaxMessageAge > 0 {
+		return o.MaxMessageAge
+	}
+
+	return defaultMaxMessageAge
+}
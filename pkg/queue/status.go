@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"encoding/json"
+	"net/http"
+	"smh-apiengine/pkg/logging"
+	"sync"
+	"time"
+)
+
+// Connection states of the consumer
+const (
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+	StateDisconnected = "disconnected"
+	StateStopped      = "stopped"
+)
+
+// Status struct is the connection state of the consumer exposed for the health checks
+type Status struct {
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	Reconnects int       `json:"reconnects"` // number of the connections lost since the start
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// StatusTracker keeps the connection state of the consumer
+type StatusTracker struct {
+	mu     sync.Mutex
+	status Status
+}
+
+// Status returns the current connection state of the consumer
+func (t *StatusTracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.status
+}
+
+// Set changes the connection state, the lost connections are counted and the error is kept as the last one
+func (t *StatusTracker) Set(state string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.status.State == StateConnected && state == StateDisconnected {
+		t.status.Reconnects++
+	}
+
+	if t.status.State != state {
+		t.status.Since = time.Now()
+	}
+
+	t.status.State = state
+
+	if err != nil {
+		t.status.LastError = err.Error()
+	}
+}
+
+// HealthHandler responds with the consumer status, the status code is 503 if the consumer is not connected
+func HealthHandler(status func() Status) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := status()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if current.State == StateConnected {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		err := json.NewEncoder(w).Encode(current)
+		if err != nil {
+			logging.WithError(err).Errorf("Failed to write the response")
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"context"
+	"smh-apiengine/pkg/logging"
+	"time"
+)
+
+const (
+	MinReconnectDelay = time.Second
+	MaxReconnectDelay = 30 * time.Second
+)
+
+// Supervise runs the consumer session until the context is cancelled. If the connection can not be established or is
+// lost (e.g. the broker restarts or the network is down) the session is started again with exponential backoff. The
+// session returns whether it has been connected and the reason of the disconnection, it has to set the connected
+// state itself. The name of the broker is used in the logs.
+func Supervise(ctx context.Context, status *StatusTracker, name string, session func(ctx context.Context) (bool, error)) {
+	delay := MinReconnectDelay
+
+	for {
+		status.Set(StateConnecting, nil)
+
+		connected, err := session(ctx)
+
+		if ctx.Err() != nil {
+			status.Set(StateStopped, nil)
+			logging.Infof("%s consumer stopped", name)
+
+			return
+		}
+
+		// the backoff starts over once the connection has been established
+		if connected {
+			delay = MinReconnectDelay
+		}
+
+		status.Set(StateDisconnected, err)
+		logging.WithError(err).Warnf("%s consumer disconnected, reconnecting in %s", name, delay)
+
+		select {
+		case <-ctx.Done():
+			status.Set(StateStopped, nil)
+
+			return
+		case <-time.After(delay):
+		}
+
+		delay = NextReconnectDelay(delay)
+	}
+}
+
+// NextReconnectDelay doubles the delay up to the max reconnect delay
+func NextReconnectDelay(delay time.Duration) time.Duration {
+	delay *= 2
+
+	if delay > MaxReconnectDelay {
+		return MaxReconnectDelay
+	}
+
+	return delay
+}
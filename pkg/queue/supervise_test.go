@@ -0,0 +1,19 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NextReconnectDelay(t *testing.T) {
+	delay := MinReconnectDelay
+
+	for i := 0; i < 10; i++ {
+		delay = NextReconnectDelay(delay)
+	}
+
+	assert.Equal(t, MaxReconnectDelay, delay)
+	assert.Equal(t, 2*time.Second, NextReconnectDelay(time.Second))
+}
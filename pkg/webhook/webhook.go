@@ -0,0 +1,439 @@
+// Package webhook posts the device control events, e.g. the failed scenarios or the devices going offline, to the
+// configured urls as signed JSON payloads with retries and keeps the log of the deliveries.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/logging"
+	"sync"
+	"time"
+)
+
+const (
+	// HeaderSignature is the hex HMAC-SHA256 of the body with the webhook secret, prefixed with "sha256="
+	HeaderSignature = "X-SMH-Signature"
+	// HeaderEvent is the type of the event
+	HeaderEvent = "X-SMH-Event"
+	// HeaderDelivery is the id of the delivery, the same for all the attempts
+	HeaderDelivery = "X-SMH-Delivery"
+
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+	StatusDropped   = "dropped"
+
+	FilterSuccess = "success"
+	FilterError   = "error"
+
+	DefaultMaxRetries = 3
+	DefaultRetryDelay = 2 * time.Second
+	DefaultTimeout    = 10 * time.Second
+	DefaultLogSize    = 100
+
+	maxRetryDelay = time.Minute
+	queueSize     = 100
+)
+
+var errWebhookURL = errors.New("webhook url must be an absolute http or https url")
+
+// Webhook struct is the url the matching events are posted to, the empty filters match all the events
+type Webhook struct {
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	Secret  string            `json:"secret,omitempty"`  // signs the payload if set
+	Events  []string          `json:"events,omitempty"`  // event types, e.g. "scenario" or "device_status"
+	Sources []string          `json:"sources,omitempty"` // sources of the executions, e.g. "alexa"
+	IDs     []string          `json:"ids,omitempty"`     // ids of the commands, scenarios, control items or devices
+	Status  string            `json:"status,omitempty"`  // "success" or "error"
+	Headers map[string]string `json:"headers,omitempty"` // e.g. the authorization of the receiver
+}
+
+// Options struct is the delivery settings shared by the webhooks
+type Options struct {
+	MaxRetries int           // how many times the failed delivery is attempted again
+	RetryDelay time.Duration // delay before the first retry, doubled for every next one up to a minute
+	Timeout    time.Duration // timeout of a single attempt
+	LogSize    int           // number of the deliveries kept in the log
+}
+
+// Payload struct is the JSON body posted to the webhook
+type Payload struct {
+	DeliveryID string    `json:"delivery_id"`
+	Webhook    string    `json:"webhook"`
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Source     string    `json:"source,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	DeviceID   string    `json:"device_id,omitempty"`
+	State      string    `json:"state,omitempty"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// Delivery struct is the entry of the delivery log
+type Delivery struct {
+	ID         string    `json:"id"`
+	Webhook    string    `json:"webhook"`
+	Type       string    `json:"type"`
+	EventID    string    `json:"event_id"`
+	Time       time.Time `json:"time"`
+	Status     string    `json:"status"`
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Dispatcher struct posts the events to the webhooks. Every webhook has its own queue, so the slow or unavailable
+// receiver does not delay the others, and the events are delivered to it in order.
+type Dispatcher struct {
+	webhooks []Webhook
+	options  Options
+	client   *http.Client
+	queues   []chan Payload
+	mu       sync.Mutex
+	log      []Delivery
+}
+
+// file struct is the content of the webhooks file
+type file struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// LoadWebhooks reads the webhooks from the JSON file {"webhooks": [...]}
+func LoadWebhooks(fileName string) ([]Webhook, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var webhooks file
+
+	err = json.Unmarshal(content, &webhooks)
+	if err != nil {
+		return nil, fmt.Errorf("webhooks file %s: %w", fileName, err)
+	}
+
+	for i, webhook := range webhooks.Webhooks {
+		err = webhook.validate()
+		if err != nil {
+			return nil, fmt.Errorf("webhook %d in %s: %w", i+1, fileName, err)
+		}
+
+		if webhook.Name == "" {
+			webhooks.Webhooks[i].Name = webhook.URL
+		}
+	}
+
+	return webhooks.Webhooks, nil
+}
+
+func NewDispatcher(webhooks []Webhook, options Options) *Dispatcher {
+	dispatcher := &Dispatcher{
+		webhooks: webhooks,
+		options:  options,
+		client:   &http.Client{Timeout: options.Timeout},
+		queues:   make([]chan Payload, len(webhooks)),
+	}
+
+	if dispatcher.client.Timeout <= 0 {
+		dispatcher.client.Timeout = DefaultTimeout
+	}
+
+	for i := range dispatcher.queues {
+		dispatcher.queues[i] = make(chan Payload, queueSize)
+	}
+
+	return dispatcher
+}
+
+// Listen queues the event for the matching webhooks, it is meant to be subscribed to device control. The event is
+// dropped for the webhook with the full queue, so the executions are never blocked by the receivers.
+func (dispatcher *Dispatcher) Listen(event devicecontrol.Event) {
+	for i, webhook := range dispatcher.webhooks {
+		if !webhook.matches(event) {
+			continue
+		}
+
+		payload := newPayload(webhook, event)
+		delivery := Delivery{
+			ID:      payload.DeliveryID,
+			Webhook: webhook.Name,
+			Type:    payload.Type,
+			EventID: payload.ID,
+			Time:    payload.Time,
+			Status:  StatusPending,
+		}
+
+		select {
+		case dispatcher.queues[i] <- payload:
+		default:
+			delivery.Status, delivery.Error = StatusDropped, "queue is full"
+			logging.Warnf("Webhook %s queue is full, the %s event is dropped", webhook.Name, payload.Type)
+		}
+
+		dispatcher.record(delivery)
+	}
+}
+
+// Run delivers the queued events until the context is cancelled, the deliveries waiting for the retry are abandoned
+func (dispatcher *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := range dispatcher.webhooks {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case payload := <-dispatcher.queues[i]:
+					dispatcher.deliver(ctx, dispatcher.webhooks[i], payload)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+// Deliveries returns up to the limit of the logged deliveries, newest first
+func (dispatcher *Dispatcher) Deliveries(limit int) []Delivery {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+
+	deliveries := []Delivery{}
+
+	for i := len(dispatcher.log) - 1; i >= 0 && (limit <= 0 || len(deliveries) < limit); i-- {
+		deliveries = append(deliveries, dispatcher.log[i])
+	}
+
+	return deliveries
+}
+
+// Webhooks returns the configured webhooks
+func (dispatcher *Dispatcher) Webhooks() []Webhook {
+	return dispatcher.webhooks
+}
+
+// deliver posts the payload and retries it with the exponential backoff if the receiver is not available or responds
+// with 5xx or 429 status, the other client errors are not retried
+func (dispatcher *Dispatcher) deliver(ctx context.Context, webhook Webhook, payload Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logging.WithError(err).Errorf("Failed to marshal the webhook payload")
+
+		return
+	}
+
+	logger := logging.WithFields(logging.Fields{"webhook": webhook.Name, "delivery": payload.DeliveryID})
+	delay := dispatcher.options.RetryDelay
+
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+
+	delivery := Delivery{ID: payload.DeliveryID, Webhook: webhook.Name, Type: payload.Type, EventID: payload.ID,
+		Time: payload.Time}
+
+	for {
+		delivery.Attempts++
+
+		var retry bool
+
+		delivery.StatusCode, retry, err = dispatcher.post(ctx, webhook, payload, body)
+
+		if err == nil {
+			delivery.Status, delivery.Error = StatusDelivered, ""
+			dispatcher.record(delivery)
+			logger.Infof("Webhook %s event delivered in %d attempt(s)", payload.Type, delivery.Attempts)
+
+			return
+		}
+
+		delivery.Status, delivery.Error = StatusFailed, err.Error()
+
+		if !retry || delivery.Attempts > dispatcher.options.MaxRetries {
+			dispatcher.record(delivery)
+			logger.WithError(err).Warnf("Webhook %s event not delivered after %d attempt(s)", payload.Type,
+				delivery.Attempts)
+
+			return
+		}
+
+		delivery.Status = StatusPending
+		dispatcher.record(delivery)
+		logger.WithError(err).Debugf("Webhook delivery failed, retrying in %s", delay)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// post makes a single delivery attempt, returns the response status and whether the failed attempt is worth
+// retrying
+func (dispatcher *Dispatcher) post(ctx context.Context, webhook Webhook, payload Payload, body []byte) (int, bool, error) {
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+
+	request = request.WithContext(ctx)
+
+	for name, value := range webhook.Headers {
+		request.Header.Set(name, value)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderEvent, payload.Type)
+	request.Header.Set(HeaderDelivery, payload.DeliveryID)
+
+	if webhook.Secret != "" {
+		request.Header.Set(HeaderSignature, Sign(webhook.Secret, body))
+	}
+
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		return 0, true, err
+	}
+
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64*1024))
+	_ = response.Body.Close()
+
+	switch {
+	case response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusMultipleChoices:
+		return response.StatusCode, false, nil
+	case response.StatusCode == http.StatusTooManyRequests, response.StatusCode >= http.StatusInternalServerError:
+		return response.StatusCode, true, fmt.Errorf("webhook responded with %s", response.Status)
+	}
+
+	return response.StatusCode, false, fmt.Errorf("webhook responded with %s", response.Status)
+}
+
+// record adds the delivery to the log or updates its entry, the oldest entries exceeding the log size are removed
+func (dispatcher *Dispatcher) record(delivery Delivery) {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+
+	for i := len(dispatcher.log) - 1; i >= 0; i-- {
+		if dispatcher.log[i].ID == delivery.ID {
+			dispatcher.log[i] = delivery
+
+			return
+		}
+	}
+
+	size := dispatcher.options.LogSize
+	if size <= 0 {
+		size = DefaultLogSize
+	}
+
+	dispatcher.log = append(dispatcher.log, delivery)
+
+	if len(dispatcher.log) > size {
+		dispatcher.log = append([]Delivery(nil), dispatcher.log[len(dispatcher.log)-size:]...)
+	}
+}
+
+// Sign returns the signature header value of the body, the receivers compute it the same way with the shared secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newPayload(webhook Webhook, event devicecontrol.Event) Payload {
+	payload := Payload{
+		DeliveryID: logging.NewRequestID(),
+		Webhook:    webhook.Name,
+		Time:       event.Time,
+		Type:       event.Type,
+		ID:         event.ID,
+		Name:       event.Name,
+		Source:     event.Source,
+		Actor:      event.Actor,
+		DeviceID:   event.DeviceID,
+		State:      event.State,
+		Success:    !event.Failed(),
+		DurationMs: event.Duration.Milliseconds(),
+		RequestID:  event.RequestID,
+	}
+
+	if event.Failed() {
+		payload.Error = event.Err.Error()
+	}
+
+	return payload
+}
+
+func (webhook Webhook) validate() error {
+	target, err := url.Parse(webhook.URL)
+	if err != nil || !target.IsAbs() || (target.Scheme != "http" && target.Scheme != "https") {
+		return errWebhookURL
+	}
+
+	if webhook.Status != "" && webhook.Status != FilterSuccess && webhook.Status != FilterError {
+		return fmt.Errorf("status must be either \"%s\" or \"%s\"", FilterSuccess, FilterError)
+	}
+
+	return nil
+}
+
+func (webhook Webhook) matches(event devicecontrol.Event) bool {
+	switch {
+	case !contains(webhook.Events, event.Type),
+		!contains(webhook.Sources, event.Source),
+		!contains(webhook.IDs, event.ID),
+		webhook.Status == FilterSuccess && event.Failed(),
+		webhook.Status == FilterError && !event.Failed():
+		return false
+	}
+
+	return true
+}
+
+// contains checks whether the filter values contain the value, the empty filter contains all the values
+func contains(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,246 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"smh-apiengine/pkg/devicecontrol"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver struct is the test webhook server responding with the queued status codes, then with 200
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newReceiver(statuses ...int) *receiver {
+	return &receiver{statuses: statuses, received: make(chan struct{}, 100)}
+}
+
+func (receiver *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	receiver.mu.Lock()
+	receiver.requests = append(receiver.requests, r)
+	receiver.bodies = append(receiver.bodies, body)
+
+	status := http.StatusOK
+	if len(receiver.statuses) > 0 {
+		status, receiver.statuses = receiver.statuses[0], receiver.statuses[1:]
+	}
+	receiver.mu.Unlock()
+
+	w.WriteHeader(status)
+	receiver.received <- struct{}{}
+}
+
+func (receiver *receiver) wait(t *testing.T, count int) {
+	for i := 0; i < count; i++ {
+		select {
+		case <-receiver.received:
+		case <-time.After(3 * time.Second):
+			t.Fatalf("webhook received %d of %d requests", i, count)
+		}
+	}
+}
+
+// waitDelivery waits until the delivery of the webhook is no longer pending
+func waitDelivery(t *testing.T, dispatcher *Dispatcher, webhook string) Delivery {
+	timeout := time.After(3 * time.Second)
+
+	for {
+		for _, delivery := range dispatcher.Deliveries(0) {
+			if delivery.Webhook == webhook && delivery.Status != StatusPending {
+				return delivery
+			}
+		}
+
+		select {
+		case <-timeout:
+			t.Fatalf("webhook %s delivery not finished", webhook)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func Test_Webhook_matches(t *testing.T) {
+	failed := devicecontrol.Event{Type: devicecontrol.EventScenario, ID: "tv-on", Source: devicecontrol.SourceSchedule,
+		Err: errors.New("timeout")}
+	offline := devicecontrol.Event{Type: devicecontrol.EventDeviceStatus, ID: "00:11:22:33:44:55",
+		State: devicecontrol.DeviceOffline, Err: errors.New("timeout")}
+	scheduled := devicecontrol.Event{Type: devicecontrol.EventCommand, ID: "tv-off", Source: devicecontrol.SourceSchedule}
+
+	tests := []struct {
+		webhook Webhook
+		event   devicecontrol.Event
+		matches bool
+	}{
+		{Webhook{}, failed, true},
+		{Webhook{Events: []string{devicecontrol.EventScenario}, Status: FilterError}, failed, true},
+		{Webhook{Events: []string{devicecontrol.EventScenario}, Status: FilterSuccess}, failed, false},
+		{Webhook{Events: []string{devicecontrol.EventScenario}}, offline, false},
+		{Webhook{Events: []string{devicecontrol.EventDeviceStatus}}, offline, true},
+		{Webhook{Sources: []string{devicecontrol.SourceSchedule}}, scheduled, true},
+		{Webhook{Sources: []string{devicecontrol.SourceSchedule}}, offline, false},
+		{Webhook{IDs: []string{"tv-on", "tv-off"}}, scheduled, true},
+		{Webhook{IDs: []string{"tv-on"}}, scheduled, false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.matches, test.webhook.matches(test.event))
+	}
+}
+
+func Test_LoadWebhooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smh-webhook")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "webhooks.json")
+
+	assert.Nil(t, ioutil.WriteFile(fileName, []byte(`{"webhooks": [
+		{"name": "alerts", "url": "https://example.com/hook", "events": ["scenario"], "status": "error"},
+		{"url": "http://example.com/schedule", "sources": ["schedule"]}]}`), 0600))
+
+	webhooks, err := LoadWebhooks(fileName)
+	assert.Nil(t, err)
+	assert.Len(t, webhooks, 2)
+	assert.Equal(t, []string{"scenario"}, webhooks[0].Events)
+	assert.Equal(t, "http://example.com/schedule", webhooks[1].Name)
+
+	assert.Nil(t, ioutil.WriteFile(fileName, []byte(`{"webhooks": [{"url": "example.com/hook"}]}`), 0600))
+	_, err = LoadWebhooks(fileName)
+	assert.True(t, errors.Is(err, errWebhookURL))
+
+	assert.Nil(t, ioutil.WriteFile(fileName, []byte(`{"webhooks": [{"url": "http://example.com", "status": "ok"}]}`), 0600))
+	_, err = LoadWebhooks(fileName)
+	assert.NotNil(t, err)
+}
+
+func Test_Dispatcher(t *testing.T) {
+	alerts := newReceiver(http.StatusInternalServerError, http.StatusTooManyRequests)
+	rejected := newReceiver(http.StatusBadRequest)
+
+	alertsServer := httptest.NewServer(alerts)
+	defer alertsServer.Close()
+
+	rejectedServer := httptest.NewServer(rejected)
+	defer rejectedServer.Close()
+
+	dispatcher := NewDispatcher([]Webhook{
+		{Name: "alerts", URL: alertsServer.URL, Secret: "secret", Status: FilterError,
+			Headers: map[string]string{"Authorization": "Bearer token"}},
+		{Name: "rejected", URL: rejectedServer.URL, Events: []string{devicecontrol.EventScenario}},
+	}, Options{MaxRetries: 3, RetryDelay: time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		dispatcher.Run(ctx)
+	}()
+
+	dispatcher.Listen(devicecontrol.Event{Type: devicecontrol.EventScenario, ID: "tv-on", Name: "TV on",
+		Source: devicecontrol.SourceSchedule, Err: errors.New("device not responding"), Duration: 1500 * time.Millisecond})
+
+	// the server errors are retried until the delivery succeeds
+	alerts.wait(t, 3)
+
+	delivery := waitDelivery(t, dispatcher, "alerts")
+	assert.Equal(t, StatusDelivered, delivery.Status)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Equal(t, http.StatusOK, delivery.StatusCode)
+	assert.Equal(t, "tv-on", delivery.EventID)
+
+	alerts.mu.Lock()
+	request, body := alerts.requests[2], alerts.bodies[2]
+	alerts.mu.Unlock()
+
+	assert.Equal(t, Sign("secret", body), request.Header.Get(HeaderSignature))
+	assert.Equal(t, devicecontrol.EventScenario, request.Header.Get(HeaderEvent))
+	assert.Equal(t, delivery.ID, request.Header.Get(HeaderDelivery))
+	assert.Equal(t, "Bearer token", request.Header.Get("Authorization"))
+
+	var payload Payload
+
+	assert.Nil(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, "TV on", payload.Name)
+	assert.Equal(t, devicecontrol.SourceSchedule, payload.Source)
+	assert.False(t, payload.Success)
+	assert.Equal(t, "device not responding", payload.Error)
+	assert.Equal(t, int64(1500), payload.DurationMs)
+
+	// the client errors are not retried
+	rejected.wait(t, 1)
+
+	delivery = waitDelivery(t, dispatcher, "rejected")
+	assert.Equal(t, StatusFailed, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusBadRequest, delivery.StatusCode)
+
+	rejected.mu.Lock()
+	assert.Empty(t, rejected.requests[0].Header.Get(HeaderSignature))
+	rejected.mu.Unlock()
+
+	// the successful command matches none of the webhooks
+	dispatcher.Listen(devicecontrol.Event{Type: devicecontrol.EventCommand, ID: "tv-off"})
+	assert.Len(t, dispatcher.Deliveries(0), 2)
+	assert.Len(t, dispatcher.Deliveries(1), 1)
+
+	cancel()
+	<-stopped
+}
+
+func Test_Dispatcher_retries(t *testing.T) {
+	unavailable := newReceiver(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+
+	server := httptest.NewServer(unavailable)
+	defer server.Close()
+
+	dispatcher := NewDispatcher([]Webhook{{Name: "unavailable", URL: server.URL}},
+		Options{MaxRetries: 2, RetryDelay: time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go dispatcher.Run(ctx)
+
+	dispatcher.Listen(devicecontrol.Event{Type: devicecontrol.EventDeviceStatus, State: devicecontrol.DeviceOffline})
+
+	unavailable.wait(t, 3)
+
+	delivery := waitDelivery(t, dispatcher, "unavailable")
+	assert.Equal(t, StatusFailed, delivery.Status)
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Equal(t, http.StatusBadGateway, delivery.StatusCode)
+}
+
+func Test_Dispatcher_log(t *testing.T) {
+	dispatcher := NewDispatcher([]Webhook{{Name: "hook", URL: "http://127.0.0.1:1"}}, Options{LogSize: 3})
+
+	// nothing delivers the queue, so the events exceeding it are dropped
+	for i := 0; i < queueSize+2; i++ {
+		dispatcher.Listen(devicecontrol.Event{Type: devicecontrol.EventCommand})
+	}
+
+	deliveries := dispatcher.Deliveries(0)
+	assert.Len(t, deliveries, 3)
+	assert.Equal(t, StatusDropped, deliveries[0].Status)
+	assert.Equal(t, StatusDropped, deliveries[1].Status)
+	assert.Equal(t, StatusPending, deliveries[2].Status)
+}
@@ -1,39 +1,96 @@
 package webserver
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/gobwas/ws"
-	"github.com/gobwas/ws/wsutil"
 	"github.com/gorilla/mux"
 	"io"
-	"log"
-	"math"
+	"io/ioutil"
 	"net/http"
 	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/auth"
 	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/history"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/metrics"
+	"smh-apiengine/pkg/webhook"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type ApiRouteHandlers struct {
 	dataProvider *devicecontrol.DeviceControl
 	middleware []mux.MiddlewareFunc
+	authMiddleware *AuthMiddleware
 	router *mux.Router
 	routesInited time.Time
+	tasks sync.WaitGroup
+	pending int64
+	stateHub *stateHub
+	history *history.Store
+	webhooks *webhook.Dispatcher
+	alexaVerifier *alexakit.Verifier
+	alexaResponseTimeout time.Duration
 }
 
-func NewApiRouteHandlers(config *ServerConfig, deviceControl *devicecontrol.DeviceControl) *ApiRouteHandlers  {
-	authMiddleware := AuthMiddleware{Token: config.Token}
+func NewApiRouteHandlers(
+	config *ServerConfig,
+	deviceControl *devicecontrol.DeviceControl,
+	tokens *auth.TokenStore,
+	historyStore *history.Store,
+	webhooks *webhook.Dispatcher) *ApiRouteHandlers  {
+	rateLimiter := NewRateLimiter(config.RateLimit)
+	publicPaths := []string{"/", "/ui", "/healthz", "/readyz"}
+
+	var alexaVerifier *alexakit.Verifier
+
+	if config.Alexa.Enabled {
+		// alexa can not send the token, its requests are authenticated by the signature instead
+		alexaVerifier = alexakit.NewVerifier(config.Alexa)
+		publicPaths = append(publicPaths, "/run/intent")
+	}
+
+	authMiddleware := &AuthMiddleware{
+		Token: config.Token,
+		Tokens: tokens,
+		Failures: rateLimiter,
+		PublicPaths: publicPaths}
 	headersMiddleware := HeadersMiddleware{}
 	middleware := []mux.MiddlewareFunc{
+		RequestIDMiddleware,
+		headersMiddleware.Middleware,
+		rateLimiter.IPMiddleware,
 		authMiddleware.Middleware,
-		headersMiddleware.Middleware}
+		rateLimiter.TokenMiddleware}
 
-	return &ApiRouteHandlers{
+	apiHandlers := &ApiRouteHandlers{
 		dataProvider: deviceControl,
 		middleware:middleware,
+		authMiddleware: authMiddleware,
 		router:mux.NewRouter(),
-		routesInited: time.Now()}
+		routesInited: time.Now(),
+		stateHub: newStateHub(),
+		history: historyStore,
+		webhooks: webhooks,
+		alexaVerifier: alexaVerifier,
+		alexaResponseTimeout: config.AlexaResponseTimeout}
+
+	deviceControl.Subscribe(apiHandlers.stateHub.listen)
+
+	if historyStore != nil {
+		deviceControl.Subscribe(historyStore.Listen)
+	}
+
+	if webhooks != nil {
+		deviceControl.Subscribe(webhooks.Listen)
+	}
+
+	apiHandlers.registerMetrics(metrics.Default)
+
+	return apiHandlers
 }
 
 func (apiHandlers *ApiRouteHandlers) Router() *mux.Router {
@@ -47,18 +104,129 @@ func (apiHandlers *ApiRouteHandlers) InitRoutes()  {
 		apiHandlers.router.Use(middlewareFunc)
 	}
 
-	apiHandlers.router.HandleFunc("/uptime", apiHandlers.handleUptime)
+	apiHandlers.router.HandleFunc("/", apiHandlers.handleRoot)
+	apiHandlers.router.HandleFunc("/ui", apiHandlers.handleUI)
+	apiHandlers.router.HandleFunc("/uptime", RequireScope(auth.ScopeRead, apiHandlers.handleUptime))
+	apiHandlers.router.HandleFunc("/healthz", apiHandlers.handleHealth)
+	apiHandlers.router.HandleFunc("/readyz", apiHandlers.handleReady)
+	apiHandlers.router.HandleFunc("/metrics", RequireScope(auth.ScopeRead, apiHandlers.handleMetrics))
 
 	// Run routes
-	apiHandlers.router.HandleFunc("/run/command/{commandId}", apiHandlers.handleRunCommand)
-	apiHandlers.router.HandleFunc("/run/scenario/{scenarioId}", apiHandlers.handleRunScenario)
-	apiHandlers.router.HandleFunc("/run/intent", apiHandlers.handleRunIntent).Methods("POST")
+	apiHandlers.router.HandleFunc("/run/command/{commandId}",
+		RequireScope(auth.ScopeRunCommands, apiHandlers.handleRunCommand))
+	apiHandlers.router.HandleFunc("/run/scenario/{scenarioId}",
+		RequireScope(auth.ScopeRunCommands, apiHandlers.handleRunScenario))
+	apiHandlers.router.HandleFunc("/run/intent",
+		apiHandlers.requireAlexaOrScope(auth.ScopeRunCommands, apiHandlers.handleRunIntent)).Methods("POST")
+	// control items check the token against the item's control in the handler
 	apiHandlers.router.HandleFunc("/run/item/{controlItemId}/{state:(?:on|off)}", apiHandlers.handleRunControlItem)
 	apiHandlers.router.HandleFunc("/run/item/{controlItemId}", apiHandlers.handleRunControlItem)
 
 	// Api routes
-	apiHandlers.router.HandleFunc("/controls", apiHandlers.handleControls)
-	apiHandlers.router.HandleFunc("/device/state", apiHandlers.handleWebsocketDeviceState)
+	apiHandlers.router.HandleFunc("/controls", RequireScope(auth.ScopeRead, apiHandlers.handleControls))
+	apiHandlers.router.HandleFunc("/controls/state", RequireScope(auth.ScopeRead, apiHandlers.handleControlsState))
+	apiHandlers.router.HandleFunc("/device/state", RequireScope(auth.ScopeRead, apiHandlers.handleWebsocketDeviceState))
+
+	if apiHandlers.history != nil {
+		apiHandlers.router.HandleFunc("/history", RequireScope(auth.ScopeAdmin, apiHandlers.handleHistory)).Methods("GET")
+	}
+
+	if apiHandlers.webhooks != nil {
+		apiHandlers.router.HandleFunc("/webhooks/deliveries",
+			RequireScope(auth.ScopeAdmin, apiHandlers.handleWebhookDeliveries)).Methods("GET")
+	}
+}
+
+// requireAlexaOrScope allows the requests authenticated with the token having provided scope (e.g. from the RMQ
+// consumer), when the alexa verification is enabled the requests without token must be signed by alexa
+func (apiHandlers *ApiRouteHandlers) requireAlexaOrScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	if apiHandlers.alexaVerifier == nil {
+		return RequireScope(scope, next)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth.FromContext(r.Context()) != nil {
+			RequireScope(scope, next)(w, r)
+
+			return
+		}
+
+		_, err := apiHandlers.alexaVerifier.VerifyHTTPRequest(r)
+
+		if err != nil {
+			logging.WithContext(r.Context()).WithError(err).Warnf(
+				"Alexa request verification failed, request from %s rejected", r.RemoteAddr)
+			w.WriteHeader(http.StatusBadRequest)
+
+			_, ioErr := io.WriteString(w, NewErrorResponse("Alexa request verification failed"))
+			if ioErr != nil {
+				logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
+			}
+
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// runAsync executes the function in a goroutine that is tracked, so the server can wait for it before exiting.
+// The function gets the context with the request values and the execution source which is not canceled when the
+// request is finished.
+func (apiHandlers *ApiRouteHandlers) runAsync(r *http.Request, sourceType string, fn func(ctx context.Context) error) {
+	apiHandlers.startTask(executionContext(r, sourceType), fn)
+}
+
+// startTask runs the execution in the background and tracks it, so the shutdown can wait for it
+func (apiHandlers *ApiRouteHandlers) startTask(ctx context.Context, fn func(ctx context.Context) error) {
+	done := apiHandlers.trackTask()
+
+	go func() {
+		defer done()
+
+		err := fn(ctx)
+
+		if err != nil {
+			logging.WithContext(ctx).WithError(err).Errorf("Execution failed")
+		}
+	}()
+}
+
+// runTask runs the execution and waits for it, tracked like the background ones. The consumer acknowledges the
+// message only after the execution, so the failed one is retried.
+func (apiHandlers *ApiRouteHandlers) runTask(ctx context.Context, fn func(ctx context.Context) error) error {
+	done := apiHandlers.trackTask()
+	defer done()
+
+	return fn(ctx)
+}
+
+// trackTask counts the execution as pending until the returned function is called
+func (apiHandlers *ApiRouteHandlers) trackTask() func() {
+	apiHandlers.tasks.Add(1)
+	atomic.AddInt64(&apiHandlers.pending, 1)
+
+	return func() {
+		atomic.AddInt64(&apiHandlers.pending, -1)
+		apiHandlers.tasks.Done()
+	}
+}
+
+// Wait blocks until all the running commands and scenarios started by the handlers are finished or the context is done
+func (apiHandlers *ApiRouteHandlers) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		apiHandlers.tasks.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // handleNotFound used for not found responses
@@ -66,42 +234,33 @@ func (apiHandlers *ApiRouteHandlers) handleNotFound(w http.ResponseWriter, r *ht
 	_, ioErr := io.WriteString(w, NewErrorResponse("Resource not found"))
 
 	if ioErr != nil {
-		log.Println(ioErr)
+		logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
 	}
 }
 
-// handlePing simple handler that returns a so called "pong" response
+// handleUptime returns the time since the server has been started
 func (apiHandlers *ApiRouteHandlers) handleUptime(w http.ResponseWriter, r *http.Request) {
 	type uptime struct {
 		Since time.Duration `json:"uptime"`
 		StartedOn string `json:"started_on"`
 	}
 
-	days := 0
 	uptimeData := uptime{
 		Since: time.Since(apiHandlers.routesInited),
-		StartedOn: fmt.Sprintf(
-			"%d.%d.%d at %d:%d",
-			apiHandlers.routesInited.Day(),
-			apiHandlers.routesInited.Month(),
-			apiHandlers.routesInited.Year(),
-			apiHandlers.routesInited.Hour(),
-			apiHandlers.routesInited.Minute())}
+		StartedOn: apiHandlers.routesInited.Format("02.01.2006 at 15:04")}
 
-	if uptimeData.Since.Hours() >= 24 {
-		days = int(math.Round(uptimeData.Since.Hours() / 24))
-	}
+	totalHours := int(uptimeData.Since.Hours())
 
 	_, ioErr := io.WriteString(w, NewSuccessResponse(
 		fmt.Sprintf(
-			"Uptime %d day(s), %.f hour(s), %.f minute(s), %.f second(s)",
-			days,
-			uptimeData.Since.Hours(),
-			uptimeData.Since.Minutes(),
-			uptimeData.Since.Seconds()),
+			"Uptime %d day(s), %d hour(s), %d minute(s), %d second(s)",
+			totalHours / 24,
+			totalHours % 24,
+			int(uptimeData.Since.Minutes()) % 60,
+			int(uptimeData.Since.Seconds()) % 60),
 			uptimeData))
 	if ioErr != nil {
-		log.Println(ioErr)
+		logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
 	}
 }
 
@@ -112,54 +271,121 @@ func (apiHandlers *ApiRouteHandlers) handleControls(w http.ResponseWriter, r *ht
 	_, ioErr := io.WriteString(w, NewSuccessResponse("controls", apiHandlers.dataProvider.AllControls()))
 
 	if ioErr != nil {
-		log.Println(ioErr)
+		logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
 	}
 }
 
-// handleRunIntent api action that accepts alexa request JSON and tries to execute matched scenario or command
-func (apiHandlers *ApiRouteHandlers) handleRunIntent(w http.ResponseWriter, r *http.Request) {
+// handleControlsState returns the active states of the control items by the item id
+func (apiHandlers *ApiRouteHandlers) handleControlsState(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
-	alexaRequestIntent, err := alexakit.NewAlexaRequestIntent(r)
+	_, ioErr := io.WriteString(w, NewSuccessResponse("states", apiHandlers.dataProvider.ControlItemStates()))
 
+	if ioErr != nil {
+		logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
+	}
+}
+
+// handleRunIntent api action that accepts alexa request JSON and tries to execute matched scenario or command. The
+// response is the alexa response JSON, so the endpoint can be used as the skill endpoint. The smart home skill api
+// directives forwarded by the RMQ consumer are accepted too.
+func (apiHandlers *ApiRouteHandlers) handleRunIntent(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, alexakit.MaxRequestSize))
 	if err != nil {
-		_, ioErr := io.WriteString(w, NewErrorResponse("Failed to accept POST body of alexa intent"))
+		logging.WithContext(r.Context()).WithError(err).Warnf("Failed to read the request body")
+		w.WriteHeader(http.StatusBadRequest)
 
-		if ioErr != nil {
-			log.Println(ioErr)
-		}
+		return
+	}
+
+	ctx := executionContext(r, devicecontrol.SourceAlexa)
 
-		log.Println(err)
+	if alexakit.IsSmartHomeRequest(body) {
+		apiHandlers.writeSmartHomeResponse(w, r, apiHandlers.runSmartHomeDirective(ctx, body))
 
 		return
 	}
 
-	simpleAlexaIntent, err := apiHandlers.dataProvider.NewSimpleRequestIntent(alexaRequestIntent)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	alexaRequestIntent, err := alexakit.NewAlexaRequestIntent(r)
 
+	// the body that is not an alexa request can not be answered by the speech, so it is rejected
 	if err != nil {
-		_, ioErr := io.WriteString(w, NewErrorResponse("Failed to create a simple alexa request intent"))
+		logging.WithContext(r.Context()).WithError(err).Warnf("Failed to parse alexa request")
+		w.WriteHeader(http.StatusBadRequest)
 
-		if ioErr != nil {
-			log.Println(ioErr)
-		}
+		return
+	}
 
-		log.Println(err)
+	apiHandlers.writeAlexaResponse(w, r, apiHandlers.runAlexaRequest(ctx, alexaRequestIntent))
+}
 
-		return
+// runAlexaRequest answers the standard requests, continues the dialog or executes the matched scenario or command
+// and returns the response telling its outcome. The context is the execution context with the request source.
+func (apiHandlers *ApiRouteHandlers) runAlexaRequest(ctx context.Context,
+	alexaRequestIntent alexakit.AlexaRequest) alexakit.AlexaResponse {
+	if response, ok := alexakit.StandardResponse(alexaRequestIntent); ok {
+		return response
 	}
 
-	go func() {
-		err = apiHandlers.dataProvider.HandleAlexaRequest(simpleAlexaIntent)
+	if alexaRequestIntent.Request.Type == alexakit.RequestTypeCanFulfillIntent {
+		return alexakit.NewCanFulfillResponse(apiHandlers.dataProvider.CanFulfillIntent(alexaRequestIntent))
+	}
 
-		if err != nil {
-			log.Println(err)
-		}
-	}()
+	locale := alexaRequestIntent.Request.Locale
+	simpleAlexaIntent, dialogResponse, err := apiHandlers.dataProvider.ResolveAlexaIntent(alexaRequestIntent)
+
+	if err != nil {
+		logging.WithContext(ctx).WithError(err).Warnf("Failed to match alexa intent")
+
+		return devicecontrol.AlexaOutcomeResponse(err, locale)
+	}
+
+	if dialogResponse != nil {
+		return *dialogResponse
+	}
 
-	_, err = io.WriteString(w, NewSuccessResponse("intent executed", nil))
+	outcome := make(chan error, 1)
+
+	apiHandlers.startTask(ctx, func(ctx context.Context) error {
+		err := apiHandlers.dataProvider.HandleAlexaRequest(ctx, simpleAlexaIntent)
+		outcome <- err
+
+		return err
+	})
+
+	timeout := apiHandlers.alexaResponseTimeout
+	if timeout <= 0 {
+		timeout = DefaultAlexaResponseTimeout
+	}
+
+	// alexa has to get the answer in 8 seconds, long executions (e.g. scenarios with delays) keep running after the
+	// optimistic confirmation is sent
+	select {
+	case err = <-outcome:
+		return devicecontrol.AlexaOutcomeResponse(err, locale)
+	case <-time.After(timeout):
+		return alexakit.NewPlainTextSpeechResponse(alexakit.Speech(locale).Confirmation)
+	}
+}
+
+func (apiHandlers *ApiRouteHandlers) writeAlexaResponse(w http.ResponseWriter, r *http.Request,
+	alexaResponse alexakit.AlexaResponse) {
+	responseJson, err := alexaResponse.ToJson()
 
 	if err != nil {
-		log.Println(err)
+		logging.WithContext(r.Context()).WithError(err).Errorf("Failed to build the response")
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.WriteString(w, responseJson)
+
+	if err != nil {
+		logging.WithContext(r.Context()).WithError(err).Errorf("Failed to write the response")
 	}
 }
 
@@ -176,24 +402,20 @@ func (apiHandlers *ApiRouteHandlers) handleRunCommand(w http.ResponseWriter, r *
 			fmt.Sprintf("Command with id %s was not found", commandID)))
 
 		if ioErr != nil {
-			log.Println(ioErr)
+			logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
 		}
 
 		return
 	}
 
-	go func() {
-		err := apiHandlers.dataProvider.ExecCommandFullCycle(*cmd)
-
-		if err != nil {
-			log.Println(err)
-		}
-	}()
+	apiHandlers.runAsync(r, devicecontrol.SourceHTTP, func(ctx context.Context) error {
+		return apiHandlers.dataProvider.ExecCommandFullCycle(ctx, *cmd)
+	})
 
 	_, err := io.WriteString(w, NewSuccessResponse("command executed", nil))
 
 	if err != nil {
-		log.Println(err)
+		logging.WithContext(r.Context()).WithError(err).Errorf("Failed to write the response")
 	}
 }
 
@@ -210,36 +432,40 @@ func (apiHandlers *ApiRouteHandlers) handleRunScenario(w http.ResponseWriter, r
 			fmt.Sprintf("Scenario with id %s was not found", scenarioID)))
 
 		if ioErr != nil {
-			log.Println(err)
+			logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
 		}
 
-		log.Println(err)
+		logging.WithContext(r.Context()).WithError(err).Warnf("Scenario not found")
 
 		return
 	}
 
-	go func() {
-		err = apiHandlers.dataProvider.ExecScenarioFullCycle(scenario)
-
-		if err != nil {
-			log.Println(err)
-		}
-	}()
+	apiHandlers.runAsync(r, devicecontrol.SourceHTTP, func(ctx context.Context) error {
+		return apiHandlers.dataProvider.ExecScenarioFullCycle(ctx, scenario)
+	})
 
 	_, err = io.WriteString(w, NewSuccessResponse("scenario executed", nil))
 
 	if err != nil {
-		log.Println(err)
+		logging.WithContext(r.Context()).WithError(err).Errorf("Failed to write the response")
 	}
 }
 
-// handleRunCommand api action that accepts command id and tries to execute matched command
+// handleRunControlItem api action that accepts control item id and tries to execute the item's next or requested state
 func (apiHandlers *ApiRouteHandlers) handleRunControlItem(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-
 	vars := mux.Vars(r)
 	controlItemID := vars["controlItemId"]
 	state := vars["state"]
+
+	token := auth.FromContext(r.Context())
+	if token != nil && !token.AllowsControl(apiHandlers.dataProvider.FindControlIDByItemID(controlItemID)) {
+		Forbidden(w, r)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
 	controlItem := apiHandlers.dataProvider.FindControlItemByID(controlItemID)
 
 	if controlItem == nil {
@@ -247,65 +473,39 @@ func (apiHandlers *ApiRouteHandlers) handleRunControlItem(w http.ResponseWriter,
 			fmt.Sprintf("Control item with id %s was not found", controlItemID)))
 
 		if ioErr != nil {
-			log.Println(ioErr)
+			logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
 		}
 
 		return
 	}
 
-	go func() {
-		err := apiHandlers.dataProvider.ExecControlItem(controlItem, state)
-
-		if err != nil {
-			log.Println(err)
-		}
-	}()
+	apiHandlers.runAsync(r, devicecontrol.SourceHTTP, func(ctx context.Context) error {
+		return apiHandlers.dataProvider.ExecControlItem(ctx, controlItem, state)
+	})
 
 	_, err := io.WriteString(w, NewSuccessResponse("control item executed", nil))
 
 	if err != nil {
-		log.Println(err)
+		logging.WithContext(r.Context()).WithError(err).Errorf("Failed to write the response")
 	}
 }
 
+// handleWebsocketDeviceState upgrades the connection to websocket and subscribes it to the state changes
 func (apiHandlers *ApiRouteHandlers) handleWebsocketDeviceState(w http.ResponseWriter, r *http.Request)  {
-	conn, _, _, err := ws.UpgradeHTTP(r, w)
+	// the browsers pass the token as websocket subprotocol, which has to be confirmed
+	upgrader := ws.HTTPUpgrader{
+		Protocol: func(protocol string) bool {
+			return protocol == wsBearerProtocol
+		},
+	}
+
+	conn, _, _, err := upgrader.Upgrade(r, w)
 
 	if err != nil {
-		log.Println(err)
+		logging.WithContext(r.Context()).WithError(err).Errorf("Failed to upgrade the connection to websocket")
 
 		return
 	}
 
-	go func() {
-		defer func() {
-			err = conn.Close()
-			if err != nil {
-				log.Println("Failed to close the connection")
-			}
-		}()
-
-		for {
-			if len(apiHandlers.dataProvider.DeviceStateBuffer) == 0 {
-				continue
-			}
-
-			for time, bufferItem := range apiHandlers.dataProvider.DeviceStateBuffer {
-				jsonStr, err := json.Marshal(bufferItem)
-
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-
-				err = wsutil.WriteServerText(conn, jsonStr)
-
-				if err != nil {
-					log.Println(err)
-				}
-
-				delete(apiHandlers.dataProvider.DeviceStateBuffer, time)
-			}
-		}
-	}()
-}
\ No newline at end of file
+	apiHandlers.stateHub.serve(conn)
+}
@@ -0,0 +1,37 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/devicecontrol"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_handleRunIntent_RejectsMalformedBody(t *testing.T) {
+	deviceControl := devicecontrol.NewDeviceControl(&devicecontrol.Config{})
+	apiHandlers := NewApiRouteHandlers(&ServerConfig{}, &deviceControl, nil, nil, nil)
+
+	request := httptest.NewRequest(http.MethodPost, "/run/intent", strings.NewReader("not an alexa request"))
+	recorder := httptest.NewRecorder()
+	apiHandlers.handleRunIntent(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Empty(t, recorder.Body.String())
+}
+
+func Test_handleRunIntent_RejectsLargeBody(t *testing.T) {
+	deviceControl := devicecontrol.NewDeviceControl(&devicecontrol.Config{})
+	apiHandlers := NewApiRouteHandlers(&ServerConfig{}, &deviceControl, nil, nil, nil)
+
+	body := `{"version":"1.0","request":{"type":"LaunchRequest","locale":"en-US"},"padding":"` +
+		strings.Repeat("x", alexakit.MaxRequestSize) + `"}`
+	request := httptest.NewRequest(http.MethodPost, "/run/intent", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	apiHandlers.handleRunIntent(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
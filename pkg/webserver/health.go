@@ -0,0 +1,153 @@
+package webserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"smh-apiengine/pkg/auth"
+	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/metrics"
+	"sync/atomic"
+	"time"
+)
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// readyStatus is the readiness response of the requests that may not see the devices status
+type readyStatus struct {
+	Status string `json:"status"`
+}
+
+// handleHealth liveness check, responds with success as long as the server is able to serve requests
+func (apiHandlers *ApiRouteHandlers) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+
+	_, ioErr := io.WriteString(w, NewSuccessResponse("ok", nil))
+	if ioErr != nil {
+		logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
+	}
+}
+
+// handleReady readiness check, responds with success if the configuration is loaded and all enabled devices are
+// reachable, otherwise responds with 503 status. The devices status with their names, addresses and errors is
+// returned only to the requests with the read scope, the others get just the status as the path is public.
+func (apiHandlers *ApiRouteHandlers) handleReady(w http.ResponseWriter, r *http.Request) {
+	ready, devices := apiHandlers.dataProvider.Ready()
+	result, msg, status := responseSuccess, "ready", http.StatusOK
+
+	if !ready {
+		result, msg, status = responseError, "not ready", http.StatusServiceUnavailable
+	}
+
+	response := newResponse(result, msg, devices)
+
+	if !apiHandlers.canReadDetails(r) {
+		content, err := json.Marshal(readyStatus{Status: msg})
+		if err != nil {
+			logging.WithContext(r.Context()).WithError(err).Errorf("Failed to build the response")
+		}
+
+		response = string(content)
+	}
+
+	w.WriteHeader(status)
+
+	_, ioErr := io.WriteString(w, response)
+	if ioErr != nil {
+		logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
+	}
+}
+
+// canReadDetails checks whether the request to the public path is authenticated with the token having the read
+// scope, the requests without the token can read the details only if the authentication is not configured
+func (apiHandlers *ApiRouteHandlers) canReadDetails(r *http.Request) bool {
+	if token := auth.FromContext(r.Context()); token != nil {
+		return token.HasScope(auth.ScopeRead)
+	}
+
+	return apiHandlers.authMiddleware != nil && apiHandlers.authMiddleware.open()
+}
+
+// handleMetrics exposes the metrics in the Prometheus text format
+func (apiHandlers *ApiRouteHandlers) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+
+	_, err := metrics.Default.WriteTo(w)
+	if err != nil {
+		logging.WithContext(r.Context()).WithError(err).Errorf("Failed to write the metrics")
+	}
+}
+
+// registerMetrics registers the server metrics and collects the device control ones from its events
+func (apiHandlers *ApiRouteHandlers) registerMetrics(registry *metrics.Registry) {
+	commands := registry.NewCounter(
+		"smh_commands_total", "Number of executed commands per device.", "device")
+	commandFailures := registry.NewCounter(
+		"smh_command_failures_total", "Number of failed commands per device.", "device")
+	commandDuration := registry.NewHistogram(
+		"smh_command_duration_seconds", "Latency of the commands per device.", metrics.DefaultBuckets, "device")
+	scenarioFailures := registry.NewCounter(
+		"smh_scenario_failures_total", "Number of failed scenarios.", "scenario")
+	scenarioDuration := registry.NewHistogram(
+		"smh_scenario_duration_seconds", "Duration of the scenarios including delays.", metrics.DefaultBuckets, "scenario")
+	controlItems := registry.NewCounter(
+		"smh_control_items_total", "Number of executed control items.", "item", "result")
+	discoveries := registry.NewCounter(
+		"smh_rediscoveries_total", "Number of device rediscoveries.", "result")
+
+	registry.NewGaugeFunc("smh_task_queue_depth",
+		"Number of accepted commands, scenarios and control items that are not finished yet.",
+		func() float64 {
+			return float64(atomic.LoadInt64(&apiHandlers.pending))
+		})
+	registry.NewGaugeFunc("smh_websocket_subscribers", "Number of connected websocket subscribers.",
+		func() float64 {
+			return float64(apiHandlers.stateHub.count())
+		})
+	registry.NewGaugeFunc("smh_uptime_seconds", "Time since the server has been started.",
+		func() float64 {
+			return time.Since(apiHandlers.routesInited).Seconds()
+		})
+
+	apiHandlers.dataProvider.Subscribe(func(event devicecontrol.Event) {
+		switch event.Type {
+		case devicecontrol.EventCommand:
+			device := apiHandlers.deviceName(event.DeviceID)
+
+			commands.Inc(device)
+			commandDuration.Observe(event.Duration.Seconds(), device)
+
+			if event.Failed() {
+				commandFailures.Inc(device)
+			}
+		case devicecontrol.EventScenario:
+			scenarioDuration.Observe(event.Duration.Seconds(), event.Name)
+
+			if event.Failed() {
+				scenarioFailures.Inc(event.Name)
+			}
+		case devicecontrol.EventControlItem:
+			controlItems.Inc(event.Name, resultLabel(event))
+		case devicecontrol.EventDiscover:
+			discoveries.Inc(resultLabel(event))
+		}
+	})
+}
+
+func (apiHandlers *ApiRouteHandlers) deviceName(mac string) string {
+	if device, ok := apiHandlers.dataProvider.GetDevices()[mac]; ok {
+		return device.Name
+	}
+
+	return mac
+}
+
+func resultLabel(event devicecontrol.Event) string {
+	if event.Failed() {
+		return responseError
+	}
+
+	return responseSuccess
+}
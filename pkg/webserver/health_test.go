@@ -0,0 +1,33 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"smh-apiengine/pkg/devicecontrol"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_handleReady_HidesDevicesWithoutToken(t *testing.T) {
+	deviceControl := devicecontrol.NewDeviceControl(&devicecontrol.Config{Devices: map[string]*devicecontrol.Device{
+		"00:11:22:33:44:55": {Name: "Living room RM"}}})
+	apiHandlers := NewApiRouteHandlers(&ServerConfig{Token: "secret"}, &deviceControl, nil, nil, nil)
+	apiHandlers.InitRoutes()
+
+	request := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	recorder := httptest.NewRecorder()
+	apiHandlers.Router().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"status":"ready"}`, recorder.Body.String())
+
+	request = httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	apiHandlers.Router().ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, strings.Contains(recorder.Body.String(), "Living room RM"), recorder.Body.String())
+}
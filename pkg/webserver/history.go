@@ -0,0 +1,126 @@
+package webserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"smh-apiengine/pkg/auth"
+	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/history"
+	"smh-apiengine/pkg/logging"
+	"strconv"
+	"time"
+)
+
+// HeaderRequestSource is the header used by the applications forwarding the requests to the api (e.g. RMQ
+// consumer) to tell the source of the execution for the history, only the tokens with the forward scope can set it
+const HeaderRequestSource = "X-Request-Source"
+
+// forwardedSources are the sources that can be set with the request source header
+var forwardedSources = map[string]bool{
+	devicecontrol.SourceRMQ:      true,
+	devicecontrol.SourceSchedule: true,
+}
+
+// handleHistory returns the execution history records matching the query filters, newest first
+func (apiHandlers *ApiRouteHandlers) handleHistory(w http.ResponseWriter, r *http.Request) {
+	filter, err := historyFilter(r.URL.Query())
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		_, ioErr := io.WriteString(w, NewErrorResponse(err.Error()))
+		if ioErr != nil {
+			logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
+		}
+
+		return
+	}
+
+	records, err := apiHandlers.history.Query(filter)
+
+	if err != nil {
+		logging.WithContext(r.Context()).WithError(err).Errorf("Failed to read the history")
+		w.WriteHeader(http.StatusInternalServerError)
+
+		_, ioErr := io.WriteString(w, NewErrorResponse("Failed to read the history"))
+		if ioErr != nil {
+			logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	_, ioErr := io.WriteString(w, NewSuccessResponse("history", records))
+	if ioErr != nil {
+		logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
+	}
+}
+
+// historyFilter creates the history filter from the query parameters
+func historyFilter(query url.Values) (history.Filter, error) {
+	filter := history.Filter{
+		Type:     query.Get("type"),
+		ID:       query.Get("id"),
+		Source:   query.Get("source"),
+		Actor:    query.Get("actor"),
+		DeviceID: query.Get("device"),
+	}
+
+	var err error
+
+	if status := query.Get("status"); status != "" {
+		if status != responseSuccess && status != responseError {
+			return filter, fmt.Errorf("status must be either \"%s\" or \"%s\"", responseSuccess, responseError)
+		}
+
+		failed := status == responseError
+		filter.Failed = &failed
+	}
+
+	if since := query.Get("since"); since != "" {
+		filter.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter, fmt.Errorf("since must be RFC3339 time")
+		}
+	}
+
+	if until := query.Get("until"); until != "" {
+		filter.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			return filter, fmt.Errorf("until must be RFC3339 time")
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 {
+			return filter, fmt.Errorf("limit must be a positive number")
+		}
+	}
+
+	return filter, nil
+}
+
+// requestSource returns the execution source of the request with the token name as actor. The source type can be
+// overridden with the request source header by the applications forwarding the requests, if their token has the
+// forward scope, so the other clients can not disguise their executions in the history.
+func requestSource(r *http.Request, sourceType string) devicecontrol.Source {
+	source := devicecontrol.Source{Type: sourceType}
+	token := auth.FromContext(r.Context())
+
+	if token == nil {
+		return source
+	}
+
+	source.Actor = token.Name
+
+	if forwarded := r.Header.Get(HeaderRequestSource); forwardedSources[forwarded] && token.HasScope(auth.ScopeForward) {
+		source.Type = forwarded
+	}
+
+	return source
+}
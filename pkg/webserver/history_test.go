@@ -0,0 +1,36 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"smh-apiengine/pkg/auth"
+	"smh-apiengine/pkg/devicecontrol"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_requestSource_ForwardScope(t *testing.T) {
+	tests := []struct {
+		token  *auth.Token
+		source string
+	}{
+		{nil, devicecontrol.SourceHTTP},
+		{&auth.Token{Name: "tablet", Scopes: []string{auth.ScopeRunCommands}}, devicecontrol.SourceHTTP},
+		{&auth.Token{Name: "consumer", Scopes: []string{auth.ScopeRunCommands, auth.ScopeForward}},
+			devicecontrol.SourceRMQ},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/run/command/tv-on", nil)
+		r.Header.Set(HeaderRequestSource, devicecontrol.SourceRMQ)
+
+		if test.token != nil {
+			r = r.WithContext(auth.NewContext(r.Context(), test.token))
+		}
+
+		source := requestSource(r, devicecontrol.SourceHTTP)
+
+		assert.Equal(t, test.source, source.Type, test.token)
+	}
+}
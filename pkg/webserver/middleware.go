@@ -1,25 +1,50 @@
 package webserver
 
 import (
+	"context"
+	"crypto/subtle"
 	"io"
-	"log"
 	"net/http"
+	"smh-apiengine/pkg/auth"
+	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/logging"
 	"strings"
+	"time"
 )
 
 const (
 	headerAuthorization = "Authorization"
 	bearerPrefix        = "Bearer "
+	defaultTokenName    = "default"
+	maxRequestIDLength  = 64
+	headerWSProtocol    = "Sec-WebSocket-Protocol"
+	// wsBearerProtocol is the websocket subprotocol followed by the token, used by the browsers which can not set
+	// the authorization header for websocket connections
+	wsBearerProtocol = "bearer"
 )
 
+// HeaderRequestID is the header carrying the request id between the applications
+const HeaderRequestID = "X-Request-ID"
+
 // ResultResponse api response for messages without payload
 type ResultResponse struct {
 	Result  string `json:"result"`
 	Message string `json:"message"`
 }
 
+// AuthMiddleware authenticates the requests either with the single token from the server config (which is granted
+// the admin scope) or with one of the named tokens from the token store. When neither is configured all the requests
+// are allowed.
 type AuthMiddleware struct {
-	Token string
+	Token       string
+	Tokens      *auth.TokenStore
+	Failures    AuthFailureRecorder
+	PublicPaths []string // paths available without authentication
+}
+
+// AuthFailureRecorder is notified about every failed authentication with the client ip
+type AuthFailureRecorder interface {
+	RecordAuthFailure(ip string)
 }
 
 type HeadersMiddleware struct {
@@ -27,36 +52,202 @@ type HeadersMiddleware struct {
 
 func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if am.Token == "" || am.isTokenValid(am.Token, r.Header.Get(headerAuthorization)) {
+		if am.open() {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		secret := am.bearer(r.Header.Get(headerAuthorization))
+		if secret == "" {
+			secret = am.websocketBearer(r)
+		}
+
+		token, err := am.authenticate(secret)
+
+		if am.public(r.URL.Path) {
+			// the token is optional for the public paths, but it is still passed to the handlers if valid
+			if err == nil {
+				r = r.WithContext(auth.NewContext(r.Context(), token))
+			}
+
 			next.ServeHTTP(w, r)
 
 			return
 		}
 
-		log.Printf("Wrong token provided: %s\n", r.Header.Get(headerAuthorization))
+		if err == nil {
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), token)))
+
+			return
+		}
+
+		logging.WithContext(r.Context()).WithError(err).Warnf(
+			"Authentication failed for \"%s\" from %s", r.RequestURI, r.RemoteAddr)
+
+		if am.Failures != nil {
+			am.Failures.RecordAuthFailure(clientIP(r))
+		}
+
 		w.WriteHeader(http.StatusForbidden)
 
 		_, ioErr := io.WriteString(w, NewErrorResponse("Wrong token provided!"))
 		if ioErr != nil {
-			log.Println(ioErr)
+			logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
 		}
 	})
 }
 
-func (am *AuthMiddleware) isTokenValid(token string, authHeader string) bool {
-	if len(authHeader) > 0 && strings.HasPrefix(authHeader, bearerPrefix) {
-		return token == strings.TrimPrefix(authHeader, bearerPrefix)
+// open returns true if there is no token configured at all
+func (am *AuthMiddleware) open() bool {
+	return am.Token == "" && (am.Tokens == nil || am.Tokens.Empty())
+}
+
+func (am *AuthMiddleware) public(path string) bool {
+	for _, publicPath := range am.PublicPaths {
+		if publicPath == path {
+			return true
+		}
 	}
 
 	return false
 }
 
+func (am *AuthMiddleware) authenticate(secret string) (*auth.Token, error) {
+	if secret == "" {
+		return nil, auth.ErrTokenInvalid
+	}
+
+	if am.Token != "" && subtle.ConstantTimeCompare([]byte(am.Token), []byte(secret)) == 1 {
+		return &auth.Token{Name: defaultTokenName, Hash: auth.HashSecret(secret), Scopes: []string{auth.ScopeAdmin}}, nil
+	}
+
+	if am.Tokens == nil {
+		return nil, auth.ErrTokenInvalid
+	}
+
+	return am.Tokens.Authenticate(secret)
+}
+
+func (am *AuthMiddleware) bearer(authHeader string) string {
+	if len(authHeader) > 0 && strings.HasPrefix(authHeader, bearerPrefix) {
+		return strings.TrimPrefix(authHeader, bearerPrefix)
+	}
+
+	return ""
+}
+
+// websocketBearer returns the token from the websocket subprotocols requested as "bearer, <token>"
+func (am *AuthMiddleware) websocketBearer(r *http.Request) string {
+	var protocols []string
+
+	for _, header := range r.Header[http.CanonicalHeaderKey(headerWSProtocol)] {
+		for _, protocol := range strings.Split(header, ",") {
+			protocols = append(protocols, strings.TrimSpace(protocol))
+		}
+	}
+
+	if len(protocols) == 2 && protocols[0] == wsBearerProtocol {
+		return protocols[1]
+	}
+
+	return ""
+}
+
+// RequireScope wraps the handler and allows only the requests authenticated with the token having provided scope.
+// Requests without authenticated token are only possible when authentication is not configured, they are allowed.
+func RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := auth.FromContext(r.Context())
+
+		if token == nil || token.HasScope(scope) {
+			next(w, r)
+
+			return
+		}
+
+		Forbidden(w, r)
+	}
+}
+
+// Forbidden writes the response for the requests whose token lacks the required scope
+func Forbidden(w http.ResponseWriter, r *http.Request) {
+	logger := logging.WithContext(r.Context())
+	logger.Warnf("Token \"%s\" is not allowed to access \"%s\"", auth.FromContext(r.Context()).Name, r.RequestURI)
+	w.WriteHeader(http.StatusForbidden)
+
+	_, ioErr := io.WriteString(w, NewErrorResponse("Token is not allowed to access this resource"))
+	if ioErr != nil {
+		logger.WithError(ioErr).Errorf("Failed to write the response")
+	}
+}
+
 func (hm *HeadersMiddleware) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Request: \"%s\", from: %s", r.RequestURI, r.RemoteAddr)
+		logging.WithContext(r.Context()).Infof("Request: \"%s\", from: %s", r.RequestURI, r.RemoteAddr)
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+// RequestIDMiddleware adds the request id to the request context and the response headers. The id provided by
+// the client in the header is used if it is valid, so the requests can be correlated across the applications.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderRequestID)
+
+		if !validRequestID(id) {
+			id = logging.NewRequestID()
+		}
+
+		w.Header().Set(HeaderRequestID, id)
+
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+
+		if !isAlnum && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// executionContext returns the context of the execution started by the request: it has the request source and is
+// not canceled when the request is answered
+func executionContext(r *http.Request, sourceType string) context.Context {
+	return devicecontrol.WithSource(detachedContext{parent: r.Context()}, requestSource(r, sourceType))
+}
+
+// detachedContext keeps the values of the parent context but is never canceled, used for the executions that
+// outlive the request
+type detachedContext struct {
+	parent context.Context
+}
+
+func (dc detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (dc detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (dc detachedContext) Err() error {
+	return nil
+}
+
+func (dc detachedContext) Value(key interface{}) interface{} {
+	return dc.parent.Value(key)
+}
@@ -0,0 +1,134 @@
+package webserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"smh-apiengine/pkg/auth"
+	"smh-apiengine/pkg/devicecontrol"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type failuresRecorder struct {
+	ips []string
+}
+
+func (recorder *failuresRecorder) RecordAuthFailure(ip string) {
+	recorder.ips = append(recorder.ips, ip)
+}
+
+func newTestTokenStore(t *testing.T) *auth.TokenStore {
+	dir, err := ioutil.TempDir("", "smh-tokens")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	store, err := auth.NewTokenStore(filepath.Join(dir, "tokens.json"))
+	assert.NoError(t, err)
+
+	return store
+}
+
+func okHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func Test_AuthMiddleware_Tokens(t *testing.T) {
+	store := newTestTokenStore(t)
+
+	reader, err := store.Add("reader", []string{auth.ScopeRead}, nil, 0)
+	assert.NoError(t, err)
+
+	expired, err := store.Add("guest", []string{auth.ScopeAdmin}, nil, time.Nanosecond)
+	assert.NoError(t, err)
+	time.Sleep(time.Millisecond)
+
+	failures := &failuresRecorder{}
+	authMiddleware := &AuthMiddleware{Tokens: store, Failures: failures, PublicPaths: []string{"/healthz"}}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/healthz", okHandler)
+	router.HandleFunc("/controls", RequireScope(auth.ScopeRead, okHandler))
+	router.HandleFunc("/run/command/{commandId}", RequireScope(auth.ScopeRunCommands, okHandler))
+	router.Use(authMiddleware.Middleware)
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		protocol      string
+		status        int
+	}{
+		{"missing token", "/controls", "", "", http.StatusForbidden},
+		{"wrong token", "/controls", "Bearer wrong", "", http.StatusForbidden},
+		{"expired token", "/controls", "Bearer " + expired, "", http.StatusForbidden},
+		{"valid token", "/controls", "Bearer " + reader, "", http.StatusOK},
+		{"insufficient scope", "/run/command/lights-on", "Bearer " + reader, "", http.StatusForbidden},
+		{"public path without token", "/healthz", "", "", http.StatusOK},
+		{"public path with wrong token", "/healthz", "Bearer wrong", "", http.StatusOK},
+		{"websocket bearer", "/controls", "", "bearer, " + reader, http.StatusOK},
+		{"websocket wrong bearer", "/controls", "", "bearer, wrong", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.authorization != "" {
+				request.Header.Set(headerAuthorization, test.authorization)
+			}
+			if test.protocol != "" {
+				request.Header.Set(headerWSProtocol, test.protocol)
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, test.status, recorder.Code)
+		})
+	}
+
+	// only the authentication failures are recorded, not the missing scopes
+	assert.Len(t, failures.ips, 4)
+}
+
+func Test_AuthMiddleware_ControlRestrictions(t *testing.T) {
+	store := newTestTokenStore(t)
+
+	tablet, err := store.Add("tablet", []string{auth.ScopeRunControls}, []string{"lights"}, 0)
+	assert.NoError(t, err)
+
+	deviceControl := devicecontrol.NewDeviceControl(&devicecontrol.Config{
+		Controls: map[string]devicecontrol.Control{
+			"lights": {ID: "lights", Items: map[string]*devicecontrol.ControlItem{"lamp": {ID: "lamp"}}},
+			"tv":     {ID: "tv", Items: map[string]*devicecontrol.ControlItem{"tv-power": {ID: "tv-power"}}},
+		}})
+	apiHandlers := NewApiRouteHandlers(&ServerConfig{}, &deviceControl, store, nil, nil)
+	apiHandlers.InitRoutes()
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"allowed control", "/run/item/lamp/on", http.StatusOK},
+		{"restricted control", "/run/item/tv-power/on", http.StatusForbidden},
+		{"other scope", "/controls", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.path, nil)
+			request.Header.Set(headerAuthorization, "Bearer "+tablet)
+
+			recorder := httptest.NewRecorder()
+			apiHandlers.Router().ServeHTTP(recorder, request)
+
+			assert.Equal(t, test.status, recorder.Code)
+		})
+	}
+}
@@ -0,0 +1,540 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/mqtt"
+	"smh-apiengine/pkg/queue"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DefaultMQTTAddress         = "localhost:1883"
+	DefaultMQTTClientID        = "smh-webserver"
+	DefaultMQTTTopicPrefix     = "smh"
+	DefaultMQTTDiscoveryPrefix = "homeassistant"
+
+	mqttOnline       = "online"
+	mqttOffline      = "offline"
+	mqttToggle       = "toggle"
+	mqttManufacturer = "Smart Home API Engine"
+
+	// mqttDiscoveryNode is the node id of the discovery topics, so the entities of the bridge are grouped together
+	mqttDiscoveryNode = "smh"
+)
+
+// MQTTConfig struct is the settings of the MQTT bridge
+type MQTTConfig struct {
+	Enabled         bool
+	Address         string // host:port of the broker, localhost:1883 if empty
+	ClientID        string // smh-webserver if empty
+	Username        string
+	Password        string
+	TopicPrefix     string // prefix of the state and command topics, smh if empty
+	Discovery       bool   // publish the Home Assistant discovery payloads
+	DiscoveryPrefix string // homeassistant if empty
+}
+
+// MQTTBridge struct connects the device control to the MQTT broker, so the hubs like Home Assistant can show and
+// control the devices. The states of the control items and the power switches are published as retained messages
+// to <prefix>/item/<id>/state and <prefix>/device/<mac>/state. The commands are taken from
+// <prefix>/item/<id>/set (the state, the next state if empty or "toggle"), <prefix>/device/<mac>/set ("on" or "off"),
+// <prefix>/command/<id>/run and <prefix>/scenario/<id>/run. The availability of the bridge is kept in
+// <prefix>/status.
+type MQTTBridge struct {
+	config      MQTTConfig
+	apiHandlers *ApiRouteHandlers
+	status      queue.StatusTracker
+	mu          sync.Mutex
+	states      map[string]string // the last state by the state topic, published again after reconnecting
+	pending     map[string]string // the states changed since they were published
+	rediscover  int32
+	changed     chan struct{}
+}
+
+// mqttDiscovery struct is the Home Assistant MQTT discovery payload of the switch, select or button entity
+type mqttDiscovery struct {
+	Name              string              `json:"name"`
+	UniqueID          string              `json:"unique_id"`
+	CommandTopic      string              `json:"command_topic"`
+	StateTopic        string              `json:"state_topic,omitempty"`
+	PayloadOn         string              `json:"payload_on,omitempty"`
+	PayloadOff        string              `json:"payload_off,omitempty"`
+	StateOn           string              `json:"state_on,omitempty"`
+	StateOff          string              `json:"state_off,omitempty"`
+	PayloadPress      string              `json:"payload_press,omitempty"`
+	Options           []string            `json:"options,omitempty"`
+	AvailabilityTopic string              `json:"availability_topic"`
+	Device            mqttDiscoveryDevice `json:"device"`
+}
+
+// mqttDiscoveryDevice struct is the device the entity belongs to, the items of a control share the device
+type mqttDiscoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+}
+
+func NewMQTTBridge(config MQTTConfig, apiHandlers *ApiRouteHandlers) *MQTTBridge {
+	bridge := &MQTTBridge{
+		config:      config,
+		apiHandlers: apiHandlers,
+		states:      make(map[string]string),
+		pending:     make(map[string]string),
+		changed:     make(chan struct{}, 1)}
+
+	for id, state := range apiHandlers.dataProvider.ControlItemStates() {
+		bridge.setState(bridge.topic("item", id, "state"), state)
+	}
+
+	apiHandlers.dataProvider.Subscribe(bridge.listen)
+
+	return bridge
+}
+
+// Run connects to the broker and bridges the states and the commands until the context is cancelled, the lost
+// connection is established again with exponential backoff
+func (bridge *MQTTBridge) Run(ctx context.Context) {
+	go bridge.refreshPowerStates(ctx)
+
+	queue.Supervise(ctx, &bridge.status, "MQTT bridge", bridge.runOnce)
+}
+
+// Status returns the connection state of the bridge
+func (bridge *MQTTBridge) Status() queue.Status {
+	return bridge.status.Status()
+}
+
+func (bridge *MQTTBridge) runOnce(ctx context.Context) (bool, error) {
+	availability := mqtt.Message{Topic: bridge.topic("status"), Payload: []byte(mqttOffline), QoS: 1, Retain: true}
+
+	client, err := mqtt.Dial(mqtt.Options{
+		Address:  bridge.address(),
+		ClientID: bridge.clientID(),
+		Username: bridge.config.Username,
+		Password: bridge.config.Password,
+		Will:     &availability,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	defer func() {
+		_ = client.Close()
+	}()
+
+	for _, filter := range []string{
+		bridge.topic("item", "+", "set"),
+		bridge.topic("device", "+", "set"),
+		bridge.topic("command", "+", "run"),
+		bridge.topic("scenario", "+", "run"),
+	} {
+		err = client.Subscribe(filter, 1, bridge.handleCommand)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if bridge.config.Discovery {
+		// home assistant announces itself when it starts, the discovery is published again in case the broker lost it
+		err = client.Subscribe(bridge.discoveryPrefix()+"/status", 1, bridge.handleHubStatus)
+		if err != nil {
+			return false, err
+		}
+
+		err = bridge.publishDiscovery(client)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	availability.Payload = []byte(mqttOnline)
+
+	err = client.Publish(availability)
+	if err != nil {
+		return false, err
+	}
+
+	err = bridge.publishStates(client, true)
+	if err != nil {
+		return false, err
+	}
+
+	bridge.status.Set(queue.StateConnected, nil)
+	logging.Infof("MQTT bridge connected to %s, topic prefix %s", bridge.address(), bridge.prefix())
+
+	for {
+		select {
+		case <-ctx.Done():
+			availability.Payload = []byte(mqttOffline)
+			_ = client.Publish(availability)
+
+			return true, nil
+		case <-client.Done():
+			if client.Err() != nil {
+				return true, client.Err()
+			}
+
+			return true, mqtt.ErrClosed
+		case <-bridge.changed:
+			if atomic.CompareAndSwapInt32(&bridge.rediscover, 1, 0) {
+				err = bridge.publishDiscovery(client)
+				if err != nil {
+					return true, err
+				}
+			}
+
+			err = bridge.publishStates(client, false)
+			if err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+// listen is the device control event listener that queues the state changes for the publication
+func (bridge *MQTTBridge) listen(event devicecontrol.Event) {
+	if event.State == "" || event.Failed() {
+		return
+	}
+
+	switch event.Type {
+	case devicecontrol.EventControlItem:
+		bridge.setState(bridge.topic("item", event.ID, "state"), event.State)
+	case devicecontrol.EventPowerState:
+		bridge.setState(bridge.topic("device", event.DeviceID, "state"), event.State)
+	}
+}
+
+func (bridge *MQTTBridge) setState(topic string, state string) {
+	bridge.mu.Lock()
+	bridge.states[topic] = state
+	bridge.pending[topic] = state
+	bridge.mu.Unlock()
+
+	bridge.notify()
+}
+
+func (bridge *MQTTBridge) notify() {
+	select {
+	case bridge.changed <- struct{}{}:
+	default:
+	}
+}
+
+// publishStates publishes the pending states or all the known states after connecting. The states which failed to
+// be published are published again after reconnecting.
+func (bridge *MQTTBridge) publishStates(client *mqtt.Client, all bool) error {
+	bridge.mu.Lock()
+	states := bridge.pending
+
+	if all {
+		states = make(map[string]string, len(bridge.states))
+
+		for topic, state := range bridge.states {
+			states[topic] = state
+		}
+	}
+
+	bridge.pending = make(map[string]string)
+	bridge.mu.Unlock()
+
+	for topic, state := range states {
+		err := client.Publish(mqtt.Message{Topic: topic, Payload: []byte(state), QoS: 1, Retain: true})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// refreshPowerStates requests the current states of the power switches, so they are known before they are switched
+func (bridge *MQTTBridge) refreshPowerStates(ctx context.Context) {
+	for _, device := range bridge.apiHandlers.dataProvider.GetDevices() {
+		if !device.SupportsPowerSwitch() || !device.Enabled {
+			continue
+		}
+
+		err := bridge.apiHandlers.dataProvider.RefreshPowerState(ctx, device)
+		if err != nil {
+			logging.WithError(err).Debugf("Failed to get the power state of %s", device.Name)
+		}
+	}
+}
+
+// handleCommand runs the command received from the command topic. It is called by the client for every message in
+// turn, so the executions are started in the background. There is no token on MQTT, the broker ACLs decide who may
+// publish the commands, so the control restrictions of the api tokens do not apply here.
+func (bridge *MQTTBridge) handleCommand(msg mqtt.Message) {
+	levels := strings.Split(strings.TrimPrefix(msg.Topic, bridge.prefix()+"/"), "/")
+	if len(levels) != 3 {
+		return
+	}
+
+	kind, id, payload := levels[0], levels[1], strings.TrimSpace(string(msg.Payload))
+
+	ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
+	ctx = devicecontrol.WithSource(ctx, devicecontrol.Source{Type: devicecontrol.SourceMQTT})
+	logger := logging.WithContext(ctx).WithField("topic", msg.Topic)
+
+	logger.Infof("MQTT command received")
+
+	var envelope queue.Envelope
+	var err error
+
+	switch kind {
+	case "item":
+		var state string
+
+		state, err = bridge.itemState(id, payload)
+		if err == nil {
+			envelope, err = queue.NewEnvelope(queue.TypeRunControlItem,
+				queue.ControlItemPayload{ControlItemID: id, State: state})
+		}
+	case "command":
+		envelope, err = queue.NewEnvelope(queue.TypeRunCommand, queue.CommandPayload{CommandID: id})
+	case "scenario":
+		envelope, err = queue.NewEnvelope(queue.TypeRunScenario, queue.ScenarioPayload{ScenarioID: id})
+	case "device":
+	default:
+		return
+	}
+
+	if err != nil {
+		logger.WithError(err).Warnf("MQTT command was not executed")
+
+		return
+	}
+
+	// the execution runs in the background like the http ones, so the slow devices do not hold the delivery of the
+	// next messages and the shutdown waits for it
+	bridge.apiHandlers.startTask(ctx, func(ctx context.Context) error {
+		if kind == "device" {
+			return bridge.switchPower(ctx, id, payload)
+		}
+
+		_, err := bridge.apiHandlers.runMessage(ctx, envelope)
+
+		return err
+	})
+}
+
+// itemState returns the state of the control item matching the payload case insensitively, the empty state selects
+// the next state of the item
+func (bridge *MQTTBridge) itemState(id string, payload string) (string, error) {
+	if payload == "" || strings.EqualFold(payload, mqttToggle) {
+		return "", nil
+	}
+
+	item := bridge.apiHandlers.dataProvider.FindControlItemByID(id)
+	if item == nil {
+		return "", fmt.Errorf("control item with id %s was not found", id)
+	}
+
+	for _, entity := range item.StateEntities {
+		if strings.EqualFold(entity.State, payload) {
+			return entity.State, nil
+		}
+	}
+
+	return "", fmt.Errorf("control item %s has no state %q", item.Name, payload)
+}
+
+func (bridge *MQTTBridge) switchPower(ctx context.Context, mac string, payload string) error {
+	on := strings.EqualFold(payload, devicecontrol.StateOn)
+	if !on && !strings.EqualFold(payload, devicecontrol.StateOff) {
+		return fmt.Errorf("power state %q is not on or off", payload)
+	}
+
+	device := bridge.powerSwitch(mac)
+	if device == nil {
+		return fmt.Errorf("power switch %s was not found", mac)
+	}
+
+	return bridge.apiHandlers.dataProvider.SwitchPower(ctx, device, on)
+}
+
+func (bridge *MQTTBridge) powerSwitch(mac string) *devicecontrol.Device {
+	for _, device := range bridge.apiHandlers.dataProvider.GetDevices() {
+		if device.Mac == mac && device.SupportsPowerSwitch() && device.Enabled {
+			return device
+		}
+	}
+
+	return nil
+}
+
+// handleHubStatus publishes the discovery again when Home Assistant comes online
+func (bridge *MQTTBridge) handleHubStatus(msg mqtt.Message) {
+	if string(msg.Payload) != mqttOnline {
+		return
+	}
+
+	atomic.StoreInt32(&bridge.rediscover, 1)
+	bridge.notify()
+}
+
+// publishDiscovery publishes the retained Home Assistant discovery payloads: the control items which can be turned
+// on and off are switches, the items with several states are selects and the items with a single state are buttons.
+// The power switch devices are switches too.
+func (bridge *MQTTBridge) publishDiscovery(client *mqtt.Client) error {
+	configs := bridge.discoveryConfigs()
+	components := make([]string, 0, len(configs))
+
+	for component := range configs {
+		components = append(components, component)
+	}
+
+	sort.Strings(components)
+
+	for _, component := range components {
+		for _, config := range configs[component] {
+			content, err := json.Marshal(config)
+			if err != nil {
+				return err
+			}
+
+			topic := strings.Join(
+				[]string{bridge.discoveryPrefix(), component, mqttDiscoveryNode, config.UniqueID, "config"}, "/")
+
+			err = client.Publish(mqtt.Message{Topic: topic, Payload: content, QoS: 1, Retain: true})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// discoveryConfigs returns the discovery payloads by the Home Assistant component
+func (bridge *MQTTBridge) discoveryConfigs() map[string][]mqttDiscovery {
+	configs := make(map[string][]mqttDiscovery)
+
+	for _, control := range bridge.apiHandlers.dataProvider.AllControls() {
+		device := mqttDiscoveryDevice{
+			Identifiers:  []string{"smh_control_" + objectID(control.ID)},
+			Name:         control.Name,
+			Manufacturer: mqttManufacturer,
+		}
+
+		for _, item := range control.Items {
+			if len(item.StateEntities) == 0 {
+				continue
+			}
+
+			config := mqttDiscovery{
+				Name:              item.Name,
+				UniqueID:          "smh_item_" + objectID(item.ID),
+				CommandTopic:      bridge.topic("item", item.ID, "set"),
+				StateTopic:        bridge.topic("item", item.ID, "state"),
+				AvailabilityTopic: bridge.topic("status"),
+				Device:            device,
+			}
+
+			switch {
+			case item.SupportsPower():
+				config.PayloadOn, config.StateOn = devicecontrol.StateOn, devicecontrol.StateOn
+				config.PayloadOff, config.StateOff = devicecontrol.StateOff, devicecontrol.StateOff
+				configs["switch"] = append(configs["switch"], config)
+			case len(item.StateEntities) > 1:
+				for _, entity := range item.StateEntities {
+					config.Options = append(config.Options, entity.State)
+				}
+
+				configs["select"] = append(configs["select"], config)
+			default:
+				config.StateTopic = ""
+				config.PayloadPress = item.StateEntities[0].State
+				configs["button"] = append(configs["button"], config)
+			}
+		}
+	}
+
+	for _, device := range bridge.apiHandlers.dataProvider.GetDevices() {
+		if !device.SupportsPowerSwitch() || !device.Enabled {
+			continue
+		}
+
+		configs["switch"] = append(configs["switch"], mqttDiscovery{
+			Name:              device.Name,
+			UniqueID:          "smh_device_" + objectID(device.Mac),
+			CommandTopic:      bridge.topic("device", device.Mac, "set"),
+			StateTopic:        bridge.topic("device", device.Mac, "state"),
+			PayloadOn:         devicecontrol.StateOn,
+			PayloadOff:        devicecontrol.StateOff,
+			StateOn:           devicecontrol.StateOn,
+			StateOff:          devicecontrol.StateOff,
+			AvailabilityTopic: bridge.topic("status"),
+			Device: mqttDiscoveryDevice{
+				Identifiers:  []string{"smh_device_" + objectID(device.Mac)},
+				Name:         device.Name,
+				Manufacturer: mqttManufacturer,
+				Model:        "Broadlink power switch",
+			},
+		})
+	}
+
+	for _, list := range configs {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].UniqueID < list[j].UniqueID
+		})
+	}
+
+	return configs
+}
+
+func (bridge *MQTTBridge) topic(levels ...string) string {
+	return strings.Join(append([]string{bridge.prefix()}, levels...), "/")
+}
+
+func (bridge *MQTTBridge) prefix() string {
+	if bridge.config.TopicPrefix != "" {
+		return strings.TrimSuffix(bridge.config.TopicPrefix, "/")
+	}
+
+	return DefaultMQTTTopicPrefix
+}
+
+func (bridge *MQTTBridge) discoveryPrefix() string {
+	if bridge.config.DiscoveryPrefix != "" {
+		return strings.TrimSuffix(bridge.config.DiscoveryPrefix, "/")
+	}
+
+	return DefaultMQTTDiscoveryPrefix
+}
+
+func (bridge *MQTTBridge) address() string {
+	if bridge.config.Address != "" {
+		return bridge.config.Address
+	}
+
+	return DefaultMQTTAddress
+}
+
+func (bridge *MQTTBridge) clientID() string {
+	if bridge.config.ClientID != "" {
+		return bridge.config.ClientID
+	}
+
+	return DefaultMQTTClientID
+}
+
+// objectID returns the id usable in the discovery topics, which allow only letters, digits, "_" and "-"
+func objectID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+
+		return '_'
+	}, id)
+}
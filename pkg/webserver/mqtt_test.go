@@ -0,0 +1,128 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/mqtt"
+	"smh-apiengine/pkg/queue"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitMessage returns the next message published to the topic, the other messages are skipped
+func waitMessage(t *testing.T, messages chan mqtt.Message, topic string) mqtt.Message {
+	timeout := time.After(3 * time.Second)
+
+	for {
+		select {
+		case msg := <-messages:
+			if msg.Topic == topic {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no message published to %s", topic)
+		}
+	}
+}
+
+func Test_MQTTBridge(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	server := mqtt.NewServer(mqtt.ServerOptions{})
+	defer server.Close()
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	// the items run the empty scenario, so they succeed without the devices
+	power := &devicecontrol.ControlItem{ID: "tv-power", Name: "Power", StateEntities: []devicecontrol.Entity{
+		{State: devicecontrol.StateOn, Type: devicecontrol.ElementTypeScenario, Target: "noop"},
+		{State: devicecontrol.StateOff, Type: devicecontrol.ElementTypeScenario, Target: "noop"}}}
+	input := &devicecontrol.ControlItem{ID: "tv-input", Name: "Input", StateEntities: []devicecontrol.Entity{
+		{State: "HDMI1", Type: devicecontrol.ElementTypeScenario, Target: "noop"},
+		{State: "HDMI2", Type: devicecontrol.ElementTypeScenario, Target: "noop"}}}
+
+	deviceControl := devicecontrol.NewDeviceControl(&devicecontrol.Config{
+		Scenarios: map[string]devicecontrol.Scenario{"noop": {ID: "noop", Name: "Noop"}},
+		Controls: map[string]devicecontrol.Control{"tv": {ID: "tv", Name: "TV", Items: map[string]*devicecontrol.ControlItem{
+			power.ID: power, input.ID: input}}},
+	})
+
+	bridge := NewMQTTBridge(MQTTConfig{Address: listener.Addr().String(), Discovery: true},
+		&ApiRouteHandlers{dataProvider: &deviceControl})
+
+	observer, err := mqtt.Dial(mqtt.Options{Address: listener.Addr().String(), ClientID: "observer"})
+	assert.Nil(t, err)
+	defer observer.Close()
+
+	messages := make(chan mqtt.Message, 100)
+	assert.Nil(t, observer.Subscribe("#", 1, func(msg mqtt.Message) {
+		messages <- msg
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		bridge.Run(ctx)
+	}()
+
+	var discovery mqttDiscovery
+
+	// the discovery is published by the component: buttons, selects and then switches
+	msg := waitMessage(t, messages, "homeassistant/select/smh/smh_item_tv-input/config")
+	assert.Nil(t, json.Unmarshal(msg.Payload, &discovery))
+	assert.Equal(t, []string{"HDMI1", "HDMI2"}, discovery.Options)
+
+	msg = waitMessage(t, messages, "homeassistant/switch/smh/smh_item_tv-power/config")
+	assert.Nil(t, json.Unmarshal(msg.Payload, &discovery))
+	assert.Equal(t, "smh/item/tv-power/set", discovery.CommandTopic)
+	assert.Equal(t, "smh/item/tv-power/state", discovery.StateTopic)
+	assert.Equal(t, "smh/status", discovery.AvailabilityTopic)
+	assert.Equal(t, "TV", discovery.Device.Name)
+
+	assert.Equal(t, mqttOnline, string(waitMessage(t, messages, "smh/status").Payload))
+
+	assert.Nil(t, observer.Publish(mqtt.Message{Topic: "smh/item/tv-power/set", Payload: []byte("ON"), QoS: 1}))
+	assert.Equal(t, devicecontrol.StateOn, string(waitMessage(t, messages, "smh/item/tv-power/state").Payload))
+
+	assert.Nil(t, observer.Publish(mqtt.Message{Topic: "smh/item/tv-input/set", Payload: []byte(""), QoS: 1}))
+	assert.Equal(t, "HDMI1", string(waitMessage(t, messages, "smh/item/tv-input/state").Payload))
+
+	// the unknown state is not executed
+	assert.Nil(t, observer.Publish(mqtt.Message{Topic: "smh/item/tv-input/set", Payload: []byte("DVI"), QoS: 1}))
+	assert.Nil(t, observer.Publish(mqtt.Message{Topic: "smh/item/tv-input/set", Payload: []byte("hdmi2"), QoS: 1}))
+	assert.Equal(t, "HDMI2", string(waitMessage(t, messages, "smh/item/tv-input/state").Payload))
+
+	// home assistant coming online gets the discovery again
+	assert.Nil(t, observer.Publish(mqtt.Message{Topic: "homeassistant/status", Payload: []byte(mqttOnline), QoS: 1}))
+	waitMessage(t, messages, "homeassistant/switch/smh/smh_item_tv-power/config")
+
+	cancel()
+	<-stopped
+
+	assert.Equal(t, mqttOffline, string(waitMessage(t, messages, "smh/status").Payload))
+	assert.Equal(t, queue.StateStopped, bridge.Status().State)
+
+	// the states are retained for the clients subscribing later
+	late, err := mqtt.Dial(mqtt.Options{Address: listener.Addr().String(), ClientID: "late"})
+	assert.Nil(t, err)
+	defer late.Close()
+
+	retained := make(chan mqtt.Message, 10)
+	assert.Nil(t, late.Subscribe("smh/item/+/state", 1, func(msg mqtt.Message) {
+		retained <- msg
+	}))
+
+	msg = waitMessage(t, retained, "smh/item/tv-power/state")
+	assert.True(t, msg.Retain)
+	assert.Equal(t, devicecontrol.StateOn, string(msg.Payload))
+}
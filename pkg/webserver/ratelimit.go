@@ -0,0 +1,224 @@
+package webserver
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"smh-apiengine/pkg/auth"
+	"smh-apiengine/pkg/logging"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const bucketIdleTimeout = 10 * time.Minute
+
+// RateLimitConfig defines the limits for the requests. Zero rate disables the corresponding limit, zero max auth
+// failures disables the bans.
+type RateLimitConfig struct {
+	TokenRate       float64 // requests per second per token
+	TokenBurst      int
+	IPRate          float64 // requests per second per client ip
+	IPBurst         int
+	MaxAuthFailures int // failed authentications before the client ip gets banned
+	BanDuration     time.Duration
+}
+
+// bucket is a token bucket refilled with the configured rate up to the burst size
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type authFailures struct {
+	count       int
+	first       time.Time
+	bannedUntil time.Time
+}
+
+// RateLimiter limits the requests per token and per client ip and temporary bans the client ips with repeated
+// authentication failures
+type RateLimiter struct {
+	config   RateLimitConfig
+	tokens   map[string]*bucket
+	ips      map[string]*bucket
+	failures map[string]*authFailures
+	lastGC   time.Time
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config:   config,
+		tokens:   make(map[string]*bucket),
+		ips:      make(map[string]*bucket),
+		failures: make(map[string]*authFailures),
+		now:      time.Now,
+	}
+}
+
+// IPMiddleware rejects the requests from banned ips or ips exceeding the rate limit, should be used before
+// the authentication
+func (rl *RateLimiter) IPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+
+		if wait := rl.banned(ip); wait > 0 {
+			logging.WithContext(r.Context()).Warnf("Request from banned ip %s rejected", ip)
+			tooManyRequests(w, r, wait, "Too many failed authentication attempts")
+
+			return
+		}
+
+		if wait := rl.allow(rl.ips, ip, rl.config.IPRate, rl.config.IPBurst); wait > 0 {
+			logging.WithContext(r.Context()).Warnf("Rate limit exceeded for ip %s", ip)
+			tooManyRequests(w, r, wait, "Rate limit exceeded")
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TokenMiddleware rejects the requests whose token exceeds the rate limit, should be used after the authentication.
+// The tokens are told apart by their hash, as the names are not unique (e.g. the named token called like the single
+// --token one).
+func (rl *RateLimiter) TokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := auth.FromContext(r.Context())
+
+		if token != nil {
+			if wait := rl.allow(rl.tokens, token.Hash, rl.config.TokenRate, rl.config.TokenBurst); wait > 0 {
+				logging.WithContext(r.Context()).Warnf("Rate limit exceeded for token \"%s\"", token.Name)
+				tooManyRequests(w, r, wait, "Rate limit exceeded")
+
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RecordAuthFailure counts the failed authentication for the ip and bans it once the limit is reached within
+// the ban duration
+func (rl *RateLimiter) RecordAuthFailure(ip string) {
+	if rl.config.MaxAuthFailures <= 0 {
+		return
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	failures, ok := rl.failures[ip]
+
+	if !ok || now.Sub(failures.first) > rl.config.BanDuration {
+		failures = &authFailures{first: now}
+		rl.failures[ip] = failures
+	}
+
+	failures.count++
+
+	if failures.count >= rl.config.MaxAuthFailures {
+		failures.bannedUntil = now.Add(rl.config.BanDuration)
+		logging.Warnf("Ip %s banned for %s after %d failed authentications", ip, rl.config.BanDuration, failures.count)
+	}
+}
+
+// banned returns the remaining ban time of the ip
+func (rl *RateLimiter) banned(ip string) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	failures, ok := rl.failures[ip]
+	if !ok {
+		return 0
+	}
+
+	return failures.bannedUntil.Sub(rl.now())
+}
+
+// allow takes a token from the bucket of the key and returns zero, or the time to wait until the next request is
+// allowed if the bucket is empty
+func (rl *RateLimiter) allow(buckets map[string]*bucket, key string, rate float64, burst int) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.gc(now)
+
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+
+	b.tokens--
+
+	return 0
+}
+
+// gc removes idle buckets and expired failure records, runs at most once per idle timeout
+func (rl *RateLimiter) gc(now time.Time) {
+	if now.Sub(rl.lastGC) < bucketIdleTimeout {
+		return
+	}
+
+	rl.lastGC = now
+
+	for _, buckets := range []map[string]*bucket{rl.tokens, rl.ips} {
+		for key, b := range buckets {
+			if now.Sub(b.last) > bucketIdleTimeout {
+				delete(buckets, key)
+			}
+		}
+	}
+
+	for ip, failures := range rl.failures {
+		if now.After(failures.bannedUntil) && now.Sub(failures.first) > rl.config.BanDuration {
+			delete(rl.failures, ip)
+		}
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration, msg string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	_, ioErr := io.WriteString(w, NewErrorResponse(fmt.Sprintf("%s, retry after %d second(s)", msg, seconds)))
+	if ioErr != nil {
+		logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
+	}
+}
+
+// clientIP returns the ip of the remote address of the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
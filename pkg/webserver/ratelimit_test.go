@@ -0,0 +1,76 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"smh-apiengine/pkg/auth"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RateLimiter_IPBurstAndRefill(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(RateLimitConfig{IPRate: 1, IPBurst: 2})
+	limiter.now = func() time.Time { return now }
+
+	handler := limiter.IPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/controls", nil)
+		r.RemoteAddr = "192.168.1.10:51000"
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve().Code)
+	assert.Equal(t, http.StatusOK, serve().Code)
+
+	w := serve()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), `"result":"error"`)
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, serve().Code)
+}
+
+func Test_RateLimiter_BanAfterAuthFailures(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(RateLimitConfig{MaxAuthFailures: 2, BanDuration: time.Minute})
+	limiter.now = func() time.Time { return now }
+
+	limiter.RecordAuthFailure("10.0.0.1")
+	assert.True(t, limiter.banned("10.0.0.1") <= 0)
+
+	limiter.RecordAuthFailure("10.0.0.1")
+	assert.Equal(t, time.Minute, limiter.banned("10.0.0.1"))
+	assert.True(t, limiter.banned("10.0.0.2") <= 0)
+
+	now = now.Add(time.Minute + time.Second)
+	assert.True(t, limiter.banned("10.0.0.1") <= 0)
+}
+
+func Test_RateLimiter_TokensWithSameName(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{TokenRate: 1, TokenBurst: 1})
+	limiter.now = func() time.Time { return time.Unix(0, 0) }
+
+	handler := limiter.TokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(token *auth.Token) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/controls", nil)
+		handler.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), token)))
+
+		return w.Code
+	}
+
+	// the named token called like the --token one does not share its limit
+	named := &auth.Token{Name: defaultTokenName, Hash: auth.HashSecret("named")}
+	admin := &auth.Token{Name: defaultTokenName, Hash: auth.HashSecret("admin")}
+
+	assert.Equal(t, http.StatusOK, serve(named))
+	assert.Equal(t, http.StatusTooManyRequests, serve(named))
+	assert.Equal(t, http.StatusOK, serve(admin))
+}
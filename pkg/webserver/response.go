@@ -2,7 +2,7 @@ package webserver
 
 import (
 	"encoding/json"
-	"log"
+	"smh-apiengine/pkg/logging"
 )
 
 const (
@@ -36,7 +36,7 @@ func newResponse(result string, msg string, payload interface{}) string {
 	jsonResp, err := json.Marshal(resp)
 
 	if err != nil {
-		log.Println("failed to build the response")
+		logging.WithError(err).Errorf("failed to build the response")
 
 		return "{\"result\":\"error\",\"message\":\"internal error\"}"
 	}
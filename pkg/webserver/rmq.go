@@ -0,0 +1,124 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/queue"
+)
+
+// RMQHandler returns the handler of the messages consumed by the webserver itself. The messages are dispatched to
+// the device control the same way as the /run requests, without posting them to the api over http.
+func (apiHandlers *ApiRouteHandlers) RMQHandler() queue.MessageHandler {
+	return queue.HandlerFunc(apiHandlers.handleRMQMessage)
+}
+
+// handleRMQMessage executes the message by its type and returns the response JSON as the reply. The message id is
+// the request id of the execution. The messages that can not be executed (e.g. unknown type, malformed payload or
+// missing command) are rejected, so they are dead-lettered without retries.
+func (apiHandlers *ApiRouteHandlers) handleRMQMessage(msg queue.Envelope) (string, error) {
+	ctx := logging.WithRequestID(context.Background(), msg.ID)
+	ctx = devicecontrol.WithSource(ctx, devicecontrol.Source{Type: devicecontrol.SourceRMQ, Actor: msg.Source})
+
+	logging.WithContext(ctx).Infof("Message consumed, type: %s", msg.Type)
+
+	return apiHandlers.runMessage(ctx, msg)
+}
+
+// runMessage executes the message by its type in the context of the execution and returns the error of the
+// execution, so the consumer retries the failed message. Shared by the consumer and the MQTT bridge, which runs it in
+// the background.
+func (apiHandlers *ApiRouteHandlers) runMessage(ctx context.Context, msg queue.Envelope) (string, error) {
+	switch msg.Type {
+	case queue.TypeAlexaIntent:
+		return apiHandlers.runRMQAlexaIntent(ctx, msg.Payload)
+	case queue.TypeRunCommand:
+		var payload queue.CommandPayload
+
+		if err := msg.DecodePayload(&payload); err != nil {
+			return "", err
+		}
+
+		cmd := apiHandlers.dataProvider.FindCommandByID(payload.CommandID)
+		if cmd == nil {
+			return "", fmt.Errorf("%w: command with id %s was not found", queue.ErrRejected, payload.CommandID)
+		}
+
+		err := apiHandlers.runTask(ctx, func(ctx context.Context) error {
+			return apiHandlers.dataProvider.ExecCommandFullCycle(ctx, *cmd)
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return NewSuccessResponse("command executed", nil), nil
+	case queue.TypeRunScenario:
+		var payload queue.ScenarioPayload
+
+		if err := msg.DecodePayload(&payload); err != nil {
+			return "", err
+		}
+
+		scenario := apiHandlers.dataProvider.FindScenarioByID(payload.ScenarioID)
+		if scenario == nil {
+			return "", fmt.Errorf("%w: scenario with id %s was not found", queue.ErrRejected, payload.ScenarioID)
+		}
+
+		err := apiHandlers.runTask(ctx, func(ctx context.Context) error {
+			return apiHandlers.dataProvider.ExecScenarioFullCycle(ctx, *scenario)
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return NewSuccessResponse("scenario executed", nil), nil
+	case queue.TypeRunControlItem:
+		var payload queue.ControlItemPayload
+
+		if err := msg.DecodePayload(&payload); err != nil {
+			return "", err
+		}
+
+		controlItem := apiHandlers.dataProvider.FindControlItemByID(payload.ControlItemID)
+		if controlItem == nil {
+			return "", fmt.Errorf("%w: control item with id %s was not found", queue.ErrRejected,
+				payload.ControlItemID)
+		}
+
+		err := apiHandlers.runTask(ctx, func(ctx context.Context) error {
+			return apiHandlers.dataProvider.ExecControlItem(ctx, controlItem, payload.State)
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return NewSuccessResponse("control item executed", nil), nil
+	}
+
+	return "", fmt.Errorf("%w: unknown message type %q", queue.ErrRejected, msg.Type)
+}
+
+// runRMQAlexaIntent executes the alexa request or the smart home directive and returns the alexa response JSON
+func (apiHandlers *ApiRouteHandlers) runRMQAlexaIntent(ctx context.Context, body []byte) (string, error) {
+	if alexakit.IsSmartHomeRequest(body) {
+		response := apiHandlers.runSmartHomeDirective(ctx, body)
+
+		return response.ToJson()
+	}
+
+	var alexaRequestIntent alexakit.AlexaRequest
+
+	err := json.Unmarshal(body, &alexaRequestIntent)
+	if err != nil {
+		logging.WithContext(ctx).WithError(err).Warnf("Failed to parse alexa request of the message")
+
+		return "", fmt.Errorf("%w: %s", queue.ErrRejected, err)
+	}
+
+	response := apiHandlers.runAlexaRequest(ctx, alexaRequestIntent)
+
+	return response.ToJson()
+}
@@ -0,0 +1,71 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/queue"
+	"strings"
+	"testing"
+)
+
+func Test_RMQHandler_RejectsMalformedMessage(t *testing.T) {
+	apiHandlers := &ApiRouteHandlers{}
+
+	reply, err := apiHandlers.RMQHandler().Handle(queue.Envelope{Type: queue.TypeAlexaIntent, Payload: []byte(`[1]`)})
+
+	assert.Empty(t, reply)
+	assert.True(t, errors.Is(err, queue.ErrRejected))
+}
+
+func Test_RMQHandler_AnswersStandardRequest(t *testing.T) {
+	apiHandlers := &ApiRouteHandlers{}
+
+	msg, _ := queue.NewEnvelope(queue.TypeAlexaIntent,
+		json.RawMessage(`{"version":"1.0","request":{"type":"LaunchRequest","locale":"en-US"}}`))
+
+	reply, err := apiHandlers.RMQHandler().Handle(msg)
+
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(reply, alexakit.Speech("en-US").Welcome), reply)
+}
+
+func Test_RMQHandler_RejectsUnknownType(t *testing.T) {
+	apiHandlers := &ApiRouteHandlers{}
+
+	_, err := apiHandlers.RMQHandler().Handle(queue.Envelope{Type: "run-macro", Payload: []byte(`{}`)})
+
+	assert.True(t, errors.Is(err, queue.ErrRejected))
+}
+
+func Test_RMQHandler_RunsScenarioByID(t *testing.T) {
+	deviceControl := devicecontrol.NewDeviceControl(&devicecontrol.Config{
+		Scenarios: map[string]devicecontrol.Scenario{"tv-on": {ID: "tv-on", Name: "Turn on TV"}}})
+	apiHandlers := &ApiRouteHandlers{dataProvider: &deviceControl}
+
+	msg, _ := queue.NewEnvelope(queue.TypeRunScenario, queue.ScenarioPayload{ScenarioID: "tv-on"})
+	_, err := apiHandlers.RMQHandler().Handle(msg)
+	assert.NoError(t, err)
+
+	msg, _ = queue.NewEnvelope(queue.TypeRunScenario, queue.ScenarioPayload{ScenarioID: "Turn on TV"})
+	_, err = apiHandlers.RMQHandler().Handle(msg)
+	assert.True(t, errors.Is(err, queue.ErrRejected))
+
+	assert.NoError(t, apiHandlers.Wait(context.Background()))
+}
+
+func Test_RMQHandler_ReturnsExecutionError(t *testing.T) {
+	deviceControl := devicecontrol.NewDeviceControl(&devicecontrol.Config{
+		Commands: map[string]devicecontrol.Command{"tv-on": {ID: "tv-on", Name: "Turn on TV", DeviceID: "unknown"}}})
+	apiHandlers := &ApiRouteHandlers{dataProvider: &deviceControl}
+
+	// the execution is finished before the message is acknowledged, its failure is retried
+	msg, _ := queue.NewEnvelope(queue.TypeRunCommand, queue.CommandPayload{CommandID: "tv-on"})
+	_, err := apiHandlers.RMQHandler().Handle(msg)
+
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, queue.ErrRejected))
+}
@@ -0,0 +1,82 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/logging"
+	"time"
+)
+
+// runSmartHomeDirective handles the smart home skill api directive and returns the event. Like the intents, the power
+// directives are answered optimistically if the execution takes longer than the alexa response timeout.
+func (apiHandlers *ApiRouteHandlers) runSmartHomeDirective(ctx context.Context, body []byte) alexakit.SmartHomeResponse {
+	var request alexakit.SmartHomeRequest
+
+	err := json.Unmarshal(body, &request)
+	if err != nil {
+		logging.WithContext(ctx).WithError(err).Warnf("Failed to parse smart home directive")
+
+		return alexakit.NewSmartHomeErrorResponse(request, alexakit.ErrorTypeInvalidDirective, "malformed directive")
+	}
+
+	responses := make(chan alexakit.SmartHomeResponse, 1)
+
+	apiHandlers.startTask(ctx, func(ctx context.Context) error {
+		response := apiHandlers.dataProvider.HandleSmartHomeDirective(ctx, request)
+		responses <- response
+
+		return nil
+	})
+
+	timeout := apiHandlers.alexaResponseTimeout
+	if timeout <= 0 {
+		timeout = DefaultAlexaResponseTimeout
+	}
+
+	select {
+	case response := <-responses:
+		return response
+	case <-time.After(timeout):
+		return optimisticSmartHomeResponse(request)
+	}
+}
+
+// optimisticSmartHomeResponse reports the requested power state, the other directives can not be answered
+func optimisticSmartHomeResponse(request alexakit.SmartHomeRequest) alexakit.SmartHomeResponse {
+	header := request.Directive.Header
+
+	if header.Namespace == alexakit.NamespacePowerController {
+		powerState := alexakit.PowerStateOff
+
+		if header.Name == alexakit.DirectiveTurnOn {
+			powerState = alexakit.PowerStateOn
+		}
+
+		return alexakit.NewStateResponse(request, alexakit.EventResponse, powerState, true)
+	}
+
+	return alexakit.NewSmartHomeErrorResponse(request, alexakit.ErrorTypeEndpointUnreachable, "timeout")
+}
+
+func (apiHandlers *ApiRouteHandlers) writeSmartHomeResponse(w http.ResponseWriter, r *http.Request,
+	response alexakit.SmartHomeResponse) {
+	responseJson, err := response.ToJson()
+
+	if err != nil {
+		logging.WithContext(r.Context()).WithError(err).Errorf("Failed to build the response")
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.WriteString(w, responseJson)
+
+	if err != nil {
+		logging.WithContext(r.Context()).WithError(err).Errorf("Failed to write the response")
+	}
+}
@@ -0,0 +1,296 @@
+package webserver
+
+import (
+	"io"
+	"net/http"
+	"smh-apiengine/pkg/logging"
+)
+
+const uiContentSecurityPolicy = "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; " +
+	"connect-src 'self' ws: wss:"
+
+// handleUI serves the remote control single page application, the page itself is public, the api requests it
+// makes are authorized with the token entered by the user
+func (apiHandlers *ApiRouteHandlers) handleUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Security-Policy", uiContentSecurityPolicy)
+	w.WriteHeader(http.StatusOK)
+
+	_, ioErr := io.WriteString(w, uiPage)
+	if ioErr != nil {
+		logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
+	}
+}
+
+// handleRoot redirects to the remote control ui
+func (apiHandlers *ApiRouteHandlers) handleRoot(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/ui", http.StatusFound)
+}
+
+// uiPage is the remote control page, it has no external dependencies, so it works without internet access.
+// Controls are rendered as tabs and their items as buttons, the states are updated from the websocket.
+const uiPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="theme-color" content="#1e2229">
+<title>Smart Home Remote</title>
+<style>
+* { box-sizing: border-box; }
+body { margin: 0; font-family: -apple-system, "Segoe UI", Roboto, sans-serif; background: #1e2229; color: #e8eaed; }
+header { display: flex; align-items: center; justify-content: space-between; padding: 12px 16px; background: #262b33; }
+header h1 { font-size: 18px; margin: 0; font-weight: 500; }
+#status { font-size: 12px; color: #9aa0a6; }
+#status.online::before { content: "\25CF "; color: #4caf50; }
+#status.offline::before { content: "\25CF "; color: #f44336; }
+nav { display: flex; overflow-x: auto; background: #262b33; border-bottom: 1px solid #3c4043; }
+nav button { flex: 0 0 auto; padding: 10px 16px; border: 0; border-bottom: 3px solid transparent; background: none;
+  color: #9aa0a6; font-size: 15px; cursor: pointer; }
+nav button.active { color: #e8eaed; border-bottom-color: #8ab4f8; }
+main { display: grid; grid-template-columns: repeat(auto-fill, minmax(110px, 1fr)); gap: 12px; padding: 16px; }
+.item { display: flex; flex-direction: column; align-items: center; justify-content: center; min-height: 100px;
+  padding: 10px; border: 1px solid #3c4043; border-radius: 12px; background: #2d323b; color: #e8eaed;
+  font-size: 14px; cursor: pointer; }
+.item:active { transform: scale(0.97); }
+.item .icon { font-size: 30px; margin-bottom: 6px; }
+.item .state { margin-top: 4px; font-size: 11px; color: #9aa0a6; text-transform: uppercase; min-height: 13px; }
+.item.on { border-color: #8ab4f8; background: #2f3d52; }
+.item.busy { opacity: 0.6; }
+.item.failed { border-color: #f44336; }
+#login { max-width: 320px; margin: 60px auto; padding: 0 16px; }
+#login input { width: 100%; padding: 10px; margin: 8px 0; border: 1px solid #3c4043; border-radius: 8px;
+  background: #2d323b; color: #e8eaed; font-size: 15px; }
+#login button { width: 100%; padding: 10px; border: 0; border-radius: 8px; background: #8ab4f8; color: #1e2229;
+  font-size: 15px; cursor: pointer; }
+#message { padding: 16px; color: #9aa0a6; text-align: center; }
+.hidden { display: none !important; }
+</style>
+</head>
+<body>
+<header>
+  <h1>Smart Home Remote</h1>
+  <span id="status" class="offline">offline</span>
+</header>
+<form id="login" class="hidden">
+  <p>Enter the api token</p>
+  <input id="token" type="password" autocomplete="current-password" placeholder="Token">
+  <button type="submit">Connect</button>
+</form>
+<nav id="tabs"></nav>
+<main id="items"></main>
+<div id="message"></div>
+<script>
+(function () {
+  "use strict";
+
+  var tokenKey = "smh-token";
+  var tabKey = "smh-tab";
+  var icons = {
+    light: "💡", lamp: "💡", tv: "📺", power: "⏻", play: "▶",
+    pause: "⏸", stop: "⏹", eject: "⏏", next: "⏭", prev: "⏮", previous: "⏮",
+    forward: "⏩", rewind: "⏪", volume: "🔊", "volume-up": "🔊",
+    "volume-down": "🔉", mute: "🔇", up: "▲", down: "▼", left: "◀",
+    right: "▶", ok: "●", fan: "🌀", ac: "❄", heat: "🔥", music: "🎵",
+    speaker: "🔈", home: "🏠", menu: "☰", input: "⇄", scenario: "✨"
+  };
+
+  var token = localStorage.getItem(tokenKey) || "";
+  var controls = [];
+  var states = {};
+  var buttons = {};
+  var activeTab = localStorage.getItem(tabKey) || "";
+  var socket = null;
+  var reconnectDelay = 1000;
+
+  function $(id) { return document.getElementById(id); }
+
+  function byName(a, b) { return a.name.localeCompare(b.name); }
+
+  function values(obj) {
+    return Object.keys(obj || {}).map(function (key) { return obj[key]; });
+  }
+
+  function icon(name, fallback) {
+    var key = (name || "").toLowerCase();
+    return icons[key] || (fallback || "?").charAt(0).toUpperCase();
+  }
+
+  function message(text) { $("message").textContent = text || ""; }
+
+  function status(online) {
+    $("status").className = online ? "online" : "offline";
+    $("status").textContent = online ? "live" : "offline";
+  }
+
+  function api(path) {
+    var headers = {};
+    if (token) { headers.Authorization = "Bearer " + token; }
+
+    return fetch(path, { headers: headers, cache: "no-store" }).then(function (resp) {
+      if (resp.status === 403) { throw { forbidden: true }; }
+
+      return resp.json().then(function (body) {
+        if (!resp.ok || body.result === "error") { throw new Error(body.message || resp.statusText); }
+        return body.payload;
+      });
+    });
+  }
+
+  function showLogin() {
+    $("login").classList.remove("hidden");
+    $("tabs").classList.add("hidden");
+    $("items").classList.add("hidden");
+    message("");
+  }
+
+  function hideLogin() {
+    $("login").classList.add("hidden");
+    $("tabs").classList.remove("hidden");
+    $("items").classList.remove("hidden");
+  }
+
+  function renderTabs() {
+    var nav = $("tabs");
+    nav.innerHTML = "";
+
+    controls.forEach(function (control) {
+      var tab = document.createElement("button");
+      var tabIcon = icons[(control.icon || "").toLowerCase()];
+      tab.textContent = tabIcon ? tabIcon + " " + control.name : control.name;
+      tab.className = control.id === activeTab ? "active" : "";
+      tab.onclick = function () {
+        activeTab = control.id;
+        localStorage.setItem(tabKey, activeTab);
+        renderTabs();
+        renderItems();
+      };
+      nav.appendChild(tab);
+    });
+  }
+
+  function renderItems() {
+    var main = $("items");
+    main.innerHTML = "";
+    buttons = {};
+
+    var control = controls.filter(function (c) { return c.id === activeTab; })[0];
+    if (!control) { return; }
+
+    values(control.items).sort(byName).forEach(function (item) {
+      var button = document.createElement("button");
+      button.className = "item";
+
+      var iconEl = document.createElement("span");
+      iconEl.className = "icon";
+      iconEl.textContent = icon(item.icon, item.name);
+
+      var nameEl = document.createElement("span");
+      nameEl.textContent = item.name;
+
+      var stateEl = document.createElement("span");
+      stateEl.className = "state";
+
+      button.appendChild(iconEl);
+      button.appendChild(nameEl);
+      button.appendChild(stateEl);
+      button.onclick = function () { run(item, button); };
+
+      buttons[item.id] = button;
+      main.appendChild(button);
+      renderState(item.id);
+    });
+  }
+
+  function renderState(id) {
+    var button = buttons[id];
+    if (!button) { return; }
+
+    var state = states[id] || "";
+    button.querySelector(".state").textContent = state === "na" ? "" : state;
+    button.classList.toggle("on", state === "on");
+  }
+
+  function run(item, button) {
+    button.classList.add("busy");
+    button.classList.remove("failed");
+
+    api("/run/item/" + encodeURIComponent(item.id)).then(function () {
+      button.classList.remove("busy");
+    }).catch(function (err) {
+      button.classList.remove("busy");
+      button.classList.add("failed");
+      if (err && err.forbidden) { message("The token is not allowed to run " + item.name); }
+    });
+  }
+
+  function connect() {
+    var proto = location.protocol === "https:" ? "wss://" : "ws://";
+    socket = token ? new WebSocket(proto + location.host + "/device/state", ["bearer", token])
+      : new WebSocket(proto + location.host + "/device/state");
+
+    socket.onopen = function () {
+      reconnectDelay = 1000;
+      status(true);
+    };
+
+    socket.onmessage = function (event) {
+      var data;
+      try { data = JSON.parse(event.data); } catch (e) { return; }
+
+      states[data.id] = data.state;
+      renderState(data.id);
+    };
+
+    socket.onclose = function () {
+      status(false);
+      setTimeout(connect, reconnectDelay);
+      reconnectDelay = Math.min(reconnectDelay * 2, 30000);
+    };
+  }
+
+  function load() {
+    message("Loading...");
+
+    // sequential requests, so a missing token is counted only once as a failed authentication
+    api("/controls").then(function (payload) {
+      controls = values(payload).sort(byName);
+
+      return api("/controls/state");
+    }).then(function (payload) {
+      states = payload || {};
+
+      if (!controls.some(function (c) { return c.id === activeTab; })) {
+        activeTab = controls.length ? controls[0].id : "";
+      }
+
+      hideLogin();
+      message(controls.length ? "" : "No controls configured");
+      renderTabs();
+      renderItems();
+
+      if (!socket) { connect(); }
+    }).catch(function (err) {
+      if (err && err.forbidden) {
+        showLogin();
+        return;
+      }
+
+      message("Failed to load the controls: " + (err && err.message ? err.message : "server is not available"));
+    });
+  }
+
+  $("login").onsubmit = function (event) {
+    event.preventDefault();
+    token = $("token").value.trim();
+    localStorage.setItem(tokenKey, token);
+    load();
+  };
+
+  load();
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,46 @@
+package webserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"smh-apiengine/pkg/logging"
+	"strconv"
+)
+
+// handleWebhookDeliveries returns the delivery log of the outbound webhooks, newest first
+func (apiHandlers *ApiRouteHandlers) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit, err := deliveriesLimit(r.URL.Query().Get("limit"))
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		_, ioErr := io.WriteString(w, NewErrorResponse(err.Error()))
+		if ioErr != nil {
+			logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	_, ioErr := io.WriteString(w, NewSuccessResponse("webhook deliveries", apiHandlers.webhooks.Deliveries(limit)))
+	if ioErr != nil {
+		logging.WithContext(r.Context()).WithError(ioErr).Errorf("Failed to write the response")
+	}
+}
+
+// deliveriesLimit parses the limit query parameter, all the logged deliveries are returned if it is not set
+func deliveriesLimit(limit string) (int, error) {
+	if limit == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.Atoi(limit)
+	if err != nil || value <= 0 {
+		return 0, errors.New("limit must be a positive number")
+	}
+
+	return value, nil
+}
@@ -1,21 +1,34 @@
 package webserver
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net/http"
+	"smh-apiengine/pkg/alexakit"
+	"smh-apiengine/pkg/logging"
+	"smh-apiengine/pkg/webhook"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// DefaultAlexaResponseTimeout is shorter than the RMQ reply timeout, so the outcome reaches the publisher in time
+const DefaultAlexaResponseTimeout = 4 * time.Second
+
 type ServerConfig struct {
 	Protocol string
 	Address  string
 	Port     int
 	Token    string
+	TokensFile string // path to the named tokens store file
 	TLSCert  string
 	TLSKey   string
+	RateLimit RateLimitConfig
+	Alexa    alexakit.VerificationConfig
+	AlexaResponseTimeout time.Duration // how long /run/intent waits for the outcome before the optimistic answer
+	MQTT     MQTTConfig // the MQTT bridge for Home Assistant and other hubs
+	WebhooksFile string // path to the JSON file with the outbound webhooks
+	Webhooks webhook.Options
 }
 
 type RouteHandlers interface {
@@ -58,11 +71,18 @@ func (s *server) ServeHTTPS() error {
 	return s.server.ListenAndServeTLS(s.config.TLSCert, s.config.TLSKey)
 }
 
+// Shutdown stops accepting new requests and waits for the active ones to finish or the context to be done
+func (s *server) Shutdown(ctx context.Context) error {
+	logging.Infof("%s server is shutting down", s.config.Protocol)
+
+	return s.server.Shutdown(ctx)
+}
+
 func (s *server) logProcess()  {
-	log.Printf("%s server is listening requests on %s:%d\n",
+	logging.Infof("%s server is listening requests on %s:%d",
 		s.config.Protocol, s.config.Address, s.config.Port)
 
 	if s.config.Token != "" {
-		log.Printf("Requests should use the following token: \"%s\"\n", s.config.Token)
+		logging.Infof("Requests should be authorized with the configured bearer token")
 	}
 }
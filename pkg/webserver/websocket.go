@@ -0,0 +1,108 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net"
+	"smh-apiengine/pkg/devicecontrol"
+	"smh-apiengine/pkg/logging"
+	"sync"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+const subscriberBufferSize = 16
+
+// DeviceState is the message sent to the websocket subscribers when a control item or device state changes
+type DeviceState struct {
+	Id    string `json:"id"`
+	Type  string `json:"type"`
+	State string `json:"state"`
+}
+
+// stateHub keeps the websocket subscribers and broadcasts the state changes to them
+type stateHub struct {
+	subscribers map[net.Conn]chan []byte
+	mu          sync.Mutex
+}
+
+func newStateHub() *stateHub {
+	return &stateHub{subscribers: make(map[net.Conn]chan []byte)}
+}
+
+// count returns the number of connected subscribers
+func (hub *stateHub) count() int {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	return len(hub.subscribers)
+}
+
+// listen is the device control event listener that broadcasts the state changes
+func (hub *stateHub) listen(event devicecontrol.Event) {
+	if event.State == "" || event.Failed() {
+		return
+	}
+
+	msg, err := json.Marshal(DeviceState{Id: event.ID, Type: event.Type, State: event.State})
+	if err != nil {
+		logging.WithError(err).Errorf("Failed to marshal the state")
+
+		return
+	}
+
+	hub.broadcast(msg)
+}
+
+// broadcast sends the message to all the subscribers, slow subscribers with the full buffer miss the message
+func (hub *stateHub) broadcast(msg []byte) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for conn, messages := range hub.subscribers {
+		select {
+		case messages <- msg:
+		default:
+			logging.Warnf("Websocket subscriber %s is too slow, message dropped", conn.RemoteAddr())
+		}
+	}
+}
+
+// serve registers the connection and writes the messages to it until the client disconnects
+func (hub *stateHub) serve(conn net.Conn) {
+	messages := make(chan []byte, subscriberBufferSize)
+
+	hub.mu.Lock()
+	hub.subscribers[conn] = messages
+	hub.mu.Unlock()
+
+	go func() {
+		for msg := range messages {
+			err := wsutil.WriteServerText(conn, msg)
+
+			if err != nil {
+				logging.WithError(err).Warnf("Failed to write to websocket subscriber %s", conn.RemoteAddr())
+			}
+		}
+	}()
+
+	go func() {
+		// reading is required to handle control frames and detect the closed connection
+		for {
+			_, err := wsutil.ReadClientText(conn)
+
+			if err != nil {
+				break
+			}
+		}
+
+		hub.mu.Lock()
+		delete(hub.subscribers, conn)
+		close(messages)
+		hub.mu.Unlock()
+
+		err := conn.Close()
+		if err != nil {
+			logging.WithError(err).Warnf("Failed to close the connection")
+		}
+	}()
+}